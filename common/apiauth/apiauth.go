@@ -0,0 +1,441 @@
+// Package apiauth authenticates API integrators by API key or OAuth 2.0
+// client credentials bearer token, checks the scopes their credential
+// carries and counts their calls per endpoint and day.
+package apiauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessTokenTTL is the lifetime of client credentials access tokens
+const AccessTokenTTL = time.Hour
+
+// Config describes the service whose API clients are authenticated
+type Config struct {
+	// Realm names the service in WWW-Authenticate challenges
+	Realm string
+
+	// Scopes lists every scope a client of the service may hold
+	Scopes []string
+
+	// Error writes a 401 or 403 response in the service's error format
+	Error func(w http.ResponseWriter, status int, message string, err error)
+
+	// Route names the endpoint a request matched in usage records. It
+	// defaults to the http.ServeMux pattern.
+	Route func(*http.Request) string
+}
+
+// Client is an integrator allowed to call the API. The secret itself is
+// never stored; clients present it as an API key or exchange it at the
+// token endpoint for a bearer token.
+type Client struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	SecretHash string    `json:"secretHash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Disabled   bool      `json:"disabled,omitempty"`
+}
+
+// HasScope reports whether the client was granted scope
+func (c *Client) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// accessToken is an issued bearer token
+type accessToken struct {
+	clientID  string
+	scopes    []string
+	expiresAt time.Time
+}
+
+// Auth authenticates API clients by API key or bearer token. Clients are
+// read from a JSON file that is reloaded when it changes on disk, so the
+// clients command can add or revoke them while the service runs.
+type Auth struct {
+	path  string
+	cfg   Config
+	usage *Usage
+
+	mu      sync.Mutex
+	clients map[string]*Client
+	modTime time.Time
+	tokens  map[string]accessToken
+}
+
+// New loads the clients file at path
+func New(path string, cfg Config, usage *Usage) (*Auth, error) {
+	if cfg.Route == nil {
+		cfg.Route = patternRoute
+	}
+	a := &Auth{
+		path:    path,
+		cfg:     cfg,
+		usage:   usage,
+		clients: make(map[string]*Client),
+		tokens:  make(map[string]accessToken),
+	}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	if len(a.clients) == 0 {
+		slog.Warn("No API clients configured; add one with: clients add CLIENT_ID SCOPES", "path", path)
+	}
+	return a, nil
+}
+
+// reload rereads the clients file if it changed. Callers must not hold a.mu.
+func (a *Auth) reload() error {
+	info, err := os.Stat(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		a.mu.Lock()
+		a.clients = make(map[string]*Client)
+		a.modTime = time.Time{}
+		a.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read API clients: %w", err)
+	}
+
+	a.mu.Lock()
+	unchanged := info.ModTime().Equal(a.modTime)
+	a.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	clients, err := readClients(a.path)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.clients = make(map[string]*Client, len(clients))
+	for _, c := range clients {
+		a.clients[c.ID] = c
+	}
+	a.modTime = info.ModTime()
+	a.mu.Unlock()
+
+	slog.Info("Loaded API clients", "count", len(clients), "path", a.path)
+	return nil
+}
+
+// client returns an enabled client by ID
+func (a *Auth) client(id string) (*Client, bool) {
+	if err := a.reload(); err != nil {
+		slog.Error("Error reloading API clients", "error", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, ok := a.clients[id]
+	if !ok || c.Disabled {
+		return nil, false
+	}
+	return c, true
+}
+
+// authenticateSecret checks a client ID and secret
+func (a *Auth) authenticateSecret(id, secret string) (*Client, bool) {
+	c, ok := a.client(id)
+	if !ok {
+		return nil, false
+	}
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(c.SecretHash)) != 1 {
+		return nil, false
+	}
+	return c, true
+}
+
+// issueToken creates a bearer token for a client limited to scopes
+func (a *Auth) issueToken(c *Client, scopes []string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range a.tokens {
+		if now.After(t.expiresAt) {
+			delete(a.tokens, k)
+		}
+	}
+	a.tokens[token] = accessToken{
+		clientID:  c.ID,
+		scopes:    scopes,
+		expiresAt: now.Add(AccessTokenTTL),
+	}
+	return token, nil
+}
+
+// authenticate resolves the calling client and the scopes its credential
+// carries. API keys carry all of the client's scopes; tokens carry the
+// scopes they were issued for.
+func (a *Auth) authenticate(r *http.Request) (*Client, []string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		id, secret, ok := strings.Cut(key, ".")
+		if !ok {
+			return nil, nil, false
+		}
+		c, ok := a.authenticateSecret(id, secret)
+		if !ok {
+			return nil, nil, false
+		}
+		return c, c.Scopes, true
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, nil, false
+	}
+
+	a.mu.Lock()
+	t, ok := a.tokens[strings.TrimSpace(token)]
+	a.mu.Unlock()
+	if !ok || time.Now().After(t.expiresAt) {
+		return nil, nil, false
+	}
+
+	// Revoking or disabling a client also invalidates its tokens
+	c, ok := a.client(t.clientID)
+	if !ok {
+		return nil, nil, false
+	}
+	scopes := slices.DeleteFunc(slices.Clone(t.scopes), func(s string) bool { return !c.HasScope(s) })
+	return c, scopes, true
+}
+
+type clientKey struct{}
+
+// ClientFrom returns the authenticated API client of a request context
+func ClientFrom(ctx context.Context) (*Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*Client)
+	return c, ok
+}
+
+// WithClient returns a copy of ctx carrying c as the authenticated client
+func WithClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// Require allows only clients whose credential carries scope. An empty scope
+// admits any authenticated client. Calls are counted in the usage records.
+func (a *Auth) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, scopes, ok := a.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q`, a.cfg.Realm))
+			a.cfg.Error(w, http.StatusUnauthorized, "Authentication required",
+				errors.New("send an X-API-Key header or an Authorization: Bearer token from the token endpoint"))
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() { a.usage.Record(c.ID, a.cfg.Route(r), rec.status) }()
+
+		if scope != "" && !slices.Contains(scopes, scope) {
+			rec.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="insufficient_scope", scope=%q`, a.cfg.Realm, scope))
+			a.cfg.Error(rec, http.StatusForbidden, "Insufficient scope",
+				fmt.Errorf("this endpoint requires the %s scope", scope))
+			return
+		}
+
+		next(rec, r.WithContext(WithClient(r.Context(), c)))
+	}
+}
+
+// TokenHandler implements the OAuth 2.0 client credentials grant. Client
+// credentials may be sent with HTTP Basic authentication or as client_id
+// and client_secret form fields.
+func (a *Auth) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form body")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only client_credentials is supported")
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	c, ok := a.authenticateSecret(id, secret)
+	if !ok {
+		slog.WarnContext(r.Context(), "Rejected token request", "client_id", id, "remote_addr", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm=%q`, a.cfg.Realm))
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	scopes := c.Scopes
+	if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+		for _, s := range requested {
+			if !c.HasScope(s) {
+				oauthError(w, http.StatusBadRequest, "invalid_scope", fmt.Sprintf("client is not granted %q", s))
+				return
+			}
+		}
+		scopes = requested
+	}
+
+	token, err := a.issueToken(c, scopes)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error issuing access token", "client_id", c.ID, "error", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "failed to issue token")
+		return
+	}
+	a.usage.Record(c.ID, a.cfg.Route(r), http.StatusOK)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(AccessTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// ClientUsage returns a client's usage records, newest day first
+func (a *Auth) ClientUsage(clientID string) []UsageRecord {
+	return a.usage.ForClient(clientID)
+}
+
+// oauthError writes an RFC 6749 error response
+func oauthError(w http.ResponseWriter, code int, errCode, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// patternRoute names a request by the http.ServeMux pattern it matched,
+// e.g. "POST /api/v1/credentials/farmer"
+func patternRoute(r *http.Request) string {
+	switch {
+	case r.Pattern == "":
+		return r.Method + " " + r.URL.Path
+	case strings.Contains(r.Pattern, " "):
+		return r.Pattern
+	default:
+		return r.Method + " " + r.Pattern
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns a 256-bit random hex token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ClientsPath is the clients file in a service's data directory
+func ClientsPath(dataDir string) string {
+	return filepath.Join(dataDir, "api-clients.json")
+}
+
+// UsagePath is the usage file in a service's data directory
+func UsagePath(dataDir string) string {
+	return filepath.Join(dataDir, "api-usage.json")
+}
+
+// readClients parses the clients file
+func readClients(path string) ([]*Client, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API clients: %w", err)
+	}
+	var clients []*Client
+	if err := json.Unmarshal(raw, &clients); err != nil {
+		return nil, fmt.Errorf("failed to parse API clients: %w", err)
+	}
+	return clients, nil
+}
+
+// writeClients atomically rewrites the clients file
+func writeClients(path string, clients []*Client) error {
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	raw, err := json.MarshalIndent(clients, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode API clients: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write API clients: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// validateScopes checks a comma-separated scope list against allowed
+func validateScopes(list string, allowed []string) ([]string, error) {
+	var scopes []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !slices.Contains(allowed, s) {
+			return nil, fmt.Errorf("unknown scope %q (expected %s)", s, strings.Join(allowed, ", "))
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return scopes, nil
+}
@@ -0,0 +1,174 @@
+package apiauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secret = "s3cret"
+
+// newTestAuth returns an Auth whose clients file holds clients, each with
+// the secret s3cret
+func newTestAuth(t *testing.T, clients ...*Client) (*Auth, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, c := range clients {
+		c.SecretHash = hashSecret(secret)
+	}
+	path := ClientsPath(dir)
+	if err := writeClients(path, clients); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := OpenUsage(UsagePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(path, Config{
+		Realm:  "test",
+		Scopes: []string{"issue", "verify"},
+		Error: func(w http.ResponseWriter, status int, message string, err error) {
+			http.Error(w, message, status)
+		},
+	}, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, path
+}
+
+// token gets a bearer token with the client credentials grant
+func token(t *testing.T, a *Auth, form url.Values) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.TokenHandler(w, r)
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body.AccessToken
+}
+
+func TestRequire(t *testing.T) {
+	a, path := newTestAuth(t, &Client{ID: "portal", Scopes: []string{"issue", "verify"}})
+	_, verifyOnly := token(t, a, url.Values{
+		"grant_type": {"client_credentials"}, "client_id": {"portal"}, "client_secret": {secret}, "scope": {"verify"},
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /issue", a.Require("issue", func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := ClientFrom(r.Context()); !ok || c.ID != "portal" {
+			t.Errorf("client = %v, want portal", c)
+		}
+	}))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{name: "no credential", want: http.StatusUnauthorized},
+		{name: "API key", header: http.Header{"X-Api-Key": {"portal." + secret}}, want: http.StatusOK},
+		{name: "wrong secret", header: http.Header{"X-Api-Key": {"portal.guess"}}, want: http.StatusUnauthorized},
+		{name: "unknown token", header: http.Header{"Authorization": {"Bearer guess"}}, want: http.StatusUnauthorized},
+		{name: "token without the scope", header: http.Header{"Authorization": {"Bearer " + verifyOnly}}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/issue", nil)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code != http.StatusOK && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate challenge")
+			}
+		})
+	}
+
+	// Calls by the client are counted, including the one without the scope
+	var issue *UsageRecord
+	for _, rec := range a.ClientUsage("portal") {
+		if rec.Endpoint == "POST /issue" {
+			issue = &rec
+		}
+	}
+	if issue == nil || issue.Requests != 2 || issue.Errors != 1 {
+		t.Errorf("POST /issue usage = %+v, want 2 requests and 1 error", issue)
+	}
+
+	// Revoking the client invalidates its tokens without a restart
+	_, issueToken := token(t, a, url.Values{"grant_type": {"client_credentials"}, "client_id": {"portal"}, "client_secret": {secret}})
+	if err := writeClients(path, []*Client{{ID: "portal", SecretHash: hashSecret(secret), Scopes: []string{"issue"}, Disabled: true}}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+	r := httptest.NewRequest(http.MethodPost, "/issue", nil)
+	r.Header.Set("Authorization", "Bearer "+issueToken)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked client: status = %d, want 401", w.Code)
+	}
+}
+
+func TestTokenHandler(t *testing.T) {
+	a, _ := newTestAuth(t, &Client{ID: "portal", Scopes: []string{"issue"}})
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		want      int
+		wantError string
+	}{
+		{name: "granted", form: url.Values{"grant_type": {"client_credentials"}, "client_id": {"portal"}, "client_secret": {secret}}, want: http.StatusOK},
+		{name: "password grant", form: url.Values{"grant_type": {"password"}}, want: http.StatusBadRequest, wantError: "unsupported_grant_type"},
+		{name: "wrong secret", form: url.Values{"grant_type": {"client_credentials"}, "client_id": {"portal"}, "client_secret": {"guess"}}, want: http.StatusUnauthorized, wantError: "invalid_client"},
+		{name: "scope not granted", form: url.Values{"grant_type": {"client_credentials"}, "client_id": {"portal"}, "client_secret": {secret}, "scope": {"verify"}}, want: http.StatusBadRequest, wantError: "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, access := token(t, a, tt.form)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantError)
+			}
+			if (access != "") != (tt.want == http.StatusOK) {
+				t.Errorf("access token %q for status %d", access, w.Code)
+			}
+		})
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	allowed := []string{"issue", "verify"}
+	tests := []struct {
+		list    string
+		want    string
+		wantErr bool
+	}{
+		{list: "issue, verify,issue", want: "issue,verify"},
+		{list: "admin", wantErr: true},
+		{list: " , ", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := validateScopes(tt.list, allowed)
+		if (err != nil) != tt.wantErr || strings.Join(got, ",") != tt.want {
+			t.Errorf("validateScopes(%q) = %v, %v, want %q", tt.list, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package apiauth
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Command manages the API clients in a service's data directory from the
+// command line, given the arguments after "clients":
+//
+//	clients add -name "Web portal" portal issue,read-catalogue
+//	clients list
+//	clients revoke portal
+//	clients usage [client-id]
+//
+// scopes lists the scopes the service's clients may hold. The running
+// service picks up changes to the clients file without a restart.
+func Command(dataDir string, scopes []string, args []string) error {
+	path := ClientsPath(dataDir)
+	if len(args) == 0 {
+		return fmt.Errorf("usage: clients add|list|revoke|usage")
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("clients add", flag.ContinueOnError)
+		name := fs.String("name", "", "display name of the integrator")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: clients add [-name NAME] CLIENT_ID SCOPE[,SCOPE...]")
+		}
+		return addClient(path, fs.Arg(0), *name, fs.Arg(1), scopes)
+
+	case "list":
+		clients, err := readClients(path)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tSTATUS")
+		for _, c := range clients {
+			status := "active"
+			if c.Disabled {
+				status = "revoked"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Name, strings.Join(c.Scopes, ","), c.CreatedAt.Format(time.DateOnly), status)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: clients revoke CLIENT_ID")
+		}
+		return revokeClient(path, args[1])
+
+	case "usage":
+		records, err := readUsage(UsagePath(dataDir))
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "DATE\tCLIENT\tENDPOINT\tREQUESTS\tERRORS\tLAST USED")
+		for _, rec := range records {
+			if len(args) > 1 && rec.ClientID != args[1] {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", rec.Date, rec.ClientID, rec.Endpoint, rec.Requests, rec.Errors, rec.LastUsedAt.Format(time.RFC3339))
+		}
+		return tw.Flush()
+	}
+
+	return fmt.Errorf("unknown clients command %q", args[0])
+}
+
+// addClient registers a client and prints its secret, which is shown only once
+func addClient(path, id, name, scopeList string, allowed []string) error {
+	if id == "" || strings.ContainsAny(id, ". \t:") {
+		return fmt.Errorf("client ID must be non-empty and must not contain dots, colons or spaces")
+	}
+	scopes, err := validateScopes(scopeList, allowed)
+	if err != nil {
+		return err
+	}
+
+	clients, err := readClients(path)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if c.ID == id {
+			return fmt.Errorf("client %q already exists", id)
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate secret: %w", err)
+	}
+	clients = append(clients, &Client{
+		ID:         id,
+		Name:       name,
+		SecretHash: hashSecret(secret),
+		Scopes:     scopes,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err := writeClients(path, clients); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created client %s with scopes %s\n\n", id, strings.Join(scopes, ", "))
+	fmt.Printf("Client ID:     %s\n", id)
+	fmt.Printf("Client secret: %s\n", secret)
+	fmt.Printf("API key:       %s.%s\n\n", id, secret)
+	fmt.Println("Store the secret now; it cannot be shown again.")
+	return nil
+}
+
+// revokeClient disables a client, which also invalidates its access tokens
+func revokeClient(path, id string) error {
+	clients, err := readClients(path)
+	if err != nil {
+		return err
+	}
+	for _, c := range clients {
+		if c.ID == id {
+			c.Disabled = true
+			if err := writeClients(path, clients); err != nil {
+				return err
+			}
+			fmt.Printf("Revoked client %s\n", id)
+			return nil
+		}
+	}
+	return fmt.Errorf("client %q not found", id)
+}
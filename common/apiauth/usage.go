@@ -0,0 +1,156 @@
+package apiauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// usageFlushInterval is how often usage records are written to disk
+const usageFlushInterval = 30 * time.Second
+
+// UsageRecord counts one client's calls to one endpoint on one day (UTC)
+type UsageRecord struct {
+	ClientID   string    `json:"clientId"`
+	Date       string    `json:"date"`
+	Endpoint   string    `json:"endpoint"`
+	Requests   int       `json:"requests"`
+	Errors     int       `json:"errors"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+type usageKey struct {
+	clientID, date, endpoint string
+}
+
+// Usage keeps per-client usage counts and persists them to a JSON file in
+// the background
+type Usage struct {
+	path string
+
+	mu      sync.Mutex
+	records map[usageKey]*UsageRecord
+	dirty   bool
+}
+
+// OpenUsage loads the usage file at path and starts flushing changes to it
+// every usageFlushInterval
+func OpenUsage(path string) (*Usage, error) {
+	u := &Usage{path: path, records: make(map[usageKey]*UsageRecord)}
+
+	records, err := readUsage(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		u.records[usageKey{rec.ClientID, rec.Date, rec.Endpoint}] = rec
+	}
+
+	go func() {
+		for range time.Tick(usageFlushInterval) {
+			if err := u.Flush(); err != nil {
+				slog.Error("Error saving API usage", "error", err)
+			}
+		}
+	}()
+	return u, nil
+}
+
+// Record counts a call. Responses with status 400 or above count as errors.
+func (u *Usage) Record(clientID, endpoint string, status int) {
+	now := time.Now().UTC()
+	key := usageKey{clientID, now.Format("2006-01-02"), endpoint}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	rec, ok := u.records[key]
+	if !ok {
+		rec = &UsageRecord{ClientID: clientID, Date: key.date, Endpoint: endpoint}
+		u.records[key] = rec
+	}
+	rec.Requests++
+	if status >= 400 {
+		rec.Errors++
+	}
+	rec.LastUsedAt = now
+	u.dirty = true
+}
+
+// ForClient returns a client's records, newest day first
+func (u *Usage) ForClient(clientID string) []UsageRecord {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var list []UsageRecord
+	for _, rec := range u.records {
+		if rec.ClientID == clientID {
+			list = append(list, *rec)
+		}
+	}
+	sortUsage(list)
+	return list
+}
+
+// Flush writes the records to disk if they changed
+func (u *Usage) Flush() error {
+	u.mu.Lock()
+	if !u.dirty {
+		u.mu.Unlock()
+		return nil
+	}
+	list := make([]UsageRecord, 0, len(u.records))
+	for _, rec := range u.records {
+		list = append(list, *rec)
+	}
+	u.dirty = false
+	u.mu.Unlock()
+
+	sortUsage(list)
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(u.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tmp := u.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	return os.Rename(tmp, u.path)
+}
+
+// readUsage parses the usage file
+func readUsage(path string) ([]*UsageRecord, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	var records []*UsageRecord
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse usage: %w", err)
+	}
+	return records, nil
+}
+
+func sortUsage(list []UsageRecord) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Date != list[j].Date {
+			return list[i].Date > list[j].Date
+		}
+		if list[i].ClientID != list[j].ClientID {
+			return list[i].ClientID < list[j].ClientID
+		}
+		return list[i].Endpoint < list[j].Endpoint
+	})
+}
@@ -0,0 +1,62 @@
+// Package auth provides session login against a local user store, role
+// based authorization and CSRF protection for the web app.
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Roles
+const (
+	RoleClerk      = "clerk"
+	RoleSupervisor = "supervisor"
+	RoleAdmin      = "admin"
+	RoleTeller     = "teller"
+)
+
+// Roles lists every role, in the order shown on the admin page
+var Roles = []string{RoleClerk, RoleSupervisor, RoleAdmin, RoleTeller}
+
+// User is an account in the local user store
+type User struct {
+	Username     string   `json:"username"`
+	Name         string   `json:"name"`
+	PasswordHash string   `json:"passwordHash"`
+	Roles        []string `json:"roles"`
+	Disabled     bool     `json:"disabled,omitempty"`
+}
+
+// HasRole reports whether the user holds any of roles. Admins hold every role.
+func (u *User) HasRole(roles ...string) bool {
+	if slices.Contains(u.Roles, RoleAdmin) {
+		return true
+	}
+	for _, role := range roles {
+		if slices.Contains(u.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayName returns the user's name, or the username when none is set
+func (u *User) DisplayName() string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Username
+}
+
+type contextKey struct{}
+
+// UserFrom returns the signed-in user of a request context
+func UserFrom(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(contextKey{}).(*User)
+	return u, ok
+}
+
+// WithUser returns a copy of ctx carrying u as the signed-in user
+func WithUser(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, contextKey{}, u)
+}
@@ -0,0 +1,414 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Single sign-on paths
+const (
+	OIDCLoginPath    = "/login/oidc"
+	OIDCCallbackPath = "/login/oidc/callback"
+)
+
+// oidcStateCookie binds a login attempt to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// oidcLoginTimeout bounds how long a user may spend at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// OIDCUsernamePrefix starts the username of every single sign-on user,
+// followed by the provider's subject identifier. Local usernames cannot
+// contain ':', so an identity provider account never shares a username,
+// and with it sessions, self-service checks and audit entries, with a local
+// account.
+const OIDCUsernamePrefix = "oidc:"
+
+// OIDCConfig configures single sign-on against an OpenID Connect provider
+// such as Keycloak
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// ProviderName is shown on the sign-in button
+	ProviderName string
+
+	// GroupsClaim names the ID token claim listing the user's groups. A
+	// dotted path such as realm_access.roles reaches into nested claims.
+	GroupsClaim string
+
+	// GroupRoles maps identity provider groups to application roles
+	GroupRoles map[string][]string
+}
+
+// OIDCConfigFromEnv reads the single sign-on settings. It returns nil when
+// OIDC_ISSUER_URL is not set.
+//
+// OIDC_GROUP_ROLES maps groups to roles as comma-separated group=role pairs,
+// for example "issuer-clerks=clerk,issuer-supervisors=supervisor". Repeat a
+// group to give it several roles.
+func OIDCConfigFromEnv() (*OIDCConfig, error) {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	cfg := &OIDCConfig{
+		IssuerURL:    issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		ProviderName: os.Getenv("OIDC_PROVIDER_NAME"),
+		GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		GroupRoles:   make(map[string][]string),
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required with OIDC_ISSUER_URL")
+	}
+	if cfg.ProviderName == "" {
+		cfg.ProviderName = "Single Sign-On"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	for _, pair := range strings.Split(os.Getenv("OIDC_GROUP_ROLES"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, "=")
+		group = normalizeGroup(group)
+		role = strings.TrimSpace(role)
+		if !ok || group == "" || !slices.Contains(Roles, role) {
+			return nil, fmt.Errorf("invalid OIDC_GROUP_ROLES entry %q", pair)
+		}
+		cfg.GroupRoles[group] = append(cfg.GroupRoles[group], role)
+	}
+	if len(cfg.GroupRoles) == 0 {
+		return nil, fmt.Errorf("OIDC_GROUP_ROLES must map at least one group to a role")
+	}
+
+	return cfg, nil
+}
+
+// oidcClient runs the authorization code flow with PKCE. Provider discovery
+// happens on first use so the app starts even while the provider is down.
+type oidcClient struct {
+	cfg *OIDCConfig
+
+	mu         sync.Mutex
+	provider   *oidc.Provider
+	verifier   *oidc.IDTokenVerifier
+	oauth      *oauth2.Config
+	endSession string
+	pending    map[string]pendingLogin
+}
+
+// pendingLogin is a login attempt waiting for the provider's callback
+type pendingLogin struct {
+	nonce     string
+	verifier  string
+	next      string
+	expiresAt time.Time
+}
+
+// EnableOIDC turns on single sign-on alongside local accounts
+func (s *Service) EnableOIDC(cfg *OIDCConfig) {
+	s.oidc = &oidcClient{cfg: cfg, pending: make(map[string]pendingLogin)}
+	slog.Info("Single sign-on enabled", "provider", cfg.ProviderName, "issuer_url", cfg.IssuerURL)
+}
+
+// setup discovers the provider's endpoints and keys
+func (c *oidcClient) setup(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		return nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, c.cfg.IssuerURL)
+	if err != nil {
+		return fmt.Errorf("failed to discover identity provider: %w", err)
+	}
+
+	var metadata struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		slog.ErrorContext(ctx, "Error reading identity provider metadata", "error", err)
+	}
+
+	c.provider = provider
+	c.verifier = provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID})
+	c.endSession = metadata.EndSessionEndpoint
+	c.oauth = &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		RedirectURL:  c.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+	}
+	return nil
+}
+
+// begin records a login attempt and returns its state
+func (c *oidcClient) begin(next string) (string, pendingLogin) {
+	state := randomToken()
+	login := pendingLogin{
+		nonce:     randomToken(),
+		verifier:  oauth2.GenerateVerifier(),
+		next:      next,
+		expiresAt: time.Now().Add(oidcLoginTimeout),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, p := range c.pending {
+		if now.After(p.expiresAt) {
+			delete(c.pending, k)
+		}
+	}
+	c.pending[state] = login
+	return state, login
+}
+
+// finish removes and returns a login attempt; each state is used once
+func (c *oidcClient) finish(state string) (pendingLogin, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	login, ok := c.pending[state]
+	delete(c.pending, state)
+	if !ok || time.Now().After(login.expiresAt) {
+		return pendingLogin{}, false
+	}
+	return login, true
+}
+
+// StartOIDC handles GET /login/oidc
+// Redirects to the identity provider's authorization endpoint
+func (s *Service) StartOIDC(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if err := s.oidc.setup(ctx); err != nil {
+		slog.ErrorContext(r.Context(), "Error starting single sign-on", "error", err)
+		s.renderLoginError(w, http.StatusBadGateway, s.oidc.cfg.ProviderName+" is not reachable. Try again later.")
+		return
+	}
+
+	state, login := s.oidc.begin(safeNext(r.FormValue("next")))
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     OIDCCallbackPath,
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	authURL := s.oidc.oauth.AuthCodeURL(state, oidc.Nonce(login.nonce), oauth2.S256ChallengeOption(login.verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback handles GET /login/oidc/callback
+// Exchanges the authorization code, verifies the ID token and signs the user
+// in with the roles their groups map to
+func (s *Service) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: OIDCCallbackPath, MaxAge: -1})
+
+	if e := r.FormValue("error"); e != "" {
+		slog.WarnContext(r.Context(), "Identity provider returned an error", "error", e, "description", r.FormValue("error_description"))
+		s.renderLoginError(w, http.StatusUnauthorized, "Sign-in with "+s.oidc.cfg.ProviderName+" was not completed")
+		return
+	}
+
+	state := r.FormValue("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value != state {
+		s.renderLoginError(w, http.StatusBadRequest, "The sign-in attempt expired or was started in another browser. Try again.")
+		return
+	}
+	login, ok := s.oidc.finish(state)
+	if !ok {
+		s.renderLoginError(w, http.StatusBadRequest, "The sign-in attempt expired. Try again.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	u, rawIDToken, err := s.oidc.authenticate(ctx, r.FormValue("code"), login)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Single sign-on failed", "error", err)
+		var noRole *noRoleError
+		if errors.As(err, &noRole) {
+			s.renderLoginError(w, http.StatusForbidden, "Your account has no role in this application. Ask an administrator to add you to a group.")
+			return
+		}
+		s.renderLoginError(w, http.StatusUnauthorized, "Sign-in with "+s.oidc.cfg.ProviderName+" failed")
+		return
+	}
+
+	if old := s.session(r); old != nil {
+		s.sessions.delete(old.id)
+	}
+	sess := s.sessions.createExternal(u, rawIDToken)
+	setCookies(w, r, sess, s.secureCookies)
+	slog.InfoContext(r.Context(), "User signed in", "user", u.Username, "name", u.Name, "provider", s.oidc.cfg.ProviderName, "roles", u.Roles)
+
+	http.Redirect(w, r, login.next, http.StatusSeeOther)
+}
+
+// noRoleError is returned when none of a user's groups map to a role
+type noRoleError struct {
+	username string
+	groups   []string
+}
+
+func (e *noRoleError) Error() string {
+	return fmt.Sprintf("user %s has no mapped role (groups: %s)", e.username, strings.Join(e.groups, ", "))
+}
+
+// authenticate redeems the code and builds the user from the ID token
+func (c *oidcClient) authenticate(ctx context.Context, code string, login pendingLogin) (*User, string, error) {
+	token, err := c.oauth.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", fmt.Errorf("token response has no id_token")
+	}
+	idToken, err := c.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return nil, "", fmt.Errorf("ID token nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", fmt.Errorf("failed to read ID token claims: %w", err)
+	}
+
+	if idToken.Subject == "" {
+		return nil, "", fmt.Errorf("ID token has no subject")
+	}
+	u := &User{
+		Username: OIDCUsernamePrefix + idToken.Subject,
+		Name:     firstClaim(claims, "name", "preferred_username", "email"),
+	}
+	groups := claimStrings(claims, c.cfg.GroupsClaim)
+	for _, group := range groups {
+		for _, role := range c.cfg.GroupRoles[normalizeGroup(group)] {
+			if !slices.Contains(u.Roles, role) {
+				u.Roles = append(u.Roles, role)
+			}
+		}
+	}
+	if len(u.Roles) == 0 {
+		return nil, "", &noRoleError{username: u.Username, groups: groups}
+	}
+
+	return u, rawIDToken, nil
+}
+
+// logoutURL returns the provider's end-session URL for a single sign-on
+// session, or "" when the provider has none
+func (c *oidcClient) logoutURL(idToken string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.endSession == "" {
+		return ""
+	}
+
+	redirect, err := url.Parse(c.cfg.RedirectURL)
+	if err != nil {
+		return ""
+	}
+	redirect.Path = LoginPath
+	redirect.RawQuery = ""
+
+	q := url.Values{}
+	q.Set("id_token_hint", idToken)
+	q.Set("client_id", c.cfg.ClientID)
+	q.Set("post_logout_redirect_uri", redirect.String())
+
+	sep := "?"
+	if strings.Contains(c.endSession, "?") {
+		sep = "&"
+	}
+	return c.endSession + sep + q.Encode()
+}
+
+// firstClaim returns the first non-empty string claim among names
+func firstClaim(claims map[string]any, names ...string) string {
+	for _, name := range names {
+		if v, ok := claims[name].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// claimStrings reads a string or string-list claim at a dotted path
+func claimStrings(claims map[string]any, path string) []string {
+	var v any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// normalizeGroup drops the leading slash Keycloak puts on full group paths
+func normalizeGroup(group string) string {
+	return strings.TrimPrefix(strings.TrimSpace(group), "/")
+}
@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockProvider is an OpenID Connect provider serving discovery, its keys and
+// a token endpoint that checks the PKCE verifier
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	// challenge is the PKCE code challenge of the last authorization request
+	challenge string
+	// claims are put in the next ID token; nonce is filled in from the
+	// authorization request unless set
+	claims map[string]any
+	nonce  string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"end_session_endpoint":                  p.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("code") != "code" || b64(sum[:]) != p.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     p.idToken(t),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// idToken signs the configured claims with RS256
+func (p *mockProvider) idToken(t *testing.T) string {
+	claims := map[string]any{
+		"iss":   p.URL,
+		"aud":   "issuer",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": p.nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Error(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// change alters the sign-in before the callback
+		change    func(p *mockProvider, q url.Values)
+		want      int
+		wantRoles []string
+	}{
+		{
+			name:      "groups map to roles",
+			want:      http.StatusSeeOther,
+			wantRoles: []string{RoleClerk, RoleSupervisor},
+		},
+		{
+			name:   "state mismatch",
+			change: func(p *mockProvider, q url.Values) { q.Set("state", "forged") },
+			want:   http.StatusBadRequest,
+		},
+		{
+			name:   "nonce mismatch",
+			change: func(p *mockProvider, q url.Values) { p.nonce = "replayed" },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong PKCE verifier",
+			change: func(p *mockProvider, q url.Values) { p.challenge = b64([]byte("another login")) },
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:   "no mapped group",
+			change: func(p *mockProvider, q url.Values) { p.claims["groups"] = []string{"visitors"} },
+			want:   http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t)
+			p.claims = map[string]any{
+				"sub":                "f81d4fae",
+				"name":               "Jane Wanjiru",
+				"preferred_username": "admin",
+				"groups":             []string{"/issuer-clerks", "issuer-supervisors", "visitors"},
+			}
+			s := newTestService(t)
+			s.EnableOIDC(&OIDCConfig{
+				IssuerURL:    p.URL,
+				ClientID:     "issuer",
+				RedirectURL:  "https://issuer.example" + OIDCCallbackPath,
+				ProviderName: "Keycloak",
+				GroupsClaim:  "groups",
+				GroupRoles: map[string][]string{
+					"issuer-clerks":      {RoleClerk},
+					"issuer-supervisors": {RoleSupervisor},
+				},
+			})
+
+			w := httptest.NewRecorder()
+			s.StartOIDC(w, httptest.NewRequest(http.MethodGet, OIDCLoginPath+"?next=/renewals", nil))
+			if w.Code != http.StatusFound {
+				t.Fatalf("start: status = %d, want 302", w.Code)
+			}
+			auth, err := url.Parse(w.Header().Get("Location"))
+			if err != nil || !strings.HasPrefix(auth.String(), p.URL+"/authorize") {
+				t.Fatalf("start redirected to %q, want the authorization endpoint", auth)
+			}
+			if m := auth.Query().Get("code_challenge_method"); m != "S256" {
+				t.Errorf("code_challenge_method = %q, want S256", m)
+			}
+			p.challenge = auth.Query().Get("code_challenge")
+			p.nonce = auth.Query().Get("nonce")
+
+			q := url.Values{"code": {"code"}, "state": {auth.Query().Get("state")}}
+			if tt.change != nil {
+				tt.change(p, q)
+			}
+			r := httptest.NewRequest(http.MethodGet, OIDCCallbackPath+"?"+q.Encode(), nil)
+			for _, c := range w.Result().Cookies() {
+				r.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			s.OIDCCallback(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("callback: status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusSeeOther {
+				return
+			}
+			if loc := w.Header().Get("Location"); loc != "/renewals" {
+				t.Errorf("callback redirected to %q, want /renewals", loc)
+			}
+
+			var sess *session
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessionCookie {
+					sess, _ = s.sessions.get(c.Value)
+				}
+			}
+			if sess == nil {
+				t.Fatal("no session after sign-in")
+			}
+			u, _ := s.identity(sess)
+			if u.Username != "oidc:f81d4fae" || u.Name != "Jane Wanjiru" {
+				t.Errorf("user = %q (%q), want oidc:f81d4fae (Jane Wanjiru)", u.Username, u.Name)
+			}
+			if !slices.Equal(u.Roles, tt.wantRoles) {
+				t.Errorf("roles = %v, want %v", u.Roles, tt.wantRoles)
+			}
+
+			// The provider's "admin" is not the local admin: ending the local
+			// account's sessions leaves the single sign-on session alone
+			s.sessions.deleteUser("admin")
+			if _, ok := s.sessions.get(sess.id); !ok {
+				t.Error("local admin's sessions ended the single sign-on session")
+			}
+		})
+	}
+}
+
+func TestAddRejectsSSOUsernames(t *testing.T) {
+	s := newTestService(t)
+	if err := s.Users.Add("oidc:f81d4fae", "Impostor", "correct horse battery", []string{RoleAdmin}); err == nil {
+		t.Error("Add accepted a username in the single sign-on namespace")
+	}
+}
@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+)
+
+// Paths served by the auth handlers
+const (
+	LoginPath  = "/login"
+	LogoutPath = "/logout"
+	UsersPath  = "/admin/users"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+// Service signs users in and guards routes
+type Service struct {
+	Users *Users
+
+	sessions      *sessions
+	templates     *template.Template
+	secureCookies bool
+	oidc          *oidcClient
+
+	// Sign-in attempts allowed per client IP and per username, against
+	// password guessing
+	loginIPs   *ratelimit.Limiter
+	loginUsers *ratelimit.Limiter
+}
+
+// New creates the auth service over the user store at usersPath. With
+// secureCookies the session cookies are only sent over HTTPS. Sign-in
+// attempts are limited per client IP (AUTH_LOGIN_IP_PER_MINUTE) and per
+// username (AUTH_LOGIN_USER_PER_MINUTE), with bursts of AUTH_LOGIN_BURST.
+func New(usersPath string, secureCookies bool) (*Service, error) {
+	users, err := OpenUsers(usersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := template.ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Service{
+		Users:         users,
+		sessions:      newSessions(),
+		templates:     templates,
+		secureCookies: secureCookies,
+		loginIPs:      ratelimit.New(ratelimit.EnvInt("AUTH_LOGIN_IP_PER_MINUTE", 10), ratelimit.EnvInt("AUTH_LOGIN_BURST", 5)),
+		loginUsers:    ratelimit.New(ratelimit.EnvInt("AUTH_LOGIN_USER_PER_MINUTE", 5), ratelimit.EnvInt("AUTH_LOGIN_BURST", 5)),
+	}, nil
+}
+
+type sessionKey struct{}
+
+// Middleware loads the signed-in user into the request context and rejects
+// state-changing requests from a session without its CSRF token
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess := s.session(r)
+		if sess != nil {
+			if u, ok := s.identity(sess); ok {
+				ctx := WithUser(r.Context(), u)
+				r = r.WithContext(context.WithValue(ctx, sessionKey{}, sess))
+			} else {
+				sess = nil
+			}
+		}
+
+		if sess != nil && !safeMethod(r.Method) && r.URL.Path != LoginPath {
+			token := csrfToken(r)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(sess.csrfToken)) != 1 {
+				slog.WarnContext(r.Context(), "Rejected request with invalid CSRF token", "method", r.Method, "path", r.URL.Path, "user", sess.username)
+				deny(w, r, http.StatusForbidden, "Invalid or missing CSRF token. Reload the page and try again.")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the CSRF token sent with r. HTMX sends the header; plain
+// forms carry a hidden field, read only from URL-encoded bodies. Multipart
+// bodies are left for the handler to parse once it has capped their size,
+// so multipart requests must send the header.
+func csrfToken(r *http.Request) string {
+	if token := r.Header.Get("X-CSRF-Token"); token != "" {
+		return token
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return ""
+	}
+	return r.PostFormValue("csrf_token")
+}
+
+// Require allows only signed-in users holding one of roles. With no roles
+// any signed-in user is allowed.
+func (s *Service) Require(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFrom(r.Context())
+		if !ok {
+			loginURL := LoginPath
+			if r.Method == http.MethodGet {
+				loginURL += "?next=" + url.QueryEscape(r.URL.RequestURI())
+			}
+			if r.Header.Get("HX-Request") != "" {
+				// Send the whole page to the login form rather than swapping it in
+				w.Header().Set("HX-Redirect", loginURL)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodGet && !isAPIRequest(r) {
+				http.Redirect(w, r, loginURL, http.StatusSeeOther)
+				return
+			}
+			deny(w, r, http.StatusUnauthorized, "Sign in required")
+			return
+		}
+
+		if len(roles) > 0 && !u.HasRole(roles...) {
+			slog.WarnContext(r.Context(), "Denied request without the required role", "method", r.Method, "path", r.URL.Path, "user", u.Username, "roles", roles)
+			deny(w, r, http.StatusForbidden, "You do not have permission to do this")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// ShowLogin handles GET /login
+func (s *Service) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	s.render(w, "login.html", map[string]any{
+		"Next": safeNext(r.FormValue("next")),
+		"SSO":  s.ssoName(),
+	})
+}
+
+// Login handles POST /login
+func (s *Service) Login(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	next := safeNext(r.FormValue("next"))
+
+	if wait, ok := s.allowLogin(r, username); !ok {
+		slog.WarnContext(r.Context(), "Throttled sign-in", "user", username, "remote_addr", r.RemoteAddr)
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+		w.WriteHeader(http.StatusTooManyRequests)
+		s.render(w, "login.html", map[string]any{
+			"Next":     next,
+			"Username": username,
+			"Error":    "Too many sign-in attempts. Wait a minute and try again.",
+			"SSO":      s.ssoName(),
+		})
+		return
+	}
+
+	u, err := s.Users.Authenticate(username, r.FormValue("password"))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Failed sign-in", "user", username, "remote_addr", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "login.html", map[string]any{
+			"Next":     next,
+			"Username": username,
+			"Error":    "Invalid username or password",
+			"SSO":      s.ssoName(),
+		})
+		return
+	}
+
+	// A fresh session on every sign-in prevents session fixation
+	if old := s.session(r); old != nil {
+		s.sessions.delete(old.id)
+	}
+	sess := s.sessions.create(u.Username)
+	setCookies(w, r, sess, s.secureCookies)
+	slog.InfoContext(r.Context(), "User signed in", "user", u.Username)
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// allowLogin takes a sign-in attempt from the client IP's and the username's
+// budgets. When either is spent it returns false and how long to wait.
+func (s *Service) allowLogin(r *http.Request, username string) (time.Duration, bool) {
+	okIP, waitIP := s.loginIPs.Allow(ratelimit.ClientIP(r))
+	okUser, waitUser := s.loginUsers.Allow(normalizeUsername(username))
+	return max(waitIP, waitUser), okIP && okUser
+}
+
+// Logout handles POST /logout
+// Single sign-on sessions are also ended at the identity provider when it
+// supports RP-initiated logout
+func (s *Service) Logout(w http.ResponseWriter, r *http.Request) {
+	target := LoginPath
+	if sess, ok := r.Context().Value(sessionKey{}).(*session); ok {
+		s.sessions.delete(sess.id)
+		slog.InfoContext(r.Context(), "User signed out", "user", sess.username)
+		if sess.external != nil && s.oidc != nil {
+			if u := s.oidc.logoutURL(sess.idToken); u != "" {
+				target = u
+			}
+		}
+	}
+	clearCookies(w)
+
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Set("HX-Redirect", target)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// ShowUsers handles GET /admin/users
+func (s *Service) ShowUsers(w http.ResponseWriter, r *http.Request) {
+	s.renderUsers(w, "", "")
+}
+
+// CreateUser handles POST /admin/users
+func (s *Service) CreateUser(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	err := s.Users.Add(username, r.FormValue("name"), r.FormValue("password"), r.Form["roles"])
+	if err != nil {
+		s.renderUsers(w, "", userErrorMessage(err))
+		return
+	}
+	slog.InfoContext(r.Context(), "User created", "user", normalizeUsername(username), "by", currentUsername(r))
+	s.renderUsers(w, "Created user "+normalizeUsername(username), "")
+}
+
+// UpdateUser handles POST /admin/users/{username}
+// The action field selects a password reset, a role change or enabling and
+// disabling the account. Changes end the user's sessions.
+func (s *Service) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := normalizeUsername(r.PathValue("username"))
+
+	var err error
+	switch r.FormValue("action") {
+	case "password":
+		err = s.Users.SetPassword(username, r.FormValue("password"))
+	case "roles":
+		if username == currentUsername(r) && !slices.Contains(r.Form["roles"], RoleAdmin) {
+			err = errors.New("you cannot remove your own admin role")
+		} else {
+			err = s.Users.SetRoles(username, r.Form["roles"])
+		}
+	case "disable":
+		if username == currentUsername(r) {
+			err = errors.New("you cannot disable your own account")
+		} else {
+			err = s.Users.SetDisabled(username, true)
+		}
+	case "enable":
+		err = s.Users.SetDisabled(username, false)
+	default:
+		err = errors.New("unknown action")
+	}
+	if err != nil {
+		s.renderUsers(w, "", userErrorMessage(err))
+		return
+	}
+
+	if username != currentUsername(r) {
+		s.sessions.deleteUser(username)
+	}
+	slog.InfoContext(r.Context(), "User updated", "user", username, "action", r.FormValue("action"), "by", currentUsername(r))
+	s.renderUsers(w, "Updated user "+username, "")
+}
+
+func (s *Service) renderUsers(w http.ResponseWriter, message, errMessage string) {
+	if errMessage != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	s.render(w, "users.html", map[string]any{
+		"Users":   s.Users.List(),
+		"Roles":   Roles,
+		"Message": message,
+		"Error":   errMessage,
+	})
+}
+
+// renderLoginError shows the login page with an error
+func (s *Service) renderLoginError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	s.render(w, "login.html", map[string]any{
+		"Next":  "/",
+		"Error": message,
+		"SSO":   s.ssoName(),
+	})
+}
+
+// ssoName returns the single sign-on provider's name, or "" when disabled
+func (s *Service) ssoName() string {
+	if s.oidc == nil {
+		return ""
+	}
+	return s.oidc.cfg.ProviderName
+}
+
+func (s *Service) render(w http.ResponseWriter, name string, data any) {
+	if err := s.templates.ExecuteTemplate(w, name, data); err != nil {
+		slog.Error("Error rendering template", "template", name, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// identity resolves the user of a session: the identity provider's user for
+// single sign-on, otherwise the still-enabled local account
+func (s *Service) identity(sess *session) (*User, bool) {
+	if sess.external != nil {
+		return sess.external, true
+	}
+	u, ok := s.Users.User(sess.username)
+	if !ok || u.Disabled {
+		return nil, false
+	}
+	return u, true
+}
+
+// session returns the live session named by the request's cookie
+func (s *Service) session(r *http.Request) *session {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	sess, ok := s.sessions.get(c.Value)
+	if !ok {
+		return nil
+	}
+	return sess
+}
+
+func currentUsername(r *http.Request) string {
+	if u, ok := UserFrom(r.Context()); ok {
+		return u.Username
+	}
+	return ""
+}
+
+func userErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrUserExists):
+		return "A user with that username already exists"
+	case errors.Is(err, ErrUserNotFound):
+		return "User not found"
+	}
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// safeNext keeps post-login redirects on this site
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// isAPIRequest reports whether a request comes from an API client rather
+// than a browser page, judged by the JSON it sends or asks for
+func isAPIRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "" &&
+		(strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") ||
+			strings.Contains(r.Header.Get("Accept"), "application/json"))
+}
+
+// deny writes an authentication or authorization failure, as JSON for API
+// clients and as plain text otherwise
+func deny(w http.ResponseWriter, r *http.Request, code int, message string) {
+	if isAPIRequest(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+		return
+	}
+	http.Error(w, message, code)
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
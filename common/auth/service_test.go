@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestService opens a service over a fresh user store
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	t.Setenv("AUTH_ADMIN_PASSWORD", "correct horse battery")
+	s, err := New(filepath.Join(t.TempDir(), "users.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// unreadBody fails the test if the middleware reads the request body
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("CSRF check read the request body")
+	return 0, io.EOF
+}
+
+func TestMiddlewareCSRF(t *testing.T) {
+	s := newTestService(t)
+	sess := s.sessions.create("admin")
+
+	tests := []struct {
+		name        string
+		contentType string
+		header      string
+		body        func(t *testing.T) io.Reader
+		want        int
+	}{
+		{
+			name:        "header",
+			contentType: "multipart/form-data; boundary=x",
+			header:      sess.csrfToken,
+			body:        func(t *testing.T) io.Reader { return unreadBody{t} },
+			want:        http.StatusOK,
+		},
+		{
+			name:        "multipart body is not parsed",
+			contentType: "multipart/form-data; boundary=x",
+			body:        func(t *testing.T) io.Reader { return unreadBody{t} },
+			want:        http.StatusForbidden,
+		},
+		{
+			name:        "form field",
+			contentType: "application/x-www-form-urlencoded",
+			body: func(t *testing.T) io.Reader {
+				return strings.NewReader(url.Values{"csrf_token": {sess.csrfToken}}.Encode())
+			},
+			want: http.StatusOK,
+		},
+		{
+			name:        "wrong token",
+			contentType: "application/x-www-form-urlencoded",
+			body:        func(t *testing.T) io.Reader { return strings.NewReader("csrf_token=guess") },
+			want:        http.StatusForbidden,
+		},
+	}
+
+	h := s.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/bulk/upload", tt.body(t))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.header != "" {
+				r.Header.Set("X-CSRF-Token", tt.header)
+			}
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: sess.id})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	t.Setenv("AUTH_LOGIN_USER_PER_MINUTE", "1")
+	t.Setenv("AUTH_LOGIN_BURST", "3")
+	s := newTestService(t)
+
+	login := func(username, remoteAddr string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {username}, "password": {"wrong password"}}
+		r := httptest.NewRequest(http.MethodPost, LoginPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		s.Login(w, r)
+		return w
+	}
+
+	// The username's budget holds across client IPs
+	for i := range 3 {
+		if w := login("admin", fmt.Sprintf("192.0.2.%d:1234", i+1)); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want 401", i+1, w.Code)
+		}
+	}
+	w := login("Admin", "192.0.2.9:1234")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Other usernames are still allowed
+	if w := login("clerk", "192.0.2.9:1234"); w.Code != http.StatusUnauthorized {
+		t.Errorf("other user: status = %d, want 401", w.Code)
+	}
+}
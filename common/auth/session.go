@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session lifetimes
+const (
+	SessionIdleTimeout = 30 * time.Minute
+	SessionMaxAge      = 12 * time.Hour
+)
+
+// Cookie names
+const (
+	sessionCookie = "session"
+	csrfCookie    = "csrf_token"
+)
+
+// session is a signed-in browser. The CSRF token is bound to it and mirrored
+// in a script-readable cookie that csrf.js sends back as a header.
+type session struct {
+	id        string
+	username  string
+	csrfToken string
+	createdAt time.Time
+	lastSeen  time.Time
+
+	// external is the identity of a single sign-on session, whose account
+	// lives at the identity provider rather than in the user store
+	external *User
+	idToken  string
+}
+
+func (s *session) expired(now time.Time) bool {
+	return now.Sub(s.lastSeen) > SessionIdleTimeout || now.Sub(s.createdAt) > SessionMaxAge
+}
+
+// sessions is the in-memory session table. Restarting the app signs
+// everyone out.
+type sessions struct {
+	mu   sync.Mutex
+	byID map[string]*session
+}
+
+func newSessions() *sessions {
+	return &sessions{byID: make(map[string]*session)}
+}
+
+// create starts a session for a local account
+func (s *sessions) create(username string) *session {
+	return s.add(newSession(username))
+}
+
+// createExternal starts a single sign-on session for an identity provider user
+func (s *sessions) createExternal(u *User, idToken string) *session {
+	sess := newSession(u.Username)
+	sess.external = u
+	sess.idToken = idToken
+	return s.add(sess)
+}
+
+func (s *sessions) add(sess *session) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[sess.id] = sess
+	s.sweep(sess.createdAt)
+	return sess
+}
+
+func newSession(username string) *session {
+	now := time.Now()
+	return &session{
+		id:        randomToken(),
+		username:  username,
+		csrfToken: randomToken(),
+		createdAt: now,
+		lastSeen:  now,
+	}
+}
+
+// get returns a live session and marks it as used
+func (s *sessions) get(id string) (*session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.byID[id]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if sess.expired(now) {
+		delete(s.byID, id)
+		return nil, false
+	}
+	sess.lastSeen = now
+	c := *sess
+	return &c, true
+}
+
+func (s *sessions) delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byID, id)
+}
+
+// deleteUser ends every session of a local account, after a password change
+// or when the account is disabled
+func (s *sessions) deleteUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, sess := range s.byID {
+		if sess.external == nil && sess.username == username {
+			delete(s.byID, id)
+		}
+	}
+}
+
+// sweep drops expired sessions. Callers must hold s.mu.
+func (s *sessions) sweep(now time.Time) {
+	for id, sess := range s.byID {
+		if sess.expired(now) {
+			delete(s.byID, id)
+		}
+	}
+}
+
+// setCookies writes the session and CSRF cookies
+func setCookies(w http.ResponseWriter, r *http.Request, sess *session, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    sess.id,
+		Path:     "/",
+		MaxAge:   int(SessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   secure || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    sess.csrfToken,
+		Path:     "/",
+		MaxAge:   int(SessionMaxAge.Seconds()),
+		Secure:   secure || r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearCookies removes the session and CSRF cookies
+func clearCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("auth: failed to read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
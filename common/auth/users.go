@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted for an account
+const MinPasswordLength = 10
+
+// User store errors
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// Users is a JSON-file backed store of accounts with bcrypt password hashes
+type Users struct {
+	path string
+
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// AdminPasswordFile is the file next to the user store that receives the
+// generated admin password
+const AdminPasswordFile = "admin-password.txt"
+
+// OpenUsers loads the user store at path. When the store is empty an admin
+// account is created, with the password from AUTH_ADMIN_PASSWORD or a
+// generated one written to AdminPasswordFile, readable by the owner only.
+// The password itself is never logged.
+func OpenUsers(path string) (*Users, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create user store directory: %w", err)
+	}
+
+	s := &Users{path: path, users: make(map[string]*User)}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read user store: %w", err)
+	}
+	if err == nil {
+		var list []*User
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, fmt.Errorf("failed to parse user store: %w", err)
+		}
+		for _, u := range list {
+			s.users[u.Username] = u
+		}
+	}
+
+	if len(s.users) == 0 {
+		password := os.Getenv("AUTH_ADMIN_PASSWORD")
+		generated := password == ""
+		if generated {
+			password = randomPassword()
+		}
+		if err := s.Add("admin", "Administrator", password, []string{RoleAdmin}); err != nil {
+			return nil, fmt.Errorf("failed to create admin account: %w", err)
+		}
+		if generated {
+			file := filepath.Join(filepath.Dir(path), AdminPasswordFile)
+			if err := writeSecret(file, password); err != nil {
+				return nil, fmt.Errorf("failed to write admin password: %w", err)
+			}
+			slog.Warn("Created admin account with a generated password; sign in, change it and delete the file", "username", "admin", "file", file)
+		}
+	}
+
+	return s, nil
+}
+
+// Authenticate checks a username and password and returns the account
+func (s *Users) Authenticate(username, password string) (*User, error) {
+	s.mu.RLock()
+	u, ok := s.users[normalizeUsername(username)]
+	s.mu.RUnlock()
+
+	if !ok || u.Disabled {
+		// Compare anyway so unknown users take as long as wrong passwords
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	c := *u
+	return &c, nil
+}
+
+// User returns a copy of the account with the given username
+func (s *Users) User(username string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[normalizeUsername(username)]
+	if !ok {
+		return nil, false
+	}
+	c := *u
+	return &c, true
+}
+
+// List returns copies of all accounts ordered by username
+func (s *Users) List() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		c := *u
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// Add creates an account
+func (s *Users) Add(username, name, password string, roles []string) error {
+	username = normalizeUsername(username)
+	if username == "" {
+		return fmt.Errorf("username is required")
+	}
+	if strings.Contains(username, ":") {
+		// Reserved for single sign-on users, such as oidc:<subject>
+		return fmt.Errorf("username may not contain ':'")
+	}
+	if err := validateRoles(roles); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return ErrUserExists
+	}
+	s.users[username] = &User{
+		Username:     username,
+		Name:         strings.TrimSpace(name),
+		PasswordHash: hash,
+		Roles:        roles,
+	}
+	return s.persist()
+}
+
+// SetPassword replaces an account's password
+func (s *Users) SetPassword(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.update(username, func(u *User) error {
+		u.PasswordHash = hash
+		return nil
+	})
+}
+
+// SetRoles replaces an account's roles
+func (s *Users) SetRoles(username string, roles []string) error {
+	if err := validateRoles(roles); err != nil {
+		return err
+	}
+	return s.update(username, func(u *User) error {
+		u.Roles = roles
+		return nil
+	})
+}
+
+// SetDisabled enables or disables an account
+func (s *Users) SetDisabled(username string, disabled bool) error {
+	return s.update(username, func(u *User) error {
+		u.Disabled = disabled
+		return nil
+	})
+}
+
+func (s *Users) update(username string, fn func(*User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[normalizeUsername(username)]
+	if !ok {
+		return ErrUserNotFound
+	}
+	c := *u
+	if err := fn(&c); err != nil {
+		return err
+	}
+	s.users[c.Username] = &c
+	if err := s.persist(); err != nil {
+		s.users[c.Username] = u
+		return err
+	}
+	return nil
+}
+
+// persist atomically rewrites the user file. Callers must hold s.mu.
+func (s *Users) persist() error {
+	list := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode user store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write user store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace user store: %w", err)
+	}
+	return nil
+}
+
+// dummyHash is compared against when a username is unknown
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unused password"), bcrypt.DefaultCost)
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func validateRoles(roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("at least one role is required")
+	}
+	for _, role := range roles {
+		if !slices.Contains(Roles, role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func randomPassword() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Error generating admin password:", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeSecret writes secret to a new file only its owner can read,
+// replacing any file left from an earlier store
+func writeSecret(path, secret string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(secret + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
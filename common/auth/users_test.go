@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedAdminPassword(t *testing.T) {
+	t.Setenv("AUTH_ADMIN_PASSWORD", "")
+	dir := t.TempDir()
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	users, err := OpenUsers(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, AdminPasswordFile)
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("password file mode = %o, want 600", perm)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := strings.TrimSpace(string(data))
+	if _, err := users.Authenticate("admin", password); err != nil {
+		t.Errorf("admin cannot sign in with the written password: %v", err)
+	}
+	if strings.Contains(logs.String(), password) {
+		t.Errorf("password logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,145 @@
+// Package geo validates farmer locations against an embedded dataset of
+// Kenya's 47 counties and their sub-counties, for the issuer and the farmer
+// credential service. Wards are out of scope: credentials record the county
+// and sub-county only, and the dataset stops there.
+package geo
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+//go:embed kenya_counties.json
+var countiesJSON []byte
+
+// County is one of Kenya's 47 counties
+type County struct {
+	Code        string      `json:"code"`
+	Name        string      `json:"name"`
+	Region      string      `json:"region"`
+	Aliases     []string    `json:"aliases,omitempty"`
+	SubCounties []SubCounty `json:"subCounties"`
+}
+
+// SubCounty is an administrative sub-county within a county
+type SubCounty struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Directory looks up counties and sub-counties by code, name or spelling variant
+type Directory struct {
+	Counties []County
+
+	byKey map[string]*County
+}
+
+// Default is the directory built from the embedded dataset
+var Default = mustLoad(countiesJSON)
+
+func mustLoad(data []byte) *Directory {
+	d, err := Load(data)
+	if err != nil {
+		log.Fatal("Error loading county dataset:", err)
+	}
+	return d
+}
+
+// Load builds a directory from the JSON county dataset
+func Load(data []byte) (*Directory, error) {
+	var dataset struct {
+		Counties []County `json:"counties"`
+	}
+	if err := json.Unmarshal(data, &dataset); err != nil {
+		return nil, fmt.Errorf("failed to parse county dataset: %w", err)
+	}
+
+	d := &Directory{
+		Counties: dataset.Counties,
+		byKey:    make(map[string]*County),
+	}
+	for i := range d.Counties {
+		county := &d.Counties[i]
+		d.byKey[county.Code] = county
+		d.byKey[normalize(county.Name)] = county
+		for _, alias := range county.Aliases {
+			d.byKey[normalize(alias)] = county
+		}
+	}
+
+	return d, nil
+}
+
+// County finds a county by its code ("032"), name or a known spelling variant
+func (d *Directory) County(id string) (*County, bool) {
+	key := strings.TrimSpace(id)
+	if county, ok := d.byKey[key]; ok {
+		return county, true
+	}
+	if len(key) > 0 && len(key) < 3 && strings.Trim(key, "0123456789") == "" {
+		if county, ok := d.byKey[strings.Repeat("0", 3-len(key))+key]; ok {
+			return county, true
+		}
+	}
+
+	county, ok := d.byKey[normalize(key)]
+	return county, ok
+}
+
+// SubCounty finds a sub-county of the county by name or spelling variant
+func (c *County) SubCounty(name string) (*SubCounty, bool) {
+	key := normalize(name)
+	for i := range c.SubCounties {
+		sub := &c.SubCounties[i]
+		if normalize(sub.Name) == key {
+			return sub, true
+		}
+		for _, alias := range sub.Aliases {
+			if normalize(alias) == key {
+				return sub, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Resolve validates a county/sub-county pair and returns their canonical
+// spellings. An empty subCounty is accepted and returned as is.
+func (d *Directory) Resolve(county, subCounty string) (string, string, error) {
+	c, ok := d.County(county)
+	if !ok {
+		return "", "", fmt.Errorf("unknown county: %q", county)
+	}
+	if strings.TrimSpace(subCounty) == "" {
+		return c.Name, "", nil
+	}
+
+	sub, ok := c.SubCounty(subCounty)
+	if !ok {
+		return "", "", fmt.Errorf("unknown sub-county %q in %s county", subCounty, c.Name)
+	}
+	return c.Name, sub.Name, nil
+}
+
+// normalize folds case, punctuation and a trailing "county"/"city" so that
+// e.g. "Murang'a", "MURANGA" and "Muranga County" compare equal
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	key := b.String()
+	for _, suffix := range []string{"county", "city"} {
+		if trimmed := strings.TrimSuffix(key, suffix); trimmed != "" {
+			key = trimmed
+		}
+	}
+	return key
+}
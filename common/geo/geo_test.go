@@ -0,0 +1,73 @@
+package geo
+
+import "testing"
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		county, subCounty string
+		wantCounty        string
+		wantSubCounty     string
+		wantErr           bool
+	}{
+		{county: "032", subCounty: "Njoro", wantCounty: "Nakuru", wantSubCounty: "Njoro"},
+		{county: "32", wantCounty: "Nakuru"},
+		{county: "1", wantCounty: "Mombasa"},
+		{county: " nakuru county ", subCounty: "NAIVASHA", wantCounty: "Nakuru", wantSubCounty: "Naivasha"},
+		{county: "MURANGA", wantCounty: "Murang'a"},
+		{county: "Murang’a County", wantCounty: "Murang'a"},
+		{county: "Nairobi City", subCounty: "Lang'ata", wantCounty: "Nairobi", wantSubCounty: "Langata"},
+		{county: "Tharaka Nithi", subCounty: "chuka", wantCounty: "Tharaka-Nithi", wantSubCounty: "Chuka/Igambang'ombe"},
+		{county: "Eldoret", subCounty: "  ", wantCounty: "Uasin Gishu"},
+		{county: "Nakuru", subCounty: "Westlands", wantErr: true},
+		{county: "Atlantis", wantErr: true},
+		{county: "048", wantErr: true},
+		{county: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.county+"/"+tt.subCounty, func(t *testing.T) {
+			county, subCounty, err := Default.Resolve(tt.county, tt.subCounty)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Resolve = %q, %q, want an error", county, subCounty)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if county != tt.wantCounty || subCounty != tt.wantSubCounty {
+				t.Errorf("Resolve = %q, %q, want %q, %q", county, subCounty, tt.wantCounty, tt.wantSubCounty)
+			}
+		})
+	}
+}
+
+// TestDataset checks that every code, name and alias names a single county
+func TestDataset(t *testing.T) {
+	if n := len(Default.Counties); n != 47 {
+		t.Errorf("%d counties, want 47", n)
+	}
+	seen := map[string]string{}
+	for _, c := range Default.Counties {
+		if len(c.SubCounties) == 0 {
+			t.Errorf("%s has no sub-counties", c.Name)
+		}
+		keys := []string{c.Code}
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			keys = append(keys, normalize(name))
+		}
+		for _, key := range keys {
+			if other, ok := seen[key]; ok && other != c.Name {
+				t.Errorf("%q names both %s and %s", key, other, c.Name)
+			}
+			seen[key] = c.Name
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load([]byte(`{"counties":`)); err == nil {
+		t.Error("Load succeeded on truncated JSON, want an error")
+	}
+}
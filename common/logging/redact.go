@@ -0,0 +1,132 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Mask replaces redacted values
+const Mask = "[REDACTED]"
+
+// secretKeys are masked wherever they appear, as attribute keys or as
+// fields of logged bodies. Keys are compared case-insensitively.
+var secretKeys = keySet(
+	"password", "secret", "client_secret", "token", "access_token", "id_token",
+	"csrf_token", "authorization", "cookie", "pin", "txcodevalue", "code",
+	"x-api-key", "phoneVerificationToken",
+)
+
+// privateJWKKeys are the private parameters of a JSON Web Key
+var privateJWKKeys = keySet("d", "p", "q", "dp", "dq", "qi", "k")
+
+var (
+	piiMu sync.RWMutex
+	// piiFields are the fields of each credential type that identify a
+	// person; allPII is their union, for bodies of unknown type
+	piiFields = map[string]map[string]bool{}
+	allPII    = map[string]bool{}
+)
+
+// RegisterPII declares the fields of credentialType that identify a person,
+// in every shape its bodies take. Whole objects such as addresses can be
+// named. Services register their credential types before logging bodies.
+func RegisterPII(credentialType string, fields ...string) {
+	piiMu.Lock()
+	defer piiMu.Unlock()
+
+	set := piiFields[credentialType]
+	if set == nil {
+		set = map[string]bool{}
+		piiFields[credentialType] = set
+	}
+	for _, f := range fields {
+		set[strings.ToLower(f)] = true
+		allPII[strings.ToLower(f)] = true
+	}
+}
+
+func keySet(keys ...string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[strings.ToLower(k)] = true
+	}
+	return set
+}
+
+// maskSecretAttr masks attributes named after secrets
+func maskSecretAttr(groups []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Mask)
+	}
+	return a
+}
+
+// Body wraps a request or response body for logging with the personal data
+// of credentialType and any secrets masked. An unknown or empty
+// credentialType masks the personal data of every type. body may be a
+// value, raw JSON bytes or a JSON string; anything that is not JSON is
+// replaced by its size.
+func Body(credentialType string, body any) slog.LogValuer {
+	return redactedBody{credentialType, body}
+}
+
+type redactedBody struct {
+	credentialType string
+	body           any
+}
+
+func (b redactedBody) LogValue() slog.Value {
+	var raw []byte
+	switch v := b.body.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		var err error
+		if raw, err = json.Marshal(v); err != nil {
+			return slog.StringValue(fmt.Sprintf("[unloggable %T]", v))
+		}
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return slog.StringValue(fmt.Sprintf("[%d bytes]", len(raw)))
+	}
+
+	piiMu.RLock()
+	defer piiMu.RUnlock()
+	pii, ok := piiFields[b.credentialType]
+	if !ok {
+		pii = allPII
+	}
+	masked, _ := json.Marshal(redact(doc, pii))
+	return slog.AnyValue(json.RawMessage(masked))
+}
+
+// redact masks the pii fields, secrets and private key material in doc
+func redact(doc any, pii map[string]bool) any {
+	switch v := doc.(type) {
+	case map[string]any:
+		_, isJWK := v["kty"]
+		for k, val := range v {
+			key := strings.ToLower(k)
+			if pii[key] || secretKeys[key] || (isJWK && privateJWKKeys[key]) {
+				v[k] = Mask
+				continue
+			}
+			v[k] = redact(val, pii)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = redact(v[i], pii)
+		}
+		return v
+	default:
+		return v
+	}
+}
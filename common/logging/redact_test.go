@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func init() {
+	RegisterPII("Farmer", "given_name", "phone")
+	RegisterPII("PDA1", "surname", "stateOfResidenceAddress")
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		credentialType string
+		body           any
+		want           string
+	}{
+		{
+			name:           "registered type",
+			credentialType: "Farmer",
+			body:           map[string]any{"given_name": "Amina", "surname": "Otieno", "county": "Nakuru"},
+			want:           `{"county":"Nakuru","given_name":"[REDACTED]","surname":"Otieno"}`,
+		},
+		{
+			name:           "unknown type masks every registered field",
+			credentialType: "",
+			body:           map[string]any{"given_name": "Amina", "surname": "Otieno", "county": "Nakuru"},
+			want:           `{"county":"Nakuru","given_name":"[REDACTED]","surname":"[REDACTED]"}`,
+		},
+		{
+			name:           "whole objects and nested fields",
+			credentialType: "PDA1",
+			body:           `{"credentialSubject":{"stateOfResidenceAddress":{"town":"Nakuru"},"section1":{"surname":"Otieno"}}}`,
+			want:           `{"credentialSubject":{"section1":{"surname":"[REDACTED]"},"stateOfResidenceAddress":"[REDACTED]"}}`,
+		},
+		{
+			name:           "secrets and private keys",
+			credentialType: "Farmer",
+			body:           []byte(`{"TxCodeValue":"1234","issuerKey":{"jwk":{"kty":"OKP","d":"private","x":"public"}}}`),
+			want:           `{"TxCodeValue":"[REDACTED]","issuerKey":{"jwk":{"d":"[REDACTED]","kty":"OKP","x":"public"}}}`,
+		},
+		{
+			name:           "not JSON",
+			credentialType: "Farmer",
+			body:           "<html>Bad Gateway</html>",
+			want:           `"[24 bytes]"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(Body(tt.credentialType, tt.body).LogValue().Any())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package metrics exposes Prometheus metrics: HTTP server traffic, walt.id
+// calls and request outcomes by credential type. Labels only take values
+// from fixed sets (methods, route patterns, credential types, outcomes) so
+// the number of series stays small.
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path is where the metrics are served
+const Path = "/metrics"
+
+// Outcomes of a request to walt.id
+const (
+	OutcomeSuccess  = "success"  // walt.id returned an offer or presentation request
+	OutcomeRejected = "rejected" // walt.id answered with an error status
+	OutcomeError    = "error"    // walt.id could not be reached or the request could not be built
+	OutcomeBusy     = "busy"     // no walt.id slot became free in time
+
+	OutcomeUnavailable = "unavailable" // the walt.id circuit breaker was open
+)
+
+// OtherCredentialType labels credential types outside an Outcomes counter's set
+const OtherCredentialType = "other"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by method, route pattern and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests, by method and route pattern.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests being served.",
+	})
+)
+
+// Outcomes counts requests to walt.id by credential type and outcome
+type Outcomes struct {
+	counter         *prometheus.CounterVec
+	credentialTypes []string
+}
+
+// NewOutcomes registers a counter of requests by credential type and
+// outcome. Types outside credentialTypes are counted as
+// OtherCredentialType.
+func NewOutcomes(opts prometheus.CounterOpts, credentialTypes ...string) *Outcomes {
+	o := &Outcomes{
+		counter:         promauto.NewCounterVec(opts, []string{"credential_type", "outcome"}),
+		credentialTypes: credentialTypes,
+	}
+	// Start every series at zero so rates and alerts work before the
+	// first request of each kind
+	for _, credentialType := range append(slices.Clone(credentialTypes), OtherCredentialType) {
+		for _, outcome := range []string{OutcomeSuccess, OutcomeRejected, OutcomeError, OutcomeBusy, OutcomeUnavailable} {
+			o.counter.WithLabelValues(credentialType, outcome)
+		}
+	}
+	return o
+}
+
+// Count counts a request for credentialType
+func (o *Outcomes) Count(credentialType, outcome string) {
+	if !slices.Contains(o.credentialTypes, credentialType) {
+		credentialType = OtherCredentialType
+	}
+	o.counter.WithLabelValues(credentialType, outcome).Inc()
+}
+
+// WaltID records the calls of a walt.id client. It implements
+// waltid.Observer.
+type WaltID struct {
+	duration    *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	retries     prometheus.Counter
+	circuitOpen prometheus.Gauge
+}
+
+// NewWaltID registers the walt.id call metrics of a service under
+// namespace. calls names the calls in help texts, such as "issuance".
+func NewWaltID(namespace, calls string) *WaltID {
+	return &WaltID{
+		duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "waltid_request_duration_seconds",
+			Help:      "Latency of walt.id " + calls + " calls, by outcome.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"outcome"}),
+		inFlight: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "waltid_requests_in_flight",
+			Help:      "walt.id " + calls + " calls in progress.",
+		}),
+		retries: promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "waltid_retries_total",
+			Help:      "walt.id " + calls + " calls retried after a transient failure.",
+		}),
+		circuitOpen: promauto.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "waltid_circuit_open",
+			Help:      "1 while the walt.id circuit breaker is failing calls fast, otherwise 0.",
+		}),
+	}
+}
+
+// Call marks a walt.id call as in flight. The returned function ends it,
+// recording its latency under outcome.
+func (m *WaltID) Call() func(outcome string) {
+	start := time.Now()
+	m.inFlight.Inc()
+	return func(outcome string) {
+		m.inFlight.Dec()
+		m.duration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Retry counts a retried walt.id call
+func (m *WaltID) Retry() {
+	m.retries.Inc()
+}
+
+// CircuitOpen records the state of the walt.id circuit breaker
+func (m *WaltID) CircuitOpen(open bool) {
+	if open {
+		m.circuitOpen.Set(1)
+	} else {
+		m.circuitOpen.Set(0)
+	}
+}
+
+// Middleware records HTTP server metrics for next. Requests are labelled
+// with the mux pattern that serves them, without its method, or
+// "unmatched", never with the raw path.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return RouteMiddleware(func(r *http.Request) string {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			return "unmatched"
+		}
+		return pattern[strings.IndexByte(pattern, '/'):]
+	}, next)
+}
+
+// RouteMiddleware records HTTP server metrics for next, labelling requests
+// with the route that serves them as returned by route. Methods other than
+// the standard ones are labelled "other", as the method comes from the
+// client.
+func RouteMiddleware(route func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, pattern := methodLabel(r.Method), route(r)
+
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpDuration.WithLabelValues(method, pattern).Observe(time.Since(start).Seconds())
+		httpRequests.WithLabelValues(method, pattern, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// methodLabel returns method if it is a standard HTTP method, or "other"
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// Handler serves the metrics. With a token, scrapers must send it as a
+// bearer token.
+func Handler(token string) http.Handler {
+	h := promhttp.Handler()
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the status code of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Flush keeps server-sent events streaming through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareMethodLabel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	h := Middleware(mux, mux)
+
+	tests := []struct {
+		method string
+		path   string
+		labels []string
+	}{
+		{method: http.MethodGet, path: "/items/42", labels: []string{"GET", "/items/{id}", "200"}},
+		{method: http.MethodDelete, path: "/items/42", labels: []string{"DELETE", "/items/{id}", "200"}},
+		{method: "PROPFIND", path: "/items/42", labels: []string{"other", "/items/{id}", "200"}},
+		{method: "X-RANDOM-1234", path: "/nowhere", labels: []string{"other", "unmatched", "404"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			before := testutil.ToFloat64(httpRequests.WithLabelValues(tt.labels...))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			if got := testutil.ToFloat64(httpRequests.WithLabelValues(tt.labels...)) - before; got != 1 {
+				t.Errorf("requests labelled %v grew by %v, want 1", tt.labels, got)
+			}
+		})
+	}
+}
+
+func TestOutcomesCount(t *testing.T) {
+	o := NewOutcomes(prometheus.CounterOpts{Name: "test_outcomes_total", Help: "Test."}, "PDA1", "Farmer")
+
+	// Every series starts at zero, including "other"
+	if n := testutil.CollectAndCount(o.counter); n != 3*5 {
+		t.Errorf("%d series, want 15", n)
+	}
+
+	o.Count("Farmer", OutcomeSuccess)
+	o.Count("Unexpected", OutcomeRejected)
+	if got := testutil.ToFloat64(o.counter.WithLabelValues("Farmer", OutcomeSuccess)); got != 1 {
+		t.Errorf("Farmer success = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(o.counter.WithLabelValues(OtherCredentialType, OutcomeRejected)); got != 1 {
+		t.Errorf("other rejected = %v, want 1", got)
+	}
+	if n := testutil.CollectAndCount(o.counter); n != 15 {
+		t.Errorf("%d series after counting, want still 15", n)
+	}
+}
@@ -0,0 +1,386 @@
+// Package openapi builds the app's OpenAPI 3.1 description, serves it with
+// a browsable docs page, and checks it against the routes the app actually
+// registers so that the two cannot drift apart.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Paths the description is served at
+const (
+	SpecPath = "/openapi.json"
+	DocsPath = "/docs"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// Schema is a JSON Schema 2020-12 object, the dialect of OpenAPI 3.1
+type Schema = map[string]any
+
+// Document is an OpenAPI 3.1 document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []SecurityRequirement `json:"security,omitempty"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Tag groups operations on the docs page
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps lower-case HTTP methods to operations
+type PathItem map[string]*Operation
+
+// SecurityRequirement maps security scheme names to required scopes
+type SecurityRequirement map[string][]string
+
+// Operation describes one method on one path
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []SecurityRequirement `json:"security,omitempty"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	Schema      Schema `json:"schema"`
+}
+
+// RequestBody describes the accepted request bodies by media type
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes one response status
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// Header describes a response header
+type Header struct {
+	Description string `json:"description,omitempty"`
+	Schema      Schema `json:"schema"`
+}
+
+// MediaType holds the schema of one content type
+type MediaType struct {
+	Schema Schema `json:"schema"`
+}
+
+// Components holds the named schemas and security schemes
+type Components struct {
+	Schemas         map[string]Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how callers authenticate
+type SecurityScheme struct {
+	Type        string      `json:"type"`
+	Description string      `json:"description,omitempty"`
+	Name        string      `json:"name,omitempty"`
+	In          string      `json:"in,omitempty"`
+	Scheme      string      `json:"scheme,omitempty"`
+	Flows       *OAuthFlows `json:"flows,omitempty"`
+}
+
+// OAuthFlows lists the OAuth 2.0 flows of an oauth2 security scheme
+type OAuthFlows struct {
+	ClientCredentials *OAuthFlow `json:"clientCredentials,omitempty"`
+}
+
+// OAuthFlow is one OAuth 2.0 flow and the scopes it grants
+type OAuthFlow struct {
+	TokenURL string            `json:"tokenUrl"`
+	Scopes   map[string]string `json:"scopes"`
+}
+
+// New starts an empty document
+func New(title, version, description string) *Document {
+	return &Document{
+		OpenAPI: "3.1.0",
+		Info:    Info{Title: title, Version: version, Description: description},
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas:         make(map[string]Schema),
+			SecuritySchemes: make(map[string]SecurityScheme),
+		},
+	}
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Add describes method on path. Path parameters the operation does not
+// declare itself are added as required strings.
+func (d *Document) Add(method, path string, op *Operation) {
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		declared := slices.ContainsFunc(op.Parameters, func(p Parameter) bool {
+			return p.In == "path" && p.Name == m[1]
+		})
+		if !declared {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name: m[1], In: "path", Required: true, Schema: Schema{"type": "string"},
+			})
+		}
+	}
+	if op.Responses == nil {
+		op.Responses = make(map[string]Response)
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = make(PathItem)
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = op
+}
+
+// AddSchema registers the schema of v's type under name and returns a
+// reference to it
+func (d *Document) AddSchema(name string, v any) Schema {
+	d.Components.Schemas[name] = SchemaOf(v)
+	return Ref(name)
+}
+
+// Ref refers to a named component schema
+func Ref(name string) Schema {
+	return Schema{"$ref": "#/components/schemas/" + name}
+}
+
+// JSON is the content of a JSON body with the given schema
+func JSON(s Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// Form is the content of a URL-encoded form with the given fields
+func Form(s Schema) map[string]MediaType {
+	return map[string]MediaType{"application/x-www-form-urlencoded": {Schema: s}}
+}
+
+// HTML is the content of an HTML page or fragment
+func HTML() map[string]MediaType {
+	return map[string]MediaType{"text/html": {Schema: Schema{"type": "string"}}}
+}
+
+// WithRequired returns s with its required properties replaced by fields.
+// Reflected schemas mark every field that is always encoded as required,
+// which suits responses; request schemas list what the handler enforces.
+func WithRequired(s Schema, fields ...string) Schema {
+	out := make(Schema, len(s))
+	for k, v := range s {
+		out[k] = v
+	}
+	delete(out, "required")
+	if len(fields) > 0 {
+		out["required"] = fields
+	}
+	return out
+}
+
+// SchemaOf reflects the JSON encoding of v's type into a schema. Embedded
+// structs are flattened like encoding/json does; fields without omitempty
+// are required since they are always encoded.
+func SchemaOf(v any) Schema {
+	return schemaFor(reflect.TypeOf(v))
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func schemaFor(t reflect.Type) Schema {
+	if t == nil {
+		return Schema{}
+	}
+	if t == timeType {
+		return Schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := Schema{}
+		var required []string
+		addStructFields(t, properties, &required)
+		s := Schema{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	default:
+		// interface{} and anything else: any JSON value
+		return Schema{}
+	}
+}
+
+func addStructFields(t reflect.Type, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructFields(ft, properties, required)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// Handler serves the document as JSON
+func (d *Document) Handler() http.HandlerFunc {
+	body, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		log.Fatalf("Error encoding OpenAPI document: %v", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(body)
+	}
+}
+
+// DocsHandler serves the docs page, which renders SpecPath
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// Check compares the document with registered ServeMux patterns, such as
+// "POST /api/v1/credentials/pda1" or "/verify-credential", and reports every
+// route missing from the document and every operation no route serves. A
+// pattern without a method serves any method on its path. Only paths for
+// which include returns true are compared.
+func (d *Document) Check(patterns []string, include func(path string) bool) error {
+	type route struct{ method, path string }
+	var routes []route
+	for _, p := range patterns {
+		method, path, found := strings.Cut(p, " ")
+		if !found {
+			method, path = "", p
+		}
+		path = strings.TrimSpace(path)
+		if include(path) {
+			routes = append(routes, route{strings.ToLower(method), path})
+		}
+	}
+
+	var problems []string
+	for _, rt := range routes {
+		item, ok := d.Paths[rt.path]
+		if !ok || (rt.method != "" && item[rt.method] == nil) {
+			problems = append(problems, fmt.Sprintf("route %s is not in the OpenAPI document", strings.TrimSpace(strings.ToUpper(rt.method)+" "+rt.path)))
+		}
+	}
+	for path, item := range d.Paths {
+		if !include(path) {
+			continue
+		}
+		for method := range item {
+			served := slices.ContainsFunc(routes, func(rt route) bool {
+				return rt.path == path && (rt.method == "" || rt.method == method)
+			})
+			if !served {
+				problems = append(problems, fmt.Sprintf("operation %s %s has no registered route", strings.ToUpper(method), path))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("OpenAPI document and routes differ:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Routes registers handlers on a ServeMux and remembers their patterns,
+// which a ServeMux cannot list, for Check
+type Routes struct {
+	mux      *http.ServeMux
+	patterns []string
+}
+
+// NewRoutes records registrations on mux
+func NewRoutes(mux *http.ServeMux) *Routes {
+	return &Routes{mux: mux}
+}
+
+// Handle registers handler for pattern
+func (rt *Routes) Handle(pattern string, handler http.Handler) {
+	rt.mux.Handle(pattern, handler)
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+// HandleFunc registers handler for pattern
+func (rt *Routes) HandleFunc(pattern string, handler http.HandlerFunc) {
+	rt.mux.HandleFunc(pattern, handler)
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+// Patterns returns the registered patterns
+func (rt *Routes) Patterns() []string {
+	return slices.Clone(rt.patterns)
+}
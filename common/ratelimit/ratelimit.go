@@ -0,0 +1,151 @@
+// Package ratelimit provides keyed token-bucket rate limits and a cap on
+// concurrent outbound calls, so that a misbehaving script cannot flood the
+// app or walt.id.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleTimeout is how long an unused bucket is kept before it is dropped
+const idleTimeout = 10 * time.Minute
+
+// Limiter applies a token bucket per key, such as a username or client IP
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// New creates a limiter allowing perMinute requests per key on average,
+// with bursts of up to burst requests
+func New(perMinute, burst int) *Limiter {
+	return &Limiter{
+		limit:     rate.Limit(float64(perMinute) / 60),
+		burst:     max(burst, 1),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > idleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// RetryAfter formats a delay for the Retry-After header in whole seconds
+func RetryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// ErrBusy is returned when no outbound call slot frees up in time
+var ErrBusy = errors.New("too many concurrent requests to the credential service")
+
+// Semaphore caps the number of concurrent outbound calls
+type Semaphore struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+// NewSemaphore allows n concurrent calls. Callers queue for up to wait
+// before giving up with ErrBusy.
+func NewSemaphore(n int, wait time.Duration) *Semaphore {
+	return &Semaphore{slots: make(chan struct{}, max(n, 1)), wait: wait}
+}
+
+// Acquire takes a slot; call Release when the call is done. It gives up
+// with ctx's error if the caller goes away while queued.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (s *Semaphore) Release() {
+	<-s.slots
+}
+
+// ClientIP returns the address of the caller. Behind a reverse proxy, set
+// TRUST_PROXY=true to use the last address in X-Forwarded-For, which is the
+// one the proxy saw.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			parts := strings.Split(xff, ",")
+			return strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// EnvInt reads a positive integer setting, falling back to def
+func EnvInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiterBurst(t *testing.T) {
+	// A token every 10ms after a burst of three
+	l := New(6000, 3)
+
+	for i := range 3 {
+		if ok, _ := l.Allow("amina"); !ok {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("amina")
+	if ok {
+		t.Fatal("request beyond the burst allowed")
+	}
+	if wait <= 0 || wait > 10*time.Millisecond {
+		t.Errorf("wait = %v, want up to 10ms", wait)
+	}
+	if ok, _ := l.Allow("john"); !ok {
+		t.Error("another key shares the exhausted bucket")
+	}
+
+	time.Sleep(wait)
+	if ok, _ := l.Allow("amina"); !ok {
+		t.Error("request refused after the bucket refilled")
+	}
+}
+
+func TestLimiterRefusalsTakeNoTokens(t *testing.T) {
+	l := New(6000, 1)
+	l.Allow("amina")
+	for range 10 {
+		l.Allow("amina")
+	}
+	// Refused requests do not push the next token further away
+	time.Sleep(10 * time.Millisecond)
+	if ok, wait := l.Allow("amina"); !ok {
+		t.Errorf("request refused for another %v after one token interval", wait)
+	}
+}
+
+func TestLimiterSweepsIdleBuckets(t *testing.T) {
+	l := New(60, 1)
+	l.Allow("amina")
+	l.Allow("john")
+
+	l.buckets["amina"].lastSeen = time.Now().Add(-2 * idleTimeout)
+	l.lastSweep = time.Now().Add(-2 * idleTimeout)
+	l.Allow("john")
+
+	if _, ok := l.buckets["amina"]; ok {
+		t.Error("idle bucket kept")
+	}
+	if _, ok := l.buckets["john"]; !ok {
+		t.Error("active bucket dropped")
+	}
+	// A swept key starts again with a full bucket
+	if ok, _ := l.Allow("amina"); !ok {
+		t.Error("request refused after the idle bucket was dropped")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{10 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+	for _, tt := range tests {
+		if got := RetryAfter(tt.d); got != tt.want {
+			t.Errorf("RetryAfter(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(1, 20*time.Millisecond)
+	ctx := context.Background()
+	if err := s.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if err := s.Acquire(ctx); !errors.Is(err, ErrBusy) {
+		t.Errorf("Acquire with no free slot = %v, want ErrBusy", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := s.Acquire(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire after the caller left = %v, want context.Canceled", err)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		s.Release()
+	}()
+	if err := s.Acquire(ctx); err != nil {
+		t.Errorf("Acquire while a slot is released = %v, want the slot", err)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trustProxy string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{name: "remote address", remoteAddr: "10.0.0.7:5123", want: "10.0.0.7"},
+		{name: "IPv6", remoteAddr: "[2001:db8::1]:5123", want: "2001:db8::1"},
+		{name: "no port", remoteAddr: "10.0.0.7", want: "10.0.0.7"},
+		{name: "untrusted proxy", remoteAddr: "10.0.0.7:5123", xff: "203.0.113.9", want: "10.0.0.7"},
+		{name: "trusted proxy", trustProxy: "true", remoteAddr: "10.0.0.7:5123", xff: "198.51.100.1, 203.0.113.9", want: "203.0.113.9"},
+		{name: "trusted proxy without header", trustProxy: "true", remoteAddr: "10.0.0.7:5123", want: "10.0.0.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUST_PROXY", tt.trustProxy)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvInt(t *testing.T) {
+	for value, want := range map[string]int{"": 5, "12": 12, "0": 5, "-3": 5, "ten": 5} {
+		t.Setenv("RATE_LIMIT_TEST", value)
+		if got := EnvInt("RATE_LIMIT_TEST", 5); got != want {
+			t.Errorf("EnvInt(%q) = %d, want %d", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// HealthPath serves the full dependency report
+const HealthPath = "/healthz"
+
+const (
+	// checkTimeout bounds each dependency check
+	checkTimeout = 3 * time.Second
+	// checkCacheTTL is how long a report is reused, so that frequent probes
+	// from several monitors do not each reach walt.id
+	checkCacheTTL = 5 * time.Second
+)
+
+// Check is a dependency probed by /healthz and /readyz
+type Check struct {
+	Name string
+	// Local checks cover what this instance needs of its own: templates,
+	// storage and keys. A failing local check makes the instance unready. A
+	// failing remote check, such as walt.id, only marks it degraded: every
+	// instance shares the dependency, so taking them all out of rotation
+	// would turn friendly error pages into connection errors.
+	Local bool
+	Run   func(ctx context.Context) error
+}
+
+// Report statuses; a single check is either ok or failed
+const (
+	StatusOK        = "ok"
+	StatusFailed    = "failed"
+	StatusDegraded  = "degraded"  // a remote dependency is failing
+	StatusUnhealthy = "unhealthy" // a local dependency is failing
+)
+
+// CheckResult is the outcome of one check
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Local     bool    `json:"local"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the JSON body of /healthz and /readyz
+type Report struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks"`
+}
+
+// Health runs the checks concurrently and caches the report briefly
+type Health struct {
+	checks []Check
+
+	mu     sync.Mutex
+	report Report
+}
+
+// NewHealth returns the health of the dependencies checks probe
+func NewHealth(checks ...Check) *Health {
+	return &Health{checks: checks}
+}
+
+// Report returns a recent report, checking every dependency if the last
+// one is older than checkCacheTTL. Concurrent probes wait for one run.
+func (h *Health) Report() Report {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.report.CheckedAt) < checkCacheTTL {
+		return h.report
+	}
+
+	report := Report{Status: StatusOK, CheckedAt: time.Now(), Checks: make(map[string]CheckResult, len(h.checks))}
+	results := make([]CheckResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.Run(ctx)
+			results[i] = CheckResult{Status: StatusOK, Local: c.Local, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				results[i].Status = StatusFailed
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range h.checks {
+		report.Checks[c.Name] = results[i]
+		switch {
+		case results[i].Error == "":
+		case c.Local:
+			report.Status = StatusUnhealthy
+		case report.Status == StatusOK:
+			report.Status = StatusDegraded
+		}
+	}
+	h.report = report
+	return report
+}
+
+// serveHealthz reports every dependency: 200 when all pass, 503 otherwise,
+// for monitoring and alerts
+func (h *Health) serveHealthz(w http.ResponseWriter) {
+	report := h.Report()
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+// serveReadyz reports the same dependencies, but only fails when a local
+// check fails or the server is shutting down
+func (h *Health) serveReadyz(w http.ResponseWriter, ready bool) {
+	if !ready {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	report := h.Report()
+	code := http.StatusOK
+	if report.Status == StatusUnhealthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// probeClient makes the reachability checks. It does not follow redirects,
+// since any answer shows the service is up.
+var probeClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// HTTPCheck checks that url answers. Any status below 500 counts, since
+// walt.id services answer their root path with a redirect or 404.
+func HTTPCheck(url string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := probeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// DirCheck checks that files can be written to dir
+func DirCheck(dir string) func(context.Context) error {
+	return func(context.Context) error {
+		f, err := os.CreateTemp(dir, ".healthz-*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		_, err = f.WriteString("ok")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(name); err == nil {
+			err = rerr
+		}
+		return err
+	}
+}
+
+// TemplatesCheck checks that the templates matching pattern parse
+func TemplatesCheck(pattern string) func(context.Context) error {
+	return func(context.Context) error {
+		_, err := template.ParseGlob(pattern)
+		return err
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func passing(context.Context) error { return nil }
+func failing(context.Context) error { return errors.New("down") }
+
+func TestProbeStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []Check
+		shutdown   bool
+		wantStatus string
+		wantHealth int
+		wantReady  int
+	}{
+		{
+			name:       "all passing",
+			checks:     []Check{{Name: "waltid", Run: passing}, {Name: "storage", Local: true, Run: passing}},
+			wantStatus: StatusOK,
+			wantHealth: http.StatusOK,
+			wantReady:  http.StatusOK,
+		},
+		{
+			name:       "remote failing",
+			checks:     []Check{{Name: "waltid", Run: failing}, {Name: "storage", Local: true, Run: passing}},
+			wantStatus: StatusDegraded,
+			wantHealth: http.StatusServiceUnavailable,
+			wantReady:  http.StatusOK,
+		},
+		{
+			name:       "local failing",
+			checks:     []Check{{Name: "waltid", Run: failing}, {Name: "storage", Local: true, Run: failing}},
+			wantStatus: StatusUnhealthy,
+			wantHealth: http.StatusServiceUnavailable,
+			wantReady:  http.StatusServiceUnavailable,
+		},
+		{
+			name:       "shutting down",
+			checks:     []Check{{Name: "storage", Local: true, Run: passing}},
+			shutdown:   true,
+			wantStatus: StatusOK,
+			wantHealth: http.StatusOK,
+			wantReady:  http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(Config{}, http.NotFoundHandler(), NewHealth(tt.checks...))
+			s.ready.Store(!tt.shutdown)
+
+			for path, want := range map[string]int{HealthPath: tt.wantHealth, ReadyPath: tt.wantReady, LivePath: http.StatusOK} {
+				w := httptest.NewRecorder()
+				s.http.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+				if w.Code != want {
+					t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+				}
+				if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+					t.Errorf("GET %s Cache-Control = %q, want no-store", path, cc)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			s.http.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, HealthPath, nil))
+			var report Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantStatus || len(report.Checks) != len(tt.checks) {
+				t.Errorf("report = %+v, want status %s with %d checks", report, tt.wantStatus, len(tt.checks))
+			}
+			for _, c := range tt.checks {
+				if got := report.Checks[c.Name]; got.Local != c.Local || (got.Error == "") != (got.Status == StatusOK) {
+					t.Errorf("check %s = %+v", c.Name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestReportCached(t *testing.T) {
+	var runs atomic.Int32
+	h := NewHealth(Check{Name: "waltid", Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}})
+
+	first := h.Report()
+	h.Report()
+	if n := runs.Load(); n != 1 {
+		t.Errorf("check ran %d times, want the report reused", n)
+	}
+
+	h.report.CheckedAt = first.CheckedAt.Add(-checkCacheTTL)
+	h.Report()
+	if n := runs.Load(); n != 2 {
+		t.Errorf("check ran %d times, want a stale report refreshed", n)
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusFound, false},
+		{http.StatusNotFound, false},
+		{http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/elsewhere", tt.status)
+		}))
+		if err := HTTPCheck(srv.URL)(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("status %d: HTTPCheck = %v, want error %v", tt.status, err, tt.wantErr)
+		}
+		srv.Close()
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	if err := HTTPCheck(srv.URL)(context.Background()); err == nil {
+		t.Error("HTTPCheck of a closed server succeeded")
+	}
+}
+
+func TestDirCheck(t *testing.T) {
+	dir := t.TempDir()
+	if err := DirCheck(dir)(context.Background()); err != nil {
+		t.Errorf("DirCheck: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("DirCheck left %d files behind", len(entries))
+	}
+	if err := DirCheck(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Error("DirCheck of a missing directory succeeded")
+	}
+}
+
+func TestTemplatesCheck(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ok.html"), []byte(`{{define "ok"}}ok{{end}}`), 0o600)
+	if err := TemplatesCheck(filepath.Join(dir, "*.html"))(context.Background()); err != nil {
+		t.Errorf("TemplatesCheck: %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{if}}`), 0o600)
+	if err := TemplatesCheck(filepath.Join(dir, "*.html"))(context.Background()); err == nil {
+		t.Error("TemplatesCheck of a broken template succeeded")
+	}
+}
@@ -0,0 +1,208 @@
+// Package server runs the HTTP server with production settings: timeouts
+// that keep slow clients from holding connections, liveness, readiness and
+// dependency health probes, optional TLS with certificate reload, and a
+// graceful shutdown on SIGINT or SIGTERM that lets in-flight requests and
+// walt.id calls finish.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+)
+
+// Probe paths. They and HealthPath are answered before any other
+// middleware, so they need no session and are neither logged nor counted.
+const (
+	LivePath  = "/livez"
+	ReadyPath = "/readyz"
+)
+
+// Config holds the server settings
+type Config struct {
+	Addr string
+
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration // whole request, including uploads
+	WriteTimeout      time.Duration // whole response, including the walt.id call behind it
+	IdleTimeout       time.Duration // keep-alive connections
+
+	// DrainDelay is how long /readyz fails before the listener closes, so
+	// that a load balancer stops sending traffic first
+	DrainDelay time.Duration
+	// ShutdownTimeout bounds waiting for in-flight requests and shutdown hooks
+	ShutdownTimeout time.Duration
+
+	// CertFile and KeyFile enable TLS. The files are reloaded when they
+	// change, so renewed certificates apply without a restart.
+	CertFile string
+	KeyFile  string
+}
+
+// ConfigFromEnv returns the settings for addr, read from
+// HTTP_READ_TIMEOUT_SECONDS, HTTP_WRITE_TIMEOUT_SECONDS,
+// HTTP_IDLE_TIMEOUT_SECONDS, SHUTDOWN_DRAIN_DELAY_SECONDS,
+// SHUTDOWN_TIMEOUT_SECONDS, TLS_CERT_FILE and TLS_KEY_FILE
+func ConfigFromEnv(addr string) Config {
+	return Config{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       envSeconds("HTTP_READ_TIMEOUT_SECONDS", 60),
+		WriteTimeout:      envSeconds("HTTP_WRITE_TIMEOUT_SECONDS", 90),
+		IdleTimeout:       envSeconds("HTTP_IDLE_TIMEOUT_SECONDS", 120),
+		DrainDelay:        envSeconds("SHUTDOWN_DRAIN_DELAY_SECONDS", 0),
+		ShutdownTimeout:   envSeconds("SHUTDOWN_TIMEOUT_SECONDS", 30),
+		CertFile:          os.Getenv("TLS_CERT_FILE"),
+		KeyFile:           os.Getenv("TLS_KEY_FILE"),
+	}
+}
+
+func envSeconds(name string, def int) time.Duration {
+	return time.Duration(ratelimit.EnvInt(name, def)) * time.Second
+}
+
+// Server is an HTTP server with probes and graceful shutdown
+type Server struct {
+	cfg    Config
+	http   *http.Server
+	ready  atomic.Bool
+	health *Health
+	hooks  []func(context.Context) error
+}
+
+// New creates a server for handler, reporting on health's checks at
+// /healthz and /readyz
+func New(cfg Config, handler http.Handler, health *Health) *Server {
+	s := &Server{cfg: cfg, health: health}
+	s.http = &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           s.probes(handler),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	return s
+}
+
+// OnShutdown registers f to run when the server shuts down, alongside the
+// wait for in-flight requests, for example to finish background jobs. ctx
+// expires at the end of the shutdown timeout.
+func (s *Server) OnShutdown(f func(ctx context.Context) error) {
+	s.hooks = append(s.hooks, f)
+}
+
+// Run serves until SIGINT or SIGTERM, then shuts down: /readyz fails, the
+// listener closes after the drain delay, and in-flight requests and the
+// shutdown hooks get until the shutdown timeout to finish. A second signal
+// stops the process at once. Run returns nil after a clean shutdown.
+func (s *Server) Run() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.serve(ctx, ln, stop)
+}
+
+// serve serves on ln until ctx is done, then shuts down. stop is called
+// once shutdown begins, so that Run's second signal is not caught.
+func (s *Server) serve(ctx context.Context, ln net.Listener, stop func()) error {
+	serve := func() error { return s.http.Serve(ln) }
+	useTLS := s.cfg.CertFile != "" || s.cfg.KeyFile != ""
+	if useTLS {
+		certs, err := newCertReloader(s.cfg.CertFile, s.cfg.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		s.http.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.GetCertificate}
+		serve = func() error { return s.http.ServeTLS(ln, "", "") }
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- serve() }()
+	s.ready.Store(true)
+	slog.Info("Listening", "addr", ln.Addr().String(), "tls", useTLS)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+	return s.shutdown()
+}
+
+// shutdown stops the server gracefully
+func (s *Server) shutdown() error {
+	slog.Info("Shutting down", "drain_delay", s.cfg.DrainDelay.String(), "timeout", s.cfg.ShutdownTimeout.String())
+	s.ready.Store(false)
+	time.Sleep(s.cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	run := func(f func(context.Context) error) {
+		defer wg.Done()
+		if err := f(ctx); err != nil {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+	}
+	wg.Add(1 + len(s.hooks))
+	go run(s.http.Shutdown)
+	for _, hook := range s.hooks {
+		go run(hook)
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Error("Shutdown did not finish cleanly", "error", err)
+		s.http.Close()
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+// probes answers the probes before handing other requests to next.
+// /livez succeeds while the process can serve requests. /healthz reports
+// every dependency check. /readyz reports the same but only fails when a
+// local check fails or shutdown has begun, as the signal to stop sending
+// traffic.
+func (s *Server) probes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LivePath:
+			writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+		case HealthPath:
+			s.health.serveHealthz(w)
+		case ReadyPath:
+			s.health.serveReadyz(w, s.ready.Load())
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// start serves handler on a local port until the returned cancel function
+// is called. It returns the server, its URL and the result of serve.
+func start(t *testing.T, cfg Config, handler http.Handler, health *Health) (*Server, string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(cfg, handler, health)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ctx, ln, func() {}) }()
+	t.Cleanup(cancel)
+
+	scheme := "http"
+	if cfg.CertFile != "" {
+		scheme = "https"
+	}
+	return s, scheme + "://" + ln.Addr().String(), cancel, done
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServeAndProbes(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "app")
+	})
+	_, url, cancel, done := start(t, Config{ShutdownTimeout: time.Second}, handler, NewHealth())
+
+	if code, body := get(t, http.DefaultClient, url+"/"); code != http.StatusOK || body != "app" {
+		t.Errorf("GET / = %d %q, want the handler's response", code, body)
+	}
+	for _, path := range []string{LivePath, HealthPath, ReadyPath} {
+		if code, _ := get(t, http.DefaultClient, url+path); code != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, code)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serve = %v, want a clean shutdown", err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		io.WriteString(w, "finished")
+	})
+	cfg := Config{DrainDelay: 100 * time.Millisecond, ShutdownTimeout: 2 * time.Second}
+	s, url, cancel, done := start(t, cfg, handler, NewHealth())
+
+	hookRan := make(chan struct{})
+	s.OnShutdown(func(context.Context) error {
+		close(hookRan)
+		return nil
+	})
+
+	// A request in flight when shutdown begins
+	inflight := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inflight <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inflight <- resp.Status + " " + string(body)
+	}()
+	<-entered
+
+	cancel()
+	// During the drain delay the listener is open but /readyz fails
+	time.Sleep(20 * time.Millisecond)
+	if code, _ := get(t, http.DefaultClient, url+ReadyPath); code != http.StatusServiceUnavailable {
+		t.Errorf("GET %s while draining = %d, want 503", ReadyPath, code)
+	}
+
+	close(release)
+	if got := <-inflight; got != "200 OK finished" {
+		t.Errorf("in-flight request = %q, want it to finish", got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve = %v, want a clean shutdown", err)
+	}
+	select {
+	case <-hookRan:
+	default:
+		t.Error("shutdown hook did not run")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	_, url, cancel, done := start(t, Config{ShutdownTimeout: 50 * time.Millisecond}, handler, NewHealth())
+
+	go http.Get(url + "/stuck")
+	<-entered
+	cancel()
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("serve = %v, want the shutdown timeout", err)
+	}
+}
+
+func TestServeMissingCertificate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Config{CertFile: "missing.pem", KeyFile: "missing-key.pem"}, http.NotFoundHandler(), NewHealth())
+	if err := s.serve(context.Background(), ln, func() {}); err == nil {
+		t.Error("serve started without its certificate")
+	}
+}
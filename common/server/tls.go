@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, at most
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves the certificate in certFile and keyFile and reloads it
+// when either file changes, as when certbot or cert-manager renews it
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // latest modification of the two files when loaded
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the
+// files have changed. A certificate that fails to load is logged and the
+// previous one kept, so a half-written renewal does not break TLS.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		modTime, err := r.modified()
+		switch {
+		case err != nil:
+			slog.Error("Error checking TLS certificate files", "error", err)
+		case !modTime.Equal(r.modTime):
+			if err := r.load(modTime); err != nil {
+				slog.Error("Error reloading TLS certificate; keeping the current one", "error", err)
+			} else {
+				slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// load reads the key pair, recording modTime as the version loaded
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+// modified returns the latest modification time of the two files
+func (r *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 named cn, and
+// sets the files' modification time to modTime
+func writeCert(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// commonName returns the subject of the certificate r serves
+func commonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	loaded := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", loaded)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+	if cn := commonName(t, r); cn != "first" {
+		t.Fatalf("serving %q, want first", cn)
+	}
+
+	// A renewal is picked up at the next check
+	writeCert(t, certFile, keyFile, "renewed", loaded.Add(time.Minute))
+	if cn := commonName(t, r); cn != "first" {
+		t.Errorf("serving %q before the check interval, want first", cn)
+	}
+	r.checked = time.Now().Add(-certCheckInterval)
+	if cn := commonName(t, r); cn != "renewed" {
+		t.Errorf("serving %q after the check interval, want renewed", cn)
+	}
+
+	// A half-written renewal keeps the current certificate
+	if err := os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r.checked = time.Now().Add(-certCheckInterval)
+	if cn := commonName(t, r); cn != "renewed" {
+		t.Errorf("serving %q after a broken renewal, want renewed", cn)
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "tls", time.Now())
+
+	cfg := Config{CertFile: certFile, KeyFile: keyFile, ShutdownTimeout: time.Second}
+	_, url, cancel, done := start(t, cfg, http.NotFoundHandler(), NewHealth())
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get(url + LivePath)
+	if err != nil {
+		t.Fatalf("GET over TLS: %v", err)
+	}
+	resp.Body.Close()
+	if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; resp.StatusCode != http.StatusOK || cn != "tls" {
+		t.Errorf("GET %s = %d from %q, want 200 from tls", LivePath, resp.StatusCode, cn)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("serve = %v, want a clean shutdown", err)
+	}
+}
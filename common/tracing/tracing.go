@@ -0,0 +1,112 @@
+// Package tracing sets up OpenTelemetry tracing for the services: a span for
+// every request, child spans for the steps of handling it and for outbound
+// walt.id calls, exported over OTLP. W3C trace context headers are read from incoming
+// requests and sent on outbound ones.
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/adammwaniki/testa-walt/common/tracing")
+
+// Setup installs the trace propagator and, when an OTLP endpoint is
+// configured with OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, a tracer provider that exports spans
+// over OTLP/HTTP. The other standard OTEL_* variables, such as
+// OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER, apply as usual. The returned
+// function flushes and stops the exporter.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		slog.Info("Tracing export disabled; set OTEL_EXPORTER_OTLP_ENDPOINT to enable it")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	slog.Info("Tracing export enabled", "protocol", "otlp/http")
+	return provider.Shutdown, nil
+}
+
+// Start starts a span for one step of handling a request
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Fail records err on span and marks it failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Middleware starts a server span for each request, named after the mux
+// pattern that serves it so that IDs in paths do not become span names.
+// Static assets, metrics scrapes and health checks are not traced.
+func Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return RouteMiddleware(func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			return pattern[strings.IndexByte(pattern, '/'):]
+		}
+		return "unmatched"
+	}, next)
+}
+
+// RouteMiddleware starts a server span for each request, named after the
+// route that serves it as returned by route
+func RouteMiddleware(route func(*http.Request) string, next http.Handler) http.Handler {
+	withRoute := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", route(r)))
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(withRoute, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + route(r)
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return !strings.HasPrefix(r.URL.Path, "/static/") && r.URL.Path != "/metrics" && r.URL.Path != "/health"
+		}),
+	)
+}
+
+// Transport wraps base, or http.DefaultTransport when nil, so that every
+// outbound request gets a client span and carries the trace context
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Host + r.URL.Path
+		}),
+	)
+}
@@ -0,0 +1,180 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recorder keeps the spans ended by every test. The global tracer provider
+// can only be installed once, so tests look at the spans they added.
+var recorder = tracetest.NewSpanRecorder()
+
+func TestMain(m *testing.M) {
+	os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	os.Unsetenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if _, err := Setup(context.Background(), "test"); err != nil {
+		panic(err)
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	os.Exit(m.Run())
+}
+
+// record returns the spans ended while f runs
+func record(f func()) []sdktrace.ReadOnlySpan {
+	before := len(recorder.Ended())
+	f()
+	return recorder.Ended()[before:]
+}
+
+// find returns the span named name
+func find(t *testing.T, spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	t.Fatalf("no span %q in %d spans", name, len(spans))
+	return nil
+}
+
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestMiddlewareSpans(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /credentials/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "build credential", attribute.String("credential.type", "pda1"))
+		Fail(span, errors.New("missing field"))
+		span.End()
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	handler := Middleware(mux, mux)
+
+	spans := record(func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/credentials/42", nil))
+	})
+
+	server := find(t, spans, "POST /credentials/{id}")
+	if route := attr(server, "http.route"); route != "/credentials/{id}" {
+		t.Errorf("http.route = %q, want the pattern without its method", route)
+	}
+	if server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span kind = %v, want server", server.SpanKind())
+	}
+
+	step := find(t, spans, "build credential")
+	if step.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Error("step span is not a child of the request span")
+	}
+	if step.Status().Code != codes.Error || step.Status().Description != "missing field" {
+		t.Errorf("failed step status = %+v, want the error", step.Status())
+	}
+	if len(step.Events()) != 1 || step.Events()[0].Name != "exception" {
+		t.Errorf("failed step events = %v, want the recorded error", step.Events())
+	}
+	if v := attr(step, "credential.type"); v != "pda1" {
+		t.Errorf("credential.type = %q, want pda1", v)
+	}
+}
+
+func TestMiddlewareUnmatchedAndFiltered(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(http.NewServeMux(), mux)
+
+	spans := record(func() {
+		for _, path := range []string{"/static/app.css", "/metrics", "/health", "/anything/123"} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+	})
+	if len(spans) != 1 || spans[0].Name() != "GET unmatched" {
+		names := make([]string, len(spans))
+		for i, s := range spans {
+			names[i] = s.Name()
+		}
+		t.Errorf("spans = %v, want only GET unmatched", names)
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	// A downstream service, standing in for walt.id, records the trace
+	// context it receives
+	var received trace.SpanContext
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		received = trace.SpanContextFromContext(ctx)
+	}))
+	defer downstream.Close()
+	client := &http.Client{Transport: Transport(nil)}
+
+	handler := RouteMiddleware(func(*http.Request) string { return "/issue" }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, downstream.URL+"/openid4vc/jwt/issue", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+	}))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/issue", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	spans := record(func() { handler.ServeHTTP(w, req) })
+	if w.Code != http.StatusOK {
+		t.Fatalf("request = %d %s", w.Code, w.Body)
+	}
+
+	server := find(t, spans, "POST /issue")
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("request span trace = %s, want the incoming %s", got, traceID)
+	}
+	if !server.Parent().IsRemote() || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("request span parent = %v, want the incoming span", server.Parent())
+	}
+
+	host := downstream.Listener.Addr().String()
+	call := find(t, spans, "POST "+host+"/openid4vc/jwt/issue")
+	if call.SpanKind() != trace.SpanKindClient || call.Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("outbound span kind %v parent %v, want a client child of the request span", call.SpanKind(), call.Parent())
+	}
+	if received.TraceID().String() != traceID || received.SpanID() != call.SpanContext().SpanID() {
+		t.Errorf("downstream received %v, want the outbound span's context", received)
+	}
+}
+
+func TestStartWithoutRequest(t *testing.T) {
+	spans := record(func() {
+		ctx, span := Start(context.Background(), "renewal run")
+		_, child := Start(ctx, "renewal")
+		child.End()
+		span.End()
+	})
+	run, child := find(t, spans, "renewal run"), find(t, spans, "renewal")
+	if run.Parent().IsValid() {
+		t.Error("background span has a parent")
+	}
+	if child.SpanContext().TraceID() != run.SpanContext().TraceID() || child.Parent().SpanID() != run.SpanContext().SpanID() {
+		t.Error("child span is not part of the background span's trace")
+	}
+}
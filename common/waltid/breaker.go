@@ -0,0 +1,90 @@
+package waltid
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker. It opens after threshold consecutive
+// failures, fails calls fast for openFor, then lets a single trial call
+// through: success closes it, failure opens it again.
+type breaker struct {
+	threshold int
+	openFor   time.Duration
+	observer  Observer
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time // zero while closed
+	trial     bool      // a trial call is in flight
+}
+
+// isOpen reports whether calls would be refused right now, without taking
+// the trial slot
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openUntil.IsZero() && (time.Now().Before(b.openUntil) || b.trial)
+}
+
+// allow reports whether a call may go ahead. After the open period the
+// first caller gets the trial; every call allowed must be followed by
+// record or release.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record ends an allowed call with its result
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := !b.openUntil.IsZero()
+	b.trial = false
+	if ok {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		if wasOpen {
+			slog.Info("walt.id circuit breaker closed")
+			b.observer.CircuitOpen(false)
+		}
+		return
+	}
+
+	b.failures++
+	if wasOpen || b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.openFor)
+		if !wasOpen {
+			slog.Error("walt.id circuit breaker opened", "failures", b.failures, "open_for", b.openFor.String())
+			b.observer.CircuitOpen(true)
+		}
+	}
+}
+
+// release ends an allowed call that says nothing about walt.id's health,
+// such as one the caller cancelled
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// remaining returns how long the circuit stays open
+func (b *breaker) remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return 0
+	}
+	return time.Until(b.openUntil)
+}
@@ -0,0 +1,74 @@
+package waltid
+
+import (
+	"testing"
+	"time"
+)
+
+// circuitRecorder is an Observer recording the breaker's state changes
+type circuitRecorder struct {
+	nopObserver
+	changes []bool
+}
+
+func (r *circuitRecorder) CircuitOpen(open bool) { r.changes = append(r.changes, open) }
+
+func TestBreakerTransitions(t *testing.T) {
+	obs := &circuitRecorder{}
+	b := &breaker{threshold: 2, openFor: time.Hour, observer: obs}
+
+	// Below the threshold, and a success resets the count
+	for _, ok := range []bool{false, true, false} {
+		if !b.allow() {
+			t.Fatal("closed breaker refused a call")
+		}
+		b.record(ok)
+	}
+	if b.isOpen() {
+		t.Fatal("opened before two consecutive failures")
+	}
+
+	b.allow()
+	b.record(false)
+	if !b.isOpen() || b.allow() {
+		t.Fatal("still closed after two consecutive failures")
+	}
+	if b.remaining() <= 0 {
+		t.Errorf("remaining = %v, want the open period", b.remaining())
+	}
+
+	// After the open period a single trial call goes through
+	b.openUntil = time.Now().Add(-time.Second)
+	if b.isOpen() {
+		t.Fatal("open after the open period")
+	}
+	if !b.allow() {
+		t.Fatal("trial call refused")
+	}
+	if b.allow() {
+		t.Fatal("second call allowed during the trial")
+	}
+
+	// A failed trial opens it again, a released one frees the trial slot
+	b.record(false)
+	if !b.isOpen() {
+		t.Fatal("closed after a failed trial")
+	}
+	b.openUntil = time.Now().Add(-time.Second)
+	b.allow()
+	b.release()
+	if !b.allow() {
+		t.Fatal("trial slot not freed by release")
+	}
+
+	// A successful trial closes it
+	b.record(true)
+	if b.isOpen() || b.remaining() != 0 {
+		t.Fatal("open after a successful trial")
+	}
+
+	want := []bool{true, false}
+	if len(obs.changes) != len(want) || obs.changes[0] != want[0] || obs.changes[1] != want[1] {
+		t.Errorf("observed changes %v, want %v", obs.changes, want)
+	}
+}
@@ -0,0 +1,290 @@
+// Package waltid is the HTTP client for walt.id. All calls share one
+// connection pool and a cap on concurrent requests, run under the caller's
+// context with a per-attempt timeout, are retried with jittered backoff
+// after transient failures (issuance requests only when walt.id was not
+// reached), and fail fast while a circuit breaker sees walt.id as down.
+package waltid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+)
+
+// ErrUnavailable is returned without calling walt.id while the circuit
+// breaker is open
+var ErrUnavailable = errors.New("walt.id is unavailable")
+
+// Outcomes of one call, as reported to the Observer
+const (
+	OutcomeSuccess  = "success"  // walt.id answered with 200
+	OutcomeRejected = "rejected" // walt.id answered with another status
+	OutcomeError    = "error"    // walt.id gave no answer
+)
+
+// Observer records the calls of a client, for metrics
+type Observer interface {
+	// Call marks an attempt as in flight; the returned function ends it
+	// with its outcome
+	Call() func(outcome string)
+	// Retry counts an attempt about to be retried
+	Retry()
+	// CircuitOpen records the state of the circuit breaker
+	CircuitOpen(open bool)
+}
+
+// nopObserver records nothing
+type nopObserver struct{}
+
+func (nopObserver) Call() func(string) { return func(string) {} }
+func (nopObserver) Retry()             {}
+func (nopObserver) CircuitOpen(bool)   {}
+
+// Config tunes the client
+type Config struct {
+	// MaxConcurrent calls run at once; others queue for up to QueueWait and
+	// then fail with ratelimit.ErrBusy
+	MaxConcurrent int
+	QueueWait     time.Duration
+
+	// Timeout bounds each attempt, including reading the response
+	Timeout time.Duration
+
+	// Attempts is the number of tries per call, including the first. Retries
+	// wait Backoff, doubling up to MaxBackoff, with jitter.
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// FailureThreshold consecutive failures open the circuit for OpenFor,
+	// after which one trial call decides whether it closes again
+	FailureThreshold int
+	OpenFor          time.Duration
+
+	// Observer records the calls; nil records nothing
+	Observer Observer
+}
+
+// ConfigFromEnv reads WALTID_MAX_CONCURRENT, WALTID_TIMEOUT_SECONDS,
+// WALTID_ATTEMPTS, WALTID_BREAKER_FAILURES and WALTID_BREAKER_OPEN_SECONDS.
+// The Observer is left for the caller to set.
+func ConfigFromEnv() Config {
+	return Config{
+		MaxConcurrent:    ratelimit.EnvInt("WALTID_MAX_CONCURRENT", 8),
+		QueueWait:        10 * time.Second,
+		Timeout:          time.Duration(ratelimit.EnvInt("WALTID_TIMEOUT_SECONDS", 15)) * time.Second,
+		Attempts:         ratelimit.EnvInt("WALTID_ATTEMPTS", 3),
+		Backoff:          250 * time.Millisecond,
+		MaxBackoff:       2 * time.Second,
+		FailureThreshold: ratelimit.EnvInt("WALTID_BREAKER_FAILURES", 5),
+		OpenFor:          time.Duration(ratelimit.EnvInt("WALTID_BREAKER_OPEN_SECONDS", 30)) * time.Second,
+	}
+}
+
+// Client calls walt.id
+type Client struct {
+	cfg      Config
+	http     *http.Client
+	slots    *ratelimit.Semaphore
+	breaker  *breaker
+	observer Observer
+}
+
+// Response is a walt.id reply, read in full
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// PooledTransport keeps up to maxIdle connections to walt.id open for reuse
+func PooledTransport(maxIdle int) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = max(maxIdle, 2)
+	return t
+}
+
+// New creates a client sending requests through transport, or a pooled
+// transport when nil
+func New(cfg Config, transport http.RoundTripper) *Client {
+	if transport == nil {
+		transport = PooledTransport(cfg.MaxConcurrent)
+	}
+	observer := cfg.Observer
+	if observer == nil {
+		observer = nopObserver{}
+	}
+	return &Client{
+		cfg:      cfg,
+		http:     &http.Client{Transport: transport},
+		slots:    ratelimit.NewSemaphore(cfg.MaxConcurrent, cfg.QueueWait),
+		breaker:  &breaker{threshold: max(cfg.FailureThreshold, 1), openFor: cfg.OpenFor, observer: observer},
+		observer: observer,
+	}
+}
+
+// With returns a client that shares c's connections, concurrency cap and
+// circuit breaker but reports its calls to o, such as calls to another
+// walt.id operation
+func (c *Client) With(o Observer) *Client {
+	if o == nil {
+		o = nopObserver{}
+	}
+	clone := *c
+	clone.observer = o
+	return &clone
+}
+
+// Post sends body to url with header. A response is returned for any
+// status; errors mean walt.id gave no usable answer: ratelimit.ErrBusy when
+// no slot freed up, ErrUnavailable while the circuit is open, the context's
+// error when the caller gave up, or the last transport error.
+//
+// Connection failures, timeouts and 502, 503 and 504 responses are
+// retried, so only requests that are safe to repeat may be posted, such as
+// verification requests, which only create a short-lived session at
+// walt.id. Issuance requests go through Issue.
+func (c *Client) Post(ctx context.Context, url string, header http.Header, body []byte) (*Response, error) {
+	return c.post(ctx, url, header, body, transient)
+}
+
+// Issue sends an issuance request, such as to /openid4vc/jwt/issue, like
+// Post. It is retried only when no connection to walt.id could be made: a
+// request that reached walt.id may have created an offer or signed a
+// credential, which a repeat would duplicate.
+func (c *Client) Issue(ctx context.Context, url string, header http.Header, body []byte) (*Response, error) {
+	return c.post(ctx, url, header, body, func(_ *Response, err error) bool {
+		return notSent(err)
+	})
+}
+
+// post makes the call for Post and Issue, trying again after the failures
+// for which retry returns true
+func (c *Client) post(ctx context.Context, url string, header http.Header, body []byte, retry func(*Response, error) bool) (*Response, error) {
+	if c.breaker.isOpen() {
+		return nil, ErrUnavailable
+	}
+	if err := c.slots.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.slots.Release()
+
+	for attempt := 1; ; attempt++ {
+		// Another call may have opened the circuit while this one waited
+		if !c.breaker.allow() {
+			return nil, ErrUnavailable
+		}
+
+		resp, err := c.attempt(ctx, url, header, body)
+		if ctx.Err() != nil {
+			// The caller gave up; that says nothing about walt.id
+			c.breaker.release()
+			return nil, ctx.Err()
+		}
+
+		// Only an unreachable or overloaded walt.id counts against the
+		// circuit: a 500 answers one request and says nothing of the next
+		c.breaker.record(!transient(resp, err))
+
+		if !retry(resp, err) || attempt >= c.cfg.Attempts {
+			return resp, err
+		}
+		if c.breaker.isOpen() {
+			return nil, ErrUnavailable
+		}
+
+		wait := c.backoff(attempt)
+		if err != nil {
+			slog.WarnContext(ctx, "Retrying walt.id call", "attempt", attempt, "wait", wait.String(), "error", err)
+		} else {
+			slog.WarnContext(ctx, "Retrying walt.id call", "attempt", attempt, "wait", wait.String(), "status", resp.StatusCode)
+		}
+		c.observer.Retry()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// transient reports whether a call failed in a way that a repeat may get
+// past: no answer within the timeout, a transport error, or 502, 503 or 504
+func transient(resp *Response, err error) bool {
+	return err != nil || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+}
+
+// notSent reports whether err means the request never left: the
+// connection to walt.id could not be made
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// attempt makes one request and reads the whole response within Timeout
+func (c *Client) attempt(ctx context.Context, url string, header http.Header, body []byte) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header.Clone()
+
+	outcome := OutcomeError
+	done := c.observer.Call()
+	defer func() { done(outcome) }()
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading walt.id response: %w", err)
+	}
+
+	outcome = OutcomeSuccess
+	if resp.StatusCode != http.StatusOK {
+		outcome = OutcomeRejected
+	}
+	return &Response{StatusCode: resp.StatusCode, Body: data}, nil
+}
+
+// backoff returns the wait before retry number attempt: the base delay
+// doubled per attempt, capped, with the upper half randomised so that
+// callers failing together do not retry together
+func (c *Client) backoff(attempt int) time.Duration {
+	d := min(c.cfg.Backoff<<(attempt-1), c.cfg.MaxBackoff)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// RetryAfter suggests how long a caller should wait after err before trying
+// again, or 0 when waiting would not help
+func (c *Client) RetryAfter(err error) time.Duration {
+	switch {
+	case errors.Is(err, ratelimit.ErrBusy):
+		return c.cfg.QueueWait
+	case errors.Is(err, ErrUnavailable):
+		return max(c.breaker.remaining(), time.Second)
+	}
+	return 0
+}
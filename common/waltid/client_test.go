@@ -0,0 +1,232 @@
+package waltid
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testConfig retries quickly and opens the circuit after threshold failures
+func testConfig(attempts, threshold int) Config {
+	return Config{
+		MaxConcurrent:    2,
+		QueueWait:        time.Second,
+		Timeout:          time.Second,
+		Attempts:         attempts,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       2 * time.Millisecond,
+		FailureThreshold: threshold,
+		OpenFor:          time.Hour,
+	}
+}
+
+// countingObserver counts retries and call outcomes
+type countingObserver struct {
+	nopObserver
+	retries  int
+	outcomes []string
+}
+
+func (o *countingObserver) Call() func(string) {
+	return func(outcome string) { o.outcomes = append(o.outcomes, outcome) }
+}
+
+func (o *countingObserver) Retry() { o.retries++ }
+
+// statusServer answers with the given statuses in turn, then 200
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestPostRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		attempts  int
+		wantCalls int32
+		want      int
+		wantErr   error
+	}{
+		{name: "success", attempts: 3, wantCalls: 1, want: http.StatusOK},
+		{name: "transient failures", statuses: []int{503, 502}, attempts: 3, wantCalls: 3, want: http.StatusOK},
+		{name: "attempts exhausted", statuses: []int{504, 504, 504}, attempts: 2, wantCalls: 2, want: http.StatusGatewayTimeout},
+		{name: "client errors are not retried", statuses: []int{400}, attempts: 3, wantCalls: 1, want: http.StatusBadRequest},
+		{name: "other server errors are not retried", statuses: []int{500}, attempts: 3, wantCalls: 1, want: http.StatusInternalServerError},
+		{name: "circuit opens between attempts", statuses: []int{503, 503, 503}, attempts: 5, wantCalls: 1, wantErr: ErrUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := statusServer(t, tt.statuses...)
+			threshold := 10
+			if tt.wantErr != nil {
+				threshold = 1
+			}
+			obs := &countingObserver{}
+			cfg := testConfig(tt.attempts, threshold)
+			cfg.Observer = obs
+			c := New(cfg, nil)
+
+			resp, err := c.Post(context.Background(), srv.URL, http.Header{}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Post error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if calls.Load() != tt.wantCalls {
+				t.Errorf("%d calls, want %d", calls.Load(), tt.wantCalls)
+			}
+			if len(obs.outcomes) != int(tt.wantCalls) || obs.retries != max(int(tt.wantCalls)-1, 0) {
+				t.Errorf("observed outcomes %v and %d retries for %d calls", obs.outcomes, obs.retries, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPostFailsFastWhileOpen(t *testing.T) {
+	srv, calls := statusServer(t, 503)
+	c := New(testConfig(1, 1), nil)
+
+	if _, err := c.Post(context.Background(), srv.URL, http.Header{}, nil); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	_, err := c.Post(context.Background(), srv.URL, http.Header{}, nil)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Post error = %v, want ErrUnavailable", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("%d calls, want none while the circuit is open", calls.Load()-1)
+	}
+	if c.RetryAfter(err) < time.Minute {
+		t.Errorf("RetryAfter = %v, want the rest of the open period", c.RetryAfter(err))
+	}
+
+	// A client for another operation shares the breaker
+	if _, err := c.With(&countingObserver{}).Post(context.Background(), srv.URL, http.Header{}, nil); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("With client error = %v, want ErrUnavailable", err)
+	}
+}
+
+// closedURL returns the URL of a port nothing listens on
+func closedURL(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return "http://" + ln.Addr().String()
+}
+
+func TestIssueRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int32
+		want      int
+	}{
+		{name: "success", wantCalls: 1, want: http.StatusOK},
+		{name: "unavailable is not retried", statuses: []int{503}, wantCalls: 1, want: http.StatusServiceUnavailable},
+		{name: "gateway timeout is not retried", statuses: []int{504}, wantCalls: 1, want: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, calls := statusServer(t, tt.statuses...)
+			c := New(testConfig(3, 10), nil)
+
+			resp, err := c.Issue(context.Background(), srv.URL, http.Header{}, nil)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+			if resp.StatusCode != tt.want || calls.Load() != tt.wantCalls {
+				t.Errorf("Issue = %d after %d calls, want %d after %d", resp.StatusCode, calls.Load(), tt.want, tt.wantCalls)
+			}
+		})
+	}
+
+	t.Run("connection refused is retried", func(t *testing.T) {
+		obs := &countingObserver{}
+		cfg := testConfig(3, 10)
+		cfg.Observer = obs
+		if _, err := New(cfg, nil).Issue(context.Background(), closedURL(t), http.Header{}, nil); err == nil {
+			t.Fatal("Issue to a closed port succeeded")
+		}
+		if len(obs.outcomes) != 3 || obs.retries != 2 {
+			t.Errorf("observed outcomes %v and %d retries, want 3 attempts", obs.outcomes, obs.retries)
+		}
+	})
+
+	t.Run("timeout is not retried", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			<-r.Context().Done()
+		}))
+		defer srv.Close()
+		cfg := testConfig(3, 10)
+		cfg.Timeout = 50 * time.Millisecond
+
+		if _, err := New(cfg, nil).Issue(context.Background(), srv.URL, http.Header{}, nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Issue error = %v, want the attempt timeout", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("%d calls, want 1", calls.Load())
+		}
+	})
+}
+
+func TestBreakerFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantOpen bool
+	}{
+		{name: "internal server errors", statuses: []int{500, 500, 500}},
+		{name: "not implemented", statuses: []int{501, 501, 501}},
+		{name: "bad gateway", statuses: []int{502, 502, 502}, wantOpen: true},
+		{name: "unavailable", statuses: []int{503, 503, 503}, wantOpen: true},
+		{name: "gateway timeout", statuses: []int{504, 504, 504}, wantOpen: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := statusServer(t, tt.statuses...)
+			c := New(testConfig(1, len(tt.statuses)), nil)
+			for range tt.statuses {
+				c.Issue(context.Background(), srv.URL, http.Header{}, nil)
+			}
+			if open := c.breaker.isOpen(); open != tt.wantOpen {
+				t.Errorf("circuit open = %v, want %v", open, tt.wantOpen)
+			}
+		})
+	}
+
+	t.Run("transport errors", func(t *testing.T) {
+		c := New(testConfig(1, 2), nil)
+		url := closedURL(t)
+		for range 2 {
+			c.Post(context.Background(), url, http.Header{}, nil)
+		}
+		if !c.breaker.isOpen() {
+			t.Error("circuit closed after transport errors, want open")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/gorilla/mux"
+)
+
+// API scopes granted to clients
+const (
+	ScopeIssue         = "issue"
+	ScopeVerify        = "verify"
+	ScopeReadCatalogue = "read-catalogue"
+
+	// ScopeReviewDuplicates lets a supervisor resolve issuances held back as
+	// probable duplicate farmers
+	ScopeReviewDuplicates = "review-duplicates"
+)
+
+// allScopes lists every scope a client may hold
+var allScopes = []string{ScopeIssue, ScopeVerify, ScopeReadCatalogue, ScopeReviewDuplicates}
+
+// newAPIAuth loads the API clients in dataDir, counting their calls in usage
+func newAPIAuth(dataDir string, usage *apiauth.Usage) (*apiauth.Auth, error) {
+	return apiauth.New(apiauth.ClientsPath(dataDir), apiauth.Config{
+		Realm:  "farmer-credential-service",
+		Scopes: allScopes,
+		Error:  respondError,
+		Route:  routeName,
+	}, usage)
+}
+
+// clientFrom returns the authenticated API client of a request
+var clientFrom = apiauth.ClientFrom
+
+// usageHandler handles GET /usage
+// Returns the calling client's own usage records
+func usageHandler(a *apiauth.Auth) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, _ := clientFrom(r.Context())
+		respondSuccess(w, http.StatusOK, map[string]any{
+			"clientId": c.ID,
+			"usage":    a.ClientUsage(c.ID),
+		})
+	}
+}
+
+// routeName identifies the endpoint a request matched, e.g. "GET /api/vc/{id}"
+func routeName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + tpl
+		}
+	}
+	return r.Method + " " + r.URL.Path
+}
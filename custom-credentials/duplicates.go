@@ -0,0 +1,587 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Probable duplicates are farmers who already hold a credential of the type
+// being issued under the same national ID or phone number, or under a
+// similar name with the same birth date and county. Issuance stops at a
+// probable duplicate until a supervisor reviews it.
+const (
+	matchNationalID  = "nationalId"
+	matchPhoneNumber = "phoneNumber"
+	matchNameDetails = "nameBirthDateCounty"
+
+	// nameSimilarityThreshold is the least similarity, from 0 to 1, at which
+	// two names with the same birth date and county are a probable match
+	nameSimilarityThreshold = 0.85
+)
+
+// Review states. A pending review blocks issuance; a supervisor then links
+// the farmer to an existing record, overrides the match as a different
+// person, or blocks the issuance.
+const (
+	reviewPending    = "pending"
+	reviewLinked     = "linked"
+	reviewOverridden = "overridden"
+	reviewBlocked    = "blocked"
+)
+
+var (
+	errReviewNotFound  = errors.New("duplicate review not found")
+	errReviewMismatch  = errors.New("duplicate review was raised for a different farmer or credential type")
+	errReviewPending   = errors.New("duplicate review is waiting for a supervisor")
+	errReviewBlocked   = errors.New("a supervisor blocked this issuance as a duplicate")
+	errReviewUsed      = errors.New("duplicate review was already used for an issuance")
+	errReviewResolved  = errors.New("duplicate review was already resolved")
+	errUnknownDecision = errors.New("decision must be link, override or block")
+	errUnknownMatch    = errors.New("recordId must name one of the review's matches")
+)
+
+// FarmerIdentity holds the request fields used to recognise a farmer
+type FarmerIdentity struct {
+	NationalID  string `json:"nationalId,omitempty"`
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+	FirstName   string `json:"firstName"`
+	FamilyName  string `json:"familyName,omitempty"`
+	BirthDate   string `json:"birthDate,omitempty"`
+	County      string `json:"county"`
+}
+
+// FarmerRecord is one issued credential in the farmer registry
+type FarmerRecord struct {
+	ID             string `json:"id"`
+	CredentialType string `json:"credentialType"`
+	FarmerIdentity
+	ClientID string    `json:"clientId"`
+	LinkedTo string    `json:"linkedTo,omitempty"` // the record of the same farmer a supervisor linked this one to
+	IssuedAt time.Time `json:"issuedAt"`
+
+	// reserved marks a record whose credential is being issued; it is
+	// matched against but not saved until the issuance succeeds
+	reserved bool
+}
+
+// DuplicateMatch is an existing record a request probably duplicates
+type DuplicateMatch struct {
+	RecordID string    `json:"recordId"`
+	Reasons  []string  `json:"reasons"`
+	Score    float64   `json:"score"`
+	IssuedAt time.Time `json:"issuedAt"`
+}
+
+// DuplicateReview is a request held back as a probable duplicate, and the
+// supervisor's decision on it
+type DuplicateReview struct {
+	ID             string           `json:"id"`
+	Status         string           `json:"status"`
+	CredentialType string           `json:"credentialType"`
+	Farmer         FarmerIdentity   `json:"farmer"`
+	Matches        []DuplicateMatch `json:"matches"`
+	ClientID       string           `json:"clientId"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	ResolvedBy     string           `json:"resolvedBy,omitempty"`
+	ResolvedAt     *time.Time       `json:"resolvedAt,omitempty"`
+	LinkedTo       string           `json:"linkedTo,omitempty"`
+	Reason         string           `json:"reason,omitempty"`
+	IssuedRecordID string           `json:"issuedRecordId,omitempty"`
+}
+
+// registryFile is the layout of the farmer registry file
+type registryFile struct {
+	Records []*FarmerRecord    `json:"records"`
+	Reviews []*DuplicateReview `json:"reviews"`
+}
+
+// FarmerRegistry records the farmers credentials were issued to and the
+// reviews of probable duplicates, persisted to a JSON file
+type FarmerRegistry struct {
+	path string
+
+	mu      sync.Mutex
+	records map[string]*FarmerRecord
+	reviews map[string]*DuplicateReview
+}
+
+func registryPath(dataDir string) string {
+	return filepath.Join(dataDir, "farmer-registry.json")
+}
+
+// NewFarmerRegistry loads the registry at path
+func NewFarmerRegistry(path string) (*FarmerRegistry, error) {
+	g := &FarmerRegistry{
+		path:    path,
+		records: make(map[string]*FarmerRecord),
+		reviews: make(map[string]*DuplicateReview),
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read farmer registry: %w", err)
+	}
+	var file registryFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse farmer registry: %w", err)
+	}
+	for _, rec := range file.Records {
+		g.records[rec.ID] = rec
+	}
+	for _, rev := range file.Reviews {
+		g.reviews[rev.ID] = rev
+	}
+	return g, nil
+}
+
+// probableDuplicateError carries the review raised for a probable duplicate
+type probableDuplicateError struct {
+	Review *DuplicateReview
+}
+
+func (e *probableDuplicateError) Error() string {
+	return fmt.Sprintf("farmer matches %d existing record(s); a supervisor must resolve review %s", len(e.Review.Matches), e.Review.ID)
+}
+
+// Registration is a farmer record held for an issuance in progress
+type Registration struct {
+	g      *FarmerRegistry
+	record *FarmerRecord
+	review *DuplicateReview
+}
+
+// Register screens req before issuance. A request without a review ID that
+// matches existing records raises a pending review, returned as a
+// *probableDuplicateError. A request with a review ID goes ahead once a
+// supervisor has linked or overridden it. The returned registration must be
+// confirmed once the credential is issued, or released if it is not; until
+// then concurrent requests for the same farmer are matched against it.
+func (g *FarmerRegistry) Register(req *FarmerCredentialRequest, clientID string) (*Registration, error) {
+	identity := identityFromRequest(req)
+	credentialType := credentialTypeID(req.FarmerType)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var review *DuplicateReview
+	if req.DuplicateReviewID != "" {
+		rev, ok := g.reviews[req.DuplicateReviewID]
+		switch {
+		case !ok:
+			return nil, errReviewNotFound
+		case rev.CredentialType != credentialType || rev.Farmer != identity:
+			return nil, errReviewMismatch
+		case rev.Status == reviewPending:
+			return nil, errReviewPending
+		case rev.Status == reviewBlocked:
+			return nil, errReviewBlocked
+		case rev.IssuedRecordID != "":
+			return nil, errReviewUsed
+		}
+		review = rev
+	} else if matches := g.match(credentialType, identity); len(matches) > 0 {
+		// A repeated request waits on the review already raised for it
+		for _, rev := range g.reviews {
+			if rev.Status == reviewPending && rev.CredentialType == credentialType && rev.Farmer == identity {
+				return nil, &probableDuplicateError{Review: rev}
+			}
+		}
+		review = &DuplicateReview{
+			ID:             newRecordID(),
+			Status:         reviewPending,
+			CredentialType: credentialType,
+			Farmer:         identity,
+			Matches:        matches,
+			ClientID:       clientID,
+			CreatedAt:      time.Now().UTC(),
+		}
+		g.reviews[review.ID] = review
+		if err := g.write(); err != nil {
+			delete(g.reviews, review.ID)
+			return nil, err
+		}
+		return nil, &probableDuplicateError{Review: review}
+	}
+
+	rec := &FarmerRecord{
+		ID:             newRecordID(),
+		CredentialType: credentialType,
+		FarmerIdentity: identity,
+		ClientID:       clientID,
+		reserved:       true,
+	}
+	if review != nil {
+		rec.LinkedTo = review.LinkedTo
+		// Held on the review so a concurrent retry with it is refused
+		review.IssuedRecordID = rec.ID
+	}
+	g.records[rec.ID] = rec
+	return &Registration{g: g, record: rec, review: review}, nil
+}
+
+// Confirm saves the record once its credential is issued. The credential
+// exists either way, so a failed write is only logged.
+func (r *Registration) Confirm() {
+	g := r.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r.record.reserved = false
+	r.record.IssuedAt = time.Now().UTC()
+	if err := g.write(); err != nil {
+		slog.Error("Error saving farmer registry", "record_id", r.record.ID, "error", err)
+	}
+}
+
+// Release drops the record of an issuance that failed, freeing its review
+// for a retry
+func (r *Registration) Release() {
+	g := r.g
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.records, r.record.ID)
+	if r.review != nil {
+		r.review.IssuedRecordID = ""
+	}
+}
+
+// match returns the records of credentialType that identity probably
+// duplicates, best match first. Callers must hold g.mu.
+func (g *FarmerRegistry) match(credentialType string, identity FarmerIdentity) []DuplicateMatch {
+	var matches []DuplicateMatch
+	for _, rec := range g.records {
+		if rec.CredentialType != credentialType {
+			continue
+		}
+		var reasons []string
+		score := 0.0
+		if identity.NationalID != "" && identity.NationalID == rec.NationalID {
+			reasons = append(reasons, matchNationalID)
+			score = 1
+		}
+		if identity.PhoneNumber != "" && identity.PhoneNumber == rec.PhoneNumber {
+			reasons = append(reasons, matchPhoneNumber)
+			score = 1
+		}
+		if identity.BirthDate != "" && identity.BirthDate == rec.BirthDate && identity.County == rec.County {
+			if sim := nameSimilarity(identity, rec.FarmerIdentity); sim >= nameSimilarityThreshold {
+				reasons = append(reasons, matchNameDetails)
+				score = max(score, sim)
+			}
+		}
+		if len(reasons) > 0 {
+			matches = append(matches, DuplicateMatch{
+				RecordID: rec.ID,
+				Reasons:  reasons,
+				Score:    float64(int(score*100)) / 100,
+				IssuedAt: rec.IssuedAt,
+			})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].RecordID < matches[j].RecordID
+	})
+	return matches
+}
+
+// Resolve records a supervisor's decision on a pending review. For link,
+// recordID names the matched record the farmer is; it may be left empty when
+// the review has a single match.
+func (g *FarmerRegistry) Resolve(id, decision, recordID, reason, resolvedBy string) (*DuplicateReview, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rev, ok := g.reviews[id]
+	if !ok {
+		return nil, errReviewNotFound
+	}
+	if rev.Status != reviewPending {
+		return nil, errReviewResolved
+	}
+
+	resolved := *rev
+	switch decision {
+	case "link":
+		if recordID == "" && len(rev.Matches) == 1 {
+			recordID = rev.Matches[0].RecordID
+		}
+		if !slices.ContainsFunc(rev.Matches, func(m DuplicateMatch) bool { return m.RecordID == recordID }) {
+			return nil, errUnknownMatch
+		}
+		resolved.Status = reviewLinked
+		resolved.LinkedTo = recordID
+	case "override":
+		resolved.Status = reviewOverridden
+	case "block":
+		resolved.Status = reviewBlocked
+	default:
+		return nil, errUnknownDecision
+	}
+	now := time.Now().UTC()
+	resolved.ResolvedBy = resolvedBy
+	resolved.ResolvedAt = &now
+	resolved.Reason = reason
+
+	g.reviews[id] = &resolved
+	if err := g.write(); err != nil {
+		g.reviews[id] = rev
+		return nil, err
+	}
+	c := resolved
+	return &c, nil
+}
+
+// Reviews returns the reviews with status, or all of them when status is
+// empty, oldest first
+func (g *FarmerRegistry) Reviews(status string) []DuplicateReview {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	list := []DuplicateReview{}
+	for _, rev := range g.reviews {
+		if status == "" || rev.Status == status {
+			list = append(list, *rev)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// Review returns a review together with the records it matched
+func (g *FarmerRegistry) Review(id string) (*DuplicateReview, []FarmerRecord, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rev, ok := g.reviews[id]
+	if !ok {
+		return nil, nil, errReviewNotFound
+	}
+	records := []FarmerRecord{}
+	for _, m := range rev.Matches {
+		if rec, ok := g.records[m.RecordID]; ok {
+			records = append(records, *rec)
+		}
+	}
+	c := *rev
+	return &c, records, nil
+}
+
+// write atomically rewrites the registry file. Callers must hold g.mu.
+func (g *FarmerRegistry) write() error {
+	file := registryFile{Records: []*FarmerRecord{}, Reviews: []*DuplicateReview{}}
+	for _, rec := range g.records {
+		if !rec.reserved {
+			file.Records = append(file.Records, rec)
+		}
+	}
+	for _, rev := range g.reviews {
+		file.Reviews = append(file.Reviews, rev)
+	}
+	sort.Slice(file.Records, func(i, j int) bool { return file.Records[i].IssuedAt.Before(file.Records[j].IssuedAt) })
+	sort.Slice(file.Reviews, func(i, j int) bool { return file.Reviews[i].CreatedAt.Before(file.Reviews[j].CreatedAt) })
+
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode farmer registry: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write farmer registry: %w", err)
+	}
+	return os.Rename(tmp, g.path)
+}
+
+// identityFromRequest takes the identifying fields of a validated request,
+// whose phone number and county are already canonical
+func identityFromRequest(req *FarmerCredentialRequest) FarmerIdentity {
+	return FarmerIdentity{
+		NationalID:  normalizeNationalID(req.NationalID),
+		PhoneNumber: req.PhoneNumber,
+		FirstName:   req.FirstName,
+		FamilyName:  req.FamilyName,
+		BirthDate:   req.BirthDate,
+		County:      req.County,
+	}
+}
+
+// normalizeNationalID keeps the letters and digits of an ID number, in upper
+// case, so that "12 345 678" and "12345678" match
+func normalizeNationalID(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsDigit(r):
+			return r
+		case unicode.IsLetter(r):
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, id)
+}
+
+// nameSimilarity compares two farmers' full names, allowing for the given
+// and family names being swapped
+func nameSimilarity(a, b FarmerIdentity) float64 {
+	name := normalizePersonName(a.FirstName + " " + a.FamilyName)
+	return max(
+		stringSimilarity(name, normalizePersonName(b.FirstName+" "+b.FamilyName)),
+		stringSimilarity(name, normalizePersonName(b.FamilyName+" "+b.FirstName)),
+	)
+}
+
+// normalizePersonName lower-cases a name and keeps only its letters, single
+// spaced
+func normalizePersonName(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) })
+	return strings.Join(fields, " ")
+}
+
+// stringSimilarity is 1 minus the edit distance between a and b relative to
+// the longer of the two
+func stringSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func newRecordID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// respondDuplicateError answers an issuance the registry held back
+func respondDuplicateError(w http.ResponseWriter, err error) {
+	var dup *probableDuplicateError
+	switch {
+	case errors.As(err, &dup):
+		slog.Log(responseContext(w), slog.LevelWarn, "Probable duplicate farmer", "status", http.StatusConflict, "review_id", dup.Review.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{
+			"success":  false,
+			"error":    "Probable duplicate farmer",
+			"details":  err.Error(),
+			"reviewId": dup.Review.ID,
+			"matches":  dup.Review.Matches,
+		})
+	case errors.Is(err, errReviewNotFound):
+		respondError(w, http.StatusNotFound, "Duplicate review not found", err)
+	case errors.Is(err, errReviewBlocked):
+		respondError(w, http.StatusForbidden, "Issuance blocked", err)
+	case errors.Is(err, errReviewMismatch), errors.Is(err, errReviewPending), errors.Is(err, errReviewUsed):
+		respondError(w, http.StatusConflict, "Duplicate review does not allow this issuance", err)
+	default:
+		respondError(w, http.StatusInternalServerError, "Failed to check for duplicate farmers", err)
+	}
+}
+
+// ListReviewsHandler handles GET /duplicates. Pending reviews are listed
+// unless ?status= asks for another state, or "all".
+func (g *FarmerRegistry) ListReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = reviewPending
+	case "all":
+		status = ""
+	case reviewPending, reviewLinked, reviewOverridden, reviewBlocked:
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid status",
+			fmt.Errorf("status must be pending, linked, overridden, blocked or all"))
+		return
+	}
+	respondSuccess(w, http.StatusOK, g.Reviews(status))
+}
+
+// GetReviewHandler handles GET /duplicates/{id}, including the full records
+// of the farmers matched so a supervisor can compare them
+func (g *FarmerRegistry) GetReviewHandler(w http.ResponseWriter, r *http.Request) {
+	rev, records, err := g.Review(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Duplicate review not found", err)
+		return
+	}
+	respondSuccess(w, http.StatusOK, map[string]any{
+		"review":  rev,
+		"records": records,
+	})
+}
+
+// ResolveReviewHandler handles POST /duplicates/{id}/resolve
+func (g *FarmerRegistry) ResolveReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Decision string `json:"decision"`
+		RecordID string `json:"recordId"`
+		Reason   string `json:"reason"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	c, _ := clientFrom(r.Context())
+	rev, err := g.Resolve(mux.Vars(r)["id"], req.Decision, req.RecordID, req.Reason, c.ID)
+	switch {
+	case errors.Is(err, errReviewNotFound):
+		respondError(w, http.StatusNotFound, "Duplicate review not found", err)
+		return
+	case errors.Is(err, errReviewResolved):
+		respondError(w, http.StatusConflict, "Duplicate review already resolved", err)
+		return
+	case errors.Is(err, errUnknownDecision), errors.Is(err, errUnknownMatch):
+		respondError(w, http.StatusBadRequest, "Invalid decision", err)
+		return
+	case err != nil:
+		respondError(w, http.StatusInternalServerError, "Failed to save decision", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Duplicate review resolved", "review_id", rev.ID, "status", rev.Status, "linked_to", rev.LinkedTo)
+	respondSuccess(w, http.StatusOK, rev)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "amina", 5},
+		{"amina", "amina", 0},
+		{"amina", "amino", 1},
+		{"wambui", "wambuí", 1},
+		{"kamau", "akmau", 2},
+		{"wanjiru", "wanjiku", 1},
+		{"njoroge", "njoroe", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := levenshtein([]rune(tt.b), []rune(tt.a)); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	amina := FarmerIdentity{FirstName: "Amina", FamilyName: "Otieno"}
+	tests := []struct {
+		name  string
+		other FarmerIdentity
+		want  float64
+	}{
+		{name: "same name", other: amina, want: 1},
+		{name: "case and punctuation", other: FarmerIdentity{FirstName: " AMINA.", FamilyName: "otieno "}, want: 1},
+		{name: "swapped names", other: FarmerIdentity{FirstName: "Otieno", FamilyName: "Amina"}, want: 1},
+		{name: "one typo", other: FarmerIdentity{FirstName: "Amina", FamilyName: "Otieng"}, want: 1 - 1.0/12},
+		{name: "two typos", other: FarmerIdentity{FirstName: "Amina", FamilyName: "Atieng"}, want: 1 - 2.0/12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nameSimilarity(amina, tt.other); got != tt.want {
+				t.Errorf("nameSimilarity = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := stringSimilarity("", ""); got != 0 {
+		t.Errorf("stringSimilarity of empty names = %v, want 0", got)
+	}
+}
+
+func TestNormalizeNationalID(t *testing.T) {
+	if got := normalizeNationalID(" 12 345-678a "); got != "12345678A" {
+		t.Errorf("normalizeNationalID = %q, want 12345678A", got)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	existing := &FarmerRecord{
+		ID:             "rec-amina",
+		CredentialType: "farmer-crop",
+		FarmerIdentity: FarmerIdentity{
+			NationalID:  "12345678",
+			PhoneNumber: "+254712345678",
+			FirstName:   "Amina",
+			FamilyName:  "Otieno",
+			BirthDate:   "1985-04-12",
+			County:      "Nakuru",
+		},
+	}
+	g := &FarmerRegistry{records: map[string]*FarmerRecord{existing.ID: existing}}
+
+	// farmer returns a different farmer with the same birth date and county
+	farmer := func(change func(*FarmerIdentity)) FarmerIdentity {
+		id := FarmerIdentity{FirstName: "John", FamilyName: "Kamau", BirthDate: "1985-04-12", County: "Nakuru"}
+		change(&id)
+		return id
+	}
+
+	tests := []struct {
+		name           string
+		credentialType string
+		identity       FarmerIdentity
+		wantReasons    []string
+		wantScore      float64
+	}{
+		{
+			name:     "different farmer",
+			identity: farmer(func(*FarmerIdentity) {}),
+		},
+		{
+			name:        "same national ID",
+			identity:    farmer(func(id *FarmerIdentity) { id.NationalID = "12345678" }),
+			wantReasons: []string{matchNationalID},
+			wantScore:   1,
+		},
+		{
+			name:        "same phone number",
+			identity:    farmer(func(id *FarmerIdentity) { id.PhoneNumber = "+254712345678" }),
+			wantReasons: []string{matchPhoneNumber},
+			wantScore:   1,
+		},
+		{
+			name:        "name above the threshold",
+			identity:    farmer(func(id *FarmerIdentity) { id.FirstName, id.FamilyName = "Amina", "Otieng" }),
+			wantReasons: []string{matchNameDetails},
+			wantScore:   0.91,
+		},
+		{
+			name:     "name below the threshold",
+			identity: farmer(func(id *FarmerIdentity) { id.FirstName, id.FamilyName = "Amina", "Atieng" }),
+		},
+		{
+			name: "same name in another county",
+			identity: farmer(func(id *FarmerIdentity) {
+				id.FirstName, id.FamilyName, id.County = "Amina", "Otieno", "Kisumu"
+			}),
+		},
+		{
+			name: "same name without a birth date",
+			identity: farmer(func(id *FarmerIdentity) {
+				id.FirstName, id.FamilyName, id.BirthDate = "Amina", "Otieno", ""
+			}),
+		},
+		{
+			name: "every reason",
+			identity: FarmerIdentity{
+				NationalID:  "12345678",
+				PhoneNumber: "+254712345678",
+				FirstName:   "Otieno",
+				FamilyName:  "Amina",
+				BirthDate:   "1985-04-12",
+				County:      "Nakuru",
+			},
+			wantReasons: []string{matchNationalID, matchPhoneNumber, matchNameDetails},
+			wantScore:   1,
+		},
+		{
+			name:           "another credential type",
+			credentialType: "farmer-livestock",
+			identity:       farmer(func(id *FarmerIdentity) { id.NationalID = "12345678" }),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			credentialType := tt.credentialType
+			if credentialType == "" {
+				credentialType = existing.CredentialType
+			}
+			matches := g.match(credentialType, tt.identity)
+			if tt.wantReasons == nil {
+				if len(matches) != 0 {
+					t.Fatalf("matches = %+v, want none", matches)
+				}
+				return
+			}
+			if len(matches) != 1 {
+				t.Fatalf("matches = %+v, want one", matches)
+			}
+			if m := matches[0]; m.RecordID != existing.ID || !reflect.DeepEqual(m.Reasons, tt.wantReasons) || m.Score != tt.wantScore {
+				t.Errorf("match = %+v, want %v with score %v", m, tt.wantReasons, tt.wantScore)
+			}
+		})
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	g := &FarmerRegistry{records: map[string]*FarmerRecord{
+		"b-name": {ID: "b-name", CredentialType: "farmer-crop", FarmerIdentity: FarmerIdentity{
+			FirstName: "Amina", FamilyName: "Otieng", BirthDate: "1985-04-12", County: "Nakuru",
+		}},
+		"c-id": {ID: "c-id", CredentialType: "farmer-crop", FarmerIdentity: FarmerIdentity{NationalID: "12345678"}},
+		"a-id": {ID: "a-id", CredentialType: "farmer-crop", FarmerIdentity: FarmerIdentity{NationalID: "12345678"}},
+	}}
+	identity := FarmerIdentity{
+		NationalID: "12345678",
+		FirstName:  "Amina",
+		FamilyName: "Otieno",
+		BirthDate:  "1985-04-12",
+		County:     "Nakuru",
+	}
+
+	var got []string
+	for _, m := range g.match("farmer-crop", identity) {
+		got = append(got, m.RecordID)
+	}
+	if want := []string{"a-id", "c-id", "b-name"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+}
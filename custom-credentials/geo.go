@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ListCountiesHandler handles GET /geo/counties
+func (s *CredentialService) ListCountiesHandler(w http.ResponseWriter, r *http.Request) {
+	type countySummary struct {
+		Code   string `json:"code"`
+		Name   string `json:"name"`
+		Region string `json:"region"`
+	}
+
+	counties := make([]countySummary, 0, len(s.geo.Counties))
+	for _, c := range s.geo.Counties {
+		counties = append(counties, countySummary{Code: c.Code, Name: c.Name, Region: c.Region})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counties)
+}
+
+// ListSubCountiesHandler handles GET /geo/counties/{id}/subcounties
+func (s *CredentialService) ListSubCountiesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	county, ok := s.geo.County(id)
+	if !ok {
+		respondError(w, http.StatusNotFound, "County not found", fmt.Errorf("unknown county: %q", id))
+		return
+	}
+
+	names := make([]string, 0, len(county.SubCounties))
+	for _, sub := range county.SubCounties {
+		names = append(names, sub.Name)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/adammwaniki/testa-walt/common/server"
+)
+
+// defaultWalletURL is the walt.id wallet API farmers claim offers with
+const defaultWalletURL = "http://139.59.15.151:7001"
+
+// serveHealth answers the legacy /health endpoint with the service name and
+// version alongside the dependency report of /healthz. It keeps "healthy" as
+// the status existing monitors look for, and fails like /healthz.
+func serveHealth(w http.ResponseWriter, health *server.Health) {
+	report := health.Report()
+	status, code := "healthy", http.StatusOK
+	if report.Status != server.StatusOK {
+		status, code = report.Status, http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"status":     status,
+		"service":    "farmer-credential-service",
+		"version":    "1.0.2",
+		"checked_at": report.CheckedAt,
+		"checks":     report.Checks,
+	})
+}
+
+// healthChecks returns the dependencies reported by /healthz, /readyz and
+// /health: the walt.id issuer API, which also verifies credentials, the
+// wallet API, the credential templates, the data directory and the issuer
+// key
+func (s *CredentialService) healthChecks(dataDir string) []server.Check {
+	walletURL := os.Getenv("WALTID_WALLET_URL")
+	if walletURL == "" {
+		walletURL = defaultWalletURL
+	}
+	return []server.Check{
+		{Name: "waltid_issuer_api", Run: server.HTTPCheck(WaltIDBaseURL)},
+		{Name: "waltid_wallet_api", Run: server.HTTPCheck(walletURL)},
+		{Name: "credential_templates", Local: true, Run: s.checkCredentialTemplates},
+		{Name: "storage", Local: true, Run: server.DirCheck(dataDir)},
+		{Name: "issuer_key", Local: true, Run: s.checkIssuerKeys},
+	}
+}
+
+// checkCredentialTemplates checks that every credential type has a mapping
+// with credential data and that its schema builds
+func (s *CredentialService) checkCredentialTemplates(context.Context) error {
+	var errs []error
+	for _, id := range s.credentialTypeNames {
+		mapping, err := s.getCredentialMapping(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		if _, ok := mapping["credentialData"].(map[string]any); !ok {
+			errs = append(errs, fmt.Errorf("%s: mapping has no credential data", id))
+		}
+		farmerType, ok := farmerTypeForCredential(id)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown farmer type", id))
+			continue
+		}
+		if _, err := s.buildCredentialSchema(farmerType); err != nil {
+			errs = append(errs, fmt.Errorf("%s: schema: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkIssuerKeys checks that the issuer key of each credential type has a
+// private part matching its public part, so that a mistyped key is caught
+// before walt.id rejects every credential signed with it
+func (s *CredentialService) checkIssuerKeys(context.Context) error {
+	var errs []error
+	for _, id := range s.credentialTypeNames {
+		mapping, err := s.getCredentialMapping(id)
+		if err != nil {
+			continue // reported by the template check
+		}
+		key, _ := mapping["issuerKey"].(map[string]any)
+		jwk, ok := key["jwk"].(map[string]string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no issuer JWK", id))
+			continue
+		}
+		if err := checkEd25519JWK(jwk["d"], jwk["x"]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func checkEd25519JWK(d, x string) error {
+	seed, err := base64.RawURLEncoding.DecodeString(d)
+	if err != nil {
+		return fmt.Errorf("d: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("d is %d bytes, want %d", len(seed), ed25519.SeedSize)
+	}
+	pub, err := base64.RawURLEncoding.DecodeString(x)
+	if err != nil {
+		return fmt.Errorf("x: %w", err)
+	}
+	if !bytes.Equal(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), pub) {
+		return fmt.Errorf("public key does not match the private key")
+	}
+	return nil
+}
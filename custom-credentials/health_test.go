@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/server"
+)
+
+func TestServeHealth(t *testing.T) {
+	tests := []struct {
+		name       string
+		check      func(context.Context) error
+		local      bool
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "healthy", check: func(context.Context) error { return nil }, wantCode: http.StatusOK, wantStatus: "healthy"},
+		{name: "walt.id down", check: func(context.Context) error { return errors.New("down") }, wantCode: http.StatusServiceUnavailable, wantStatus: server.StatusDegraded},
+		{name: "storage failing", check: func(context.Context) error { return errors.New("read-only") }, local: true, wantCode: http.StatusServiceUnavailable, wantStatus: server.StatusUnhealthy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := server.NewHealth(server.Check{Name: "dependency", Local: tt.local, Run: tt.check})
+			w := httptest.NewRecorder()
+			serveHealth(w, health)
+
+			var body struct {
+				Status  string                        `json:"status"`
+				Service string                        `json:"service"`
+				Checks  map[string]server.CheckResult `json:"checks"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantCode || body.Status != tt.wantStatus {
+				t.Errorf("/health = %d %q, want %d %q", w.Code, body.Status, tt.wantCode, tt.wantStatus)
+			}
+			if body.Service != "farmer-credential-service" || len(body.Checks) != 1 {
+				t.Errorf("/health body = %+v, want the service name and its check", body)
+			}
+		})
+	}
+}
+
+func TestLocalHealthChecks(t *testing.T) {
+	dir := t.TempDir()
+	service := NewCredentialService()
+	for _, c := range service.healthChecks(dir) {
+		if !c.Local {
+			continue
+		}
+		if err := c.Run(context.Background()); err != nil {
+			t.Errorf("%s: %v", c.Name, err)
+		}
+	}
+}
+
+func TestCheckEd25519JWK(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := base64.RawURLEncoding.EncodeToString
+	d, x := enc(priv.Seed()), enc(pub)
+
+	if err := checkEd25519JWK(d, x); err != nil {
+		t.Errorf("matching key: %v", err)
+	}
+	if err := checkEd25519JWK(d, enc(other)); err == nil {
+		t.Error("mismatched public key accepted")
+	}
+	if err := checkEd25519JWK(enc(priv.Seed()[:16]), x); err == nil {
+		t.Error("short private key accepted")
+	}
+	if err := checkEd25519JWK("not base64!", x); err == nil {
+		t.Error("malformed private key accepted")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Idempotency keys let a client retry an issuance safely: a request repeated
+// with the same Idempotency-Key header, such as a retry after a timeout, gets
+// the original response instead of a second credential
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeySize    = 255
+
+	// idempotencyTTL is how long a key is remembered
+	idempotencyTTL = 24 * time.Hour
+)
+
+// IdempotencyRecord is the stored response to the first request made with a
+// key
+type IdempotencyRecord struct {
+	ClientID    string    `json:"clientId"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	ContentType string    `json:"contentType"`
+	Body        string    `json:"body"` // kept as a string so replays are byte for byte
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type idempotencyKey struct {
+	clientID, key string
+}
+
+// IdempotencyStore remembers the responses to requests made with an
+// idempotency key and persists them to a JSON file, so that replays are
+// recognised across restarts
+type IdempotencyStore struct {
+	path string
+
+	mu      sync.Mutex
+	records map[idempotencyKey]*IdempotencyRecord
+	held    map[idempotencyKey]chan struct{} // keys of requests in progress
+}
+
+func idempotencyPath(dataDir string) string {
+	return filepath.Join(dataDir, "idempotency-keys.json")
+}
+
+// NewIdempotencyStore loads the records at path, dropping expired ones
+func NewIdempotencyStore(path string) (*IdempotencyStore, error) {
+	s := &IdempotencyStore{
+		path:    path,
+		records: make(map[idempotencyKey]*IdempotencyRecord),
+		held:    make(map[idempotencyKey]chan struct{}),
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency keys: %w", err)
+	}
+	var records []*IdempotencyRecord
+	if err := json.Unmarshal(raw, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse idempotency keys: %w", err)
+	}
+	for _, rec := range records {
+		if time.Since(rec.CreatedAt) < idempotencyTTL {
+			s.records[idempotencyKey{rec.ClientID, rec.Key}] = rec
+		}
+	}
+	return s, nil
+}
+
+// Handle makes next idempotent for requests with an Idempotency-Key header.
+// The first request with a key runs next; a successful response is stored
+// and returned to later requests with the same key and body, marked with
+// Idempotent-Replayed: true. Failed requests are not stored, so they can be
+// retried with the same key. A request whose key is in use by a request in
+// progress waits for it, and a key sent with a different body is rejected
+// with 422. Keys belong to the calling API client.
+func (s *IdempotencyStore) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			respondError(w, http.StatusBadRequest, "Invalid Idempotency-Key",
+				fmt.Errorf("the key must be 1 to %d printable ASCII characters", maxIdempotencyKeySize))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(w, http.StatusRequestEntityTooLarge, "Request body too large",
+					fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+				return
+			}
+			respondError(w, http.StatusBadRequest, "Failed to read request body", err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var clientID string
+		if c, ok := clientFrom(r.Context()); ok {
+			clientID = c.ID
+		}
+		k := idempotencyKey{clientID, key}
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		rec, done, err := s.claim(r, k, fingerprint)
+		switch {
+		case errors.Is(err, errKeyReused):
+			respondError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request", err)
+			return
+		case err != nil:
+			// The client gave up while an earlier request with the key ran
+			return
+		case rec != nil:
+			slog.InfoContext(r.Context(), "Replaying response for idempotency key", "client_id", clientID)
+			w.Header().Set("Content-Type", rec.ContentType)
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(rec.Status)
+			io.WriteString(w, rec.Body)
+			return
+		}
+
+		out := &bodyRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if out.status < 200 || out.status >= 300 {
+				done(nil)
+				return
+			}
+			done(&IdempotencyRecord{
+				ClientID:    clientID,
+				Key:         key,
+				Fingerprint: fingerprint,
+				Status:      out.status,
+				ContentType: out.Header().Get("Content-Type"),
+				Body:        out.body.String(),
+				CreatedAt:   time.Now().UTC(),
+			})
+		}()
+		next(out, r)
+	}
+}
+
+// errKeyReused is returned when a key comes back with a different request
+var errKeyReused = errors.New("idempotency key was already used for a different request")
+
+// claim returns the stored record for k, or holds k for this request until
+// done is called with the record to store, or nil to release the key
+func (s *IdempotencyStore) claim(r *http.Request, k idempotencyKey, fingerprint string) (*IdempotencyRecord, func(*IdempotencyRecord), error) {
+	for {
+		s.mu.Lock()
+		if rec, ok := s.records[k]; ok && time.Since(rec.CreatedAt) < idempotencyTTL {
+			s.mu.Unlock()
+			if rec.Fingerprint != fingerprint {
+				return nil, nil, errKeyReused
+			}
+			return rec, nil, nil
+		}
+
+		held, ok := s.held[k]
+		if !ok {
+			held = make(chan struct{})
+			s.held[k] = held
+			s.mu.Unlock()
+			return nil, func(rec *IdempotencyRecord) { s.finish(r, k, rec, held) }, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-held:
+		case <-r.Context().Done():
+			return nil, nil, r.Context().Err()
+		}
+	}
+}
+
+// finish stores rec, if any, and releases k to requests waiting on it
+func (s *IdempotencyStore) finish(r *http.Request, k idempotencyKey, rec *IdempotencyRecord, held chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(held)
+	delete(s.held, k)
+
+	if rec == nil {
+		return
+	}
+	for other, old := range s.records {
+		if time.Since(old.CreatedAt) >= idempotencyTTL {
+			delete(s.records, other)
+		}
+	}
+	s.records[k] = rec
+	// The credential is already issued, so a failed write only loses the
+	// key after a restart
+	if err := s.write(); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving idempotency keys", "error", err)
+	}
+}
+
+// write atomically rewrites the records file. Callers must hold s.mu.
+func (s *IdempotencyStore) write() error {
+	list := make([]*IdempotencyRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		list = append(list, rec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency keys: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write idempotency keys: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// bodyRecorder keeps a copy of the response status and body
+type bodyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bodyRecorder) WriteHeader(code int) {
+	if !b.wroteHeader {
+		b.status = code
+		b.wroteHeader = true
+	}
+	b.ResponseWriter.WriteHeader(code)
+}
+
+func (b *bodyRecorder) Write(p []byte) (int, error) {
+	b.wroteHeader = true
+	b.body.Write(p)
+	return b.ResponseWriter.Write(p)
+}
+
+func (b *bodyRecorder) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
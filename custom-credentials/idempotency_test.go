@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+)
+
+// counter is an issuance handler that counts its calls and answers with the
+// call number, failing while status is an error
+type counter struct {
+	mu     sync.Mutex
+	calls  int
+	status int
+}
+
+func (c *counter) handle(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.calls++
+	n, status := c.calls, c.status
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if status != 0 {
+		w.WriteHeader(status)
+	}
+	fmt.Fprintf(w, `{"call":%d}`, n)
+}
+
+// send makes an issuance request as client with an idempotency key
+func send(h http.HandlerFunc, client, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/issue/farmer", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	r = r.WithContext(apiauth.WithClient(r.Context(), &apiauth.Client{ID: client}))
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func newTestIdempotencyStore(t *testing.T, path string) *IdempotencyStore {
+	t.Helper()
+	s, err := NewIdempotencyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency-keys.json")
+	c := &counter{}
+	h := newTestIdempotencyStore(t, path).Handle(c.handle)
+
+	first := send(h, "coop", "key-1", `{"firstName":"Amina"}`)
+	replay := send(h, "coop", "key-1", `{"firstName":"Amina"}`)
+	if c.calls != 1 {
+		t.Fatalf("handler called %d times, want once", c.calls)
+	}
+	if replay.Body.String() != first.Body.String() || replay.Code != first.Code {
+		t.Errorf("replay = %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get(idempotentReplayedHeader) != "true" || first.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("%s on the first response %q and the replay %q, want it on the replay only", idempotentReplayedHeader,
+			first.Header().Get(idempotentReplayedHeader), replay.Header().Get(idempotentReplayedHeader))
+	}
+
+	// The key survives a restart
+	h = newTestIdempotencyStore(t, path).Handle(c.handle)
+	if w := send(h, "coop", "key-1", `{"firstName":"Amina"}`); c.calls != 1 || w.Body.String() != first.Body.String() {
+		t.Errorf("after a restart: %d calls and %s, want the stored response", c.calls, w.Body)
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	c := &counter{}
+	h := newTestIdempotencyStore(t, filepath.Join(t.TempDir(), "idempotency-keys.json")).Handle(c.handle)
+	send(h, "coop", "key-1", `{"firstName":"Amina"}`)
+
+	tests := []struct {
+		name      string
+		client    string
+		key       string
+		body      string
+		wantCode  int
+		wantCalls int
+	}{
+		{name: "different body", client: "coop", key: "key-1", body: `{"firstName":"John"}`, wantCode: http.StatusUnprocessableEntity, wantCalls: 1},
+		{name: "another client", client: "bank", key: "key-1", body: `{"firstName":"Amina"}`, wantCode: http.StatusOK, wantCalls: 2},
+		{name: "another key", client: "coop", key: "key-2", body: `{"firstName":"Amina"}`, wantCode: http.StatusOK, wantCalls: 3},
+		{name: "no key", client: "coop", body: `{"firstName":"Amina"}`, wantCode: http.StatusOK, wantCalls: 4},
+		{name: "invalid key", client: "coop", key: "key\x01", body: `{"firstName":"Amina"}`, wantCode: http.StatusBadRequest, wantCalls: 4},
+		{name: "key too long", client: "coop", key: strings.Repeat("k", maxIdempotencyKeySize+1), wantCode: http.StatusBadRequest, wantCalls: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := send(h, tt.client, tt.key, tt.body)
+			if w.Code != tt.wantCode || c.calls != tt.wantCalls {
+				t.Errorf("status %d after %d calls, want %d after %d", w.Code, c.calls, tt.wantCode, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyFailureNotStored(t *testing.T) {
+	c := &counter{status: http.StatusBadGateway}
+	h := newTestIdempotencyStore(t, filepath.Join(t.TempDir(), "idempotency-keys.json")).Handle(c.handle)
+
+	if w := send(h, "coop", "key-1", `{}`); w.Code != http.StatusBadGateway {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	c.status = 0
+	w := send(h, "coop", "key-1", `{}`)
+	if w.Code != http.StatusOK || c.calls != 2 || w.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("retry: status %d after %d calls, want a fresh success", w.Code, c.calls)
+	}
+}
+
+func TestIdempotencyConcurrentRequests(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	c := &counter{}
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		c.handle(w, r)
+	}
+	h := newTestIdempotencyStore(t, filepath.Join(t.TempDir(), "idempotency-keys.json")).Handle(slow)
+
+	responses := make(chan *httptest.ResponseRecorder, 2)
+	go func() { responses <- send(h, "coop", "key-1", `{}`) }()
+	<-started
+	go func() { responses <- send(h, "coop", "key-1", `{}`) }()
+	close(release)
+
+	a, b := <-responses, <-responses
+	if c.calls != 1 || a.Body.String() != b.Body.String() {
+		t.Errorf("%d calls with responses %s and %s, want one call answered twice", c.calls, a.Body, b.Body)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/adammwaniki/testa-walt/common/logging"
+)
+
+// farmerPIIFields identify a farmer in every credential type
+var farmerPIIFields = []string{"firstName", "familyName", "phoneNumber", "birthDate"}
+
+// farmerTypeRegistrations are the type-specific registration numbers, which
+// identify a farmer as well
+var farmerTypeRegistrations = map[string][]string{
+	"dairy":        {"kdbNumber"},
+	"poultry":      {"veterinaryRegistration"},
+	"horticulture": {"hcdNumber"},
+	"aquaculture":  {"fishDepartmentPermit"},
+}
+
+// The personal data masked when the requests and credentials of each
+// credential type, e.g. DairyFarmerCredential, are logged
+func init() {
+	for farmerType := range farmerTypeSpecifics {
+		logging.RegisterPII(credentialTypeID(farmerType), append(farmerTypeRegistrations[farmerType], farmerPIIFields...)...)
+	}
+}
+
+// responseContext recovers the request ID from the response header for
+// helpers such as respondError that only see the ResponseWriter
+func responseContext(w http.ResponseWriter) context.Context {
+	return logging.WithRequestID(context.Background(), w.Header().Get(logging.RequestIDHeader))
+}
+
+// maskPhone keeps the country code and last three digits of a phone number
+func maskPhone(phone string) string {
+	if len(phone) <= 7 {
+		return logging.Mask
+	}
+	return phone[:4] + strings.Repeat("*", len(phone)-7) + phone[len(phone)-3:]
+}
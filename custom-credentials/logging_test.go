@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/logging"
+)
+
+func TestFarmerPIIMasked(t *testing.T) {
+	req := map[string]any{
+		"firstName":              "Amina",
+		"familyName":             "Otieno",
+		"phoneNumber":            "+254712345678",
+		"county":                 "Nakuru",
+		"phoneVerificationToken": "token",
+		"dairySpecifics":         map[string]any{"kdbNumber": "KDB-123", "numberOfCattle": 4},
+	}
+	got, err := json.Marshal(logging.Body(credentialTypeID("dairy"), req).LogValue().Any())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"county":"Nakuru","dairySpecifics":{"kdbNumber":"[REDACTED]","numberOfCattle":4},` +
+		`"familyName":"[REDACTED]","firstName":"[REDACTED]","phoneNumber":"[REDACTED]","phoneVerificationToken":"[REDACTED]"}`
+	if string(got) != want {
+		t.Errorf("Body = %s, want %s", got, want)
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	for phone, want := range map[string]string{"+254712345678": "+254******678", "0712": logging.Mask} {
+		if got := maskPhone(phone); got != want {
+			t.Errorf("maskPhone(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
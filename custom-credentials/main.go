@@ -1,16 +1,30 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/geo"
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/server"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/common/waltid"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // Configuration
@@ -33,11 +47,23 @@ type FarmerCredentialRequest struct {
 	PoultrySpecifics      *PoultrySpecifics      `json:"poultrySpecifics,omitempty"`
 	HorticultureSpecifics *HorticultureSpecifics `json:"horticultureSpecifics,omitempty"`
 	AquacultureSpecifics  *AquacultureSpecifics  `json:"aquacultureSpecifics,omitempty"`
+
+	// PhoneVerificationToken is returned by /otp/verify and proves the farmer
+	// controls PhoneNumber. It is not part of the issued credential.
+	PhoneVerificationToken string `json:"phoneVerificationToken,omitempty" credential:"-"`
+
+	// NationalID is used to recognise farmers who already hold a credential.
+	// DuplicateReviewID names the supervisor review that cleared a request
+	// held back as a probable duplicate. Neither is part of the credential.
+	NationalID        string `json:"nationalId,omitempty" credential:"-"`
+	DuplicateReviewID string `json:"duplicateReviewId,omitempty" credential:"-"`
 }
 
+// FarmSize is the farm area as reported, plus its value in canonical units
 type FarmSize struct {
-	Value float64 `json:"value"`
-	Unit  string  `json:"unit"`
+	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
+	Normalized *Quantity `json:"normalized,omitempty"`
 }
 
 type DairySpecifics struct {
@@ -48,9 +74,11 @@ type DairySpecifics struct {
 	KDBNumber              string            `json:"kdbNumber,omitempty"`
 }
 
+// ProductionMetric is a production rate as reported, plus its value in canonical units
 type ProductionMetric struct {
-	Value float64 `json:"value"`
-	Unit  string  `json:"unit"`
+	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
+	Normalized *Quantity `json:"normalized,omitempty"`
 }
 
 type PoultrySpecifics struct {
@@ -65,6 +93,8 @@ type PoultrySpecifics struct {
 type PoultryProduction struct {
 	EggsPerDay   int     `json:"eggsPerDay,omitempty"`
 	MeatPerCycle float64 `json:"meatPerCycle,omitempty"`
+	// TraysPerDay is derived from EggsPerDay
+	TraysPerDay float64 `json:"traysPerDay,omitempty"`
 }
 
 type HorticultureSpecifics struct {
@@ -92,6 +122,9 @@ type AquaProduction struct {
 	CyclesPerYear int     `json:"cyclesPerYear"`
 	FishPerCycle  int     `json:"fishPerCycle"`
 	KgPerCycle    float64 `json:"kgPerCycle"`
+	// HarvestPerCycle is the harvest in any mass unit. When given, KgPerCycle
+	// is derived from it.
+	HarvestPerCycle *ProductionMetric `json:"harvestPerCycle,omitempty"`
 }
 
 // VC Repository compatible structures
@@ -108,9 +141,18 @@ type VCRepoCredential struct {
 
 // CredentialService handles credential operations
 type CredentialService struct {
-	client              *http.Client
 	credentialsMap      map[string]VCRepoCredential
 	credentialTypeNames []string
+	geo                 *geo.Directory
+	otp                 *OTPService
+
+	// walt.id clients for issuing and verifying. They share connections,
+	// the cap on concurrent calls and the circuit breaker.
+	waltIDIssue  *waltid.Client
+	waltIDVerify *waltid.Client
+
+	// farmers screens issuance requests for probable duplicates, when set
+	farmers *FarmerRegistry
 }
 
 // CredentialMapping represents the field mapping for a credential type
@@ -122,9 +164,16 @@ type CredentialMapping struct {
 }
 
 func NewCredentialService() *CredentialService {
+	waltIDConfig := waltid.ConfigFromEnv()
+	waltIDConfig.Observer = waltIDObserver("issue")
+	waltIDIssue := waltid.New(waltIDConfig, tracing.Transport(waltid.PooledTransport(waltIDConfig.MaxConcurrent)))
+
 	service := &CredentialService{
-		client:         &http.Client{Timeout: 30 * time.Second},
 		credentialsMap: make(map[string]VCRepoCredential),
+		geo:            geo.Default,
+		otp:            NewOTPService(newSMSSenderFromEnv(), os.Getenv("OTP_REQUIRED") == "true"),
+		waltIDIssue:    waltIDIssue,
+		waltIDVerify:   waltIDIssue.With(waltIDObserver("verify")),
 	}
 	
 	// Initialize credentials
@@ -142,7 +191,7 @@ func (s *CredentialService) initializeCredentials() {
 			Icon:        "🐄",
 			Type:        "dairy",
 			Category:    "agriculture",
-			IssuerURL:   fmt.Sprintf("http://%s/credentials/issue", getServiceHost()),
+			IssuerURL:   publicURL + "/credentials/issue",
 			Schema: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -173,7 +222,7 @@ func (s *CredentialService) initializeCredentials() {
 			Icon:        "🐔",
 			Type:        "poultry",
 			Category:    "agriculture",
-			IssuerURL:   fmt.Sprintf("http://%s/credentials/issue", getServiceHost()),
+			IssuerURL:   publicURL + "/credentials/issue",
 			Schema: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -203,7 +252,7 @@ func (s *CredentialService) initializeCredentials() {
 			Icon:        "🥬",
 			Type:        "horticulture",
 			Category:    "agriculture",
-			IssuerURL:   fmt.Sprintf("http://%s/credentials/issue", getServiceHost()),
+			IssuerURL:   publicURL + "/credentials/issue",
 			Schema: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -233,7 +282,7 @@ func (s *CredentialService) initializeCredentials() {
 			Icon:        "🐟",
 			Type:        "aquaculture",
 			Category:    "agriculture",
-			IssuerURL:   fmt.Sprintf("http://%s/credentials/issue", getServiceHost()),
+			IssuerURL:   publicURL + "/credentials/issue",
 			Schema: map[string]any{
 				"type": "object",
 				"properties": map[string]any{
@@ -278,7 +327,7 @@ func (s *CredentialService) GetVCByIDHandler(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	credentialID := vars["id"]
 	
-	log.Printf("Fetching credential by ID: %s", credentialID)
+	slog.DebugContext(r.Context(), "Fetching credential", "credential_id", credentialID)
 	
 	// Find the requested credential
 	if cred, found := s.credentialsMap[credentialID]; found {
@@ -309,31 +358,89 @@ func (s *CredentialService) GetVCRepoCredentialsHandler(w http.ResponseWriter, r
 // IssueCredentialHandler handles POST /credentials/issue
 func (s *CredentialService) IssueCredentialHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse request
+	ctx, span := tracing.Start(r.Context(), "decode request")
 	var req FarmerCredentialRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondError(w, http.StatusBadRequest, "Invalid request body", err)
+	if !decodeJSONBody(w, r, &req) {
+		span.SetStatus(codes.Error, "invalid request body")
+		span.End()
 		return
 	}
+	span.SetAttributes(attribute.String("farmer.type", req.FarmerType))
 
 	// Validate required fields
 	if err := s.validateRequest(&req); err != nil {
+		countIssuance(req.FarmerType, outcomeInvalid)
+		tracing.Fail(span, err)
+		span.End()
 		respondError(w, http.StatusBadRequest, "Validation failed", err)
 		return
 	}
+	span.End()
+
+	// Hold back probable duplicates for a supervisor, before the phone
+	// verification is spent so that the request can be retried once cleared
+	var issued bool
+	if s.farmers != nil {
+		var clientID string
+		if c, ok := clientFrom(ctx); ok {
+			clientID = c.ID
+		}
+		registration, err := s.farmers.Register(&req, clientID)
+		if err != nil {
+			countIssuance(req.FarmerType, outcomeInvalid)
+			respondDuplicateError(w, err)
+			return
+		}
+		defer func() {
+			if issued {
+				registration.Confirm()
+			} else {
+				registration.Release()
+			}
+		}()
+	}
+
+	// Phone verification tokens are single use, but are only spent once
+	// walt.id has issued the credential so that a failed call can be retried
+	if req.PhoneVerificationToken != "" {
+		restore, err := s.otp.Consume(req.PhoneVerificationToken, req.PhoneNumber)
+		if err != nil {
+			countIssuance(req.FarmerType, outcomeInvalid)
+			respondError(w, http.StatusBadRequest, "Validation failed", err)
+			return
+		}
+		defer func() {
+			if !issued {
+				restore()
+			}
+		}()
+	}
 
 	// Build credential
+	_, span = tracing.Start(ctx, "build credential", attribute.String("farmer.type", req.FarmerType))
 	credential, err := s.buildCredential(&req)
 	if err != nil {
+		countIssuance(req.FarmerType, outcomeError)
+		tracing.Fail(span, err)
+		span.End()
 		respondError(w, http.StatusInternalServerError, "Failed to build credential", err)
 		return
 	}
+	span.End()
+
+	credentialType := credentialTypeID(req.FarmerType)
+	slog.DebugContext(ctx, "Sending credential to walt.id", "credential_type", credentialType,
+		"body", logging.Body(credentialType, credential))
 
 	// Issue via walt.id
-	issuedCredential, err := s.issueToWaltID(credential)
+	issuedCredential, err := s.issueToWaltID(ctx, credential)
+	countIssuance(req.FarmerType, waltIDOutcome(err))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to issue credential", err)
+		s.respondWaltIDError(w, "Failed to issue credential", err)
 		return
 	}
+	issued = true
+	slog.InfoContext(ctx, "Credential issued", "credential_type", credentialType)
 
 	// Return success response
 	respondSuccess(w, http.StatusOK, issuedCredential)
@@ -350,7 +457,32 @@ func (s *CredentialService) GetCredentialSchemaHandler(w http.ResponseWriter, r
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+// GetVersionedSchemaHandler handles GET /schemas/{version}/{id}.json
+// Serves the schema referenced by credentialSchema.id in issued credentials
+func (s *CredentialService) GetVersionedSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	version := vars["version"]
+	credentialID := vars["id"]
+
+	farmerType, ok := farmerTypeForCredential(credentialID)
+	if !ok || version != SchemaVersion {
+		respondError(w, http.StatusNotFound, "Schema not found",
+			fmt.Errorf("no schema %s for credential type: %s", version, credentialID))
+		return
+	}
+
+	schema, err := s.getSchema(farmerType)
+	if err != nil {
+		respondError(w, http.StatusNotFound, "Schema not found", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
 	json.NewEncoder(w).Encode(schema)
 }
 
@@ -359,16 +491,27 @@ func (s *CredentialService) VerifyCredentialHandler(w http.ResponseWriter, r *ht
 	// Read credential JWT from body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, "Failed to read request", err)
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		respondError(w, status, "Failed to read request", err)
 		return
 	}
 
 	// Verify with walt.id
-	verified, result, err := s.verifyWithWaltID(string(body))
+	verified, result, err := s.verifyWithWaltID(r.Context(), string(body))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Verification failed", err)
+		credentialsVerified.WithLabelValues(waltIDOutcome(err)).Inc()
+		s.respondWaltIDError(w, "Verification failed", err)
 		return
 	}
+	if verified {
+		credentialsVerified.WithLabelValues(outcomeVerified).Inc()
+	} else {
+		credentialsVerified.WithLabelValues(outcomeRejected).Inc()
+	}
 
 	response := map[string]any{
 		"verified": verified,
@@ -426,6 +569,36 @@ func (s *CredentialService) validateRequest(req *FarmerCredentialRequest) error
 		return fmt.Errorf("county is required")
 	}
 
+	// Validate location and store the canonical spellings
+	county, subCounty, err := s.geo.Resolve(req.County, req.SubCounty)
+	if err != nil {
+		return err
+	}
+	req.County = county
+	req.SubCounty = subCounty
+
+	// Validate units and record canonical values for comparison
+	if err := normalizeMeasurements(req); err != nil {
+		return err
+	}
+
+	// Store phone numbers in E.164 and, when enabled, require proof of control
+	if req.PhoneNumber != "" {
+		phoneNumber, _, err := normalizeKenyanPhone(req.PhoneNumber)
+		if err != nil {
+			return err
+		}
+		req.PhoneNumber = phoneNumber
+	}
+	if s.otp.required {
+		if req.PhoneNumber == "" {
+			return fmt.Errorf("phoneNumber is required")
+		}
+		if req.PhoneVerificationToken == "" {
+			return fmt.Errorf("phoneVerificationToken is required, verify the phone number via /otp/send and /otp/verify")
+		}
+	}
+
 	// Validate type-specific fields
 	switch req.FarmerType {
 	case "dairy":
@@ -456,7 +629,7 @@ func (s *CredentialService) GetCredentialMappingHandler(w http.ResponseWriter, r
 	vars := mux.Vars(r)
 	credentialID := vars["id"]
 	
-	log.Printf("Fetching credential mapping for ID: %s", credentialID)
+	slog.DebugContext(r.Context(), "Fetching credential mapping", "credential_id", credentialID)
 	
 	// Get the mapping for the requested credential type
 	mapping, err := s.getCredentialMapping(credentialID)
@@ -549,7 +722,7 @@ func (s *CredentialService) getCredentialMapping(credentialID string) (map[strin
 				"numberOfCattle":         15,
 				"milkingCows":            10,
 				"avgDailyProductionValue": 120.0,
-				"avgDailyProductionUnit":  "liters",
+				"avgDailyProductionUnit":  "liters/day",
 				"kdbNumber":              "KDB-12345",
 				"registrationDate":       "2024-01-15T10:30:00Z",
 			},
@@ -807,6 +980,15 @@ func (s *CredentialService) getCredentialMapping(credentialID string) (map[strin
 	if !ok {
 		return nil, fmt.Errorf("mapping not found for credential type: %s", credentialID)
 	}
+
+	// Point portal-issued credentials at the same published schema
+	if farmerType, ok := farmerTypeForCredential(credentialID); ok {
+		data, ok := mapping["credentialData"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("mapping for %s has no credential data", credentialID)
+		}
+		data["credentialSchema"] = credentialSchemaRef(farmerType)
+	}
 	
 	return mapping, nil
 }
@@ -821,25 +1003,33 @@ func (s *CredentialService) buildCredential(req *FarmerCredentialRequest) (map[s
 			"https://www.w3.org/2018/credentials/v1",
 			"https://w3id.org/security/suites/jws-2020/v1",
 		},
-		"type":         []string{"VerifiableCredential", "FarmerCredential", fmt.Sprintf("%sFarmerCredential", capitalize(req.FarmerType))},
-		"issuer":       IssuerDID,
-		"issuanceDate": time.Now().Format(time.RFC3339),
+		"type":             []string{"VerifiableCredential", "FarmerCredential", credentialTypeID(req.FarmerType)},
+		"issuer":           IssuerDID,
+		"issuanceDate":     time.Now().Format(time.RFC3339),
+		"credentialSchema": credentialSchemaRef(req.FarmerType),
 		"credentialSubject": map[string]any{
 			"id":               holderDID,
 			"farmerType":       req.FarmerType,
 			"firstName":        req.FirstName,
 			"familyName":       req.FamilyName,
 			"phoneNumber":      req.PhoneNumber,
-			"birthDate":        req.BirthDate,
 			"county":           req.County,
 			"subCounty":        req.SubCounty,
-			"farmSize":         req.FarmSize,
 			"registrationDate": time.Now().Format(time.RFC3339),
 		},
 	}
 
-	// Add type-specific data
+	// Optional fields are left out rather than written empty, as the schema
+	// does not accept null for them
 	subject := credential["credentialSubject"].(map[string]any)
+	if req.BirthDate != "" {
+		subject["birthDate"] = req.BirthDate
+	}
+	if req.FarmSize != nil {
+		subject["farmSize"] = req.FarmSize
+	}
+
+	// Add type-specific data
 	switch req.FarmerType {
 	case "dairy":
 		subject["dairySpecifics"] = req.DairySpecifics
@@ -855,38 +1045,27 @@ func (s *CredentialService) buildCredential(req *FarmerCredentialRequest) (map[s
 }
 
 // issueToWaltID sends credential to walt.id for signing
-func (s *CredentialService) issueToWaltID(credential map[string]any) (map[string]any, error) {
+func (s *CredentialService) issueToWaltID(ctx context.Context, credential map[string]any) (map[string]any, error) {
 	jsonData, err := json.Marshal(credential)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal credential: %w", err)
 	}
 
-	url := fmt.Sprintf("%s/openid4vc/jwt/issue", WaltIDBaseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := s.client.Do(req)
+	resp, err := s.waltIDIssue.Issue(ctx, WaltIDBaseURL+"/openid4vc/jwt/issue", header, jsonData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("walt.id returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &waltIDStatusError{status: resp.StatusCode, body: string(resp.Body)}
 	}
 
 	var result map[string]any
-	if err := json.Unmarshal(body, &result); err != nil {
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
@@ -894,88 +1073,26 @@ func (s *CredentialService) issueToWaltID(credential map[string]any) (map[string
 }
 
 // verifyWithWaltID verifies a credential with walt.id
-func (s *CredentialService) verifyWithWaltID(credentialJWT string) (bool, map[string]any, error) {
-	url := fmt.Sprintf("%s/openid4vc/verify", WaltIDBaseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBufferString(credentialJWT))
-	if err != nil {
-		return false, nil, err
-	}
-
-	req.Header.Set("Content-Type", "text/plain")
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return false, nil, err
-	}
-	defer resp.Body.Close()
+func (s *CredentialService) verifyWithWaltID(ctx context.Context, credentialJWT string) (bool, map[string]any, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := s.waltIDVerify.Post(ctx, WaltIDBaseURL+"/openid4vc/verify", header, []byte(credentialJWT))
 	if err != nil {
 		return false, nil, err
 	}
 
 	var result map[string]any
-	if err := json.Unmarshal(body, &result); err != nil {
+	if err := json.Unmarshal(resp.Body, &result); err != nil {
 		return false, nil, err
 	}
 
-	verified := resp.StatusCode == http.StatusOK
-	return verified, result, nil
+	return resp.StatusCode == http.StatusOK, result, nil
 }
 
-// getSchema returns the schema for a farmer type
+// getSchema returns the JSON Schema for a farmer type
 func (s *CredentialService) getSchema(farmerType string) (map[string]any, error) {
-	// This would load from files in production
-	schemas := map[string]map[string]any{
-		"dairy": {
-			"type":        "dairy",
-			"name":        "Dairy Farmer Credential",
-			"description": "Credential for dairy farmers in Kenya",
-			"fields": []map[string]any{
-				{"name": "cattleBreeds", "type": "array", "required": true},
-				{"name": "numberOfCattle", "type": "integer", "required": true},
-				{"name": "milkingCows", "type": "integer", "required": true},
-				{"name": "averageDailyProduction", "type": "object", "required": true},
-			},
-		},
-		"poultry": {
-			"type":        "poultry",
-			"name":        "Poultry Farmer Credential",
-			"description": "Credential for poultry farmers in Kenya",
-			"fields": []map[string]any{
-				{"name": "farmingType", "type": "string", "required": true},
-				{"name": "birdPopulation", "type": "integer", "required": true},
-				{"name": "housingType", "type": "string", "required": true},
-			},
-		},
-		"horticulture": {
-			"type":        "horticulture",
-			"name":        "Horticulture Farmer Credential",
-			"description": "Credential for horticulture farmers in Kenya",
-			"fields": []map[string]any{
-				{"name": "crops", "type": "array", "required": true},
-				{"name": "farmingMethod", "type": "string", "required": true},
-				{"name": "irrigationSystem", "type": "string", "required": true},
-			},
-		},
-		"aquaculture": {
-			"type":        "aquaculture",
-			"name":        "Aquaculture Farmer Credential",
-			"description": "Credential for aquaculture farmers in Kenya",
-			"fields": []map[string]any{
-				{"name": "species", "type": "array", "required": true},
-				{"name": "farmingSystem", "type": "string", "required": true},
-				{"name": "waterSource", "type": "string", "required": true},
-			},
-		},
-	}
-
-	schema, ok := schemas[farmerType]
-	if !ok {
-		return nil, fmt.Errorf("schema not found for type: %s", farmerType)
-	}
-
-	return schema, nil
+	return s.buildCredentialSchema(farmerType)
 }
 
 // Helper functions
@@ -986,16 +1103,31 @@ func capitalize(s string) string {
 	return string(s[0]-32) + s[1:]
 }
 
-func getServiceHost() string {
-	host := os.Getenv("SERVICE_HOST")
-	if host == "" {
-		host = "139.59.15.151:7105"
+// publicURL is the https base URL wallets and clients reach the service on,
+// set from CREDENTIALS_PUBLIC_URL at startup. Credential schemas are published
+// under it.
+var publicURL string
+
+// publicURLFromEnv reads CREDENTIALS_PUBLIC_URL, which must be an https URL
+// without a query, and returns it without a trailing slash
+func publicURLFromEnv() (string, error) {
+	raw := strings.TrimSpace(os.Getenv("CREDENTIALS_PUBLIC_URL"))
+	if raw == "" {
+		return "", errors.New("CREDENTIALS_PUBLIC_URL is not set")
 	}
-	return host
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("CREDENTIALS_PUBLIC_URL %q is not an https URL", raw)
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
 }
 
 func respondError(w http.ResponseWriter, code int, message string, err error) {
-	log.Printf("Error: %s - %v", message, err)
+	level := slog.LevelWarn
+	if code >= 500 {
+		level = slog.LevelError
+	}
+	slog.Log(responseContext(w), level, message, "status", code, "error", err)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(map[string]any{
@@ -1014,77 +1146,130 @@ func respondSuccess(w http.ResponseWriter, code int, data any) {
 	})
 }
 
-// CORS middleware with enhanced configuration
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		
-		// Allow requests from the web portal
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-		}
-		
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Accept")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Max-Age", "3600")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+// corsMiddleware allows cross-origin calls from the configured origins only.
+// "*" allows any origin. API credentials travel in headers, so cookies are
+// never allowed cross-origin.
+func corsMiddleware(allowedOrigins []string) mux.MiddlewareFunc {
+	anyOrigin := slices.Contains(allowedOrigins, "*")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+
+			allowed := origin != "" && (anyOrigin || slices.Contains(allowedOrigins, origin))
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Requested-With, X-Request-ID, Accept, Idempotency-Key")
+				w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Retry-After, Idempotent-Replayed")
+				w.Header().Set("Access-Control-Max-Age", "3600")
+			}
+
+			// Handle preflight requests
+			if r.Method == "OPTIONS" {
+				if origin != "" && !allowed {
+					slog.WarnContext(r.Context(), "Rejected CORS preflight", "origin", origin)
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-// Logging middleware
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		log.Printf("%s %s %s Origin: %s", r.Method, r.RequestURI, r.RemoteAddr, r.Header.Get("Origin"))
-		next.ServeHTTP(w, r)
-		log.Printf("Completed in %v", time.Since(start))
-	})
+// corsOriginsFromEnv parses the comma-separated CORS_ALLOWED_ORIGINS
+func corsOriginsFromEnv() []string {
+	var origins []string
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	return origins
 }
 
 func main() {
+	logging.Setup("custom-credentials")
+
+	dataDir := os.Getenv("CREDENTIALS_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "state"
+	}
+
+	// API client administration: main clients add|list|revoke|usage
+	if len(os.Args) > 1 && os.Args[1] == "clients" {
+		if err := apiauth.Command(dataDir, allScopes, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "7105"
 	}
 
-	service := NewCredentialService()
-	r := mux.NewRouter()
+	// Issued credentials reference their schema under the public URL, so the
+	// service does not start without one
+	base, err := publicURLFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid public URL: %v", err)
+	}
+	publicURL = base
 
-	// Health check
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status":  "healthy",
-			"service": "farmer-credential-service",
-			"version": "1.0.2",
-		})
-	}).Methods("GET", "OPTIONS")
+	// The data directory is created up front so that the storage health
+	// check passes before the first client or usage record is written
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	usage, err := apiauth.OpenUsage(apiauth.UsagePath(dataDir))
+	if err != nil {
+		log.Fatalf("Failed to load API usage: %v", err)
+	}
+	apiAuth, err := newAPIAuth(dataDir, usage)
+	if err != nil {
+		log.Fatalf("Failed to load API clients: %v", err)
+	}
 
-	// VC Repository compatible endpoints (MATCHING EXACT API FORMAT)
-	r.HandleFunc("/api/list", service.GetVCRepoListHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/vc/{id}", service.GetVCByIDHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/credentials", service.GetVCRepoCredentialsHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/mapping/{id}", service.GetCredentialMappingHandler).Methods("GET", "OPTIONS")
+	// Responses to issuance requests with an Idempotency-Key, for replays
+	idempotency, err := NewIdempotencyStore(idempotencyPath(dataDir))
+	if err != nil {
+		log.Fatalf("Failed to load idempotency keys: %v", err)
+	}
 
-	// Original credential endpoints (kept for backward compatibility)
-	r.HandleFunc("/credentials/issue", service.IssueCredentialHandler).Methods("POST", "OPTIONS")
-	r.HandleFunc("/credentials/verify", service.VerifyCredentialHandler).Methods("POST", "OPTIONS")
-	r.HandleFunc("/credentials/types", service.ListCredentialTypesHandler).Methods("GET", "OPTIONS")
-	r.HandleFunc("/credentials/schemas/{type}", service.GetCredentialSchemaHandler).Methods("GET", "OPTIONS")
+	// Farmers already issued credentials, to hold back probable duplicates
+	farmers, err := NewFarmerRegistry(registryPath(dataDir))
+	if err != nil {
+		log.Fatalf("Failed to load farmer registry: %v", err)
+	}
+
+	// Per-client and per-IP limits on the endpoints that reach walt.id or send SMS
+	limits := NewRateLimitsFromEnv()
+
+	service := NewCredentialService()
+	service.farmers = farmers
+
+	// Dependency checks for /health and /healthz
+	health := server.NewHealth(service.healthChecks(dataDir)...)
+
+	// The VC repository endpoints are read by the walt.id web portal, which
+	// cannot send credentials; PUBLIC_CATALOGUE=true leaves them open
+	publicCatalogue := os.Getenv("PUBLIC_CATALOGUE") == "true"
+	r, spec := newRouter(service, apiAuth, limits, idempotency, farmers, health, publicCatalogue)
+	if err := checkOpenAPI(r, spec); err != nil {
+		slog.Warn("OpenAPI document and routes differ", "error", err)
+	}
 
 	// Apply middleware (ORDER MATTERS - CORS must be first!)
-	r.Use(corsMiddleware)
-	r.Use(loggingMiddleware)
+	corsOrigins := corsOriginsFromEnv()
+	r.Use(corsMiddleware(corsOrigins))
+	r.Use(limitBodyMiddleware)
 
 	// Get host interface
 	host := os.Getenv("HOST")
@@ -1092,21 +1277,99 @@ func main() {
 		host = "0.0.0.0" // Bind to all interfaces by default
 	}
 
-	log.Printf("Farmer Credential Service")
-	log.Printf("Starting on %s:%s", host, port)
-	log.Printf("Health: http://localhost:%s/health", port)
-	log.Printf("VC Repo API (List): GET http://localhost:%s/api/list", port)
-	log.Printf("VC Repo API (By ID): GET http://localhost:%s/api/vc/{id}", port)
-	log.Printf("VC Repo API (All): GET http://localhost:%s/api/credentials", port)
-	log.Printf("Issue: POST http://localhost:%s/credentials/issue", port)
-	log.Printf("Verify: POST http://localhost:%s/credentials/verify", port)
-	log.Printf("Types: GET http://localhost:%s/credentials/types", port)
-	log.Printf("Schema: GET http://localhost:%s/credentials/schemas/{type}", port)
-	log.Printf("CORS enabled for web portal access")
-	log.Printf("Accessible from network on http://<your-ip>:%s", port)
+	slog.Info("Farmer Credential Service starting",
+		"addr", host+":"+port,
+		"url", "http://localhost:"+port,
+		"public_url", publicURL,
+		"api_docs", "http://localhost:"+port+docsPath,
+		"otp_required", service.otp.required,
+		"public_catalogue", publicCatalogue,
+		"cors_allowed_origins", corsOrigins,
+	)
 
 	addr := host + ":" + port
-	if err := http.ListenAndServe(addr, r); err != nil {
+	// Traces exported over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(context.Background(), "custom-credentials")
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	handler := tracing.RouteMiddleware(func(req *http.Request) string {
+		return routeTemplate(r, req)
+	}, logging.Middleware(r))
+	err = server.New(server.ConfigFromEnv(addr), metricsMiddleware(r, handler), health).Run()
+
+	// Save the usage counted since the last periodic flush
+	if err := usage.Flush(); err != nil {
+		slog.Error("Error saving API usage", "error", err)
+	}
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
+}
+
+// newRouter registers every route. It returns the router and the API
+// description, which openapi_test.go checks against each other.
+func newRouter(service *CredentialService, apiAuth *apiauth.Auth, limits *RateLimits, idempotency *IdempotencyStore,
+	farmers *FarmerRegistry, health *server.Health, publicCatalogue bool) (*mux.Router, map[string]any) {
+	catalogue := func(h http.HandlerFunc) http.HandlerFunc { return apiAuth.Require(ScopeReadCatalogue, h) }
+	if publicCatalogue {
+		catalogue = func(h http.HandlerFunc) http.HandlerFunc { return h }
+	}
+
+	r := mux.NewRouter()
+
+	// Health check, running the same dependency checks as /healthz
+	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		serveHealth(w, health)
+	}).Methods("GET", "OPTIONS")
+
+	// VC Repository compatible endpoints (MATCHING EXACT API FORMAT)
+	r.HandleFunc("/api/list", catalogue(service.GetVCRepoListHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/vc/{id}", catalogue(service.GetVCByIDHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/credentials", catalogue(service.GetVCRepoCredentialsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/mapping/{id}", catalogue(service.GetCredentialMappingHandler)).Methods("GET", "OPTIONS")
+
+	// Original credential endpoints (kept for backward compatibility)
+	r.HandleFunc("/credentials/issue", apiAuth.Require(ScopeIssue, limits.Limit(idempotency.Handle(service.IssueCredentialHandler)))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/credentials/verify", apiAuth.Require(ScopeVerify, limits.Limit(service.VerifyCredentialHandler))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/credentials/types", catalogue(service.ListCredentialTypesHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/credentials/schemas/{type}", catalogue(service.GetCredentialSchemaHandler)).Methods("GET", "OPTIONS")
+
+	// Phone number verification
+	r.HandleFunc("/otp/send", apiAuth.Require(ScopeIssue, limits.Limit(service.SendOTPHandler))).Methods("POST", "OPTIONS")
+	r.HandleFunc("/otp/verify", apiAuth.Require(ScopeIssue, limits.Limit(service.VerifyOTPHandler))).Methods("POST", "OPTIONS")
+
+	// Supervisor review of probable duplicate farmers
+	r.HandleFunc("/duplicates", apiAuth.Require(ScopeReviewDuplicates, farmers.ListReviewsHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/duplicates/{id}", apiAuth.Require(ScopeReviewDuplicates, farmers.GetReviewHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/duplicates/{id}/resolve", apiAuth.Require(ScopeReviewDuplicates, farmers.ResolveReviewHandler)).Methods("POST", "OPTIONS")
+
+	// Units of measure accepted for farm size and production
+	r.HandleFunc("/units", catalogue(service.ListUnitsHandler)).Methods("GET", "OPTIONS")
+
+	// Administrative geography (counties and sub-counties)
+	r.HandleFunc("/geo/counties", catalogue(service.ListCountiesHandler)).Methods("GET", "OPTIONS")
+	r.HandleFunc("/geo/counties/{id}/subcounties", catalogue(service.ListSubCountiesHandler)).Methods("GET", "OPTIONS")
+
+	// API client authentication
+	r.HandleFunc("/oauth/token", limits.Limit(apiAuth.TokenHandler)).Methods("POST", "OPTIONS")
+	r.HandleFunc("/usage", apiAuth.Require("", usageHandler(apiAuth))).Methods("GET", "OPTIONS")
+
+	// Published JSON Schemas referenced by credentialSchema in issued credentials.
+	// Public so that any verifier can resolve them.
+	r.HandleFunc("/schemas/{version}/{id}.json", service.GetVersionedSchemaHandler).Methods("GET", "OPTIONS")
+
+	// Prometheus metrics, behind a bearer token when METRICS_TOKEN is set
+	r.Handle(metricsPath, metrics.Handler(os.Getenv("METRICS_TOKEN"))).Methods("GET")
+
+	// API description and docs
+	spec := buildOpenAPI(publicCatalogue)
+	r.HandleFunc(openAPIPath, OpenAPIHandler(spec)).Methods("GET", "OPTIONS")
+	r.HandleFunc(docsPath, DocsHandler).Methods("GET", "OPTIONS")
+
+	return r, spec
 }
\ No newline at end of file
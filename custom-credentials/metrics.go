@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/waltid"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// metricsPath is where the Prometheus metrics are served
+const metricsPath = metrics.Path
+
+// Outcomes of issuance, verification and walt.id calls. Labels only take
+// values from fixed sets like this one so the number of series stays small.
+const (
+	outcomeSuccess     = "success"     // walt.id answered with 200
+	outcomeInvalid     = "invalid"     // the request failed validation
+	outcomeRejected    = "rejected"    // walt.id answered with an error status
+	outcomeError       = "error"       // walt.id could not be reached or answered garbage
+	outcomeBusy        = "busy"        // no walt.id slot became free in time
+	outcomeUnavailable = "unavailable" // the walt.id circuit breaker is open
+	outcomeVerified    = "verified"    // walt.id verified the credential
+)
+
+var (
+	credentialsIssued = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "credentials",
+		Name:      "issued_total",
+		Help:      "Issuance requests, by credential type and outcome.",
+	}, []string{"credential_type", "outcome"})
+
+	credentialsVerified = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "credentials",
+		Name:      "verified_total",
+		Help:      "Verification requests, by outcome; rejected means walt.id did not verify the credential.",
+	}, []string{"outcome"})
+
+	waltIDDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "credentials",
+		Name:      "waltid_request_duration_seconds",
+		Help:      "Latency of walt.id calls, by operation and outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation", "outcome"})
+
+	waltIDInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "credentials",
+		Name:      "waltid_requests_in_flight",
+		Help:      "walt.id calls in progress, by operation.",
+	}, []string{"operation"})
+
+	waltIDRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "credentials",
+		Name:      "waltid_retries_total",
+		Help:      "walt.id calls retried after a transient failure, by operation.",
+	}, []string{"operation"})
+
+	waltIDCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "credentials",
+		Name:      "waltid_circuit_open",
+		Help:      "1 while the walt.id circuit breaker is open.",
+	})
+)
+
+func init() {
+	// Start every series at zero so rates and alerts work before the
+	// first request of each kind
+	for farmerType := range farmerTypeSpecifics {
+		for _, outcome := range []string{outcomeSuccess, outcomeInvalid, outcomeRejected, outcomeError, outcomeBusy, outcomeUnavailable} {
+			credentialsIssued.WithLabelValues(credentialTypeID(farmerType), outcome)
+		}
+	}
+	for _, outcome := range []string{outcomeVerified, outcomeRejected, outcomeError, outcomeBusy, outcomeUnavailable} {
+		credentialsVerified.WithLabelValues(outcome)
+	}
+}
+
+// countIssuance counts an issuance request for farmerType. Unknown farmer
+// types are counted as "other".
+func countIssuance(farmerType, outcome string) {
+	credentialType := "other"
+	if _, ok := farmerTypeSpecifics[farmerType]; ok {
+		credentialType = credentialTypeID(farmerType)
+	}
+	credentialsIssued.WithLabelValues(credentialType, outcome).Inc()
+}
+
+// waltIDStatusError is a walt.id response with an error status
+type waltIDStatusError struct {
+	status int
+	body   string
+}
+
+func (e *waltIDStatusError) Error() string {
+	return fmt.Sprintf("walt.id returned status %d: %s", e.status, e.body)
+}
+
+// waltIDOutcome classifies the error from a walt.id call
+func waltIDOutcome(err error) string {
+	var statusErr *waltIDStatusError
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case errors.Is(err, ratelimit.ErrBusy):
+		return outcomeBusy
+	case errors.Is(err, waltid.ErrUnavailable):
+		return outcomeUnavailable
+	case errors.As(err, &statusErr):
+		return outcomeRejected
+	default:
+		return outcomeError
+	}
+}
+
+// waltIDObserver records the walt.id calls of one operation, "issue" or
+// "verify". It implements waltid.Observer.
+type waltIDObserver string
+
+// Call marks a walt.id call as in flight. The returned function ends it,
+// recording its latency under outcome.
+func (o waltIDObserver) Call() func(outcome string) {
+	start := time.Now()
+	waltIDInFlight.WithLabelValues(string(o)).Inc()
+	return func(outcome string) {
+		waltIDInFlight.WithLabelValues(string(o)).Dec()
+		waltIDDuration.WithLabelValues(string(o), outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Retry counts a retried walt.id call
+func (o waltIDObserver) Retry() {
+	waltIDRetries.WithLabelValues(string(o)).Inc()
+}
+
+// CircuitOpen records the state of the walt.id circuit breaker
+func (o waltIDObserver) CircuitOpen(open bool) {
+	if open {
+		waltIDCircuitOpen.Set(1)
+	} else {
+		waltIDCircuitOpen.Set(0)
+	}
+}
+
+// metricsMiddleware records HTTP server metrics with the shared metrics
+// middleware. Requests are labelled with the route template that serves
+// them, or "unmatched", never with the raw path.
+func metricsMiddleware(router *mux.Router, next http.Handler) http.Handler {
+	return metrics.RouteMiddleware(func(r *http.Request) string {
+		return routeTemplate(router, r)
+	}, next)
+}
+
+// routeTemplate returns the template of the route serving r, or "unmatched"
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if tmpl, err := match.Route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unmatched"
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/gorilla/mux"
+)
+
+// Paths the API description is served at
+const (
+	openAPIPath = "/openapi.json"
+	docsPath    = "/docs"
+)
+
+// APIVersion is the version of the OpenAPI document
+const APIVersion = "1.0.2"
+
+//go:embed data/openapi-docs.html
+var docsPage []byte
+
+// openAPIOperation is one method on one path of the API
+type openAPIOperation struct {
+	method, path string
+	operation    map[string]any
+}
+
+// scoped is the security requirement of an endpoint needing scope: an API
+// key holding it or an OAuth access token granted it
+func scoped(scope string) []any {
+	oauthScopes := []string{}
+	if scope != "" {
+		oauthScopes = []string{scope}
+	}
+	return []any{
+		map[string]any{"apiKey": []string{}},
+		map[string]any{"oauth2": oauthScopes},
+	}
+}
+
+// public is the security requirement of an endpoint open to anyone
+var public = []any{map[string]any{}}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// successResponse describes a respondSuccess envelope around data
+func successResponse(description string, data map[string]any) map[string]any {
+	return map[string]any{
+		"description": description,
+		"content": jsonContent(map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"success": map[string]any{"const": true},
+				"data":    data,
+			},
+			"required": []string{"success", "data"},
+		}),
+	}
+}
+
+// errorResponse describes a respondError envelope
+func errorResponse(description string) map[string]any {
+	return map[string]any{"description": description, "content": jsonContent(schemaRef("Error"))}
+}
+
+// retryResponse describes an error response carrying Retry-After
+func retryResponse(description string) map[string]any {
+	resp := errorResponse(description)
+	resp["headers"] = map[string]any{
+		"Retry-After": map[string]any{
+			"description": "Seconds to wait before retrying",
+			"schema":      map[string]any{"type": "integer"},
+		},
+	}
+	return resp
+}
+
+func jsonResponse(description string, schema map[string]any) map[string]any {
+	return map[string]any{"description": description, "content": jsonContent(schema)}
+}
+
+func objectSchema(properties map[string]any, required ...string) map[string]any {
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var (
+	stringSchema  = map[string]any{"type": "string"}
+	integerSchema = map[string]any{"type": "integer"}
+	stringArray   = map[string]any{"type": "array", "items": stringSchema}
+
+	// healthSchema describes /health: the service, its overall status and
+	// each dependency check by name
+	healthSchema = objectSchema(map[string]any{
+		"status":     map[string]any{"type": "string", "enum": []string{"healthy", "degraded", "unhealthy"}},
+		"service":    stringSchema,
+		"version":    stringSchema,
+		"checked_at": map[string]any{"type": "string", "format": "date-time"},
+		"checks": map[string]any{
+			"type": "object",
+			"additionalProperties": objectSchema(map[string]any{
+				"status":     map[string]any{"type": "string", "enum": []string{"ok", "failed"}},
+				"local":      map[string]any{"type": "boolean"},
+				"latency_ms": map[string]any{"type": "number"},
+				"error":      stringSchema,
+			}, "status", "local", "latency_ms"),
+		},
+	}, "status", "service", "version", "checked_at", "checks")
+)
+
+// openAPIOperations lists every documented endpoint. Catalogue endpoints are
+// public when publicCatalogue is set, as in main.
+func openAPIOperations(publicCatalogue bool) []openAPIOperation {
+	catalogue := scoped(ScopeReadCatalogue)
+	if publicCatalogue {
+		catalogue = public
+	}
+
+	authErrors := func(responses map[string]any) map[string]any {
+		responses["401"] = errorResponse("Missing or invalid API key or access token")
+		responses["403"] = errorResponse("Client lacks the required scope")
+		return responses
+	}
+	catalogueResponses := func(responses map[string]any) map[string]any {
+		if publicCatalogue {
+			return responses
+		}
+		return authErrors(responses)
+	}
+	limited := func(responses map[string]any) map[string]any {
+		responses["413"] = errorResponse("Request body too large")
+		responses["429"] = retryResponse("Rate limit exceeded")
+		return authErrors(responses)
+	}
+
+	// A blocked duplicate is refused with 403, as is a client without the scope
+	issueResponses := limited(map[string]any{
+		"200": successResponse("Credential issued", map[string]any{"type": "object", "description": "walt.id issuance response"}),
+		"400": errorResponse("Invalid request or Idempotency-Key"),
+		"404": errorResponse("Unknown duplicateReviewId"),
+		"409": jsonResponse("Probable duplicate farmer, held for a supervisor; or the duplicateReviewId does not clear this request",
+			objectSchema(map[string]any{
+				"success":  map[string]any{"const": false},
+				"error":    stringSchema,
+				"details":  stringSchema,
+				"reviewId": stringSchema,
+				"matches":  map[string]any{"type": "array", "items": schemaRef("DuplicateMatch")},
+			}, "success", "error", "details")),
+		"422": errorResponse("Idempotency-Key was already used for a different request"),
+		"500": errorResponse("walt.id failed to issue the credential"),
+		"503": retryResponse("walt.id is saturated"),
+	})
+	issueResponses["403"] = errorResponse("Client lacks the required scope, or a supervisor blocked the issuance as a duplicate")
+	reviewID := map[string]any{"name": "id", "in": "path", "required": true, "schema": stringSchema}
+
+	return []openAPIOperation{
+		{"GET", "/health", map[string]any{
+			"operationId": "health",
+			"summary":     "Service health",
+			"description": "Checks walt.id, the credential templates, storage and the issuer key, as /healthz does. The report is cached for 5 seconds.",
+			"tags":        []string{"Service"},
+			"security":    public,
+			"responses": map[string]any{
+				"200": jsonResponse("Every dependency is healthy", healthSchema),
+				"503": jsonResponse("A dependency is failing; status is degraded or unhealthy", healthSchema),
+			},
+		}},
+
+		{"GET", "/api/list", map[string]any{
+			"operationId": "listCredentialTypeIds",
+			"summary":     "Credential type IDs (VC repository format)",
+			"tags":        []string{"VC repository"},
+			"security":    catalogue,
+			"responses":   catalogueResponses(map[string]any{"200": jsonResponse("Credential type IDs", stringArray)}),
+		}},
+		{"GET", "/api/vc/{id}", map[string]any{
+			"operationId": "getCredentialType",
+			"summary":     "Credential type (VC repository format)",
+			"tags":        []string{"VC repository"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("The credential type", schemaRef("VCRepoCredential")),
+				"404": jsonResponse("Unknown credential type", objectSchema(map[string]any{"error": stringSchema}, "error")),
+			}),
+		}},
+		{"GET", "/api/credentials", map[string]any{
+			"operationId": "listCredentialTypes",
+			"summary":     "All credential types (VC repository format)",
+			"tags":        []string{"VC repository"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("Credential types", map[string]any{"type": "array", "items": schemaRef("VCRepoCredential")}),
+			}),
+		}},
+		{"GET", "/api/mapping/{id}", map[string]any{
+			"operationId": "getCredentialMapping",
+			"summary":     "Field mapping of a credential type",
+			"tags":        []string{"VC repository"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("The walt.id data mapping", map[string]any{"type": "object"}),
+				"404": jsonResponse("Unknown credential type", objectSchema(map[string]any{"error": stringSchema}, "error")),
+			}),
+		}},
+
+		{"POST", "/credentials/issue", map[string]any{
+			"operationId": "issueCredential",
+			"summary":     "Issue a farmer credential",
+			"description": "Validates the request, normalises the location, units and phone number, and issues the credential through walt.id. " +
+				"A farmer who probably already holds a credential of the type is held back with 409 and a review ID; " +
+				"once a supervisor links or overrides the review, repeat the request with duplicateReviewId set to it.",
+			"tags":     []string{"Credentials"},
+			"security": scoped(ScopeIssue),
+			"parameters": []any{map[string]any{
+				"name": idempotencyKeyHeader, "in": "header",
+				"description": "Unique key for this issuance. A request repeated with the same key and body within 24 hours " +
+					"returns the original response, with Idempotent-Replayed: true, instead of issuing again.",
+				"schema": map[string]any{"type": "string", "maxLength": maxIdempotencyKeySize},
+			}},
+			"requestBody": map[string]any{"required": true, "content": jsonContent(schemaRef("FarmerCredentialRequest"))},
+			"responses":   issueResponses,
+		}},
+		{"POST", "/credentials/verify", map[string]any{
+			"operationId": "verifyCredential",
+			"summary":     "Verify a credential",
+			"tags":        []string{"Credentials"},
+			"security":    scoped(ScopeVerify),
+			"requestBody": map[string]any{
+				"required":    true,
+				"description": "The credential as a compact JWT",
+				"content":     map[string]any{"text/plain": map[string]any{"schema": stringSchema}},
+			},
+			"responses": limited(map[string]any{
+				"200": jsonResponse("Verification result", objectSchema(map[string]any{
+					"verified": map[string]any{"type": "boolean"},
+					"result":   map[string]any{"type": "object"},
+				}, "verified", "result")),
+				"400": errorResponse("Unreadable request"),
+				"500": errorResponse("walt.id failed to verify the credential"),
+				"503": retryResponse("walt.id is saturated"),
+			}),
+		}},
+		{"GET", "/credentials/types", map[string]any{
+			"operationId": "listFarmerTypes",
+			"summary":     "Farmer types",
+			"tags":        []string{"Credentials"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("Farmer types", objectSchema(map[string]any{
+					"types": map[string]any{"type": "array", "items": objectSchema(map[string]any{
+						"type": stringSchema, "name": stringSchema, "icon": stringSchema, "description": stringSchema,
+					}, "type", "name", "icon", "description")},
+				}, "types")),
+			}),
+		}},
+		{"GET", "/credentials/schemas/{type}", map[string]any{
+			"operationId": "getCredentialSchema",
+			"summary":     "JSON Schema of a farmer type's credential",
+			"tags":        []string{"Credentials"},
+			"security":    catalogue,
+			"parameters": []any{map[string]any{
+				"name": "type", "in": "path", "required": true,
+				"schema": map[string]any{"type": "string", "enum": farmerTypes()},
+			}},
+			"responses": catalogueResponses(map[string]any{
+				"200": map[string]any{"description": "JSON Schema 2020-12 document",
+					"content": map[string]any{"application/schema+json": map[string]any{"schema": map[string]any{"type": "object"}}}},
+				"404": errorResponse("Unknown farmer type"),
+			}),
+		}},
+
+		{"POST", "/otp/send", map[string]any{
+			"operationId": "sendOTP",
+			"summary":     "Send a verification code by SMS",
+			"tags":        []string{"Phone verification"},
+			"security":    scoped(ScopeIssue),
+			"requestBody": map[string]any{"required": true, "content": jsonContent(objectSchema(map[string]any{
+				"phoneNumber": stringSchema,
+			}, "phoneNumber"))},
+			"responses": limited(map[string]any{
+				"200": successResponse("Code sent", objectSchema(map[string]any{
+					"phoneNumber": stringSchema, "operator": stringSchema, "expiresIn": integerSchema,
+				}, "phoneNumber", "operator", "expiresIn")),
+				"400": errorResponse("Invalid phone number"),
+				"502": errorResponse("The SMS could not be sent"),
+			}),
+		}},
+		{"POST", "/otp/verify", map[string]any{
+			"operationId": "verifyOTP",
+			"summary":     "Exchange a verification code for a phone verification token",
+			"tags":        []string{"Phone verification"},
+			"security":    scoped(ScopeIssue),
+			"requestBody": map[string]any{"required": true, "content": jsonContent(objectSchema(map[string]any{
+				"phoneNumber": stringSchema, "code": stringSchema,
+			}, "phoneNumber", "code"))},
+			"responses": limited(map[string]any{
+				"200": successResponse("Phone number verified", objectSchema(map[string]any{
+					"phoneNumber": stringSchema, "phoneVerificationToken": stringSchema, "expiresIn": integerSchema,
+				}, "phoneNumber", "phoneVerificationToken", "expiresIn")),
+				"400": errorResponse("Invalid phone number"),
+			}),
+		}},
+
+		{"GET", "/duplicates", map[string]any{
+			"operationId": "listDuplicateReviews",
+			"summary":     "Reviews of probable duplicate farmers",
+			"tags":        []string{"Duplicate farmers"},
+			"security":    scoped(ScopeReviewDuplicates),
+			"parameters": []any{map[string]any{
+				"name": "status", "in": "query", "description": "Reviews in this state, or all; pending by default",
+				"schema": map[string]any{"type": "string", "enum": []string{"pending", "linked", "overridden", "blocked", "all"}},
+			}},
+			"responses": authErrors(map[string]any{
+				"200": successResponse("Reviews, oldest first", map[string]any{"type": "array", "items": schemaRef("DuplicateReview")}),
+				"400": errorResponse("Invalid status"),
+			}),
+		}},
+		{"GET", "/duplicates/{id}", map[string]any{
+			"operationId": "getDuplicateReview",
+			"summary":     "A review with the records of the farmers it matched",
+			"tags":        []string{"Duplicate farmers"},
+			"security":    scoped(ScopeReviewDuplicates),
+			"parameters":  []any{reviewID},
+			"responses": authErrors(map[string]any{
+				"200": successResponse("The review", objectSchema(map[string]any{
+					"review":  schemaRef("DuplicateReview"),
+					"records": map[string]any{"type": "array", "items": schemaRef("FarmerRecord")},
+				}, "review", "records")),
+				"404": errorResponse("Unknown review"),
+			}),
+		}},
+		{"POST", "/duplicates/{id}/resolve", map[string]any{
+			"operationId": "resolveDuplicateReview",
+			"summary":     "Link, override or block a probable duplicate",
+			"description": "link records the farmer as the one holding recordId, which defaults to the only match; " +
+				"override records them as a different farmer; both let the issuance go ahead. block refuses it.",
+			"tags":       []string{"Duplicate farmers"},
+			"security":   scoped(ScopeReviewDuplicates),
+			"parameters": []any{reviewID},
+			"requestBody": map[string]any{"required": true, "content": jsonContent(objectSchema(map[string]any{
+				"decision": map[string]any{"type": "string", "enum": []string{"link", "override", "block"}},
+				"recordId": stringSchema,
+				"reason":   stringSchema,
+			}, "decision"))},
+			"responses": authErrors(map[string]any{
+				"200": successResponse("The resolved review", schemaRef("DuplicateReview")),
+				"400": errorResponse("Invalid decision or recordId"),
+				"404": errorResponse("Unknown review"),
+				"409": errorResponse("Review already resolved"),
+				"413": errorResponse("Request body too large"),
+			}),
+		}},
+
+		{"GET", "/units", map[string]any{
+			"operationId": "listUnits",
+			"summary":     "Accepted units of measure by dimension",
+			"tags":        []string{"Reference data"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("Units by dimension", map[string]any{
+					"type": "object",
+					"additionalProperties": objectSchema(map[string]any{
+						"canonical": stringSchema, "accepted": stringArray,
+					}, "canonical", "accepted"),
+				}),
+			}),
+		}},
+		{"GET", "/geo/counties", map[string]any{
+			"operationId": "listCounties",
+			"summary":     "Kenyan counties",
+			"tags":        []string{"Reference data"},
+			"security":    catalogue,
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("Counties", map[string]any{"type": "array", "items": objectSchema(map[string]any{
+					"code": stringSchema, "name": stringSchema, "region": stringSchema,
+				}, "code", "name", "region")}),
+			}),
+		}},
+		{"GET", "/geo/counties/{id}/subcounties", map[string]any{
+			"operationId": "listSubCounties",
+			"summary":     "Sub-counties of a county",
+			"tags":        []string{"Reference data"},
+			"security":    catalogue,
+			"parameters": []any{map[string]any{
+				"name": "id", "in": "path", "required": true, "description": "County code or name",
+				"schema": stringSchema,
+			}},
+			"responses": catalogueResponses(map[string]any{
+				"200": jsonResponse("Sub-county names", stringArray),
+				"404": errorResponse("Unknown county"),
+			}),
+		}},
+
+		{"POST", "/oauth/token", map[string]any{
+			"operationId": "token",
+			"summary":     "Get an access token (client credentials grant)",
+			"description": "Client credentials may be sent with HTTP Basic authentication or as client_id and client_secret form fields.",
+			"tags":        []string{"API access"},
+			"security":    public,
+			"requestBody": map[string]any{"required": true, "content": map[string]any{
+				"application/x-www-form-urlencoded": map[string]any{"schema": objectSchema(map[string]any{
+					"grant_type":    map[string]any{"const": "client_credentials"},
+					"scope":         map[string]any{"type": "string", "description": "Space-separated subset of the client's scopes"},
+					"client_id":     stringSchema,
+					"client_secret": stringSchema,
+				}, "grant_type")},
+			}},
+			"responses": map[string]any{
+				"200": jsonResponse("Access token", objectSchema(map[string]any{
+					"access_token": stringSchema,
+					"token_type":   map[string]any{"const": "Bearer"},
+					"expires_in":   integerSchema,
+					"scope":        stringSchema,
+				}, "access_token", "token_type", "expires_in", "scope")),
+				"400": jsonResponse("invalid_request, unsupported_grant_type or invalid_scope", schemaRef("OAuthError")),
+				"401": jsonResponse("invalid_client", schemaRef("OAuthError")),
+				"429": retryResponse("Rate limit exceeded"),
+			},
+		}},
+		{"GET", "/usage", map[string]any{
+			"operationId": "getUsage",
+			"summary":     "The calling client's usage",
+			"tags":        []string{"API access"},
+			"security":    scoped(""),
+			"responses": authErrors(map[string]any{
+				"200": successResponse("Usage records", objectSchema(map[string]any{
+					"clientId": stringSchema,
+					"usage":    map[string]any{"type": "array", "items": schemaRef("UsageRecord")},
+				}, "clientId", "usage")),
+			}),
+		}},
+
+		{"GET", "/schemas/{version}/{id}.json", map[string]any{
+			"operationId": "getPublishedSchema",
+			"summary":     "Published credential schema",
+			"description": "The document referenced by credentialSchema.id in issued credentials.",
+			"tags":        []string{"Credentials"},
+			"security":    public,
+			"parameters": []any{
+				map[string]any{"name": "version", "in": "path", "required": true,
+					"schema": map[string]any{"const": SchemaVersion}},
+				map[string]any{"name": "id", "in": "path", "required": true, "description": "Credential type, e.g. DairyFarmerCredential",
+					"schema": stringSchema},
+			},
+			"responses": map[string]any{
+				"200": map[string]any{"description": "JSON Schema 2020-12 document",
+					"content": map[string]any{"application/schema+json": map[string]any{"schema": map[string]any{"type": "object"}}}},
+				"404": errorResponse("Unknown schema"),
+			},
+		}},
+	}
+}
+
+// farmerTypes lists the farmer types in a stable order
+func farmerTypes() []string {
+	types := make([]string, 0, len(farmerTypeSpecifics))
+	for t := range farmerTypeSpecifics {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	return types
+}
+
+var pathParam = regexp.MustCompile(`\{([^}]+)\}`)
+
+// buildOpenAPI assembles the OpenAPI 3.1 document
+func buildOpenAPI(publicCatalogue bool) map[string]any {
+	paths := map[string]any{}
+	for _, op := range openAPIOperations(publicCatalogue) {
+		// Declare any path parameters the operation leaves out
+		params, _ := op.operation["parameters"].([]any)
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			declared := slices.ContainsFunc(params, func(p any) bool {
+				return p.(map[string]any)["name"] == m[1]
+			})
+			if !declared {
+				params = append(params, map[string]any{
+					"name": m[1], "in": "path", "required": true, "schema": stringSchema,
+				})
+			}
+		}
+		if len(params) > 0 {
+			op.operation["parameters"] = params
+		}
+
+		item, ok := paths[op.path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = op.operation
+	}
+
+	request := schemaForType(reflect.TypeOf(FarmerCredentialRequest{}))
+	request["required"] = []string{"farmerType", "firstName", "county"}
+	request["properties"].(map[string]any)["farmerType"] = map[string]any{"type": "string", "enum": farmerTypes()}
+	request["properties"].(map[string]any)["phoneVerificationToken"] = map[string]any{
+		"type":        "string",
+		"description": "Returned by /otp/verify; required when OTP verification is enabled",
+	}
+	request["properties"].(map[string]any)["nationalId"] = map[string]any{
+		"type":        "string",
+		"description": "National ID number, used to recognise farmers who already hold a credential",
+	}
+	request["properties"].(map[string]any)["duplicateReviewId"] = map[string]any{
+		"type":        "string",
+		"description": "A review of this request as a probable duplicate that a supervisor linked or overrode",
+	}
+
+	// Records embed FarmerIdentity, which schemaForType does not flatten
+	identity := schemaForType(reflect.TypeOf(FarmerIdentity{}))
+	record := objectSchema(map[string]any{
+		"id":             stringSchema,
+		"credentialType": stringSchema,
+		"clientId":       stringSchema,
+		"linkedTo":       stringSchema,
+		"issuedAt":       map[string]any{"type": "string", "format": "date-time"},
+	}, "id", "credentialType", "clientId", "issuedAt")
+	for name, prop := range identity["properties"].(map[string]any) {
+		record["properties"].(map[string]any)[name] = prop
+	}
+	record["required"] = append(record["required"].([]string), identity["required"].([]string)...)
+	match := schemaForType(reflect.TypeOf(DuplicateMatch{}))
+	match["properties"].(map[string]any)["issuedAt"] = map[string]any{"type": "string", "format": "date-time"}
+	review := schemaForType(reflect.TypeOf(DuplicateReview{}))
+	review["properties"].(map[string]any)["matches"] = map[string]any{"type": "array", "items": schemaRef("DuplicateMatch")}
+	review["properties"].(map[string]any)["status"] = map[string]any{"type": "string", "enum": []string{"pending", "linked", "overridden", "blocked"}}
+	review["properties"].(map[string]any)["createdAt"] = map[string]any{"type": "string", "format": "date-time"}
+	review["properties"].(map[string]any)["resolvedAt"] = map[string]any{"type": "string", "format": "date-time"}
+
+	// schemaForType has no notion of time.Time, which encodes as RFC 3339
+	usage := schemaForType(reflect.TypeOf(apiauth.UsageRecord{}))
+	usage["properties"].(map[string]any)["lastUsedAt"] = map[string]any{"type": "string", "format": "date-time"}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":       "Farmer Credential Service",
+			"version":     APIVersion,
+			"description": "Issues and verifies farmer credentials through walt.id and serves the credential catalogue to the walt.id portal.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"FarmerCredentialRequest": request,
+				"VCRepoCredential":        schemaForType(reflect.TypeOf(VCRepoCredential{})),
+				"UsageRecord":             usage,
+				"FarmerRecord":            record,
+				"DuplicateMatch":          match,
+				"DuplicateReview":         review,
+				"Error": objectSchema(map[string]any{
+					"success": map[string]any{"const": false},
+					"error":   stringSchema,
+					"details": stringSchema,
+				}, "success", "error", "details"),
+				"OAuthError": objectSchema(map[string]any{
+					"error":             stringSchema,
+					"error_description": stringSchema,
+				}, "error", "error_description"),
+			},
+			"securitySchemes": map[string]any{
+				"apiKey": map[string]any{
+					"type": "apiKey", "in": "header", "name": "X-API-Key",
+					"description": "Client ID and secret joined by a dot: id.secret",
+				},
+				"oauth2": map[string]any{
+					"type": "oauth2",
+					"flows": map[string]any{
+						"clientCredentials": map[string]any{
+							"tokenUrl": "/oauth/token",
+							"scopes": map[string]any{
+								ScopeIssue:            "Issue credentials and verify phone numbers",
+								ScopeVerify:           "Verify credentials",
+								ScopeReadCatalogue:    "Read credential types, schemas and reference data",
+								ScopeReviewDuplicates: "Review issuances held back as probable duplicate farmers",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler handles GET /openapi.json
+func OpenAPIHandler(doc map[string]any) http.HandlerFunc {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("encoding OpenAPI document: %v", err))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(body)
+	}
+}
+
+// DocsHandler handles GET /docs
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// checkOpenAPI reports every route missing from doc and every documented
+// operation no route serves. Preflight-only OPTIONS methods and the
+// description's own routes are ignored.
+func checkOpenAPI(r *mux.Router, doc map[string]any) error {
+	routes := map[string]bool{}
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || path == openAPIPath || path == docsPath || path == metricsPath {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return fmt.Errorf("route %s has no methods", path)
+		}
+		for _, m := range methods {
+			if m != http.MethodOptions {
+				routes[m+" "+path] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var problems []string
+	for route := range routes {
+		if !documented[route] {
+			problems = append(problems, fmt.Sprintf("route %s is not in the OpenAPI document", route))
+		}
+	}
+	for op := range documented {
+		if !routes[op] {
+			problems = append(problems, fmt.Sprintf("operation %s has no registered route", op))
+		}
+	}
+
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("OpenAPI document and routes differ:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/server"
+)
+
+// TestOpenAPIMatchesRoutes fails when a route is missing from the OpenAPI
+// document or the document describes a route that is not served, with the
+// catalogue endpoints behind API keys and open
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	dir := t.TempDir()
+	usage, err := apiauth.OpenUsage(apiauth.UsagePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiAuth, err := newAPIAuth(dir, usage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idempotency, err := NewIdempotencyStore(idempotencyPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	farmers, err := NewFarmerRegistry(registryPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewCredentialService()
+	service.farmers = farmers
+	health := server.NewHealth(service.healthChecks(dir)...)
+
+	for _, publicCatalogue := range []bool{false, true} {
+		r, spec := newRouter(service, apiAuth, NewRateLimitsFromEnv(), idempotency, farmers, health, publicCatalogue)
+		if err := checkOpenAPI(r, spec); err != nil {
+			t.Errorf("public catalogue %v: %v", publicCatalogue, err)
+		}
+	}
+}
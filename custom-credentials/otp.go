@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// OTP settings
+const (
+	otpLength         = 6
+	otpTTL            = 5 * time.Minute
+	otpResendCooldown = 60 * time.Second
+	otpMaxAttempts    = 5
+	phoneTokenTTL     = 15 * time.Minute
+	otpSweepInterval  = time.Minute
+)
+
+// errOTPCooldown is returned when a code is requested again too soon
+var errOTPCooldown = errors.New("a code was sent recently, please wait before requesting another")
+
+// SMSSender delivers text messages to a phone number in E.164 format.
+// Production deployments plug in their SMS gateway here.
+type SMSSender interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+// LogSMSSender writes messages to the service log. For local development only.
+type LogSMSSender struct{}
+
+// Send logs the message instead of delivering it
+func (LogSMSSender) Send(ctx context.Context, to, message string) error {
+	slog.InfoContext(ctx, "SMS", "to", maskPhone(to), "sms_message", message)
+	return nil
+}
+
+// FileSMSSender appends messages to a local outbox file. For development and tests.
+type FileSMSSender struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Send appends the message to the outbox file
+func (f *FileSMSSender) Send(ctx context.Context, to, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open SMS outbox: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, message)
+	return err
+}
+
+// newSMSSenderFromEnv selects the SMS sender configured by SMS_SENDER
+func newSMSSenderFromEnv() SMSSender {
+	switch os.Getenv("SMS_SENDER") {
+	case "file":
+		path := os.Getenv("SMS_OUTBOX_FILE")
+		if path == "" {
+			path = "sms-outbox.log"
+		}
+		return &FileSMSSender{Path: path}
+	case "", "log":
+		return LogSMSSender{}
+	default:
+		log.Fatalf("Unknown SMS_SENDER %q (expected log or file)", os.Getenv("SMS_SENDER"))
+		return nil
+	}
+}
+
+// otpChallenge is an outstanding one-time code sent to a phone number
+type otpChallenge struct {
+	codeHash  [sha256.Size]byte
+	expiresAt time.Time
+	sentAt    time.Time
+	attempts  int
+}
+
+// phoneToken proves a phone number was verified with a one-time code
+type phoneToken struct {
+	phoneNumber string
+	expiresAt   time.Time
+}
+
+// OTPService sends one-time codes and exchanges correct codes for
+// single-use phone verification tokens accepted by /credentials/issue
+type OTPService struct {
+	sender   SMSSender
+	required bool
+
+	mu         sync.Mutex
+	challenges map[string]*otpChallenge
+	tokens     map[string]phoneToken
+	sweptAt    time.Time
+}
+
+// NewOTPService creates the OTP service. When required is true every
+// issuance must present a phone verification token.
+func NewOTPService(sender SMSSender, required bool) *OTPService {
+	return &OTPService{
+		sender:     sender,
+		required:   required,
+		challenges: make(map[string]*otpChallenge),
+		tokens:     make(map[string]phoneToken),
+	}
+}
+
+// Send generates a code for the phone number and delivers it by SMS
+func (o *OTPService) Send(ctx context.Context, phoneNumber string) error {
+	o.mu.Lock()
+	if existing, ok := o.challenges[phoneNumber]; ok && time.Since(existing.sentAt) < otpResendCooldown {
+		o.mu.Unlock()
+		return errOTPCooldown
+	}
+
+	code, err := randomDigits(otpLength)
+	if err != nil {
+		o.mu.Unlock()
+		return fmt.Errorf("failed to generate code: %w", err)
+	}
+
+	now := time.Now()
+	o.sweep(now)
+	o.challenges[phoneNumber] = &otpChallenge{
+		codeHash:  sha256.Sum256([]byte(code)),
+		expiresAt: now.Add(otpTTL),
+		sentAt:    now,
+	}
+	o.mu.Unlock()
+
+	message := fmt.Sprintf("Your Testa Gava verification code is %s. It expires in %d minutes.", code, int(otpTTL.Minutes()))
+	if err := o.sender.Send(ctx, phoneNumber, message); err != nil {
+		o.mu.Lock()
+		delete(o.challenges, phoneNumber)
+		o.mu.Unlock()
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+
+	return nil
+}
+
+// Verify checks a code and returns a phone verification token on success
+func (o *OTPService) Verify(phoneNumber, code string) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	challenge, ok := o.challenges[phoneNumber]
+	if !ok || time.Now().After(challenge.expiresAt) {
+		delete(o.challenges, phoneNumber)
+		return "", fmt.Errorf("no active code for this phone number")
+	}
+
+	challenge.attempts++
+	hash := sha256.Sum256([]byte(code))
+	if subtle.ConstantTimeCompare(hash[:], challenge.codeHash[:]) != 1 {
+		if challenge.attempts >= otpMaxAttempts {
+			delete(o.challenges, phoneNumber)
+			return "", fmt.Errorf("too many incorrect attempts, request a new code")
+		}
+		return "", fmt.Errorf("incorrect code")
+	}
+	delete(o.challenges, phoneNumber)
+
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	now := time.Now()
+	o.sweep(now)
+	o.tokens[token] = phoneToken{
+		phoneNumber: phoneNumber,
+		expiresAt:   now.Add(phoneTokenTTL),
+	}
+
+	return token, nil
+}
+
+// Consume redeems a verification token for the given phone number. Tokens are
+// single use so one verification cannot back several credentials. The token
+// is taken out at once, so concurrent requests cannot share it, and restore
+// puts it back for a retry when the credential was not issued.
+func (o *OTPService) Consume(token, phoneNumber string) (restore func(), err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	t, ok := o.tokens[token]
+	if !ok || time.Now().After(t.expiresAt) {
+		delete(o.tokens, token)
+		return nil, fmt.Errorf("phoneVerificationToken is invalid or expired")
+	}
+	if t.phoneNumber != phoneNumber {
+		return nil, fmt.Errorf("phoneVerificationToken was issued for a different phone number")
+	}
+
+	delete(o.tokens, token)
+	return func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.tokens[token] = t
+	}, nil
+}
+
+// sweep drops expired codes and tokens, at most once per otpSweepInterval.
+// Codes that are requested but never verified and tokens that are never
+// redeemed would otherwise stay in memory. Callers hold o.mu.
+func (o *OTPService) sweep(now time.Time) {
+	if now.Sub(o.sweptAt) < otpSweepInterval {
+		return
+	}
+	o.sweptAt = now
+
+	for phoneNumber, challenge := range o.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(o.challenges, phoneNumber)
+		}
+	}
+	for token, t := range o.tokens {
+		if now.After(t.expiresAt) {
+			delete(o.tokens, token)
+		}
+	}
+}
+
+// SendOTPHandler handles POST /otp/send
+func (s *CredentialService) SendOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PhoneNumber string `json:"phoneNumber"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	phoneNumber, operator, err := normalizeKenyanPhone(req.PhoneNumber)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid phone number", err)
+		return
+	}
+
+	if err := s.otp.Send(r.Context(), phoneNumber); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errOTPCooldown) {
+			status = http.StatusTooManyRequests
+		}
+		respondError(w, status, "Failed to send code", err)
+		return
+	}
+
+	respondSuccess(w, http.StatusOK, map[string]any{
+		"phoneNumber": phoneNumber,
+		"operator":    operator,
+		"expiresIn":   int(otpTTL.Seconds()),
+	})
+}
+
+// VerifyOTPHandler handles POST /otp/verify
+func (s *CredentialService) VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PhoneNumber string `json:"phoneNumber"`
+		Code        string `json:"code"`
+	}
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+
+	phoneNumber, _, err := normalizeKenyanPhone(req.PhoneNumber)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid phone number", err)
+		return
+	}
+
+	token, err := s.otp.Verify(phoneNumber, req.Code)
+	if err != nil {
+		respondError(w, http.StatusUnauthorized, "Verification failed", err)
+		return
+	}
+
+	respondSuccess(w, http.StatusOK, map[string]any{
+		"phoneNumber":            phoneNumber,
+		"phoneVerificationToken": token,
+		"expiresIn":              int(phoneTokenTTL.Seconds()),
+	})
+}
+
+// randomDigits returns a uniformly random numeric string of length n
+func randomDigits(n int) (string, error) {
+	digits := make([]byte, n)
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + d.Int64())
+	}
+	return string(digits), nil
+}
+
+// randomToken returns a 256-bit random hex token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
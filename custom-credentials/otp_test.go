@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/waltid"
+)
+
+// smsOutbox keeps the last message sent to each phone number
+type smsOutbox map[string]string
+
+func (o smsOutbox) Send(ctx context.Context, to, message string) error {
+	o[to] = message
+	return nil
+}
+
+// waltIDStub answers every walt.id call with status
+type waltIDStub struct {
+	status int
+}
+
+func (s *waltIDStub) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: s.status,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"id":"urn:uuid:1"}`)),
+		Request:    r,
+	}, nil
+}
+
+func TestPhoneTokenSpentOnIssuance(t *testing.T) {
+	outbox := smsOutbox{}
+	stub := &waltIDStub{status: http.StatusBadGateway}
+	service := NewCredentialService()
+	service.otp = NewOTPService(outbox, true)
+	service.waltIDIssue = waltid.New(waltid.Config{MaxConcurrent: 1, QueueWait: time.Second, Timeout: time.Second, Attempts: 1, FailureThreshold: 100}, stub)
+
+	const phone = "+254712345678"
+	if err := service.otp.Send(context.Background(), phone); err != nil {
+		t.Fatal(err)
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(outbox[phone])
+	token, err := service.otp.Verify(phone, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"farmerType":"horticulture","firstName":"Amina","familyName":"Otieno","phoneNumber":"0712 345 678",` +
+		`"county":"Nakuru","subCounty":"Njoro","phoneVerificationToken":"` + token + `",` +
+		`"horticultureSpecifics":{"crops":["Kale"],"farmingMethod":"open field","irrigationSystem":"drip"}}`
+	issue := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/credentials/issue", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		service.IssueCredentialHandler(w, r)
+		return w.Code
+	}
+
+	// A walt.id failure leaves the token for the retry, which spends it
+	tests := []struct {
+		name       string
+		waltStatus int
+		want       int
+	}{
+		{name: "walt.id fails", waltStatus: http.StatusBadGateway, want: http.StatusInternalServerError},
+		{name: "retry", waltStatus: http.StatusOK, want: http.StatusOK},
+		{name: "token reused", waltStatus: http.StatusOK, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		stub.status = tt.waltStatus
+		if got := issue(); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mobilePrefixRange is a range of Kenyan mobile number prefixes, expressed as
+// the first three digits of the national significant number (e.g. 712345678 -> 712)
+type mobilePrefixRange struct {
+	from, to int
+	operator string
+}
+
+// kenyanMobilePrefixes lists the prefixes allocated to mobile operators by the
+// Communications Authority of Kenya
+var kenyanMobilePrefixes = []mobilePrefixRange{
+	{700, 729, "Safaricom"},
+	{740, 743, "Safaricom"},
+	{745, 746, "Safaricom"},
+	{748, 748, "Safaricom"},
+	{757, 759, "Safaricom"},
+	{768, 769, "Safaricom"},
+	{790, 799, "Safaricom"},
+	{110, 115, "Safaricom"},
+	{730, 739, "Airtel"},
+	{750, 756, "Airtel"},
+	{762, 762, "Airtel"},
+	{780, 789, "Airtel"},
+	{100, 102, "Airtel"},
+	{770, 779, "Telkom"},
+	{747, 747, "Faiba"},
+	{763, 766, "Equitel"},
+}
+
+// normalizeKenyanPhone converts a Kenyan mobile number in any common local or
+// international format ("0712 345 678", "712345678", "254712345678",
+// "+254-712-345-678") to E.164 and reports the operator it belongs to. A '+'
+// may only lead the number, followed by the 254 country code.
+func normalizeKenyanPhone(raw string) (string, string, error) {
+	trimmed := strings.TrimSpace(raw)
+	international := strings.HasPrefix(trimmed, "+")
+	digits := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return r
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+			return -1
+		}
+		return 'x'
+	}, strings.TrimPrefix(trimmed, "+"))
+
+	if digits == "" {
+		return "", "", fmt.Errorf("phoneNumber is empty")
+	}
+	if strings.Contains(digits, "x") {
+		return "", "", fmt.Errorf("phoneNumber %q contains invalid characters", raw)
+	}
+	if international && !strings.HasPrefix(digits, "254") {
+		return "", "", fmt.Errorf("phoneNumber %q is not a Kenyan mobile number", raw)
+	}
+
+	// Reduce to the 9-digit national significant number
+	var nsn string
+	switch {
+	case strings.HasPrefix(digits, "00254") && len(digits) == 14:
+		nsn = digits[5:]
+	case strings.HasPrefix(digits, "254") && len(digits) == 12:
+		nsn = digits[3:]
+	case strings.HasPrefix(digits, "0") && len(digits) == 10:
+		nsn = digits[1:]
+	case len(digits) == 9:
+		nsn = digits
+	default:
+		return "", "", fmt.Errorf("phoneNumber %q is not a Kenyan mobile number", raw)
+	}
+
+	prefix, _ := strconv.Atoi(nsn[:3])
+	for _, p := range kenyanMobilePrefixes {
+		if prefix >= p.from && prefix <= p.to {
+			return "+254" + nsn, p.operator, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("phoneNumber %q does not use a known Kenyan mobile prefix", raw)
+}
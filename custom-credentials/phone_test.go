@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestNormalizeKenyanPhone(t *testing.T) {
+	tests := []struct {
+		raw          string
+		want         string
+		wantOperator string
+		wantErr      bool
+	}{
+		{raw: "0712 345 678", want: "+254712345678", wantOperator: "Safaricom"},
+		{raw: "712345678", want: "+254712345678", wantOperator: "Safaricom"},
+		{raw: "254712345678", want: "+254712345678", wantOperator: "Safaricom"},
+		{raw: "+254-712-345-678", want: "+254712345678", wantOperator: "Safaricom"},
+		{raw: "00254712345678", want: "+254712345678", wantOperator: "Safaricom"},
+		{raw: " (0733) 123.456 ", want: "+254733123456", wantOperator: "Airtel"},
+		{raw: "0110123456", want: "+254110123456", wantOperator: "Safaricom"},
+		{raw: "0100123456", want: "+254100123456", wantOperator: "Airtel"},
+		{raw: "0771234567", want: "+254771234567", wantOperator: "Telkom"},
+		{raw: "0747123456", want: "+254747123456", wantOperator: "Faiba"},
+		{raw: "0764123456", want: "+254764123456", wantOperator: "Equitel"},
+		{raw: "", wantErr: true},
+		{raw: "0712 345 67a", wantErr: true},
+		{raw: "071234567", wantErr: true},
+		{raw: "+255712345678", wantErr: true},
+		{raw: "0712+345678", wantErr: true},
+		{raw: "254712345678+", wantErr: true},
+		{raw: "++254712345678", wantErr: true},
+		{raw: "+0712345678", wantErr: true},
+		{raw: "+00254712345678", wantErr: true},
+		{raw: "0201234567", wantErr: true},
+		{raw: "0744123456", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, operator, err := normalizeKenyanPhone(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("normalizeKenyanPhone = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeKenyanPhone: %v", err)
+			}
+			if got != tt.want || operator != tt.wantOperator {
+				t.Errorf("normalizeKenyanPhone = %q, %q, want %q, %q", got, operator, tt.want, tt.wantOperator)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+)
+
+// Abuse protection defaults, overridable from the environment
+const (
+	defaultClientPerMinute = 60
+	defaultIPPerMinute     = 120
+	defaultRateBurst       = 10
+	defaultMaxRequestBytes = 1 << 20
+)
+
+// RateLimits holds the per-client and per-IP limits for expensive endpoints
+type RateLimits struct {
+	perClient *ratelimit.Limiter
+	perIP     *ratelimit.Limiter
+}
+
+// NewRateLimitsFromEnv reads RATE_LIMIT_CLIENT_PER_MINUTE,
+// RATE_LIMIT_IP_PER_MINUTE and RATE_LIMIT_BURST
+func NewRateLimitsFromEnv() *RateLimits {
+	burst := ratelimit.EnvInt("RATE_LIMIT_BURST", defaultRateBurst)
+	return &RateLimits{
+		perClient: ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_CLIENT_PER_MINUTE", defaultClientPerMinute), burst),
+		perIP:     ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_IP_PER_MINUTE", defaultIPPerMinute), burst),
+	}
+}
+
+// Limit rejects requests over the caller's IP limit or, once authenticated,
+// its client limit with 429 Too Many Requests and a Retry-After header
+func (l *RateLimits) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ratelimit.ClientIP(r)
+		ok, retryAfter := l.perIP.Allow(ip)
+		who := ip
+		if c, authenticated := clientFrom(r.Context()); ok && authenticated {
+			ok, retryAfter = l.perClient.Allow(c.ID)
+			who = "client " + c.ID
+		}
+
+		if !ok {
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(retryAfter))
+			respondError(w, http.StatusTooManyRequests, "Too many requests",
+				fmt.Errorf("rate limit exceeded for %s, retry in %s seconds", who, ratelimit.RetryAfter(retryAfter)))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// limitBodyMiddleware caps request bodies at MAX_REQUEST_BYTES
+func limitBodyMiddleware(next http.Handler) http.Handler {
+	limit := int64(ratelimit.EnvInt("MAX_REQUEST_BYTES", defaultMaxRequestBytes))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeJSONBody decodes the request body into v and writes the error
+// response if it cannot. Oversized bodies get 413 Request Entity Too Large.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, http.StatusRequestEntityTooLarge, "Request body too large",
+			fmt.Errorf("request body exceeds %d bytes", tooLarge.Limit))
+		return false
+	}
+	respondError(w, http.StatusBadRequest, "Invalid request body", err)
+	return false
+}
+
+// respondWaltIDError writes the response for a failed walt.id call. A full
+// walt.id queue or an open circuit breaker is reported as 503 with a
+// Retry-After header.
+func (s *CredentialService) respondWaltIDError(w http.ResponseWriter, message string, err error) {
+	if wait := s.waltIDIssue.RetryAfter(err); wait > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+		respondError(w, http.StatusServiceUnavailable, message, err)
+		return
+	}
+	respondError(w, http.StatusInternalServerError, message, err)
+}
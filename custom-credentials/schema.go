@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaVersion is part of every published schema URL. Bump it whenever the
+// credential subject changes shape so credentials issued against the old
+// schema keep validating against the document they reference.
+const SchemaVersion = "v1"
+
+// JSONSchemaDraft is the dialect of the generated schema documents
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// farmerTypeSpecifics maps a farmer type to the credentialSubject property
+// holding its type-specific data
+var farmerTypeSpecifics = map[string]string{
+	"dairy":        "dairySpecifics",
+	"poultry":      "poultrySpecifics",
+	"horticulture": "horticultureSpecifics",
+	"aquaculture":  "aquacultureSpecifics",
+}
+
+// credentialTypeID returns the credential type name for a farmer type,
+// e.g. "dairy" -> "DairyFarmerCredential"
+func credentialTypeID(farmerType string) string {
+	return fmt.Sprintf("%sFarmerCredential", capitalize(farmerType))
+}
+
+// farmerTypeForCredential is the inverse of credentialTypeID
+func farmerTypeForCredential(credentialID string) (string, bool) {
+	for farmerType := range farmerTypeSpecifics {
+		if credentialTypeID(farmerType) == credentialID {
+			return farmerType, true
+		}
+	}
+	return "", false
+}
+
+// schemaURL returns the stable, versioned URL a credential schema is hosted at
+func schemaURL(credentialID string) string {
+	return fmt.Sprintf("%s/schemas/%s/%s.json", publicURL, SchemaVersion, credentialID)
+}
+
+// credentialSchemaRef is the credentialSchema entry embedded in issued credentials
+func credentialSchemaRef(farmerType string) map[string]any {
+	return map[string]any{
+		"id":   schemaURL(credentialTypeID(farmerType)),
+		"type": "JsonSchema",
+	}
+}
+
+// buildCredentialSchema generates the full JSON Schema for a farmer credential
+// type from the Go request structs, describing the credential exactly as
+// buildCredential produces it
+func (s *CredentialService) buildCredentialSchema(farmerType string) (map[string]any, error) {
+	specificsField, ok := farmerTypeSpecifics[farmerType]
+	if !ok {
+		return nil, fmt.Errorf("schema not found for type: %s", farmerType)
+	}
+
+	credentialID := credentialTypeID(farmerType)
+
+	// The subject is the request minus the specifics of other farmer types.
+	// Fields without omitempty are required. buildCredential leaves out empty
+	// omitempty fields, so those are optional and never null. The specifics of
+	// the schema's farmer type are always written and stay required.
+	properties := map[string]any{
+		"id": map[string]any{"type": "string"},
+		"registrationDate": map[string]any{
+			"type":   "string",
+			"format": "date-time",
+		},
+	}
+	required := []string{"id", "registrationDate"}
+
+	requestType := reflect.TypeOf(FarmerCredentialRequest{})
+	for i := 0; i < requestType.NumField(); i++ {
+		field := requestType.Field(i)
+		name, omitEmpty := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		isSpecifics := strings.HasSuffix(name, "Specifics")
+		if isSpecifics && name != specificsField {
+			continue
+		}
+
+		var prop map[string]any
+		switch {
+		case name == "farmerType":
+			prop = map[string]any{"type": "string", "const": farmerType}
+		case isSpecifics:
+			prop = schemaForType(field.Type.Elem())
+		default:
+			prop = schemaForType(field.Type)
+		}
+
+		if omitEmpty && !isSpecifics {
+			prop["type"] = nonNullType(prop["type"])
+		} else {
+			required = append(required, name)
+		}
+		properties[name] = prop
+	}
+
+	subject := map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+
+	info := s.credentialsMap[credentialID]
+
+	return map[string]any{
+		"$schema":     JSONSchemaDraft,
+		"$id":         schemaURL(credentialID),
+		"title":       info.Name,
+		"description": info.Description,
+		"type":        "object",
+		"properties": map[string]any{
+			"@context": map[string]any{
+				"type":  "array",
+				"items": map[string]any{"type": "string"},
+			},
+			"id": map[string]any{"type": "string"},
+			"type": map[string]any{
+				"type":     "array",
+				"items":    map[string]any{"type": "string"},
+				"contains": map[string]any{"const": credentialID},
+			},
+			"issuer": map[string]any{
+				"oneOf": []any{
+					map[string]any{"type": "string"},
+					map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"id": map[string]any{"type": "string"}},
+						"required":   []string{"id"},
+					},
+				},
+			},
+			"issuanceDate":   map[string]any{"type": "string"},
+			"expirationDate": map[string]any{"type": "string"},
+			"credentialSchema": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"id":   map[string]any{"type": "string"},
+					"type": map[string]any{"type": "string"},
+				},
+				"required": []string{"id", "type"},
+			},
+			"credentialSubject": subject,
+		},
+		"required": []string{"@context", "type", "issuer", "credentialSubject"},
+	}, nil
+}
+
+// schemaForType reflects a Go type into a JSON Schema fragment. Nested struct
+// fields without omitempty are required; pointers and slices that are not
+// omitempty can be serialized as null and are therefore nullable.
+func schemaForType(t reflect.Type) map[string]any {
+	nullable := false
+	if t.Kind() == reflect.Pointer {
+		nullable = true
+		t = t.Elem()
+	}
+
+	var schema map[string]any
+	switch t.Kind() {
+	case reflect.String:
+		schema = map[string]any{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema = map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		nullable = nullable || t.Kind() == reflect.Slice
+		schema = map[string]any{
+			"type":  "array",
+			"items": schemaForType(t.Elem()),
+		}
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, omitEmpty := jsonFieldName(field)
+			if name == "" {
+				continue
+			}
+
+			prop := schemaForType(field.Type)
+			if omitEmpty {
+				// omitempty drops nil values, so the property is never null
+				prop["type"] = nonNullType(prop["type"])
+			} else {
+				required = append(required, name)
+			}
+			properties[name] = prop
+		}
+		schema = map[string]any{
+			"type":       "object",
+			"properties": properties,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	default:
+		schema = map[string]any{}
+	}
+
+	if nullable {
+		if typ, ok := schema["type"].(string); ok {
+			schema["type"] = []string{typ, "null"}
+		}
+	}
+
+	return schema
+}
+
+// nonNullType strips "null" from a nullable type declaration
+func nonNullType(typ any) any {
+	if types, ok := typ.([]string); ok && len(types) == 2 && types[1] == "null" {
+		return types[0]
+	}
+	return typ
+}
+
+// jsonFieldName returns the JSON property name of a struct field and whether
+// it is tagged omitempty. An empty name means the field is not part of the
+// credential, either because it is not serialized or is tagged credential:"-".
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() || field.Tag.Get("credential") == "-" {
+		return "", false
+	}
+
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, strings.Contains(","+opts+",", ",omitempty,")
+}
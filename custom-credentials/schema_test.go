@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sampleRequests holds a complete request for each farmer type
+var sampleRequests = map[string]*FarmerCredentialRequest{
+	"dairy": {
+		DairySpecifics: &DairySpecifics{
+			CattleBreeds: []string{"Friesian"}, NumberOfCattle: 12, MilkingCows: 8,
+			AverageDailyProduction: &ProductionMetric{Value: 120, Unit: "litres/day", Normalized: &Quantity{120, "litres/day"}},
+			KDBNumber:              "KDB-0042",
+		},
+	},
+	"poultry": {
+		PoultrySpecifics: &PoultrySpecifics{
+			FarmingType: "layers", BirdPopulation: 500, HousingType: "deep litter",
+			ProductionCapacity: &PoultryProduction{EggsPerDay: 420, TraysPerDay: 14},
+		},
+	},
+	"horticulture": {
+		HorticultureSpecifics: &HorticultureSpecifics{
+			Crops: []string{"French beans"}, FarmingMethod: "open field", IrrigationSystem: "drip",
+			Certifications: []string{"GlobalG.A.P."}, ExportMarket: true,
+		},
+	},
+	"aquaculture": {
+		AquacultureSpecifics: &AquacultureSpecifics{
+			Species: []string{"Tilapia"}, FarmingSystem: "ponds", NumberOfPonds: 3, WaterSource: "borehole",
+			ProductionCycle: &AquaProduction{CyclesPerYear: 2, FishPerCycle: 3000, KgPerCycle: 900},
+		},
+	},
+}
+
+func TestCredentialSchemaValidatesCredential(t *testing.T) {
+	defer func(old string) { publicURL = old }(publicURL)
+	publicURL = "https://credentials.example"
+	service := NewCredentialService()
+
+	for farmerType := range farmerTypeSpecifics {
+		t.Run(farmerType, func(t *testing.T) {
+			req := *sampleRequests[farmerType]
+			req.FarmerType = farmerType
+			req.FirstName, req.FamilyName, req.PhoneNumber = "Amina", "Otieno", "+254712345678"
+			req.County, req.SubCounty = "Nakuru", "Njoro"
+			req.BirthDate = "1985-04-12"
+			req.FarmSize = &FarmSize{Value: 2, Unit: "acres", Normalized: &Quantity{2, "acres"}}
+
+			schema, err := service.buildCredentialSchema(farmerType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			credential, err := service.buildCredential(&req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id := schemaURL(credentialTypeID(farmerType))
+			if schema["$id"] != id || !strings.HasPrefix(id, "https://credentials.example/schemas/") {
+				t.Errorf("schema $id = %v, want %s", schema["$id"], id)
+			}
+			if got := credentialSchemaRef(farmerType)["id"]; got != id {
+				t.Errorf("credentialSchema id = %v, want %s", got, id)
+			}
+
+			if errs := validateJSON(roundTrip(t, schema), roundTrip(t, credential), "$"); len(errs) > 0 {
+				t.Errorf("credential does not match its schema:\n%s", strings.Join(errs, "\n"))
+			}
+
+			// A credential missing a required field or naming another farmer
+			// type must not pass, or the check above proves nothing
+			subject := credential["credentialSubject"].(map[string]any)
+			delete(subject, "county")
+			subject["farmerType"] = "unknown"
+			if errs := validateJSON(roundTrip(t, schema), roundTrip(t, credential), "$"); len(errs) != 2 {
+				t.Errorf("invalid credential: errors = %q, want 2", errs)
+			}
+		})
+	}
+}
+
+func TestPublicURLFromEnv(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{env: "https://credentials.example/", want: "https://credentials.example"},
+		{env: "https://example.org:7105/farmers", want: "https://example.org:7105/farmers"},
+		{env: "", wantErr: true},
+		{env: "http://139.59.15.151:7105", wantErr: true},
+		{env: "139.59.15.151:7105", wantErr: true},
+		{env: "https://credentials.example/?x=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("CREDENTIALS_PUBLIC_URL", tt.env)
+		got, err := publicURLFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("publicURLFromEnv() with %q = %q, %v, want %q", tt.env, got, err, tt.want)
+		}
+	}
+}
+
+// roundTrip returns v as decoded from its JSON encoding
+func roundTrip(t *testing.T, v any) any {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// validateJSON checks value against the JSON Schema keywords the generated
+// schemas use: type, const, format date-time, properties, required, items,
+// contains and oneOf. It returns one message per failure.
+func validateJSON(schema, value any, path string) []string {
+	s, _ := schema.(map[string]any)
+	var errs []string
+
+	if typ, ok := s["type"]; ok && !matchesType(typ, value) {
+		return []string{fmt.Sprintf("%s: %v is not of type %v", path, value, typ)}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		errs = append(errs, fmt.Sprintf("%s: %v is not %v", path, value, c))
+	}
+	if s["format"] == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value.(string)); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v is not a date-time", path, value))
+		}
+	}
+
+	if obj, ok := value.(map[string]any); ok {
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s is required", path, name))
+			}
+		}
+		properties, _ := s["properties"].(map[string]any)
+		for name, prop := range properties {
+			if v, ok := obj[name]; ok {
+				errs = append(errs, validateJSON(prop, v, path+"."+name)...)
+			}
+		}
+	}
+
+	if arr, ok := value.([]any); ok {
+		if items, ok := s["items"]; ok {
+			for i, v := range arr {
+				errs = append(errs, validateJSON(items, v, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		if contains, ok := s["contains"]; ok && !slicesContain(arr, contains, path) {
+			errs = append(errs, fmt.Sprintf("%s: no item matches %v", path, contains))
+		}
+	}
+
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if len(validateJSON(sub, value, path)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			errs = append(errs, fmt.Sprintf("%s: matches %d of oneOf, want 1", path, matches))
+		}
+	}
+
+	return errs
+}
+
+func slicesContain(arr []any, schema any, path string) bool {
+	for _, v := range arr {
+		if len(validateJSON(schema, v, path)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesType reports whether value has the JSON type typ, a name or a list
+// of names
+func matchesType(typ, value any) bool {
+	if types, ok := typ.([]any); ok {
+		for _, t := range types {
+			if matchesType(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || typ == "integer" && v == math.Trunc(v)
+	case string:
+		return typ == "string"
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
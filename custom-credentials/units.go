@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Unit dimensions and their canonical units. Normalised values are always
+// expressed in the canonical unit so farms can be compared directly.
+const (
+	DimensionArea        = "area"
+	DimensionDailyVolume = "dailyVolume"
+	DimensionMass        = "mass"
+	DimensionEggs        = "eggs"
+)
+
+var canonicalUnits = map[string]string{
+	DimensionArea:        "acres",
+	DimensionDailyVolume: "litres/day",
+	DimensionMass:        "kg",
+	DimensionEggs:        "trays",
+}
+
+// EggsPerTray is the number of eggs in a standard Kenyan egg tray
+const EggsPerTray = 30
+
+// unitDef describes an accepted unit: value * factor gives the canonical unit
+type unitDef struct {
+	dimension string
+	factor    float64
+}
+
+// units maps every accepted spelling to its definition
+var units = map[string]unitDef{
+	// Area
+	"acres":        {DimensionArea, 1},
+	"acre":         {DimensionArea, 1},
+	"ac":           {DimensionArea, 1},
+	"hectares":     {DimensionArea, 2.471054},
+	"hectare":      {DimensionArea, 2.471054},
+	"ha":           {DimensionArea, 2.471054},
+	"sqm":          {DimensionArea, 0.0002471054},
+	"m2":           {DimensionArea, 0.0002471054},
+	"squaremetres": {DimensionArea, 0.0002471054},
+	"squaremeters": {DimensionArea, 0.0002471054},
+
+	// Daily volume (milk production)
+	"litres/day":     {DimensionDailyVolume, 1},
+	"liters/day":     {DimensionDailyVolume, 1},
+	"l/day":          {DimensionDailyVolume, 1},
+	"litresperday":   {DimensionDailyVolume, 1},
+	"litersperday":   {DimensionDailyVolume, 1},
+	"litres/week":    {DimensionDailyVolume, 1.0 / 7},
+	"liters/week":    {DimensionDailyVolume, 1.0 / 7},
+	"litresperweek":  {DimensionDailyVolume, 1.0 / 7},
+	"litersperweek":  {DimensionDailyVolume, 1.0 / 7},
+	"litres/month":   {DimensionDailyVolume, 1.0 / 30},
+	"liters/month":   {DimensionDailyVolume, 1.0 / 30},
+	"litrespermonth": {DimensionDailyVolume, 1.0 / 30},
+	"literspermonth": {DimensionDailyVolume, 1.0 / 30},
+
+	// Mass (aquaculture harvests)
+	"kg":        {DimensionMass, 1},
+	"kgs":       {DimensionMass, 1},
+	"kilograms": {DimensionMass, 1},
+	"kilogram":  {DimensionMass, 1},
+	"g":         {DimensionMass, 0.001},
+	"grams":     {DimensionMass, 0.001},
+	"tonnes":    {DimensionMass, 1000},
+	"tonne":     {DimensionMass, 1000},
+	"t":         {DimensionMass, 1000},
+
+	// Eggs
+	"trays":  {DimensionEggs, 1},
+	"tray":   {DimensionEggs, 1},
+	"crates": {DimensionEggs, 1},
+	"crate":  {DimensionEggs, 1},
+	"eggs":   {DimensionEggs, 1.0 / EggsPerTray},
+	"egg":    {DimensionEggs, 1.0 / EggsPerTray},
+}
+
+// ambiguousUnits are spellings that name a unit but not its dimension: bare
+// litres could be per day, per week or per month
+var ambiguousUnits = map[string]string{
+	"litres": DimensionDailyVolume,
+	"liters": DimensionDailyVolume,
+	"l":      DimensionDailyVolume,
+}
+
+// Quantity is a value in the canonical unit of its dimension
+type Quantity struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// normalizeQuantity converts value/unit into the canonical unit of dimension
+func normalizeQuantity(value float64, unit, dimension string) (*Quantity, error) {
+	if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("value must be a non-negative number, got %v", value)
+	}
+
+	key := unitKey(unit)
+	if ambiguousUnits[key] == dimension {
+		return nil, fmt.Errorf("ambiguous unit %q, expected one of: %s", unit, strings.Join(unitsFor(dimension), ", "))
+	}
+	def, ok := units[key]
+	if !ok || def.dimension != dimension {
+		return nil, fmt.Errorf("unsupported unit %q, expected one of: %s", unit, strings.Join(unitsFor(dimension), ", "))
+	}
+
+	return &Quantity{
+		Value: roundTo(value*def.factor, 4),
+		Unit:  canonicalUnits[dimension],
+	}, nil
+}
+
+// normalizeMeasurements validates the free-form units in a request and
+// records the canonical equivalent alongside each original value
+func normalizeMeasurements(req *FarmerCredentialRequest) error {
+	if req.FarmSize != nil {
+		normalized, err := normalizeQuantity(req.FarmSize.Value, req.FarmSize.Unit, DimensionArea)
+		if err != nil {
+			return fmt.Errorf("farmSize: %w", err)
+		}
+		req.FarmSize.Normalized = normalized
+	}
+
+	if req.DairySpecifics != nil && req.DairySpecifics.AverageDailyProduction != nil {
+		production := req.DairySpecifics.AverageDailyProduction
+		normalized, err := normalizeQuantity(production.Value, production.Unit, DimensionDailyVolume)
+		if err != nil {
+			return fmt.Errorf("dairySpecifics.averageDailyProduction: %w", err)
+		}
+		production.Normalized = normalized
+	}
+
+	if req.PoultrySpecifics != nil && req.PoultrySpecifics.ProductionCapacity != nil {
+		capacity := req.PoultrySpecifics.ProductionCapacity
+		if capacity.EggsPerDay < 0 || capacity.MeatPerCycle < 0 {
+			return fmt.Errorf("poultrySpecifics.productionCapacity: values must be non-negative")
+		}
+		// Trays are derived from eggs; a value sent by the client is replaced
+		capacity.TraysPerDay = 0
+		if capacity.EggsPerDay > 0 {
+			trays, _ := normalizeQuantity(float64(capacity.EggsPerDay), "eggs", DimensionEggs)
+			capacity.TraysPerDay = trays.Value
+		}
+	}
+
+	if req.AquacultureSpecifics != nil && req.AquacultureSpecifics.ProductionCycle != nil {
+		cycle := req.AquacultureSpecifics.ProductionCycle
+		if cycle.CyclesPerYear < 0 || cycle.FishPerCycle < 0 || cycle.KgPerCycle < 0 {
+			return fmt.Errorf("aquacultureSpecifics.productionCycle: values must be non-negative")
+		}
+		if cycle.HarvestPerCycle != nil {
+			normalized, err := normalizeQuantity(cycle.HarvestPerCycle.Value, cycle.HarvestPerCycle.Unit, DimensionMass)
+			if err != nil {
+				return fmt.Errorf("aquacultureSpecifics.productionCycle.harvestPerCycle: %w", err)
+			}
+			cycle.HarvestPerCycle.Normalized = normalized
+			cycle.KgPerCycle = normalized.Value
+		}
+	}
+
+	return nil
+}
+
+// ListUnitsHandler handles GET /units
+// Returns the accepted spellings and canonical unit for each dimension
+func (s *CredentialService) ListUnitsHandler(w http.ResponseWriter, r *http.Request) {
+	dimensions := map[string]any{}
+	for dimension, canonical := range canonicalUnits {
+		dimensions[dimension] = map[string]any{
+			"canonical": canonical,
+			"accepted":  unitsFor(dimension),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dimensions)
+}
+
+// unitsFor lists the accepted unit spellings of a dimension
+func unitsFor(dimension string) []string {
+	var names []string
+	for name, def := range units {
+		if def.dimension == dimension {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unitKey folds case and whitespace so "Litres per day" matches "litresperday"
+func unitKey(unit string) string {
+	return strings.Join(strings.Fields(strings.ToLower(unit)), "")
+}
+
+func roundTo(value float64, places int) float64 {
+	scale := math.Pow(10, float64(places))
+	return math.Round(value*scale) / scale
+}
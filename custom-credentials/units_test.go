@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizeQuantity(t *testing.T) {
+	tests := []struct {
+		value     float64
+		unit      string
+		dimension string
+		want      Quantity
+		wantErr   bool
+	}{
+		{value: 2, unit: "acres", dimension: DimensionArea, want: Quantity{2, "acres"}},
+		{value: 1, unit: "Ha", dimension: DimensionArea, want: Quantity{2.4711, "acres"}},
+		{value: 4047, unit: "Square Metres", dimension: DimensionArea, want: Quantity{1.0, "acres"}},
+		{value: 20, unit: "Litres per day", dimension: DimensionDailyVolume, want: Quantity{20, "litres/day"}},
+		{value: 140, unit: "litres/week", dimension: DimensionDailyVolume, want: Quantity{20, "litres/day"}},
+		{value: 600, unit: "liters/month", dimension: DimensionDailyVolume, want: Quantity{20, "litres/day"}},
+		{value: 1.5, unit: "tonnes", dimension: DimensionMass, want: Quantity{1500, "kg"}},
+		{value: 250, unit: "g", dimension: DimensionMass, want: Quantity{0.25, "kg"}},
+		{value: 45, unit: "eggs", dimension: DimensionEggs, want: Quantity{1.5, "trays"}},
+		{value: 0, unit: "kg", dimension: DimensionMass, want: Quantity{0, "kg"}},
+		{value: 20, unit: "litres", dimension: DimensionDailyVolume, wantErr: true},
+		{value: 20, unit: "L", dimension: DimensionDailyVolume, wantErr: true},
+		{value: 2, unit: "kg", dimension: DimensionArea, wantErr: true},
+		{value: 2, unit: "furlongs", dimension: DimensionArea, wantErr: true},
+		{value: -1, unit: "acres", dimension: DimensionArea, wantErr: true},
+		{value: math.NaN(), unit: "acres", dimension: DimensionArea, wantErr: true},
+		{value: math.Inf(1), unit: "acres", dimension: DimensionArea, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			got, err := normalizeQuantity(tt.value, tt.unit, tt.dimension)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("normalizeQuantity(%v, %q) = %+v, want an error", tt.value, tt.unit, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeQuantity(%v, %q): %v", tt.value, tt.unit, err)
+			}
+			if *got != tt.want {
+				t.Errorf("normalizeQuantity(%v, %q) = %+v, want %+v", tt.value, tt.unit, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMeasurements(t *testing.T) {
+	req := &FarmerCredentialRequest{
+		FarmSize:       &FarmSize{Value: 1, Unit: "hectare"},
+		DairySpecifics: &DairySpecifics{AverageDailyProduction: &ProductionMetric{Value: 70, Unit: "litres/week"}},
+		PoultrySpecifics: &PoultrySpecifics{
+			// Trays sent by the client are replaced by the eggs' equivalent
+			ProductionCapacity: &PoultryProduction{EggsPerDay: 90, TraysPerDay: 10},
+		},
+		AquacultureSpecifics: &AquacultureSpecifics{
+			ProductionCycle: &AquaProduction{KgPerCycle: 1, HarvestPerCycle: &ProductionMetric{Value: 2, Unit: "tonnes"}},
+		},
+	}
+	if err := normalizeMeasurements(req); err != nil {
+		t.Fatalf("normalizeMeasurements: %v", err)
+	}
+	if got := *req.FarmSize.Normalized; got != (Quantity{2.4711, "acres"}) {
+		t.Errorf("farm size = %+v, want 2.4711 acres", got)
+	}
+	if got := *req.DairySpecifics.AverageDailyProduction.Normalized; got != (Quantity{10, "litres/day"}) {
+		t.Errorf("daily production = %+v, want 10 litres/day", got)
+	}
+	if got := req.PoultrySpecifics.ProductionCapacity.TraysPerDay; got != 3 {
+		t.Errorf("trays per day = %v, want 3", got)
+	}
+	if got := req.AquacultureSpecifics.ProductionCycle.KgPerCycle; got != 2000 {
+		t.Errorf("kg per cycle = %v, want 2000", got)
+	}
+
+	invalid := []*FarmerCredentialRequest{
+		{FarmSize: &FarmSize{Value: 1, Unit: "litres"}},
+		{DairySpecifics: &DairySpecifics{AverageDailyProduction: &ProductionMetric{Value: 20, Unit: "litres"}}},
+		{PoultrySpecifics: &PoultrySpecifics{ProductionCapacity: &PoultryProduction{EggsPerDay: -30}}},
+		{AquacultureSpecifics: &AquacultureSpecifics{ProductionCycle: &AquaProduction{FishPerCycle: -1}}},
+		{AquacultureSpecifics: &AquacultureSpecifics{
+			ProductionCycle: &AquaProduction{HarvestPerCycle: &ProductionMetric{Value: 2, Unit: "fish"}},
+		}},
+	}
+	for i, req := range invalid {
+		if err := normalizeMeasurements(req); err == nil {
+			t.Errorf("request %d: normalizeMeasurements succeeded, want an error", i)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package bulk
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/models"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Result statuses
+const (
+	StatusPending = "pending"
+	StatusIssued  = "issued"
+	StatusFailed  = "failed"
+	StatusInvalid = "invalid"
+)
+
+// maxJobs bounds how many finished jobs are kept in memory for download
+const maxJobs = 50
+
+// ErrStopped is recorded for rows left unissued when the service shuts down
+var ErrStopped = errors.New("not issued: the service shut down; upload this row again")
+
+// IssueFunc issues one farmer credential and returns the offer link
+type IssueFunc func(context.Context, *models.SimpleFarmerCredential) (string, error)
+
+// Result is the outcome of one row of a bulk job
+type Result struct {
+	Row      int
+	Farmer   models.SimpleFarmerCredential
+	Status   string
+	OfferURL string
+	Error    string
+}
+
+// Progress is a snapshot of a job's counters
+type Progress struct {
+	Total    int
+	Done     int
+	Issued   int
+	Failed   int
+	Invalid  int
+	Finished bool
+}
+
+// Job is a bulk issuance run over the rows of one upload
+type Job struct {
+	ID       string
+	Filename string
+	// Owner is the username of the staff member who uploaded the file. Only
+	// they can follow the job and fetch its results, which hold offers.
+	Owner     string
+	CreatedAt time.Time
+
+	mu       sync.Mutex
+	results  []Result
+	progress Progress
+	changed  chan struct{}
+}
+
+// Progress returns the job's current counters
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// Changed returns a channel that is closed on the next progress update
+func (j *Job) Changed() <-chan struct{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.changed
+}
+
+// Results returns a copy of the per-row results in row order
+func (j *Job) Results() []Result {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Result(nil), j.results...)
+}
+
+// Result returns the result for a spreadsheet row number
+func (j *Job) Result(row int) (Result, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, r := range j.results {
+		if r.Row == row {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
+
+func (j *Job) record(i int, offerURL string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err != nil {
+		j.results[i].Status = StatusFailed
+		j.results[i].Error = err.Error()
+		j.progress.Failed++
+	} else {
+		j.results[i].Status = StatusIssued
+		j.results[i].OfferURL = offerURL
+		j.progress.Issued++
+	}
+	j.progress.Done++
+	j.notify()
+}
+
+func (j *Job) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.progress.Finished = true
+	j.notify()
+}
+
+// notify wakes every waiter on Changed. Callers must hold j.mu.
+func (j *Job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// WriteCSV writes the per-row results, with the offer link and a QR code
+// URL for each issued credential. qrURL builds the QR link for a row.
+func (j *Job) WriteCSV(w io.Writer, qrURL func(row int) string) error {
+	writer := csv.NewWriter(w)
+	header := append([]string{"row"}, Fields...)
+	header = append(header, "status", "offer_url", "qr_code_url", "error")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range j.Results() {
+		qr := ""
+		if r.Status == StatusIssued {
+			qr = qrURL(r.Row)
+		}
+		record := []string{
+			strconv.Itoa(r.Row),
+			r.Farmer.GivenName,
+			r.Farmer.FamilyName,
+			r.Farmer.FarmName,
+			r.Farmer.FarmType,
+			r.Farmer.LicenseNo,
+			r.Farmer.County,
+			r.Farmer.SubCounty,
+			r.Status,
+			r.OfferURL,
+			qr,
+			r.Error,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Manager runs bulk jobs on a bounded worker pool
+type Manager struct {
+	workers int
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	// running counts jobs in progress; stopping is closed by Shutdown
+	running  sync.WaitGroup
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+// NewManager creates a job manager issuing at most workers credentials at once
+func NewManager(workers int) *Manager {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Manager{
+		workers:  workers,
+		jobs:     make(map[string]*Job),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Job looks up a job by ID for owner. Other users' jobs are not found.
+func (m *Manager) Job(id, owner string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || owner == "" || job.Owner != owner {
+		return nil, false
+	}
+	return job, true
+}
+
+// Start issues a credential for every valid row of owner's upload in the
+// background. Invalid rows are recorded in the results without being sent
+// to walt.id. The rows are issued under ctx without its cancellation, so
+// that they keep the upload's request ID and trace after the upload
+// response is sent. Rows not yet sent when Shutdown is called fail with
+// ErrStopped.
+func (m *Manager) Start(ctx context.Context, owner, filename string, rows []Row, issue IssueFunc) *Job {
+	job := &Job{
+		ID:        newJobID(),
+		Filename:  filename,
+		Owner:     owner,
+		CreatedAt: time.Now(),
+		results:   make([]Result, len(rows)),
+		changed:   make(chan struct{}),
+	}
+	job.progress.Total = len(rows)
+
+	var pending []int
+	for i, row := range rows {
+		job.results[i] = Result{Row: row.Number, Farmer: row.Farmer, Status: StatusPending}
+		if !row.Valid() {
+			job.results[i].Status = StatusInvalid
+			job.results[i].Error = strings.Join(row.Errors, "; ")
+			job.progress.Invalid++
+			job.progress.Done++
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	if !m.add(job) {
+		for _, i := range pending {
+			job.record(i, "", ErrStopped)
+		}
+		job.finish()
+		return job
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer m.running.Done()
+		queue := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < m.workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range queue {
+					farmer := job.results[i].Farmer
+					rowCtx, span := tracing.Start(ctx, "bulk row",
+						attribute.String("bulk.job_id", job.ID), attribute.Int("bulk.row", job.results[i].Row))
+					offerURL, err := issue(rowCtx, &farmer)
+					if err != nil {
+						tracing.Fail(span, err)
+					}
+					span.End()
+					job.record(i, offerURL, err)
+				}
+			}()
+		}
+
+		// After Shutdown the rows still queued are failed rather than sent
+		for _, i := range pending {
+			select {
+			case <-m.stopping:
+				job.record(i, "", ErrStopped)
+				continue
+			default:
+			}
+			select {
+			case queue <- i:
+			case <-m.stopping:
+				job.record(i, "", ErrStopped)
+			}
+		}
+		close(queue)
+		wg.Wait()
+
+		job.finish()
+		p := job.Progress()
+		slog.InfoContext(ctx, "Bulk job finished", "job_id", job.ID, "issued", p.Issued, "failed", p.Failed, "invalid", p.Invalid)
+	}()
+
+	return job
+}
+
+// Shutdown stops the running jobs from sending more rows to walt.id and
+// waits for the rows already in flight, or for ctx to expire
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopOnce.Do(func() { close(m.stopping) })
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("bulk jobs still running: %w", ctx.Err())
+	}
+}
+
+// add registers a job as running, evicting the oldest finished ones beyond
+// maxJobs. After Shutdown the job is registered but not counted as running,
+// and add returns false.
+func (m *Manager) add(job *Job) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.jobs[job.ID] = job
+	running := true
+	select {
+	case <-m.stopping:
+		running = false
+	default:
+		m.running.Add(1)
+	}
+	if len(m.jobs) <= maxJobs {
+		return running
+	}
+
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.Before(jobs[b].CreatedAt) })
+	for _, j := range jobs[:len(jobs)-maxJobs] {
+		if j.Progress().Finished {
+			delete(m.jobs, j.ID)
+		}
+	}
+	return running
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,40 @@
+package bulk
+
+import (
+	"context"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/models"
+)
+
+func TestJobOwner(t *testing.T) {
+	m := NewManager(1)
+	issue := func(context.Context, *models.SimpleFarmerCredential) (string, error) {
+		return "openid-credential-offer://offer", nil
+	}
+	job := m.Start(context.Background(), "clerk1", "farmers.csv", []Row{{Number: 2}}, issue)
+	for {
+		changed := job.Changed()
+		if job.Progress().Finished {
+			break
+		}
+		<-changed
+	}
+
+	tests := []struct {
+		owner string
+		want  bool
+	}{
+		{owner: "clerk1", want: true},
+		{owner: "clerk2", want: false},
+		{owner: "", want: false},
+	}
+	for _, tt := range tests {
+		if got, ok := m.Job(job.ID, tt.owner); ok != tt.want || (ok && got != job) {
+			t.Errorf("Job(%s, %q) found = %v, want %v", job.ID, tt.owner, ok, tt.want)
+		}
+	}
+	if _, ok := m.Job("missing", "clerk1"); ok {
+		t.Error("Job found an unknown ID")
+	}
+}
@@ -0,0 +1,203 @@
+package bulk
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/adammwaniki/testa-walt/models"
+)
+
+// Fields are the farmer credential fields every row must provide
+var Fields = []string{"given_name", "family_name", "farm_name", "farm_type", "license_no", "county", "sub_county"}
+
+// OptionalFields are the farmer fields a row may leave out: contact
+// details for expiry reminders
+var OptionalFields = []string{"phone", "email"}
+
+// FarmTypes are the farm types offered on the farmer form
+var FarmTypes = []string{"Dairy", "Poultry", "Crop", "Mixed", "Horticulture", "Livestock", "Aquaculture", "Other"}
+
+// Mapping lists, per credential field, the column headers that may hold it
+type Mapping map[string][]string
+
+// baseMapping applies to every farm type
+var baseMapping = Mapping{
+	"given_name":  {"given_name", "first name", "firstname", "given name", "forename", "forenames"},
+	"family_name": {"family_name", "surname", "last name", "lastname", "family name"},
+	"farm_name":   {"farm_name", "farm", "farm name"},
+	"farm_type":   {"farm_type", "farm type", "farmer type", "type"},
+	"license_no":  {"license_no", "license", "licence", "license number", "licence number", "licence no"},
+	"county":      {"county"},
+	"sub_county":  {"sub_county", "subcounty", "sub-county", "sub county", "constituency"},
+	"phone":       {"phone", "phone number", "mobile", "mobile number", "telephone", "tel"},
+	"email":       {"email", "email address", "e-mail"},
+}
+
+// typeMappings adds the regulator-specific column names cooperatives use for
+// each farm type's licence number
+var typeMappings = map[string]Mapping{
+	"Dairy":        {"license_no": {"kdb_number", "kdb number", "kdb no", "kdb"}},
+	"Poultry":      {"license_no": {"veterinary_registration", "vet registration", "vet no"}},
+	"Horticulture": {"license_no": {"hcd_number", "hcd number", "hcd no", "hcd"}},
+	"Aquaculture":  {"license_no": {"fish_department_permit", "fish permit", "permit no", "permit"}},
+}
+
+// MappingFor returns the column mapping for a farm type
+func MappingFor(farmType string) Mapping {
+	mapping := Mapping{}
+	for field, headers := range baseMapping {
+		mapping[field] = append([]string(nil), headers...)
+	}
+	for field, headers := range typeMappings[farmType] {
+		mapping[field] = append(mapping[field], headers...)
+	}
+	return mapping
+}
+
+// Columns locates each field's column in the header row, or -1 when absent
+func (m Mapping) Columns(headers []string) map[string]int {
+	columns := make(map[string]int, len(Fields)+len(OptionalFields))
+	for _, field := range slices.Concat(Fields, OptionalFields) {
+		columns[field] = -1
+		for _, alias := range m[field] {
+			if idx := headerIndex(headers, alias); idx >= 0 {
+				columns[field] = idx
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// Row is one farmer parsed from the upload
+type Row struct {
+	Number int // spreadsheet row number
+	Farmer models.SimpleFarmerCredential
+	Errors []string
+}
+
+// Valid reports whether the row passed validation
+func (r Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// ParseRows maps the sheet to farmer rows and validates them. When farmType
+// is empty each row must carry its own farm type column. validate performs
+// any further checks (such as location lookup) and may normalise the farmer.
+func ParseRows(sheet *Sheet, farmType string, validate func(*models.SimpleFarmerCredential) error) ([]Row, error) {
+	if farmType != "" {
+		canonical, ok := CanonicalFarmType(farmType)
+		if !ok {
+			return nil, fmt.Errorf("unknown farm type %q", farmType)
+		}
+		farmType = canonical
+	}
+
+	rows := make([]Row, 0, len(sheet.Rows))
+	for i, record := range sheet.Rows {
+		row := Row{Number: sheet.RowNumbers[i]}
+
+		cell := func(columns map[string]int, field string) string {
+			if idx := columns[field]; idx >= 0 && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+
+		rowType := farmType
+		if rowType == "" {
+			rowType = cell(MappingFor("").Columns(sheet.Headers), "farm_type")
+		}
+		if canonical, ok := CanonicalFarmType(rowType); ok {
+			rowType = canonical
+		} else if rowType == "" {
+			row.Errors = append(row.Errors, "farm_type is required")
+		} else {
+			row.Errors = append(row.Errors, fmt.Sprintf("unknown farm_type %q", rowType))
+		}
+
+		columns := MappingFor(rowType).Columns(sheet.Headers)
+		row.Farmer = models.SimpleFarmerCredential{
+			GivenName:  cell(columns, "given_name"),
+			FamilyName: cell(columns, "family_name"),
+			FarmName:   cell(columns, "farm_name"),
+			FarmType:   rowType,
+			LicenseNo:  cell(columns, "license_no"),
+			County:     cell(columns, "county"),
+			SubCounty:  cell(columns, "sub_county"),
+			Phone:      cell(columns, "phone"),
+			Email:      cell(columns, "email"),
+		}
+
+		required := map[string]string{
+			"given_name":  row.Farmer.GivenName,
+			"family_name": row.Farmer.FamilyName,
+			"farm_name":   row.Farmer.FarmName,
+			"license_no":  row.Farmer.LicenseNo,
+			"county":      row.Farmer.County,
+		}
+		for _, field := range Fields {
+			if value, ok := required[field]; ok && value == "" {
+				row.Errors = append(row.Errors, fmt.Sprintf("%s is required", field))
+			}
+		}
+
+		if row.Farmer.County != "" {
+			if err := validate(&row.Farmer); err != nil {
+				row.Errors = append(row.Errors, err.Error())
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// MissingColumns lists required fields with no matching column in the header
+func MissingColumns(headers []string, farmType string) []string {
+	columns := MappingFor(farmType).Columns(headers)
+	var missing []string
+	for _, field := range Fields {
+		if field == "farm_type" && farmType != "" {
+			continue
+		}
+		if columns[field] < 0 {
+			missing = append(missing, field)
+		}
+	}
+	return missing
+}
+
+// CanonicalFarmType matches a farm type case-insensitively against FarmTypes
+func CanonicalFarmType(farmType string) (string, bool) {
+	for _, t := range FarmTypes {
+		if strings.EqualFold(t, strings.TrimSpace(farmType)) {
+			return t, true
+		}
+	}
+	return "", false
+}
+
+func headerIndex(headers []string, alias string) int {
+	key := headerKey(alias)
+	for i, header := range headers {
+		if headerKey(header) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// headerKey folds case, spaces and punctuation in column headers
+func headerKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,298 @@
+package bulk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MaxRows is the largest number of data rows accepted in one upload
+const MaxRows = 5000
+
+// maxColumns is the widest worksheet Excel allows, up to column XFD
+const maxColumns = 16384
+
+// maxCells caps the cells, blank ones included, that an XLSX upload may
+// spread its rows over, so that a few cells far to the right cannot make
+// every row take megabytes
+const maxCells = 1 << 20
+
+// Sheet is a parsed upload: a header row followed by data rows
+type Sheet struct {
+	Headers []string
+	Rows    [][]string
+	// RowNumbers are the spreadsheet row numbers of Rows, counting from 1.
+	// Blank rows are dropped, so they are not always consecutive.
+	RowNumbers []int
+}
+
+// ReadFile parses an uploaded CSV or XLSX file, choosing the format by extension
+func ReadFile(filename string, data []byte) (*Sheet, error) {
+	var records [][]string
+	var numbers []int
+	var err error
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv", ".txt":
+		records, numbers, err = readCSV(data)
+	case ".xlsx":
+		records, numbers, err = readXLSX(data)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, upload a .csv or .xlsx file", filepath.Ext(filename))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop fully blank rows, which spreadsheets often leave at the end
+	var rows [][]string
+	var rowNumbers []int
+	for i, record := range records {
+		if strings.TrimSpace(strings.Join(record, "")) != "" {
+			rows = append(rows, record)
+			rowNumbers = append(rowNumbers, numbers[i])
+		}
+	}
+
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("the file needs a header row and at least one farmer row")
+	}
+	if len(rows)-1 > MaxRows {
+		return nil, fmt.Errorf("the file has %d rows, the limit is %d per upload", len(rows)-1, MaxRows)
+	}
+
+	return &Sheet{Headers: rows[0], Rows: rows[1:], RowNumbers: rowNumbers[1:]}, nil
+}
+
+// readCSV reads the records of a CSV file and their row numbers
+func readCSV(data []byte) ([][]string, []int, error) {
+	// Excel writes a UTF-8 byte order mark at the start of CSV exports
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var records [][]string
+	var numbers []int
+	number, end := 0, 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		// The reader skips blank lines, which spreadsheets still count as
+		// rows, while a quoted field spanning lines stays in one row
+		start, _ := reader.FieldPos(0)
+		number += start - end
+		last := len(record) - 1
+		line, _ := reader.FieldPos(last)
+		end = line + strings.Count(record[last], "\n")
+
+		records = append(records, record)
+		numbers = append(numbers, number)
+	}
+	return records, numbers, nil
+}
+
+// readXLSX reads the first worksheet of an Office Open XML workbook and the
+// row numbers of its rows
+func readXLSX(data []byte) ([][]string, []int, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open XLSX: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	sharedStrings, err := readSharedStrings(files["xl/sharedStrings.xml"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var worksheet struct {
+		Rows []struct {
+			Number int `xml:"r,attr"`
+			Cells  []struct {
+				Ref    string `xml:"r,attr"`
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					Text string `xml:"t"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeZipXML(files[sheetPath], &worksheet); err != nil {
+		return nil, nil, fmt.Errorf("failed to read worksheet: %w", err)
+	}
+
+	records := make([][]string, 0, len(worksheet.Rows))
+	numbers := make([]int, 0, len(worksheet.Rows))
+	cells := 0
+	for _, row := range worksheet.Rows {
+		// Empty rows are left out of the worksheet, so rows carry their number
+		number := 1
+		if n := len(numbers); n > 0 {
+			number = numbers[n-1] + 1
+		}
+		if row.Number > 0 {
+			number = row.Number
+		}
+
+		var record []string
+		for i, cell := range row.Cells {
+			col := i
+			if cell.Ref != "" {
+				if col, err = columnIndex(cell.Ref); err != nil {
+					return nil, nil, err
+				}
+			}
+			if col >= maxColumns {
+				return nil, nil, fmt.Errorf("row %d has more than %d cells", number, maxColumns)
+			}
+			if col >= len(record) {
+				if cells += col + 1 - len(record); cells > maxCells {
+					return nil, nil, fmt.Errorf("the worksheet has more than %d cells, remove unused columns and rows", maxCells)
+				}
+				record = append(record, make([]string, col+1-len(record))...)
+			}
+
+			switch cell.Type {
+			case "s":
+				idx, err := strconv.Atoi(cell.Value)
+				if err != nil || idx < 0 || idx >= len(sharedStrings) {
+					return nil, nil, fmt.Errorf("invalid shared string reference in cell %s", cell.Ref)
+				}
+				record[col] = sharedStrings[idx]
+			case "inlineStr":
+				record[col] = cell.Inline.Text
+			default:
+				record[col] = cell.Value
+			}
+		}
+		records = append(records, record)
+		numbers = append(numbers, number)
+	}
+
+	return records, numbers, nil
+}
+
+// firstSheetPath resolves the part name of the first worksheet in the workbook
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+
+	if err := decodeZipXML(files["xl/workbook.xml"], &workbook); err == nil && len(workbook.Sheets) > 0 {
+		if err := decodeZipXML(files["xl/_rels/workbook.xml.rels"], &rels); err == nil {
+			for _, rel := range rels.Relationships {
+				if rel.ID != workbook.Sheets[0].RelID {
+					continue
+				}
+				target := strings.TrimPrefix(rel.Target, "/")
+				if !strings.HasPrefix(target, "xl/") {
+					target = path.Join("xl", target)
+				}
+				if _, ok := files[target]; ok {
+					return target, nil
+				}
+			}
+		}
+	}
+
+	if _, ok := files["xl/worksheets/sheet1.xml"]; ok {
+		return "xl/worksheets/sheet1.xml", nil
+	}
+	return "", fmt.Errorf("the workbook has no worksheets")
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	var table struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := decodeZipXML(f, &table); err != nil {
+		return nil, fmt.Errorf("failed to read shared strings: %w", err)
+	}
+
+	values := make([]string, len(table.Items))
+	for i, item := range table.Items {
+		if len(item.Runs) == 0 {
+			values[i] = item.Text
+			continue
+		}
+		var b strings.Builder
+		for _, run := range item.Runs {
+			b.WriteString(run.Text)
+		}
+		values[i] = b.String()
+	}
+	return values, nil
+}
+
+func decodeZipXML(f *zip.File, v any) error {
+	if f == nil {
+		return fmt.Errorf("missing part")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xml.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(v)
+}
+
+// columnIndex converts a cell reference such as "C12" to a zero-based
+// column. References without a column, or past column XFD, are errors.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		if col = col*26 + int(r-'A'+1); col > maxColumns {
+			return 0, fmt.Errorf("invalid cell reference %q: past the last column XFD", ref)
+		}
+	}
+	if col == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
@@ -0,0 +1,141 @@
+package bulk
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadFileRowNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []int
+	}{
+		{
+			name: "consecutive rows",
+			data: "given_name,family_name\nAmina,Otieno\nJohn,Kamau\n",
+			want: []int{2, 3},
+		},
+		{
+			name: "blank lines",
+			data: "given_name,family_name\n\nAmina,Otieno\n\n\nJohn,Kamau\n",
+			want: []int{3, 6},
+		},
+		{
+			name: "rows of empty cells",
+			data: "given_name,family_name\n,\nAmina,Otieno\n",
+			want: []int{3},
+		},
+		{
+			name: "quoted field spanning lines",
+			data: "given_name,family_name\n\"Amina\nWanjiru\",Otieno\nJohn,Kamau\n",
+			want: []int{2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheet, err := ReadFile("farmers.csv", []byte(tt.data))
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if !reflect.DeepEqual(sheet.RowNumbers, tt.want) {
+				t.Errorf("RowNumbers = %v, want %v", sheet.RowNumbers, tt.want)
+			}
+			if len(sheet.Rows) != len(sheet.RowNumbers) {
+				t.Errorf("%d rows but %d row numbers", len(sheet.Rows), len(sheet.RowNumbers))
+			}
+		})
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    int
+		wantErr bool
+	}{
+		{ref: "A1", want: 0},
+		{ref: "C12", want: 2},
+		{ref: "Z3", want: 25},
+		{ref: "AA3", want: 26},
+		{ref: "XFD1048576", want: 16383},
+		{ref: "XFE1", wantErr: true},
+		{ref: "ZZZZZZZZZZZZZZZ1", wantErr: true},
+		{ref: "12", wantErr: true},
+		{ref: "", wantErr: true},
+		{ref: "a1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := columnIndex(tt.ref)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, %v, want %d, error %v", tt.ref, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// xlsx builds a workbook whose first worksheet holds rows
+func xlsx(t *testing.T, rows string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(f, `<worksheet><sheetData>%s</sheetData></worksheet>`, rows)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadXLSX(t *testing.T) {
+	header := `<row r="1"><c r="A1" t="inlineStr"><is><t>given_name</t></is></c><c r="C1" t="inlineStr"><is><t>county</t></is></c></row>`
+	wide := strings.Repeat(`<row><c r="XFD1"><v>1</v></c></row>`, maxCells/maxColumns+1)
+
+	tests := []struct {
+		name    string
+		rows    string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "cells placed by reference",
+			rows: header + `<row r="2"><c r="C2"><v>Nakuru</v></c><c r="A2" t="inlineStr"><is><t>Amina</t></is></c></row>`,
+			want: [][]string{{"Amina", "", "Nakuru"}},
+		},
+		{
+			name: "cells without references",
+			rows: header + `<row r="2"><c t="inlineStr"><is><t>Amina</t></is></c><c/><c><v>Nakuru</v></c></row>`,
+			want: [][]string{{"Amina", "", "Nakuru"}},
+		},
+		{name: "reference without a column", rows: header + `<row r="2"><c r="2"><v>1</v></c></row>`, wantErr: "invalid cell reference"},
+		{name: "column past XFD", rows: header + `<row r="2"><c r="XFE2"><v>1</v></c></row>`, wantErr: "past the last column"},
+		{name: "overflowing column", rows: header + `<row r="2"><c r="ZZZZZZZZZZZZZZZZZZZZ2"><v>1</v></c></row>`, wantErr: "past the last column"},
+		{name: "too many cells", rows: header + wide, wantErr: "cells"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheet, err := ReadFile("farmers.xlsx", xlsx(t, tt.rows))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadFile = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if !reflect.DeepEqual(sheet.Rows, tt.want) {
+				t.Errorf("Rows = %q, want %q", sheet.Rows, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/bulk"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// maxAPIBodyBytes caps JSON request bodies on the API
+const maxAPIBodyBytes = 1 << 20
+
+// PDA1APIRequest is the body of POST /api/v1/credentials/pda1: the PDA1
+// form fields plus an optional note for the reviewer under maker-checker
+type PDA1APIRequest struct {
+	models.FarmerCredential
+	Comment string `json:"comment,omitempty"`
+}
+
+// FarmerAPIRequest is the body of POST /api/v1/credentials/farmer: the
+// farmer form fields plus the issuance options offered on the form.
+// Supersedes names an offered credential that the new one replaces.
+type FarmerAPIRequest struct {
+	models.SimpleFarmerCredential
+	RequirePIN    bool   `json:"require_pin,omitempty"`
+	DeferApproval bool   `json:"defer_approval,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+	Supersedes    string `json:"supersedes,omitempty"`
+}
+
+// IssuanceResponse is returned by the issuance API. An offered credential
+// carries the walt.id offer URL; a pending application carries the claim
+// page URL where the offer appears once approved.
+type IssuanceResponse struct {
+	IssuanceID string `json:"issuanceId,omitempty"`
+	Status     string `json:"status"`
+	OfferURL   string `json:"offerUrl,omitempty"`
+	ClaimURL   string `json:"claimUrl,omitempty"`
+	PIN        string `json:"pin,omitempty"`
+	// PINShown is set on a replay of a PIN-protected offer, whose PIN was
+	// returned by the first request only
+	PINShown   bool   `json:"pinShown,omitempty"`
+	Approval   string `json:"approval,omitempty"`
+	Supersedes string `json:"supersedes,omitempty"`
+}
+
+// APIError is the body of every API error response
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// APIIssuePDA1 handles POST /api/v1/credentials/pda1
+func (h *Handler) APIIssuePDA1(w http.ResponseWriter, r *http.Request) {
+	var req PDA1APIRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	farmer := &req.FarmerCredential
+	if err := validatePDA1(farmer); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.validityFor(farmer, time.Time{}); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid validity period: "+err.Error())
+		return
+	}
+	name := farmer.Forenames + " " + farmer.Surname
+
+	claim, err := h.claimIdempotencyKey(r, req)
+	if err != nil {
+		h.writeIdempotencyError(w, r, err)
+		return
+	}
+	if claim.Previous != nil {
+		h.writeAPIReplay(w, r, claim.Previous)
+		return
+	}
+	var id string
+	defer func() { claim.Finish(r, id) }()
+
+	if h.MakerChecker {
+		id = h.apiSubmitApplication(w, r, store.CredentialPDA1, name, farmer, h.approvalFor(""), false, req.Comment, "")
+		return
+	}
+
+	offerURL, id, err := h.issuePDA1Credential(r.Context(), farmer)
+	if err != nil {
+		h.writeIssuanceError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusCreated, IssuanceResponse{
+		IssuanceID: id,
+		Status:     store.StatusOffered,
+		OfferURL:   offerURL,
+	})
+}
+
+// APIIssueFarmer handles POST /api/v1/credentials/farmer
+func (h *Handler) APIIssueFarmer(w http.ResponseWriter, r *http.Request) {
+	var req FarmerAPIRequest
+	if !decodeAPIRequest(w, r, &req) {
+		return
+	}
+
+	farmer := &req.SimpleFarmerCredential
+	if err := validateFarmer(farmer); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := farmer.GivenName + " " + farmer.FamilyName
+	if req.Supersedes != "" {
+		if _, err := h.updatableIssuance(req.Supersedes); err != nil {
+			writeAPIError(w, updateErrorStatus(err), updateErrorMessage(err))
+			return
+		}
+	}
+
+	claim, err := h.claimIdempotencyKey(r, req)
+	if err != nil {
+		h.writeIdempotencyError(w, r, err)
+		return
+	}
+	if claim.Previous != nil {
+		h.writeAPIReplay(w, r, claim.Previous)
+		return
+	}
+	var id string
+	defer func() { claim.Finish(r, id) }()
+
+	if h.MakerChecker || req.DeferApproval {
+		id = h.apiSubmitApplication(w, r, store.CredentialFarmer, name, farmer, h.approvalFor(farmer.FarmType), req.RequirePIN, req.Comment, req.Supersedes)
+		return
+	}
+
+	offerURL, pin, id, err := h.issueFarmerOffer(r.Context(), farmer, req.RequirePIN, req.Supersedes, time.Time{})
+	if err != nil {
+		h.writeIssuanceError(w, err)
+		return
+	}
+
+	writeAPIJSON(w, http.StatusCreated, IssuanceResponse{
+		IssuanceID: id,
+		Status:     store.StatusOffered,
+		OfferURL:   offerURL,
+		PIN:        pin,
+		Supersedes: req.Supersedes,
+	})
+}
+
+// apiSubmitApplication records an application for approval and answers 202
+// Accepted with its claim page. It returns the application's ID, or an
+// empty string if it could not be recorded.
+func (h *Handler) apiSubmitApplication(w http.ResponseWriter, r *http.Request, credentialType, name string, subject any, approval string, requirePIN bool, note, supersedes string) string {
+	iss, err := h.newApplication(credentialType, name, subject, approval, requirePIN, currentUsername(r), note, supersedes)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Failed to record the application")
+		return ""
+	}
+
+	writeAPIJSON(w, http.StatusAccepted, IssuanceResponse{
+		IssuanceID: iss.ID,
+		Status:     iss.Status,
+		ClaimURL:   fmt.Sprintf("%s/offers/%s", requestBaseURL(r), iss.ID),
+		Approval:   approval,
+		Supersedes: supersedes,
+	})
+	return iss.ID
+}
+
+// validatePDA1 checks the fields the PDA1 form marks as required
+func validatePDA1(farmer *models.FarmerCredential) error {
+	required := []struct{ field, value string }{
+		{"personalIdentificationNumber", farmer.PersonalIdentificationNumber},
+		{"sex", farmer.Sex},
+		{"surname", farmer.Surname},
+		{"forenames", farmer.Forenames},
+		{"dateBirth", farmer.DateOfBirth},
+	}
+	for _, f := range required {
+		if strings.TrimSpace(f.value) == "" {
+			return fmt.Errorf("%s is required", f.field)
+		}
+	}
+	return nil
+}
+
+// validateFarmer checks the fields the farmer form marks as required,
+// canonicalises the farm type and resolves the location
+func validateFarmer(farmer *models.SimpleFarmerCredential) error {
+	required := []struct{ field, value string }{
+		{"given_name", farmer.GivenName},
+		{"family_name", farmer.FamilyName},
+		{"farm_name", farmer.FarmName},
+		{"farm_type", farmer.FarmType},
+		{"license_no", farmer.LicenseNo},
+		{"county", farmer.County},
+	}
+	for _, f := range required {
+		if strings.TrimSpace(f.value) == "" {
+			return fmt.Errorf("%s is required", f.field)
+		}
+	}
+
+	farmType, ok := bulk.CanonicalFarmType(farmer.FarmType)
+	if !ok {
+		return fmt.Errorf("unknown farm_type %q (expected one of %s)", farmer.FarmType, strings.Join(bulk.FarmTypes, ", "))
+	}
+	farmer.FarmType = farmType
+
+	if err := resolveLocation(farmer); err != nil {
+		return fmt.Errorf("invalid location: %w", err)
+	}
+	return resolveContact(farmer)
+}
+
+// decodeAPIRequest decodes a JSON request body, writing the error response
+// when it cannot
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+
+	_, span := tracing.Start(r.Context(), "decode request")
+	defer span.End()
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		tracing.Fail(span, err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeIssuanceError maps a walt.id failure to an API error response.
+// While walt.id is busy or unavailable clients get 503 with Retry-After.
+func (h *Handler) writeIssuanceError(w http.ResponseWriter, err error) {
+	if wait := h.waltID.RetryAfter(err); wait > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+		writeAPIError(w, http.StatusServiceUnavailable, issuanceErrorMessage(err))
+		return
+	}
+	writeAPIError(w, http.StatusBadGateway, issuanceErrorMessage(err))
+}
+
+func writeAPIError(w http.ResponseWriter, code int, message string) {
+	writeAPIJSON(w, code, APIError{Error: message})
+}
+
+func writeAPIJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding API response", "error", err)
+	}
+}
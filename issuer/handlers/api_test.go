@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/waltid"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+func TestAPIIssueFarmer(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestHandler(t, offerServer(&calls))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+		wantError   string
+		wantCalls   int32
+	}{
+		{name: "offered", body: farmerJSON, want: http.StatusCreated, wantCalls: 1},
+		{name: "deferred", body: strings.Replace(farmerJSON, `"require_pin":true`, `"defer_approval":true`, 1), want: http.StatusAccepted},
+		{name: "missing field", body: strings.Replace(farmerJSON, `"county":"Mombasa",`, "", 1), want: http.StatusBadRequest, wantError: "county is required"},
+		{name: "invalid JSON", body: `{"given_name":`, want: http.StatusBadRequest, wantError: "Invalid JSON"},
+		{name: "too large", body: `{"comment":"` + strings.Repeat("a", maxAPIBodyBytes) + `"}`, want: http.StatusRequestEntityTooLarge},
+		{name: "not JSON", contentType: "application/x-www-form-urlencoded", body: "given_name=Amina", want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.contentType != "" {
+				header.Set("Content-Type", tt.contentType)
+			}
+			before := calls.Load()
+			w := postJSON(h.APIIssueFarmer, "/api/v1/credentials/farmer", "clerk", tt.body, header)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if got := calls.Load() - before; got != tt.wantCalls {
+				t.Errorf("walt.id calls = %d, want %d", got, tt.wantCalls)
+			}
+
+			if tt.want >= 400 {
+				var apiErr APIError
+				if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || !strings.Contains(apiErr.Error, tt.wantError) {
+					t.Errorf("error = %s, want %q", w.Body, tt.wantError)
+				}
+				return
+			}
+
+			var resp IssuanceResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			iss, err := h.Store.Issuance(resp.IssuanceID)
+			if err != nil {
+				t.Fatalf("issuance %q not recorded: %v", resp.IssuanceID, err)
+			}
+			switch tt.want {
+			case http.StatusCreated:
+				if resp.Status != store.StatusOffered || resp.OfferURL == "" || len(resp.PIN) != PINLength {
+					t.Errorf("response = %+v, want an offer with a PIN", resp)
+				}
+			case http.StatusAccepted:
+				if resp.Status != store.StatusPending || !strings.HasSuffix(resp.ClaimURL, "/offers/"+iss.ID) || iss.SubmittedBy != "clerk" {
+					t.Errorf("response = %+v submitted by %q, want a pending application by clerk", resp, iss.SubmittedBy)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIIssueWaltIDUnavailable(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "restarting", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	// One failure opens the breaker, so the second request is refused
+	// without calling walt.id
+	h := newTestHandler(t, nil)
+	cfg := waltid.Config{MaxConcurrent: 2, QueueWait: time.Second, Timeout: 5 * time.Second, Attempts: 1, FailureThreshold: 1, OpenFor: time.Minute}
+	h.waltID = waltid.New(cfg, redirectTransport{target: target})
+
+	w := postJSON(h.APIIssueFarmer, "/api/v1/credentials/farmer", "clerk", farmerJSON, nil)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("first request = %d %s, want 502", w.Code, w.Body)
+	}
+	w = postJSON(h.APIIssueFarmer, "/api/v1/credentials/farmer", "clerk", farmerJSON, nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("second request = %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if calls.Load() != 1 {
+		t.Errorf("walt.id calls = %d, want 1", calls.Load())
+	}
+}
+
+func TestRequireAPI(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestHandler(t, offerServer(&calls))
+
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte("s3cret"))
+	clients, _ := json.Marshal([]apiauth.Client{
+		{ID: "field-app", SecretHash: hex.EncodeToString(sum[:]), Scopes: []string{ScopeIssue}},
+		{ID: "reports", SecretHash: hex.EncodeToString(sum[:]), Scopes: []string{}},
+	})
+	if err := os.WriteFile(apiauth.ClientsPath(dir), clients, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := apiauth.OpenUsage(apiauth.UsagePath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.APIAuth, err = newAPIAuth(dir, usage); err != nil {
+		t.Fatal(err)
+	}
+	handler := h.RequireAPI(ScopeIssue, h.APIIssueFarmer)
+	// Deferred applications record who submitted them
+	deferred := strings.Replace(farmerJSON, `"require_pin":true`, `"defer_approval":true`, 1)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{name: "API key", header: http.Header{"X-Api-Key": {"field-app.s3cret"}}, want: http.StatusAccepted},
+		{name: "session cookie", header: http.Header{"Cookie": {"session=abc"}}, want: http.StatusUnauthorized},
+		{name: "wrong secret", header: http.Header{"X-Api-Key": {"field-app.guess"}}, want: http.StatusUnauthorized},
+		{name: "no issue scope", header: http.Header{"X-Api-Key": {"reports.s3cret"}}, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/credentials/farmer", strings.NewReader(deferred))
+			r.Header.Set("Content-Type", "application/json")
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.want)
+			}
+			if w.Code != http.StatusAccepted {
+				return
+			}
+			var resp IssuanceResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			iss, err := h.Store.Issuance(resp.IssuanceID)
+			if err != nil || iss.SubmittedBy != APIClientPrefix+"field-app" {
+				t.Errorf("issuance = %+v, %v, want one submitted by client:field-app", iss, err)
+			}
+		})
+	}
+
+	// A bearer token from the client credentials grant works like the key
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {"field-app"}, "client_secret": {"s3cret"}}
+	r := httptest.NewRequest(http.MethodPost, APITokenPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.APIAuth.TokenHandler(w, r)
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &token); err != nil || token.AccessToken == "" {
+		t.Fatalf("token response = %d %s", w.Code, w.Body)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/api/v1/credentials/farmer", strings.NewReader(farmerJSON))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusCreated {
+		t.Errorf("bearer token: status = %d %s, want 201", w.Code, w.Body)
+	}
+}
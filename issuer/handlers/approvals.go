@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/adammwaniki/testa-walt/validity"
+	"github.com/skip2/go-qrcode"
+)
+
+// Review decisions
+const (
+	DecisionApproved = "approved"
+	DecisionRejected = "rejected"
+)
+
+// approvalAuthorities names who signs off deferred credentials per farm type
+var approvalAuthorities = map[string]string{
+	"Dairy": "KDB officer",
+}
+
+// errSelfReview is returned when the clerk who submitted an application tries
+// to decide it
+var errSelfReview = errors.New("reviewer submitted the application")
+
+// approvalView is an issuance as shown in the approver queue
+type approvalView struct {
+	*store.Issuance
+	Baseline  *store.Issuance
+	Changes   []fieldChange
+	Unchanged int
+	PIN       string
+	Error     string
+}
+
+// fieldChange is one subject field that differs from the baseline
+type fieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// offerView is the claim page state of an issuance
+type offerView struct {
+	*store.Issuance
+	QRCode template.URL
+}
+
+// approvalFor names who signs off an application for the given farm type
+func (h *Handler) approvalFor(farmType string) string {
+	if approval := approvalAuthorities[farmType]; approval != "" {
+		return approval
+	}
+	if h.MakerChecker {
+		return "Supervisor"
+	}
+	return "Approving officer"
+}
+
+// submitApplication records a credential application for approval instead of
+// issuing it, and returns the claim page where the offer will appear. The
+// signed-in clerk is recorded as the submitter. It returns the application's
+// ID, or an empty string if it could not be recorded.
+func (h *Handler) submitApplication(w http.ResponseWriter, r *http.Request, credentialType, name string, subject any, approval string, requirePIN bool, supersedes string) string {
+	iss, err := h.newApplication(credentialType, name, subject, approval, requirePIN, currentUsername(r), r.FormValue("comment"), supersedes)
+	if err != nil {
+		h.renderError(w, "Failed to record the application")
+		return ""
+	}
+	h.renderSubmitted(w, r, iss)
+	return iss.ID
+}
+
+// renderSubmitted renders the claim page link of a recorded application
+func (h *Handler) renderSubmitted(w http.ResponseWriter, r *http.Request, iss *store.Issuance) {
+	claimURL := fmt.Sprintf("%s/offers/%s", requestBaseURL(r), iss.ID)
+	qrHTML := ""
+	if png, err := qrcode.Encode(claimURL, qrcode.Medium, 256); err == nil {
+		qrHTML = fmt.Sprintf(`
+			<div class="qr-container">
+				<img src="data:image/png;base64,%s" alt="Claim page QR code" width="256" height="256">
+			</div>`, base64.StdEncoding.EncodeToString(png))
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `
+		<div id="result" class="success-message">
+			<h3>Application Submitted for Approval</h3>
+			<p>The %s credential for <strong>%s</strong> will be issued once a %s approves it.</p>
+
+			<div class="credential-link-container">
+				<label>Claim Page:</label>
+				<div class="link-display">
+					<textarea readonly>%s</textarea>
+				</div>
+			</div>
+			%s
+
+			<div class="instructions">
+				<h4>Next Steps:</h4>
+				<ol>
+					<li>Give the holder the claim page link or QR code</li>
+					<li>The application appears in the <a href="/approvals">approval queue</a></li>
+					<li>Once approved, the claim page shows the credential offer for the wallet</li>
+				</ol>
+			</div>
+
+			<button onclick="location.href='/'" class="btn-secondary">Issue Another Credential</button>
+		</div>
+		%s
+	`, template.HTMLEscapeString(iss.CredentialType), template.HTMLEscapeString(iss.SubjectName), template.HTMLEscapeString(iss.Approval),
+		template.HTMLEscapeString(claimURL), qrHTML, nextIdempotencyKeyInput())
+}
+
+// newApplication stores a pending application, with the clerk's note as its
+// first comment. An application that supersedes an earlier issuance replaces
+// that credential once approved.
+func (h *Handler) newApplication(credentialType, name string, subject any, approval string, requirePIN bool, submittedBy, note, supersedes string) (*store.Issuance, error) {
+	raw, err := json.Marshal(subject)
+	if err != nil {
+		slog.Error("Error encoding application", "error", err)
+		return nil, err
+	}
+
+	iss := &store.Issuance{
+		CredentialType: credentialType,
+		SubjectName:    name,
+		SubjectKey:     subjectKey(subject),
+		Subject:        raw,
+		Status:         store.StatusPending,
+		RequirePIN:     requirePIN,
+		Approval:       approval,
+		SubmittedBy:    submittedBy,
+		Supersedes:     supersedes,
+	}
+	if note = strings.TrimSpace(note); note != "" {
+		iss.Comments = []store.Comment{{Author: submittedBy, Text: note, CreatedAt: time.Now().UTC()}}
+	}
+
+	if err := h.Store.SaveIssuance(iss); err != nil {
+		slog.Error("Error saving application", "error", err)
+		return nil, err
+	}
+	slog.Info("Recorded application", "credential_type", iss.CredentialType, "issuance_id", iss.ID, "approval", approval)
+	return iss, nil
+}
+
+// ShowOffer handles GET /offers/{id}
+// The farmer-facing claim page of a deferred issuance
+func (h *Handler) ShowOffer(w http.ResponseWriter, r *http.Request) {
+	iss, err := h.Store.Issuance(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	view := offerView{Issuance: iss}
+	if iss.Status == store.StatusOffered && iss.OfferURL != "" {
+		png, err := qrcode.Encode(iss.OfferURL, qrcode.Medium, 256)
+		if err != nil {
+			slog.Error("Error generating offer QR code", "error", err)
+		} else {
+			view.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		}
+	}
+
+	if err := h.Templates.ExecuteTemplate(w, "offer.html", view); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering offer page", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ShowApprovals handles GET /approvals
+// Lists deferred applications awaiting a decision
+func (h *Handler) ShowApprovals(w http.ResponseWriter, r *http.Request) {
+	var pending []approvalView
+	for _, iss := range h.Store.Issuances() {
+		if iss.Status != store.StatusPending && iss.Status != store.StatusApproving {
+			continue
+		}
+		pending = append(pending, h.newApprovalView(iss))
+	}
+
+	err := h.Templates.ExecuteTemplate(w, "approvals.html", map[string]any{"Pending": pending})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering approvals", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ApproveIssuance handles POST /approvals/{id}/approve
+// Issues the deferred credential through walt.id and publishes the offer on
+// the claim page
+func (h *Handler) ApproveIssuance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	reviewer := currentUsername(r)
+
+	// Claim the application so a concurrent approval cannot issue it twice
+	iss, err := h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		if iss.Status != store.StatusPending {
+			return store.ErrConflict
+		}
+		if isSubmitter(iss, reviewer) {
+			return errSelfReview
+		}
+		iss.Status = store.StatusApproving
+		return nil
+	})
+	if err != nil {
+		h.renderApprovalRow(w, id, "", decisionErrorMessage(err))
+		return
+	}
+
+	// An update is only issued while the credential it replaces is current
+	if iss.Supersedes != "" {
+		if _, err := h.updatableIssuance(iss.Supersedes); err != nil {
+			h.releaseApplication(id)
+			h.renderApprovalRow(w, id, "", updateErrorMessage(err))
+			return
+		}
+	}
+
+	offer, err := h.requestApplicationOffer(r.Context(), iss)
+	if err != nil {
+		h.releaseApplication(id)
+		h.renderApprovalRow(w, id, "", issuanceErrorMessage(err))
+		return
+	}
+
+	_, err = h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		iss.Status = store.StatusOffered
+		iss.OfferURL = offer.URL
+		iss.PIN = offer.PINState
+		iss.StatusListIndex = offer.StatusIndex
+		setValidity(iss, offer.Validity)
+		iss.Review = &store.Review{Reviewer: reviewer, Decision: DecisionApproved, DecidedAt: time.Now().UTC()}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving approval", "issuance_id", id, "error", err)
+	}
+	slog.InfoContext(r.Context(), "Application approved", "issuance_id", id, "by", reviewer)
+	if iss.Supersedes != "" {
+		h.supersede(r.Context(), iss.Supersedes, id)
+	}
+
+	h.renderApprovalRow(w, id, offer.PIN, "")
+}
+
+// RejectIssuance handles POST /approvals/{id}/reject
+func (h *Handler) RejectIssuance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	reviewer := currentUsername(r)
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		h.renderApprovalRow(w, id, "", "Enter a reason before rejecting")
+		return
+	}
+
+	_, err := h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		if iss.Status != store.StatusPending {
+			return store.ErrConflict
+		}
+		if isSubmitter(iss, reviewer) {
+			return errSelfReview
+		}
+		iss.Status = store.StatusRejected
+		iss.Review = &store.Review{Reviewer: reviewer, Decision: DecisionRejected, Reason: reason, DecidedAt: time.Now().UTC()}
+		return nil
+	})
+	if err != nil {
+		h.renderApprovalRow(w, id, "", decisionErrorMessage(err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Application rejected", "issuance_id", id, "by", reviewer)
+
+	h.renderApprovalRow(w, id, "", "")
+}
+
+// CommentOnApplication handles POST /approvals/{id}/comments
+func (h *Handler) CommentOnApplication(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	author := currentUsername(r)
+	text := strings.TrimSpace(r.FormValue("comment"))
+	if text == "" {
+		h.renderApprovalRow(w, id, "", "Enter a comment")
+		return
+	}
+
+	_, err := h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		iss.Comments = append(iss.Comments, store.Comment{Author: author, Text: text, CreatedAt: time.Now().UTC()})
+		return nil
+	})
+	if err != nil {
+		h.renderApprovalRow(w, id, "", decisionErrorMessage(err))
+		return
+	}
+
+	h.renderApprovalRow(w, id, "", "")
+}
+
+// applicationOffer is the walt.id offer made for an approved application
+type applicationOffer struct {
+	URL string
+	// PIN and PINState are set when the application asked for a PIN
+	PIN      string
+	PINState *store.PINState
+	// StatusIndex is the status list bit of a farmer credential
+	StatusIndex *int
+	Validity    validity.Period
+}
+
+// requestApplicationOffer issues the credential held in an application. Its
+// validity starts at approval, or for a renewal when the credential it
+// renews expires.
+func (h *Handler) requestApplicationOffer(ctx context.Context, iss *store.Issuance) (applicationOffer, error) {
+	start := h.validityStart(iss.Renews)
+	switch iss.CredentialType {
+	case store.CredentialFarmer:
+		var farmer models.SimpleFarmerCredential
+		if err := json.Unmarshal(iss.Subject, &farmer); err != nil {
+			return applicationOffer{}, &IssuanceError{Message: "The application data could not be read", Err: err}
+		}
+		period, err := h.issuanceValidity(&farmer, start)
+		if err != nil {
+			return applicationOffer{}, err
+		}
+		statusIndex, err := h.allocateStatusIndex(ctx)
+		if err != nil {
+			return applicationOffer{}, err
+		}
+		offerURL, pin, pinState, err := h.requestFarmerOffer(ctx, &farmer, iss.RequirePIN, statusIndex, period)
+		return applicationOffer{URL: offerURL, PIN: pin, PINState: pinState, StatusIndex: statusIndex, Validity: period}, err
+
+	case store.CredentialPDA1:
+		var farmer models.FarmerCredential
+		if err := json.Unmarshal(iss.Subject, &farmer); err != nil {
+			return applicationOffer{}, &IssuanceError{Message: "The application data could not be read", Err: err}
+		}
+		period, err := h.issuanceValidity(&farmer, start)
+		if err != nil {
+			return applicationOffer{}, err
+		}
+		offerURL, err := h.requestPDA1Offer(ctx, &farmer, period)
+		return applicationOffer{URL: offerURL, Validity: period}, err
+	}
+
+	return applicationOffer{}, &IssuanceError{
+		Message: "Unknown credential type",
+		Err:     fmt.Errorf("unknown credential type %q", iss.CredentialType),
+	}
+}
+
+// isSubmitter reports whether reviewer is the clerk who made the application
+func isSubmitter(iss *store.Issuance, reviewer string) bool {
+	return iss.SubmittedBy != "" && iss.SubmittedBy == reviewer
+}
+
+// currentUsername returns the username of the signed-in user
+func currentUsername(r *http.Request) string {
+	if u, ok := auth.UserFrom(r.Context()); ok {
+		return u.Username
+	}
+	return ""
+}
+
+// releaseApplication returns an application to the queue after a failed approval
+func (h *Handler) releaseApplication(id string) {
+	_, err := h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		iss.Status = store.StatusPending
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error releasing application", "issuance_id", id, "error", err)
+	}
+}
+
+// renderApprovalRow renders one row of the approval queue after a decision
+func (h *Handler) renderApprovalRow(w http.ResponseWriter, id, pin, message string) {
+	iss, err := h.Store.Issuance(id)
+	if err != nil {
+		h.renderError(w, "Application not found")
+		return
+	}
+
+	view := h.newApprovalView(iss)
+	view.PIN = pin
+	view.Error = message
+
+	if err := h.Templates.ExecuteTemplate(w, "approval-row", view); err != nil {
+		slog.Error("Error rendering approval row", "error", err)
+	}
+}
+
+// newApprovalView prepares an application for review, diffing its subject
+// against the credential it updates, or else the previous issuance or
+// application for the same holder
+func (h *Handler) newApprovalView(iss *store.Issuance) approvalView {
+	view := approvalView{Issuance: iss}
+
+	var baseline json.RawMessage
+	if prev, err := h.Store.Issuance(iss.Supersedes); iss.Supersedes != "" && err == nil {
+		view.Baseline = prev
+		baseline = prev.Subject
+	} else if prev, ok := h.Store.Previous(iss); ok {
+		view.Baseline = prev
+		baseline = prev.Subject
+	}
+	view.Changes, view.Unchanged = diffSubjects(baseline, iss.Subject)
+	return view
+}
+
+// diffSubjects compares two subjects field by field. Fields missing from
+// old are reported as new values; equal fields are only counted.
+func diffSubjects(old, new json.RawMessage) ([]fieldChange, int) {
+	oldFields := flattenSubject(old)
+	newFields := flattenSubject(new)
+
+	keys := make([]string, 0, len(newFields))
+	for k := range newFields {
+		keys = append(keys, k)
+	}
+	for k := range oldFields {
+		if _, ok := newFields[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []fieldChange
+	unchanged := 0
+	for _, k := range keys {
+		if oldFields[k] == newFields[k] {
+			unchanged++
+			continue
+		}
+		changes = append(changes, fieldChange{Field: k, Old: oldFields[k], New: newFields[k]})
+	}
+	return changes, unchanged
+}
+
+// flattenSubject renders each top-level subject field as a string. Empty
+// fields and false flags are left out so they compare equal to absent ones.
+func flattenSubject(raw json.RawMessage) map[string]string {
+	fields := map[string]string{}
+	if len(raw) == 0 {
+		return fields
+	}
+
+	var m map[string]any
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return fields
+	}
+	for k, v := range m {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				fields[k] = v
+			}
+		case bool:
+			if v {
+				fields[k] = "yes"
+			}
+		case float64:
+			fields[k] = fmt.Sprint(v)
+		default:
+			b, _ := json.Marshal(v)
+			fields[k] = string(b)
+		}
+	}
+	return fields
+}
+
+// decisionErrorMessage explains why a decision could not be recorded
+func decisionErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return "Application not found"
+	case errors.Is(err, errSelfReview):
+		return "You submitted this application, so another supervisor must review it"
+	case errors.Is(err, store.ErrConflict):
+		return "This application has already been decided or is being processed"
+	default:
+		slog.Error("Error recording decision", "error", err)
+		return "Failed to record the decision"
+	}
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// asUser returns a request signed in as username
+func asUser(r *http.Request, username string) *http.Request {
+	return r.WithContext(auth.WithUser(r.Context(), &auth.User{Username: username, Roles: []string{auth.RoleSupervisor}}))
+}
+
+// decide posts a review decision on application id as reviewer
+func decide(h *Handler, decision, id, reviewer string, form url.Values) string {
+	r := httptest.NewRequest(http.MethodPost, "/approvals/"+id+"/"+decision, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	switch decision {
+	case "approve":
+		h.ApproveIssuance(w, asUser(r, reviewer))
+	case "reject":
+		h.RejectIssuance(w, asUser(r, reviewer))
+	}
+	return w.Body.String()
+}
+
+// claimPage renders the holder's claim page of application id
+func claimPage(t *testing.T, h *Handler, id string) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/offers/"+id, nil)
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	h.ShowOffer(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("claim page status = %d, want 200", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestApproveIssuance(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	offers := offerServer(&calls)
+	h := newTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			calls.Add(1)
+			http.Error(w, "issuer key not found", http.StatusInternalServerError)
+			return
+		}
+		offers(w, r)
+	})
+
+	apply := func() string {
+		subject := map[string]string{"given_name": "Amina", "family_name": "Otieno", "farm_type": "dairy"}
+		iss, err := h.newApplication(store.CredentialFarmer, "Amina Otieno", subject, "KDB officer", true, "clerk", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return iss.ID
+	}
+
+	tests := []struct {
+		name       string
+		reviewer   string
+		fail       bool
+		wantStatus string
+		wantBody   string
+		wantCalls  int32
+		wantClaim  string
+	}{
+		{name: "approved", reviewer: "supervisor", wantStatus: store.StatusOffered, wantBody: "pin-value", wantCalls: 1, wantClaim: "Your Credential Is Ready"},
+		{name: "self-approval", reviewer: "clerk", wantStatus: store.StatusPending, wantBody: "another supervisor must review it", wantClaim: "Awaiting Approval"},
+		{name: "walt.id failure", reviewer: "supervisor", fail: true, wantStatus: store.StatusPending, wantBody: "error-message", wantCalls: 1, wantClaim: "Awaiting Approval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := apply()
+			if claim := claimPage(t, h, id); !strings.Contains(claim, "Awaiting Approval") {
+				t.Errorf("claim page before review = %q, want it awaiting approval", claim)
+			}
+
+			failing.Store(tt.fail)
+			before := calls.Load()
+			body := decide(h, "approve", id, tt.reviewer, nil)
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			if got := calls.Load() - before; got != tt.wantCalls {
+				t.Errorf("walt.id calls = %d, want %d", got, tt.wantCalls)
+			}
+
+			iss, err := h.Store.Issuance(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iss.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", iss.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == store.StatusOffered {
+				if iss.Review == nil || iss.Review.Reviewer != tt.reviewer || iss.Review.Decision != DecisionApproved {
+					t.Errorf("review = %+v, want approved by %s", iss.Review, tt.reviewer)
+				}
+				if iss.OfferURL == "" || iss.PIN == nil {
+					t.Errorf("offer = %q, PIN %v, want a PIN-protected offer", iss.OfferURL, iss.PIN)
+				}
+				// A second approval finds the application decided
+				if body := decide(h, "approve", id, tt.reviewer, nil); !strings.Contains(body, "already been decided") || calls.Load()-before != 1 {
+					t.Errorf("second approval = %q after %d walt.id calls, want a conflict", body, calls.Load()-before)
+				}
+			}
+			if claim := claimPage(t, h, id); !strings.Contains(claim, tt.wantClaim) {
+				t.Errorf("claim page = %q, want %q", claim, tt.wantClaim)
+			}
+		})
+	}
+}
+
+func TestRejectIssuance(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestHandler(t, offerServer(&calls))
+
+	tests := []struct {
+		name       string
+		reviewer   string
+		reason     string
+		wantStatus string
+		wantBody   string
+	}{
+		{name: "rejected", reviewer: "supervisor", reason: "Herd size does not match the KDB register", wantStatus: store.StatusRejected, wantBody: "rejected by supervisor"},
+		{name: "no reason", reviewer: "supervisor", wantStatus: store.StatusPending, wantBody: "Enter a reason"},
+		{name: "self-rejection", reviewer: "clerk", reason: "Typo in the name", wantStatus: store.StatusPending, wantBody: "another supervisor must review it"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iss, err := h.newApplication(store.CredentialFarmer, "Amina Otieno", map[string]string{"given_name": "Amina"}, "KDB officer", false, "clerk", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := decide(h, "reject", iss.ID, tt.reviewer, url.Values{"reason": {tt.reason}})
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+			got, err := h.Store.Issuance(iss.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got.Status, tt.wantStatus)
+			}
+			if tt.wantStatus == store.StatusRejected {
+				if claim := claimPage(t, h, iss.ID); !strings.Contains(claim, "Application Not Approved") || !strings.Contains(claim, tt.reason) {
+					t.Errorf("claim page = %q, want the rejection and its reason", claim)
+				}
+			}
+		})
+	}
+	if calls.Load() != 0 {
+		t.Errorf("walt.id calls = %d, want none for rejections", calls.Load())
+	}
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/bulk"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/skip2/go-qrcode"
+)
+
+// maxUploadSize bounds bulk upload files
+const maxUploadSize = 10 << 20
+
+// ShowBulkForm renders the bulk enrolment upload page
+func (h *Handler) ShowBulkForm(w http.ResponseWriter, r *http.Request) {
+	data := h.formData()
+	data["FarmTypes"] = bulk.FarmTypes
+	data["Fields"] = bulk.Fields
+	data["OptionalFields"] = bulk.OptionalFields
+	err := h.Templates.ExecuteTemplate(w, "bulk-upload.html", data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering bulk upload form", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// UploadBulk handles a CSV/XLSX upload. With dry_run it only returns a
+// validation report; otherwise it starts a bulk issuance job.
+func (h *Handler) UploadBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		slog.WarnContext(r.Context(), "Error parsing upload", "error", err)
+		h.renderError(w, "Failed to read the upload. Files must be under 10 MB.")
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		h.renderError(w, "Please choose a CSV or XLSX file to upload")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error reading upload", "error", err)
+		h.renderError(w, "Failed to read the uploaded file")
+		return
+	}
+
+	sheet, err := bulk.ReadFile(header.Filename, data)
+	if err != nil {
+		h.renderError(w, err.Error())
+		return
+	}
+
+	farmType := r.FormValue("farm_type")
+	if missing := bulk.MissingColumns(sheet.Headers, farmType); len(missing) > 0 {
+		h.renderError(w, fmt.Sprintf(
+			"The file is missing columns for: %s", strings.Join(missing, ", ")))
+		return
+	}
+
+	rows, err := bulk.ParseRows(sheet, farmType, resolveFarmer)
+	if err != nil {
+		h.renderError(w, err.Error())
+		return
+	}
+
+	if r.FormValue("dry_run") == "on" {
+		h.renderBulkReport(w, header.Filename, sheet, farmType, rows)
+		return
+	}
+
+	// Under maker-checker each row becomes an application for supervisor
+	// review, and the results carry claim page links instead of offers
+	issue := h.issueFarmerCredential
+	heading := "Bulk Issuance Started"
+	if h.MakerChecker {
+		submittedBy := currentUsername(r)
+		base := requestBaseURL(r)
+		issue = func(_ context.Context, farmer *models.SimpleFarmerCredential) (string, error) {
+			name := farmer.GivenName + " " + farmer.FamilyName
+			iss, err := h.newApplication(store.CredentialFarmer, name, farmer, h.approvalFor(farmer.FarmType), false, submittedBy, "Bulk upload: "+header.Filename, "")
+			if err != nil {
+				return "", err
+			}
+			return base + "/offers/" + iss.ID, nil
+		}
+		heading = "Bulk Applications Submitted for Review"
+	}
+
+	job := h.Bulk.Start(r.Context(), currentUsername(r), header.Filename, rows, issue)
+	slog.InfoContext(r.Context(), "Bulk job started", "job_id", job.ID, "file", header.Filename, "rows", len(rows))
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `
+		<div id="result" class="success-message">
+			<h3>%s</h3>
+			<p>Processing <strong>%s</strong></p>
+			<div hx-ext="sse" sse-connect="/bulk/jobs/%s/events" sse-swap="progress,done">
+				%s
+			</div>
+		</div>
+	`, heading, template.HTMLEscapeString(header.Filename), job.ID, bulkProgressHTML(job))
+}
+
+// BulkEvents handles GET /bulk/jobs/{id}/events
+// Streams job progress as server-sent events for the HTMX sse extension
+func (h *Handler) BulkEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Bulk.Job(r.PathValue("id"), currentUsername(r))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// A reconnect after the final event gets 204, which stops EventSource retrying
+	if r.Header.Get("Last-Event-ID") != "" && job.Progress().Finished {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// The stream lasts as long as the job, beyond the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Error clearing write deadline for progress stream", "error", err)
+	}
+
+	for {
+		changed := job.Changed()
+		progress := job.Progress()
+
+		event := "progress"
+		if progress.Finished {
+			event = "done"
+		}
+
+		fmt.Fprintf(w, "id: %d\nevent: %s\n", progress.Done, event)
+		for _, line := range strings.Split(bulkProgressHTML(job), "\n") {
+			fmt.Fprintf(w, "data: %s\n", line)
+		}
+		fmt.Fprint(w, "\n")
+		flusher.Flush()
+
+		if progress.Finished {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// BulkResults handles GET /bulk/jobs/{id}/results.csv
+func (h *Handler) BulkResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Bulk.Job(r.PathValue("id"), currentUsername(r))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	base := requestBaseURL(r)
+	filename := strings.TrimSuffix(job.Filename, "."+fileExt(job.Filename)) + "-results.csv"
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	err := job.WriteCSV(w, func(row int) string {
+		return fmt.Sprintf("%s/bulk/jobs/%s/rows/%d/qr.png", base, job.ID, row)
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error writing bulk results", "error", err)
+	}
+}
+
+// BulkQRCode handles GET /bulk/jobs/{id}/rows/{row}/qr.png
+// Renders the credential offer of one row as a QR code for wallet scanning
+func (h *Handler) BulkQRCode(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.Bulk.Job(r.PathValue("id"), currentUsername(r))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	row, err := strconv.Atoi(r.PathValue("row"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	result, ok := job.Result(row)
+	if !ok || result.OfferURL == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	png, err := qrcode.Encode(result.OfferURL, qrcode.Medium, 320)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error generating QR code", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// renderBulkReport renders the dry-run validation report
+func (h *Handler) renderBulkReport(w http.ResponseWriter, filename string, sheet *bulk.Sheet, farmType string, rows []bulk.Row) {
+	valid := 0
+	var problems strings.Builder
+	for _, row := range rows {
+		if row.Valid() {
+			valid++
+			continue
+		}
+		fmt.Fprintf(&problems, "<tr><td>%d</td><td>%s</td><td>%s</td></tr>",
+			row.Number,
+			template.HTMLEscapeString(strings.TrimSpace(row.Farmer.GivenName+" "+row.Farmer.FamilyName)),
+			template.HTMLEscapeString(strings.Join(row.Errors, "; ")))
+	}
+
+	var mapping strings.Builder
+	columns := bulk.MappingFor(farmType).Columns(sheet.Headers)
+	for _, field := range bulk.Fields {
+		column := "<em>from selected farm type</em>"
+		if idx := columns[field]; idx >= 0 {
+			column = template.HTMLEscapeString(sheet.Headers[idx])
+		} else if field != "farm_type" || farmType == "" {
+			column = "<em>not found</em>"
+		}
+		fmt.Fprintf(&mapping, "<tr><td>%s</td><td>%s</td></tr>", field, column)
+	}
+	for _, field := range bulk.OptionalFields {
+		column := "<em>not found (optional)</em>"
+		if idx := columns[field]; idx >= 0 {
+			column = template.HTMLEscapeString(sheet.Headers[idx])
+		}
+		fmt.Fprintf(&mapping, "<tr><td>%s</td><td>%s</td></tr>", field, column)
+	}
+
+	problemsHTML := "<p>All rows passed validation.</p>"
+	if problems.Len() > 0 {
+		problemsHTML = `<table class="bulk-table"><thead><tr><th>Row</th><th>Farmer</th><th>Problems</th></tr></thead><tbody>` +
+			problems.String() + `</tbody></table>`
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `
+		<div id="result" class="success-message">
+			<h3>Dry Run: %s</h3>
+			<p><strong>%d</strong> of <strong>%d</strong> rows are ready to issue. No credentials were issued.</p>
+
+			<h4>Column Mapping</h4>
+			<table class="bulk-table"><thead><tr><th>Field</th><th>Column</th></tr></thead><tbody>%s</tbody></table>
+
+			<h4>Validation Problems</h4>
+			%s
+		</div>
+	`, template.HTMLEscapeString(filename), valid, len(rows), mapping.String(), problemsHTML)
+}
+
+// bulkProgressHTML renders the progress panel of a job
+func bulkProgressHTML(job *bulk.Job) string {
+	p := job.Progress()
+
+	download := ""
+	if p.Finished {
+		download = fmt.Sprintf(`<p><a href="/bulk/jobs/%s/results.csv" class="btn-primary">Download Results (CSV)</a></p>`, job.ID)
+	}
+
+	return fmt.Sprintf(`<div class="bulk-progress">
+<progress max="%d" value="%d"></progress>
+<p>%d of %d rows processed: <strong>%d issued</strong>, %d failed, %d invalid</p>
+%s
+</div>`, p.Total, p.Done, p.Done, p.Total, p.Issued, p.Failed, p.Invalid, download)
+}
+
+// requestBaseURL reconstructs the externally visible base URL of the app
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func fileExt(filename string) string {
+	if i := strings.LastIndex(filename, "."); i >= 0 {
+		return filename[i+1:]
+	}
+	return ""
+}
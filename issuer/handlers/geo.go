@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/adammwaniki/testa-walt/common/geo"
+)
+
+// ListCounties handles GET /geo/counties
+// Returns <option> elements for HTMX dropdowns, or JSON when requested. The
+// county named by ?selected=, by code or name, is preselected.
+func (h *Handler) ListCounties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	counties := geo.Default.Counties
+
+	if wantsJSON(r) {
+		type countySummary struct {
+			Code   string `json:"code"`
+			Name   string `json:"name"`
+			Region string `json:"region"`
+		}
+		summaries := make([]countySummary, 0, len(counties))
+		for _, c := range counties {
+			summaries = append(summaries, countySummary{Code: c.Code, Name: c.Name, Region: c.Region})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaries)
+		return
+	}
+
+	selected, _ := geo.Default.County(r.URL.Query().Get("selected"))
+
+	var b strings.Builder
+	b.WriteString(`<option value="">Select County...</option>`)
+	for _, c := range counties {
+		fmt.Fprintf(&b, `<option value="%s"%s>%s</option>`,
+			template.HTMLEscapeString(c.Code), selectedAttr(selected != nil && selected.Code == c.Code), template.HTMLEscapeString(c.Name))
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(b.String()))
+}
+
+// ListSubCounties handles GET /geo/counties/{id}/subcounties
+// The sub-county named by ?selected= is preselected
+func (h *Handler) ListSubCounties(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	county, ok := geo.Default.County(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "County not found", http.StatusNotFound)
+		return
+	}
+
+	if wantsJSON(r) {
+		names := make([]string, 0, len(county.SubCounties))
+		for _, sub := range county.SubCounties {
+			names = append(names, sub.Name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	selected, _ := county.SubCounty(r.URL.Query().Get("selected"))
+
+	var b strings.Builder
+	b.WriteString(`<option value="">Select Sub-County...</option>`)
+	for i, sub := range county.SubCounties {
+		name := template.HTMLEscapeString(sub.Name)
+		fmt.Fprintf(&b, `<option value="%s"%s>%s</option>`, name, selectedAttr(selected == &county.SubCounties[i]), name)
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(b.String()))
+}
+
+// selectedAttr returns the selected attribute of a preselected <option>
+func selectedAttr(selected bool) string {
+	if selected {
+		return " selected"
+	}
+	return ""
+}
+
+// wantsJSON reports whether the client asked for JSON rather than an HTML fragment
+func wantsJSON(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
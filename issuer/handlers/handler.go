@@ -1,23 +1,64 @@
 package handlers
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/adammwaniki/testa-walt/bulk"
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/geo"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/common/waltid"
 	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/notify"
+	"github.com/adammwaniki/testa-walt/renewal"
+	"github.com/adammwaniki/testa-walt/statuslist"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/adammwaniki/testa-walt/validity"
+	"github.com/skip2/go-qrcode"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Handler holds dependencies for HTTP handlers
 type Handler struct {
 	WaltIDURL string
 	Templates *template.Template
+	Bulk      *bulk.Manager
+	Store     *store.Store
+	Renewals  *renewal.Scheduler
+
+	// APIAuth authenticates the clients of the JSON API by API key or
+	// bearer token
+	APIAuth  *apiauth.Auth
+	apiUsage *apiauth.Usage
+
+	// MakerChecker sends every issuance through supervisor review
+	MakerChecker bool
+
+	// Validity holds the validity policy of each credential type
+	Validity map[string]validity.Policy
+
+	// PublicURL is the issuer's external base URL, used in the status list
+	// links of issued credentials
+	PublicURL    string
+	statusSigner *statuslist.Signer
+
+	// Abuse protection: request limits per user and per client IP on the
+	// issuance endpoints, and a cap on concurrent walt.id calls
+	userLimits *ratelimit.Limiter
+	ipLimits   *ratelimit.Limiter
+	waltID     *waltid.Client
 }
 
 // NewHandler creates a new handler with dependencies
@@ -28,10 +69,78 @@ func NewHandler(waltIDURL string) *Handler {
 		log.Fatal("Error parsing templates:", err)
 	}
 
-	return &Handler{
-		WaltIDURL: waltIDURL,
-		Templates: templates,
+	// Bulk issuance worker pool size bounds concurrent walt.id calls
+	workers := 4
+	if v, err := strconv.Atoi(os.Getenv("BULK_WORKERS")); err == nil && v > 0 {
+		workers = v
 	}
+
+	// Issuance ledger, kept as a JSON file in the data directory
+	dataDir := DataDir()
+	issuances, err := store.Open(dataDir)
+	if err != nil {
+		log.Fatal("Error opening issuer store:", err)
+	}
+
+	// Superseded farmer credentials are revoked through a status list signed
+	// with the farmer issuer key
+	statusSigner, err := newStatusSigner()
+	if err != nil {
+		log.Fatal("Error loading status list key:", err)
+	}
+
+	// JSON API clients and their usage, kept in the data directory
+	apiUsage, err := apiauth.OpenUsage(apiauth.UsagePath(dataDir))
+	if err != nil {
+		log.Fatal("Error loading API usage:", err)
+	}
+	apiAuth, err := newAPIAuth(dataDir, apiUsage)
+	if err != nil {
+		log.Fatal("Error loading API clients:", err)
+	}
+
+	// Each credential type has its own validity period
+	policies, err := validityPoliciesFromEnv()
+	if err != nil {
+		log.Fatal("Error reading validity policies:", err)
+	}
+
+	h := &Handler{
+		WaltIDURL:    waltIDURL,
+		Templates:    templates,
+		Bulk:         bulk.NewManager(workers),
+		Store:        issuances,
+		APIAuth:      apiAuth,
+		apiUsage:     apiUsage,
+		MakerChecker: os.Getenv("MAKER_CHECKER") == "true",
+		Validity:     policies,
+		PublicURL:    publicURL(),
+		statusSigner: statusSigner,
+		userLimits:   ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_USER_PER_MINUTE", 30), ratelimit.EnvInt("RATE_LIMIT_BURST", 10)),
+		ipLimits:     ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_IP_PER_MINUTE", 60), ratelimit.EnvInt("RATE_LIMIT_BURST", 10)),
+		waltID:       newWaltIDClient(),
+	}
+
+	// Credentials nearing expiry are renewed and their holders reminded
+	// through the configured notification channels
+	h.Renewals = renewal.New(renewal.ConfigFromEnv(h.PublicURL+"/offers/"), issuances, h.renewIssuance, notify.FromEnv())
+	return h
+}
+
+// newWaltIDClient creates the shared walt.id client, traced and configured
+// from the WALTID_* environment variables
+func newWaltIDClient() *waltid.Client {
+	cfg := waltid.ConfigFromEnv()
+	cfg.Observer = waltIDMetrics
+	return waltid.New(cfg, tracing.Transport(waltid.PooledTransport(cfg.MaxConcurrent)))
+}
+
+// DataDir returns the directory for the issuer's files (ISSUER_DATA_DIR)
+func DataDir() string {
+	if dir := os.Getenv("ISSUER_DATA_DIR"); dir != "" {
+		return dir
+	}
+	return "data"
 }
 
 // Home renders the home page with credential selection
@@ -41,31 +150,42 @@ func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.Templates.ExecuteTemplate(w, "index.html", nil)
+	data := map[string]any{}
+	if u, ok := auth.UserFrom(r.Context()); ok {
+		data["User"] = u
+	}
+
+	err := h.Templates.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
-		log.Printf("Error rendering template: %v", err)
+		slog.ErrorContext(r.Context(), "Error rendering template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
 // ShowPDA1Form renders the PDA1 credential form
 func (h *Handler) ShowPDA1Form(w http.ResponseWriter, r *http.Request) {
-	err := h.Templates.ExecuteTemplate(w, "pda1-form.html", nil)
+	err := h.Templates.ExecuteTemplate(w, "pda1-form.html", h.formData())
 	if err != nil {
-		log.Printf("Error rendering PDA1 form: %v", err)
+		slog.ErrorContext(r.Context(), "Error rendering PDA1 form", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
 // ShowFarmerForm renders the Farmer credential form
 func (h *Handler) ShowFarmerForm(w http.ResponseWriter, r *http.Request) {
-	err := h.Templates.ExecuteTemplate(w, "farmer-form.html", nil)
+	err := h.Templates.ExecuteTemplate(w, "farmer-form.html", h.formData())
 	if err != nil {
-		log.Printf("Error rendering Farmer form: %v", err)
+		slog.ErrorContext(r.Context(), "Error rendering farmer form", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
+// formData is the template data shared by the issuance forms
+func (h *Handler) formData() map[string]any {
+	return map[string]any{"MakerChecker": h.MakerChecker, "IdempotencyKey": store.NewID(), "FarmTypes": farmTypes,
+		"PDA1Validity": describeValidity(h.Validity[store.CredentialPDA1]), "FarmerValidity": describeValidity(h.Validity[store.CredentialFarmer])}
+}
+
 // IssueCredential handles the PDA1 credential issuance request
 func (h *Handler) IssueCredential(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -74,8 +194,11 @@ func (h *Handler) IssueCredential(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse form data
+	ctx, span := tracing.Start(r.Context(), "parse form", attribute.String("credential.type", store.CredentialPDA1))
 	if err := r.ParseForm(); err != nil {
-		log.Printf("Error parsing form: %v", err)
+		slog.WarnContext(ctx, "Error parsing form", "error", err)
+		tracing.Fail(span, err)
+		span.End()
 		h.renderError(w, "Failed to parse form data")
 		return
 	}
@@ -83,62 +206,44 @@ func (h *Handler) IssueCredential(w http.ResponseWriter, r *http.Request) {
 	// Extract farmer data from form
 	farmer := h.extractFarmerData(r)
 
-	// Build credential request
-	credRequest := h.buildCredentialRequest(farmer)
-
-	// Marshal request to JSON
-	requestBody, err := json.Marshal(credRequest)
-	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
-		h.renderError(w, "Failed to create credential request")
+	// The Section 2 period becomes the credential's validity
+	if _, err := h.validityFor(farmer, time.Time{}); err != nil {
+		slog.WarnContext(ctx, "Invalid PDA1 validity period", "error", err)
+		tracing.Fail(span, err)
+		span.End()
+		h.renderError(w, fmt.Sprintf("Invalid validity period: %v", err))
 		return
 	}
+	span.End()
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Make request to Walt.id
-	req, err := http.NewRequest("POST", h.WaltIDURL, bytes.NewBuffer(requestBody))
+	// A resubmitted form, such as a double click, gets the first result
+	claim, err := h.claimIdempotencyKey(r, formRequest(r))
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		h.renderError(w, "Failed to create request")
+		h.renderIdempotencyError(w, r, err)
 		return
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request to Walt.id: %v", err)
-		h.renderError(w, "Failed to connect to credential service. Please try again.")
+	if claim.Previous != nil {
+		h.renderReplay(w, r, claim.Previous)
 		return
 	}
-	defer resp.Body.Close()
+	var issuanceID string
+	defer func() { claim.Finish(r, issuanceID) }()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		h.renderError(w, "Failed to read response")
+	// Under maker-checker the clerk's draft waits for supervisor review
+	if h.MakerChecker {
+		issuanceID = h.submitApplication(w, r, store.CredentialPDA1, farmer.Forenames+" "+farmer.Surname, farmer, h.approvalFor(""), false, "")
 		return
 	}
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Walt.id returned status %d: %s", resp.StatusCode, string(body))
-		h.renderError(w, fmt.Sprintf("Credential service error: %s", string(body)))
+	// Issue via walt.id
+	credentialLink, issuanceID, err := h.issuePDA1Credential(r.Context(), farmer)
+	if err != nil {
+		h.renderIssuanceError(w, err)
 		return
 	}
 
-	// Response is the credential link
-	credentialLink := string(body)
-
 	// Render success response with HTMX
-	h.renderSuccess(w, credentialLink, farmer.Forenames+" "+farmer.Surname, "PDA1")
+	h.renderSuccess(r.Context(), w, credentialLink, farmer.Forenames+" "+farmer.Surname, "PDA1", "")
 }
 
 // IssueFarmerCredential handles the Farmer credential issuance request
@@ -149,8 +254,11 @@ func (h *Handler) IssueFarmerCredential(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Parse form data
+	ctx, span := tracing.Start(r.Context(), "parse form", attribute.String("credential.type", store.CredentialFarmer))
 	if err := r.ParseForm(); err != nil {
-		log.Printf("Error parsing form: %v", err)
+		slog.WarnContext(ctx, "Error parsing form", "error", err)
+		tracing.Fail(span, err)
+		span.End()
 		h.renderError(w, "Failed to parse form data")
 		return
 	}
@@ -162,66 +270,89 @@ func (h *Handler) IssueFarmerCredential(w http.ResponseWriter, r *http.Request)
 		FarmName:   r.FormValue("farm_name"),
 		FarmType:   r.FormValue("farm_type"),
 		LicenseNo:  r.FormValue("license_no"),
-		Region:     r.FormValue("region"),
+		County:     r.FormValue("county"),
+		SubCounty:  r.FormValue("sub_county"),
+		Phone:      r.FormValue("phone"),
+		Email:      r.FormValue("email"),
 	}
 
-	// Build credential request
-	credRequest := h.buildFarmerCredentialRequest(farmerCred)
-
-	// Marshal request to JSON
-	requestBody, err := json.Marshal(credRequest)
-	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
-		h.renderError(w, "Failed to create credential request")
+	// Validate location against the county dataset and use canonical spellings
+	if err := resolveLocation(farmerCred); err != nil {
+		slog.WarnContext(ctx, "Invalid farmer location", "error", err)
+		tracing.Fail(span, err)
+		span.End()
+		h.renderError(w, fmt.Sprintf("Invalid location: %v", err))
+		return
+	}
+	if err := resolveContact(farmerCred); err != nil {
+		slog.WarnContext(ctx, "Invalid farmer contact details", "error", err)
+		tracing.Fail(span, err)
+		span.End()
+		h.renderError(w, fmt.Sprintf("Invalid contact details: %v", err))
 		return
 	}
+	span.End()
 
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	requirePIN := r.FormValue("require_pin") == "on"
+
+	// An update replaces an earlier credential, which must still be current
+	supersedes := r.FormValue("supersedes")
+	if supersedes != "" {
+		if _, err := h.updatableIssuance(supersedes); err != nil {
+			h.renderError(w, updateErrorMessage(err))
+			return
+		}
 	}
 
-	// Use the farmer credential endpoint
-	farmerURL := "http://139.59.15.151:7002/openid4vc/jwt/issue"
-	req, err := http.NewRequest("POST", farmerURL, bytes.NewBuffer(requestBody))
+	// A resubmitted form, such as a double click, gets the first result
+	claim, err := h.claimIdempotencyKey(r, formRequest(r))
 	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		h.renderError(w, "Failed to create request")
+		h.renderIdempotencyError(w, r, err)
 		return
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request to Walt.id: %v", err)
-		h.renderError(w, "Failed to connect to credential service. Please try again.")
+	if claim.Previous != nil {
+		h.renderReplay(w, r, claim.Previous)
+		return
+	}
+	var issuanceID string
+	defer func() { claim.Finish(r, issuanceID) }()
+
+	// Applications needing sign-off, or every application under
+	// maker-checker, are recorded and issued on approval
+	if h.MakerChecker || r.FormValue("defer_approval") == "on" {
+		name := farmerCred.GivenName + " " + farmerCred.FamilyName
+		issuanceID = h.submitApplication(w, r, store.CredentialFarmer, name, farmerCred, h.approvalFor(farmerCred.FarmType), requirePIN, supersedes)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Read response
-	body, err := io.ReadAll(resp.Body)
+	// Issue via walt.id, optionally bound to a PIN delivered out-of-band
+	credentialLink, pin, issuanceID, err := h.issueFarmerOffer(r.Context(), farmerCred, requirePIN, supersedes, time.Time{})
 	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		h.renderError(w, "Failed to read response")
+		h.renderIssuanceError(w, err)
 		return
 	}
 
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Walt.id returned status %d: %s", resp.StatusCode, string(body))
-		h.renderError(w, fmt.Sprintf("Credential service error: %s", string(body)))
-		return
+	// Render success response
+	h.renderSuccess(r.Context(), w, credentialLink, farmerCred.GivenName+" "+farmerCred.FamilyName, "Farmer", pin)
+}
+
+// resolveLocation validates the farmer's county and sub-county, normalises
+// their spelling and derives the region (former province) from the county
+func resolveLocation(farmer *models.SimpleFarmerCredential) error {
+	if farmer.SubCounty == "" {
+		return fmt.Errorf("sub-county is required")
 	}
 
-	// Response is the credential link
-	credentialLink := string(body)
+	county, subCounty, err := geo.Default.Resolve(farmer.County, farmer.SubCounty)
+	if err != nil {
+		return err
+	}
 
-	// Render success response
-	h.renderSuccess(w, credentialLink, farmerCred.GivenName+" "+farmerCred.FamilyName, "Farmer")
+	c, _ := geo.Default.County(county)
+	farmer.County = county
+	farmer.SubCounty = subCounty
+	farmer.Region = c.Region
+	return nil
 }
 
 // extractFarmerData extracts farmer information from the form
@@ -298,7 +429,9 @@ func (h *Handler) extractFarmerData(r *http.Request) *models.FarmerCredential {
 }
 
 // buildCredentialRequest builds the complete Walt.id credential request for PDA1
-func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential) *models.CredentialRequest {
+func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential, period validity.Period) *models.CredentialRequest {
+	dates := newValidityDates(period, "timestamp-ebsi")
+
 	// Parse nationalities (comma-separated)
 	nationalities := []string{"BE"}
 	if farmer.Nationalities != "" {
@@ -311,13 +444,7 @@ func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential) *model
 	return &models.CredentialRequest{
 		IssuerKey: models.IssuerKey{
 			Type: "jwk",
-			JWK: models.JWK{
-				Kty: "EC",
-				X:   "SgfOvOk1TL5yiXhK5Nq7OwKfn_RUkDizlIhAf8qd2wE",
-				Y:   "u_y5JZOsw3SrnNPydzJkoaiqb8raSdCNE_nPovt1fNI",
-				Crv: "P-256",
-				D:   "UqSi2MbJmPczfRmwRDeOJrdivoEy-qk4OEDjFwJYlUI",
-			},
+			JWK:  pda1IssuerJWK,
 		},
 		CredentialConfigurationID: "VerifiablePortableDocumentA1_jwt_vc",
 		CredentialData: models.CredentialData{
@@ -328,8 +455,10 @@ func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential) *model
 				"VerifiableAttestation",
 				"VerifiablePortableDocumentA1",
 			},
-			Issuer:       "did:ebsi:zf39qHTXaLrr6iy3tQhT3UZ",
-			IssuanceDate: "2020-03-10T04:24:12Z",
+			Issuer:         "did:ebsi:zf39qHTXaLrr6iy3tQhT3UZ",
+			IssuanceDate:   "2020-03-10T04:24:12Z",
+			ValidFrom:      dates.ValidFrom,
+			ExpirationDate: dates.ExpirationDate,
 			CredentialSubject: models.CredentialSubject{
 				ID: "did:key:z2dmzD81cgPx8Vki7JbuuMmFYrWPgYoytykUZ3eyqht1j9KbrvQgsKodq2xnfBMYGk99qtunHHQuvvi35kRvbH9SDnue2ZNJqcnaU7yAxeKqEqDX4qFzeKYCj6rdbFnTsf4c8QjFXcgGYS21Db9d2FhHxw9ZEnqt9KPgLsLbQHVAmNNZoz",
 				Section1: models.Section1{
@@ -414,8 +543,8 @@ func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential) *model
 			},
 			IssuanceDate:   "<timestamp-ebsi>",
 			Issued:         "<timestamp-ebsi>",
-			ValidFrom:      "<timestamp-ebsi>",
-			ExpirationDate: "<timestamp-ebsi-in:365d>",
+			ValidFrom:      dates.MappedValidFrom,
+			ExpirationDate: dates.MappedExpirationDate,
 			CredentialSchema: models.CredentialSchema{
 				ID:   "https://api-conformance.ebsi.eu/trusted-schemas-registry/v3/schemas/z5qB8tydkn3Xk3VXb15SJ9dAWW6wky1YEoVdGzudWzhcW",
 				Type: "FullJsonSchemaValidator2021",
@@ -427,26 +556,22 @@ func (h *Handler) buildCredentialRequest(farmer *models.FarmerCredential) *model
 }
 
 // buildFarmerCredentialRequest builds the farmer credential request
-func (h *Handler) buildFarmerCredentialRequest(farmer *models.SimpleFarmerCredential) *models.SimpleFarmerCredentialRequest {
+func (h *Handler) buildFarmerCredentialRequest(farmer *models.SimpleFarmerCredential, period validity.Period) *models.SimpleFarmerCredentialRequest {
+	dates := newValidityDates(period, "timestamp")
+
 	return &models.SimpleFarmerCredentialRequest{
 		IssuerKey: models.FarmerIssuerKey{
 			Type: "jwk",
-			JWK: models.FarmerJWK{
-				Kty: "OKP",
-				D:   "uX8gZ8UPrWQhzOFaA5gmmkfOiIGCE7w1zbRUh9v6xb8",
-				Crv: "Ed25519",
-				Kid: "ynzK6u55SjO6hFEsW0kBKon_bpvpf5zrr-Q3FNHeAVE",
-				X:   "e3CE1EOpYtE_6UyIN58UJwWmGGesV3kZHMVZABIQI3M",
-			},
+			JWK:  farmerIssuerJWK,
 		},
-		IssuerDid:                 "did:jwk:eyJrdHkiOiJPS1AiLCJjcnYiOiJFZDI1NTE5Iiwia2lkIjoieW56SzZ1NTVTak82aEZFc1cwa0JLb25fYnB2cGY1enJyLVEzRk5IZUFWRSIsIngiOiJlM0NFMUVPcFl0RV82VXlJTjU4VUp3V21HR2VzVjNrWkhNVlpBQklRSTNNIn0",
+		IssuerDid:                 farmerIssuerDID,
 		CredentialConfigurationID: "FarmerCredential_jwt_vc_json",
 		CredentialData: models.SimpleFarmerCredentialData{
 			Context: []string{"https://www.w3.org/2018/credentials/v1"},
 			ID:      "urn:uuid:{{$uuid}}",
 			Type:    []string{"VerifiableCredential", "FarmerCredential"},
 			Issuer: models.FarmerIssuer{
-				ID:   "did:jwk:eyJrdHkiOiJPS1AiLCJjcnYiOiJFZDI1NTE5Iiwia2lkIjoieW56SzZ1NTVTak82aEZFc1cwa0JLb25fYnB2cGY1enJyLVEzRk5IZUFWRSIsIngiOiJlM0NFMUVPcFl0RV82VXlJTjU4VUp3V21HR2VzVjNrWkhNVlpBQklRSTNNIn0",
+				ID:   farmerIssuerDID,
 				Name: "Testa Gava",
 			},
 			CredentialSubject: models.SimpleFarmerCredentialSubject{
@@ -456,12 +581,16 @@ func (h *Handler) buildFarmerCredentialRequest(farmer *models.SimpleFarmerCreden
 				FarmType:   farmer.FarmType,
 				LicenseNo:  farmer.LicenseNo,
 				Region:     farmer.Region,
+				County:     farmer.County,
+				SubCounty:  farmer.SubCounty,
 			},
+			ValidFrom:      dates.ValidFrom,
+			ExpirationDate: dates.ExpirationDate,
 		},
 		Mapping: models.SimpleFarmerMapping{
 			ID:             "<uuid>",
 			IssuanceDate:   "<timestamp>",
-			ExpirationDate: "<timestamp-in:365d>",
+			ExpirationDate: dates.MappedExpirationDate,
 		},
 	}
 }
@@ -540,8 +669,44 @@ func (h *Handler) createSDSection(fields map[string]bool) models.SDSection {
 	}
 }
 
-// renderSuccess renders the success message with the credential link
-func (h *Handler) renderSuccess(w http.ResponseWriter, credentialLink, name, credentialType string) {
+// renderSuccess renders the success message with the credential link. When
+// the offer is PIN protected the PIN is shown in its own panel, apart from the
+// link and QR code, so it can be read out to the farmer rather than shared.
+func (h *Handler) renderSuccess(ctx context.Context, w http.ResponseWriter, credentialLink, name, credentialType, pin string) {
+	ctx, span := tracing.Start(ctx, "render result")
+	defer span.End()
+
+	qrHTML := ""
+	if png, err := qrcode.Encode(credentialLink, qrcode.Medium, 256); err != nil {
+		slog.ErrorContext(ctx, "Error generating offer QR code", "error", err)
+	} else {
+		qrHTML = fmt.Sprintf(`
+			<div class="qr-container">
+				<img src="data:image/png;base64,%s" alt="Credential offer QR code" width="256" height="256">
+			</div>`, base64.StdEncoding.EncodeToString(png))
+	}
+
+	pinHTML := ""
+	steps := `
+					<li>Copy the credential link above or scan the QR code</li>
+					<li>Open your digital wallet app (e.g., Walt.id's Wallet)</li>
+					<li>Paste the link to import your credential</li>
+					<li>Your digital ID is now ready to use!</li>`
+	if pin != "" {
+		pinHTML = fmt.Sprintf(`
+			<div class="pin-box">
+				<label>Transaction PIN</label>
+				<div class="pin-value">%s</div>
+				<p>Give this PIN to the farmer in person or by phone. Do not send it together with the link.
+				It is shown only once and is required to claim the credential.</p>
+			</div>`, template.HTMLEscapeString(pin))
+		steps = `
+					<li>Share the credential link or QR code with the farmer</li>
+					<li>Tell the farmer the PIN separately</li>
+					<li>The farmer opens the offer in their wallet and enters the PIN when prompted</li>
+					<li>Their digital ID is now ready to use!</li>`
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	html := fmt.Sprintf(`
 		<div id="result" class="success-message">
@@ -558,19 +723,18 @@ func (h *Handler) renderSuccess(w http.ResponseWriter, credentialLink, name, cre
 					<button onclick="copyToClipboard()" class="copy-btn">Copy Link</button>
 				</div>
 			</div>
+			%s
+			%s
 			
 			<div class="instructions">
 				<h4>Next Steps:</h4>
-				<ol>
-					<li>Copy the credential link above</li>
-					<li>Open your digital wallet app (e.g., Walt.id's Wallet)</li>
-					<li>Paste the link to import your credential</li>
-					<li>Your digital ID is now ready to use!</li>
+				<ol>%s
 				</ol>
 			</div>
 			
 			<button onclick="location.href='/'" class="btn-secondary">Issue Another Credential</button>
 		</div>
+		%s
 
 		<script>
 		function copyToClipboard() {
@@ -590,12 +754,26 @@ func (h *Handler) renderSuccess(w http.ResponseWriter, credentialLink, name, cre
 			}, 2000);
 		}
 		</script>
-	`, credentialType, name, credentialLink)
+	`, template.HTMLEscapeString(credentialType), template.HTMLEscapeString(name), template.HTMLEscapeString(credentialLink),
+		qrHTML, pinHTML, steps, nextIdempotencyKeyInput())
 
 	w.Write([]byte(html))
 }
 
-// renderError renders an error message
+// renderIssuanceError renders an issuance failure. While walt.id is busy or
+// unavailable the response is 503 with Retry-After, which htmx still swaps
+// in.
+func (h *Handler) renderIssuanceError(w http.ResponseWriter, err error) {
+	if wait := h.waltID.RetryAfter(err); wait > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	h.renderError(w, issuanceErrorMessage(err))
+}
+
+// renderError renders an error message. The message is plain text and is
+// escaped, as it may echo form input.
 func (h *Handler) renderError(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "text/html")
 	html := fmt.Sprintf(`
@@ -605,7 +783,7 @@ func (h *Handler) renderError(w http.ResponseWriter, message string) {
 			<p>%s</p>
 			<button onclick="location.href='/'" class="btn-secondary">Try Again</button>
 		</div>
-	`, message)
+	`, template.HTMLEscapeString(message))
 
 	w.Write([]byte(html))
 }
\ No newline at end of file
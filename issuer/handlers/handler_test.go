@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/waltid"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// redirectTransport sends every request to target, whatever its URL, so
+// that handlers posting to the configured walt.id hosts reach a test server
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestHandler returns a handler with an empty store whose walt.id calls
+// are answered by waltID
+func newTestHandler(t *testing.T, waltID http.HandlerFunc) *Handler {
+	t.Helper()
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(waltID)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+
+	cfg := waltid.Config{MaxConcurrent: 2, QueueWait: time.Second, Timeout: 5 * time.Second, Attempts: 1, FailureThreshold: 100, OpenFor: time.Minute}
+	return &Handler{
+		Store:     st,
+		Templates: template.Must(template.ParseGlob("../templates/*.html")),
+		PublicURL: "https://issuer.example",
+		waltID:    waltid.New(cfg, redirectTransport{target: target}),
+	}
+}
+
+// offerServer answers every walt.id call with a credential offer link and
+// counts the calls
+func offerServer(calls *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("openid-credential-offer://?credential_offer_uri=https%3A%2F%2Fwaltid.example%2Foffer"))
+	}
+}
+
+// farmerJSON is a valid farmer API request body
+const farmerJSON = `{"given_name":"Amina","family_name":"Otieno","farm_name":"Otieno Dairy","farm_type":"Dairy",` +
+	`"license_no":"KDB-0042","county":"Mombasa","sub_county":"Nyali","require_pin":true}`
+
+// postJSON calls an API handler as username with a JSON body
+func postJSON(handler http.HandlerFunc, path, username, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r = r.WithContext(auth.WithUser(r.Context(), &auth.User{Username: username, Roles: []string{auth.RoleClerk}}))
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestRenderSuccessEscapes(t *testing.T) {
+	h := newTestHandler(t, nil)
+	const script = `<script>alert(1)</script>`
+
+	tests := []struct {
+		name   string
+		render func(w http.ResponseWriter)
+	}{
+		{
+			name: "success",
+			render: func(w http.ResponseWriter) {
+				h.renderSuccess(context.Background(), w, "openid-credential-offer://?x="+script, "Amina "+script, "Farmer"+script, "12"+script)
+			},
+		},
+		{
+			name: "replay",
+			render: func(w http.ResponseWriter) {
+				iss := &store.Issuance{SubjectName: "Amina " + script, CredentialType: "Farmer" + script, OfferURL: "openid-credential-offer://?x=" + script}
+				h.renderReplay(w, httptest.NewRequest(http.MethodPost, "/issue", nil), iss)
+			},
+		},
+		{
+			name: "submitted",
+			render: func(w http.ResponseWriter) {
+				iss := &store.Issuance{ID: "abc", SubjectName: "Amina " + script, CredentialType: "Farmer" + script, Approval: "KDB officer" + script}
+				h.renderSubmitted(w, httptest.NewRequest(http.MethodPost, "/issue", nil), iss)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.render(w)
+			body := w.Body.String()
+			if strings.Contains(body, script) || !strings.Contains(body, template.HTMLEscapeString(script)) {
+				t.Errorf("body = %s, want the script escaped", body)
+			}
+		})
+	}
+}
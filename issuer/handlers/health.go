@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/adammwaniki/testa-walt/common/server"
+)
+
+// defaultWalletURL is the walt.id wallet API farmers claim offers with
+const defaultWalletURL = "http://139.59.15.151:7001"
+
+// HealthChecks returns the dependencies reported by /healthz and /readyz:
+// the walt.id issuer and wallet APIs, the page templates, the data
+// directory and the issuer keys
+func (h *Handler) HealthChecks() []server.Check {
+	walletURL := os.Getenv("WALTID_WALLET_URL")
+	if walletURL == "" {
+		walletURL = defaultWalletURL
+	}
+
+	checks := []server.Check{
+		{Name: "waltid_issuer_api", Run: server.HTTPCheck(baseURL(h.WaltIDURL))},
+		{Name: "waltid_wallet_api", Run: server.HTTPCheck(walletURL)},
+		{Name: "templates", Local: true, Run: server.TemplatesCheck("templates/*.html")},
+		{Name: "storage", Local: true, Run: server.DirCheck(DataDir())},
+		{Name: "issuer_keys", Local: true, Run: checkIssuerKeys},
+	}
+	// Farmer credentials are issued through their own endpoint, which may be
+	// another walt.id instance
+	if farmerBase := baseURL(FarmerWaltIDURL); farmerBase != baseURL(h.WaltIDURL) {
+		checks = append(checks, server.Check{Name: "waltid_farmer_issuer_api", Run: server.HTTPCheck(farmerBase)})
+	}
+	return checks
+}
+
+// baseURL returns the scheme and host of rawURL
+func baseURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// checkIssuerKeys checks that each issuer key's private part matches its
+// public part, so that a mistyped key is caught before walt.id rejects every
+// credential signed with it
+func checkIssuerKeys(context.Context) error {
+	return errors.Join(
+		checkP256JWK("PDA1 issuer key", pda1IssuerJWK.D, pda1IssuerJWK.X, pda1IssuerJWK.Y),
+		checkEd25519JWK("farmer issuer key", farmerIssuerJWK.D, farmerIssuerJWK.X),
+	)
+}
+
+func checkP256JWK(name, d, x, y string) error {
+	priv, err := decodeJWKField(d)
+	if err != nil {
+		return fmt.Errorf("%s: d: %w", name, err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(priv)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	xb, errX := decodeJWKField(x)
+	yb, errY := decodeJWKField(y)
+	if err := errors.Join(errX, errY); err != nil {
+		return fmt.Errorf("%s: public key: %w", name, err)
+	}
+	// An uncompressed P-256 point is 0x04 followed by X and Y
+	if !bytes.Equal(key.PublicKey().Bytes(), append(append([]byte{4}, xb...), yb...)) {
+		return fmt.Errorf("%s: public key does not match the private key", name)
+	}
+	return nil
+}
+
+func checkEd25519JWK(name, d, x string) error {
+	seed, err := decodeJWKField(d)
+	if err != nil {
+		return fmt.Errorf("%s: d: %w", name, err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("%s: d is %d bytes, want %d", name, len(seed), ed25519.SeedSize)
+	}
+	pub, err := decodeJWKField(x)
+	if err != nil {
+		return fmt.Errorf("%s: x: %w", name, err)
+	}
+	if !bytes.Equal(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey), pub) {
+		return fmt.Errorf("%s: public key does not match the private key", name)
+	}
+	return nil
+}
+
+func decodeJWKField(v string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(v)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// Idempotency keys let a client retry an issuance request safely: a repeat
+// with the same key, such as a double-clicked submit or a mobile request
+// retried after a timeout, is answered with the original result instead of
+// issuing a second credential. API clients send the key in the
+// Idempotency-Key header; the issuance forms carry it in a hidden field.
+const (
+	IdempotencyKeyHeader  = "Idempotency-Key"
+	idempotencyKeyField   = "idempotency_key"
+	maxIdempotencyKeySize = 255
+
+	// idempotentReplayedHeader marks a response answered from an earlier
+	// request with the same key
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// errInvalidIdempotencyKey is returned for a key that is too long or holds
+// characters other than printable ASCII
+var errInvalidIdempotencyKey = fmt.Errorf("Idempotency-Key must be 1 to %d printable ASCII characters", maxIdempotencyKeySize)
+
+// idempotencyClaim is the state of an issuance request's idempotency key
+type idempotencyClaim struct {
+	// Previous is the issuance an earlier request with the key created. The
+	// request is answered from it.
+	Previous *store.Issuance
+
+	finish func(issuanceID string) error
+}
+
+// Finish records the issuance the request created, or releases the key for
+// a retry when issuanceID is empty. It does nothing for requests without a
+// key.
+func (c *idempotencyClaim) Finish(r *http.Request, issuanceID string) {
+	if c.finish == nil {
+		return
+	}
+	if err := c.finish(issuanceID); err != nil {
+		slog.ErrorContext(r.Context(), "Error recording idempotency key", "issuance_id", issuanceID, "error", err)
+	}
+}
+
+// claimIdempotencyKey looks up the request's idempotency key, if it has one.
+// request is what the key must keep meaning: the decoded API body or the
+// submitted form. Keys belong to the signed-in user, so users cannot replay
+// each other's results. A request whose key is in use by another request
+// in progress waits for that one to finish.
+func (h *Handler) claimIdempotencyKey(r *http.Request, request any) (*idempotencyClaim, error) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" && r.PostForm != nil {
+		key = r.PostForm.Get(idempotencyKeyField)
+	}
+	if key == "" {
+		return &idempotencyClaim{}, nil
+	}
+	if !validIdempotencyKey(key) {
+		return nil, errInvalidIdempotencyKey
+	}
+
+	fingerprint, err := requestFingerprint(r.URL.Path, request)
+	if err != nil {
+		return nil, err
+	}
+
+	prev, finish, err := h.Store.ClaimIdempotencyKey(r.Context(), currentUsername(r), key, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		slog.InfoContext(r.Context(), "Replaying issuance for idempotency key", "issuance_id", prev.ID)
+	}
+	return &idempotencyClaim{Previous: prev, finish: finish}, nil
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint hashes the path and the request it carries
+func requestFingerprint(path string, request any) (string, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(path+"\n"), raw...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// formRequest returns the submitted form fields that describe the request,
+// leaving out the CSRF token and the idempotency key itself
+func formRequest(r *http.Request) url.Values {
+	form := url.Values{}
+	for name, values := range r.PostForm {
+		if name != "csrf_token" && name != idempotencyKeyField {
+			form[name] = values
+		}
+	}
+	return form
+}
+
+// writeIdempotencyError answers an API request whose key could not be used
+func (h *Handler) writeIdempotencyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidIdempotencyKey):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, store.ErrKeyReused):
+		writeAPIError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	case r.Context().Err() != nil:
+		// The client gave up while an earlier request with the key ran
+	default:
+		slog.ErrorContext(r.Context(), "Error checking idempotency key", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to check the idempotency key")
+	}
+}
+
+// renderIdempotencyError answers a form submission whose key could not be
+// used
+func (h *Handler) renderIdempotencyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidIdempotencyKey):
+		h.renderError(w, "This form is out of date. Reload the page and try again.")
+	case errors.Is(err, store.ErrKeyReused):
+		h.renderError(w, "This form was already submitted with different details. Reload the page to issue another credential.")
+	case r.Context().Err() != nil:
+	default:
+		slog.ErrorContext(r.Context(), "Error checking idempotency key", "error", err)
+		h.renderError(w, "Failed to check whether this form was already submitted")
+	}
+}
+
+// writeAPIReplay answers an API request with the result of the earlier
+// request that used its key: the offer, or the claim page of an
+// application, as it stands now. The PIN of a PIN-protected offer was only in
+// the first response and is never stored, so a replay leaves it out.
+func (h *Handler) writeAPIReplay(w http.ResponseWriter, r *http.Request, iss *store.Issuance) {
+	w.Header().Set(idempotentReplayedHeader, "true")
+	if iss.Approval != "" {
+		writeAPIJSON(w, http.StatusAccepted, IssuanceResponse{
+			IssuanceID: iss.ID,
+			Status:     iss.Status,
+			ClaimURL:   fmt.Sprintf("%s/offers/%s", requestBaseURL(r), iss.ID),
+			Approval:   iss.Approval,
+			Supersedes: iss.Supersedes,
+		})
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, IssuanceResponse{
+		IssuanceID: iss.ID,
+		Status:     iss.Status,
+		OfferURL:   iss.OfferURL,
+		PINShown:   iss.PIN != nil,
+		Supersedes: iss.Supersedes,
+	})
+}
+
+// renderReplay answers a resubmitted form with the result of the first
+// submission. The PIN of a PIN-protected offer is never shown again.
+func (h *Handler) renderReplay(w http.ResponseWriter, r *http.Request, iss *store.Issuance) {
+	w.Header().Set(idempotentReplayedHeader, "true")
+	switch {
+	case iss.Approval != "":
+		h.renderSubmitted(w, r, iss)
+	case iss.PIN != nil:
+		h.renderError(w, "This credential was already issued. Its PIN was shown when the form was first submitted and cannot be shown again.")
+	default:
+		h.renderSuccess(r.Context(), w, iss.OfferURL, iss.SubjectName, iss.CredentialType, "")
+	}
+}
+
+// nextIdempotencyKeyInput returns a fresh hidden key field for the form,
+// swapped in out of band by htmx after a result, so that the next credential
+// issued from the same page is a new request
+func nextIdempotencyKeyInput() string {
+	return fmt.Sprintf(`<input type="hidden" id="%s" name="%s" value="%s" hx-swap-oob="true">`,
+		idempotencyKeyField, idempotencyKeyField, store.NewID())
+}
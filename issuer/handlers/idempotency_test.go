@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplayLeavesOutPIN(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestHandler(t, offerServer(&calls))
+	header := http.Header{IdempotencyKeyHeader: {"7c9e6679-7425-40de-944b-e07fc1f90ae7"}}
+
+	issue := func() IssuanceResponse {
+		t.Helper()
+		w := postJSON(h.APIIssueFarmer, "/api/v1/credentials/farmer", "clerk", farmerJSON, header)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status = %d %s, want 201", w.Code, w.Body)
+		}
+		var resp IssuanceResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := issue()
+	if len(first.PIN) != PINLength || first.PINShown {
+		t.Errorf("first response PIN %q, pinShown %v, want the PIN", first.PIN, first.PINShown)
+	}
+	replay := issue()
+	if replay.PIN != "" || !replay.PINShown || replay.IssuanceID != first.IssuanceID {
+		t.Errorf("replay = %+v, want issuance %s without its PIN", replay, first.IssuanceID)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("walt.id calls = %d, want 1", calls.Load())
+	}
+
+	iss, err := h.Store.Issuance(first.IssuanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iss.PIN == nil || iss.PIN.Length != PINLength {
+		t.Errorf("stored PIN state = %+v, want a %d-digit PIN recorded", iss.PIN, PINLength)
+	}
+}
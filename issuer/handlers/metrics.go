@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The issuer's own metrics; HTTP traffic is recorded by metrics.Middleware
+var (
+	// issuances counts walt.id offer requests
+	issuances = metrics.NewOutcomes(prometheus.CounterOpts{
+		Namespace: "issuer",
+		Name:      "credential_issuances_total",
+		Help:      "Credential offer requests to walt.id, by credential type and outcome.",
+	}, store.CredentialPDA1, store.CredentialFarmer)
+
+	// waltIDMetrics records the calls of the walt.id client
+	waltIDMetrics = metrics.NewWaltID("issuer", "issuance")
+)
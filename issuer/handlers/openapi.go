@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adammwaniki/testa-walt/bulk"
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/openapi"
+)
+
+// APIVersion is the version of the JSON API in its OpenAPI document
+const APIVersion = "1.0.0"
+
+// IsAPIPath reports whether path belongs to the JSON API described by
+// OpenAPI. The HTML pages and HTMX fragments are not part of it.
+func IsAPIPath(path string) bool {
+	return strings.HasPrefix(path, "/api/")
+}
+
+// OpenAPI describes the JSON issuance API
+func OpenAPI() *openapi.Document {
+	doc := openapi.New("Testa issuer API", APIVersion,
+		"Issues PDA1 and farmer credentials through walt.id for the mobile field app. "+
+			"Clients are registered with `issuer clients add` and authenticate with an X-API-Key header "+
+			"or a bearer token from "+APITokenPath+".")
+
+	doc.Components.SecuritySchemes["apiKey"] = openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "X-API-Key",
+		Description: "Client ID and secret joined by a dot: id.secret",
+	}
+	doc.Components.SecuritySchemes["oauth2"] = openapi.SecurityScheme{
+		Type: "oauth2",
+		Flows: &openapi.OAuthFlows{ClientCredentials: &openapi.OAuthFlow{
+			TokenURL: APITokenPath,
+			Scopes:   map[string]string{ScopeIssue: "Issue credentials and submit applications"},
+		}},
+	}
+	doc.Security = []openapi.SecurityRequirement{{"apiKey": {}}, {"oauth2": {ScopeIssue}}}
+	doc.Tags = []openapi.Tag{
+		{Name: "Issuance", Description: "Credential offers and maker-checker applications"},
+		{Name: "API access", Description: "Access tokens for API clients"},
+	}
+
+	pda1 := openapi.WithRequired(openapi.SchemaOf(PDA1APIRequest{}),
+		"personalIdentificationNumber", "sex", "surname", "forenames", "dateBirth")
+	pda1["properties"].(openapi.Schema)["comment"] = openapi.Schema{
+		"type": "string", "description": "Note for the reviewer when the application needs approval",
+	}
+	for _, field := range []string{"startingDate", "endingDate"} {
+		pda1["properties"].(openapi.Schema)[field] = openapi.Schema{"type": "string", "format": "date",
+			"description": "Section 2 period, YYYY-MM-DD. Under the default PDA1_VALIDITY=form policy it is also the credential's validity."}
+	}
+	doc.Components.Schemas["PDA1Request"] = pda1
+
+	farmer := openapi.WithRequired(openapi.SchemaOf(FarmerAPIRequest{}),
+		"given_name", "family_name", "farm_name", "farm_type", "license_no", "county")
+	props := farmer["properties"].(openapi.Schema)
+	props["farm_type"] = openapi.Schema{"type": "string", "enum": bulk.FarmTypes,
+		"description": "Matched case-insensitively"}
+	props["require_pin"] = openapi.Schema{"type": "boolean",
+		"description": "Protect the offer with a PIN the wallet must prompt for"}
+	props["defer_approval"] = openapi.Schema{"type": "boolean",
+		"description": "Submit for approval even when maker-checker is off"}
+	props["comment"] = openapi.Schema{"type": "string",
+		"description": "Note for the reviewer when the application needs approval"}
+	props["phone"] = openapi.Schema{"type": "string",
+		"description": "Mobile number for expiry reminders. Kenyan numbers such as 0712 345678 are stored as +254712345678; others need a country code."}
+	props["email"] = openapi.Schema{"type": "string", "format": "email",
+		"description": "Email address for expiry reminders"}
+	props["supersedes"] = openapi.Schema{"type": "string",
+		"description": "ID of an offered farmer credential this one updates. The old credential is revoked through the status list once the new one is offered."}
+	doc.Components.Schemas["FarmerRequest"] = farmer
+
+	issuance := doc.AddSchema("IssuanceResponse", IssuanceResponse{})
+	doc.AddSchema("Error", APIError{})
+
+	// A retried request with the same Idempotency-Key gets the first result
+	idempotencyKey := openapi.Parameter{
+		Name: IdempotencyKeyHeader, In: "header",
+		Description: "Unique key for this issuance, up to " + strconv.Itoa(maxIdempotencyKeySize) + " printable ASCII characters. " +
+			"A request repeated with the same key within 24 hours returns the original result instead of issuing again. " +
+			"The PIN of a PIN-protected offer is only in the first response; a replay sets pinShown instead.",
+		Schema: openapi.Schema{"type": "string", "maxLength": maxIdempotencyKeySize},
+	}
+	replayed := map[string]openapi.Header{
+		idempotentReplayedHeader: {Description: "true when the response repeats the result of an earlier request with the same Idempotency-Key",
+			Schema: openapi.Schema{"type": "string"}},
+	}
+
+	responses := func() map[string]openapi.Response {
+		return map[string]openapi.Response{
+			"201": {Description: "Credential offered", Headers: replayed, Content: openapi.JSON(issuance)},
+			"202": {Description: "Application recorded and waiting for approval", Headers: replayed, Content: openapi.JSON(issuance)},
+			"400": apiErrorResponse("Missing or invalid fields, validity period or Idempotency-Key"),
+			"401": apiErrorResponse("Missing or invalid API key or bearer token"),
+			"403": apiErrorResponse("The client's credential does not carry the issue scope"),
+			"413": apiErrorResponse("Request body larger than " + strconv.Itoa(maxAPIBodyBytes) + " bytes"),
+			"415": apiErrorResponse("Content-Type is not application/json"),
+			"422": apiErrorResponse("Idempotency-Key was already used for a different request"),
+			"429": retryAfterResponse("Rate limit exceeded"),
+			"502": apiErrorResponse("walt.id failed to create the offer"),
+			"503": retryAfterResponse("walt.id is saturated or unavailable"),
+		}
+	}
+
+	doc.Add(http.MethodPost, "/api/v1/credentials/pda1", &openapi.Operation{
+		OperationID: "issuePDA1",
+		Summary:     "Issue a PDA1 credential",
+		Tags:        []string{"Issuance"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.Ref("PDA1Request"))},
+		Responses:   responses(),
+	})
+	farmerResponses := responses()
+	farmerResponses["404"] = apiErrorResponse("The credential named in supersedes does not exist")
+	farmerResponses["409"] = apiErrorResponse("The credential named in supersedes is not offered or was already updated")
+	doc.Add(http.MethodPost, "/api/v1/credentials/farmer", &openapi.Operation{
+		OperationID: "issueFarmer",
+		Summary:     "Issue a farmer credential",
+		Description: "The county and sub-county are resolved against the Kenyan administrative geography. " +
+			"Set supersedes to update an earlier credential after the farm data changed.",
+		Tags:        []string{"Issuance"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(openapi.Ref("FarmerRequest"))},
+		Responses:   farmerResponses,
+	})
+
+	doc.AddSchema("OAuthError", oauthError{})
+	doc.Add(http.MethodPost, APITokenPath, &openapi.Operation{
+		OperationID: "token",
+		Summary:     "Get an access token (client credentials grant)",
+		Description: "Client credentials may be sent with HTTP Basic authentication or as client_id and client_secret form fields. " +
+			"Tokens last " + strconv.Itoa(int(apiauth.AccessTokenTTL.Minutes())) + " minutes.",
+		Tags:        []string{"API access"},
+		Security:    []openapi.SecurityRequirement{{}},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.Form(openapi.SchemaOf(tokenRequest{}))},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "Access token", Content: openapi.JSON(openapi.SchemaOf(tokenResponse{}))},
+			"400": {Description: "invalid_request, unsupported_grant_type or invalid_scope", Content: openapi.JSON(openapi.Ref("OAuthError"))},
+			"401": {Description: "invalid_client", Content: openapi.JSON(openapi.Ref("OAuthError"))},
+			"429": retryAfterResponse("Rate limit exceeded"),
+		},
+	})
+
+	return doc
+}
+
+// tokenRequest is the client credentials grant form
+type tokenRequest struct {
+	GrantType    string `json:"grant_type"`
+	Scope        string `json:"scope,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// tokenResponse is a granted access token
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+// oauthError is an RFC 6749 error response
+type oauthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func apiErrorResponse(description string) openapi.Response {
+	return openapi.Response{Description: description, Content: openapi.JSON(openapi.Ref("Error"))}
+}
+
+func retryAfterResponse(description string) openapi.Response {
+	resp := apiErrorResponse(description)
+	resp.Headers = map[string]openapi.Header{
+		"Retry-After": {Description: "Seconds to wait before retrying", Schema: openapi.Schema{"type": "integer"}},
+	}
+	return resp
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// The personal data masked in logged walt.id exchanges, named as in both the
+// form and the walt.id request
+func init() {
+	logging.RegisterPII(store.CredentialPDA1,
+		"personalIdentificationNumber", "sex", "surname", "forenames", "dateBirth", "nationalities",
+		"residenceStreetNo", "residencePostCode", "residenceTown",
+		"stayStreetNo", "stayPostCode", "stayTown",
+		"stateOfResidenceAddress", "stateOfStayAddress",
+		"email", "officePhoneNo", "officeFaxNo", "signature",
+	)
+	logging.RegisterPII(store.CredentialFarmer,
+		"given_name", "family_name", "license_no", "phone", "email",
+	)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/renewal"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/adammwaniki/testa-walt/validity"
+)
+
+// renewalSubmitter is recorded as the submitter of renewal applications
+const renewalSubmitter = "Renewal scheduler"
+
+// renewalView is a credential on the expiry dashboard
+type renewalView struct {
+	*store.Issuance
+	Expired bool
+	Days    int // until expiry, or since expiry when Expired
+	Renewal *store.Issuance
+}
+
+// renewIssuance creates the renewal of an expiring farmer credential: a new
+// offer with the same data, or an application for approval under
+// maker-checker. The renewal is valid from the old credential's expiry. It is
+// the renewal scheduler's RenewFunc. The PIN of a PIN-protected offer is not
+// kept: the office gives the holder one made with NewRenewalPIN.
+func (h *Handler) renewIssuance(ctx context.Context, iss *store.Issuance) (renewal.Renewal, error) {
+	if iss.CredentialType != store.CredentialFarmer {
+		return renewal.Renewal{}, renewal.ErrNotRenewable
+	}
+	var farmer models.SimpleFarmerCredential
+	if err := json.Unmarshal(iss.Subject, &farmer); err != nil {
+		return renewal.Renewal{}, fmt.Errorf("reading credential subject: %w", err)
+	}
+	requirePIN := iss.PIN != nil || iss.RequirePIN
+
+	if h.MakerChecker {
+		note := fmt.Sprintf("Renewal of %s, expiring %s", iss.ID, iss.ExpiresAt.Format("2 Jan 2006"))
+		app, err := h.newApplication(store.CredentialFarmer, iss.SubjectName, &farmer, h.approvalFor(farmer.FarmType), requirePIN, renewalSubmitter, note, "")
+		if err != nil {
+			return renewal.Renewal{}, err
+		}
+		return renewal.Renewal{ID: app.ID}, nil
+	}
+
+	_, _, id, err := h.issueFarmerOffer(ctx, &farmer, requirePIN, "", *iss.ExpiresAt)
+	if err != nil {
+		return renewal.Renewal{}, err
+	}
+	if id == "" {
+		return renewal.Renewal{}, fmt.Errorf("renewal offer for %s was not recorded", iss.ID)
+	}
+	return renewal.Renewal{ID: id}, nil
+}
+
+// errNoRenewalPIN is returned for renewals whose PIN the office cannot
+// replace: superseded or unprotected offers, and applications approved
+// under maker-checker, whose approver gave the PIN out
+var errNoRenewalPIN = errors.New("renewal has no PIN to replace")
+
+// NewRenewalPIN handles POST /renewals/{id}/pin
+// Replaces the offer of an unclaimed PIN-protected renewal with one under a
+// new PIN and shows the PIN once, for the clerk to give the holder who got
+// the reminder. The claim page then shows the new offer only.
+func (h *Handler) NewRenewalPIN(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	pin, err := h.reofferRenewal(r.Context(), id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		h.renderError(w, "Renewal not found")
+		return
+	case errors.Is(err, errNoRenewalPIN), errors.Is(err, store.ErrConflict):
+		h.renderError(w, "This renewal is no longer offered, or has no PIN for the office to give out")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error replacing renewal offer", "issuance_id", id, "error", err)
+		h.renderError(w, issuanceErrorMessage(err))
+		return
+	}
+	slog.InfoContext(r.Context(), "Renewal PIN replaced", "issuance_id", id, "by", currentUsername(r))
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `
+		<div id="result" class="success-message">
+			<div class="pin-box">
+				<label>Transaction PIN</label>
+				<div class="pin-value">%s</div>
+				<p>Give this PIN to the farmer in person or by phone. It is shown only once and
+				replaces any PIN given out for this renewal before.</p>
+			</div>
+		</div>
+	`, template.HTMLEscapeString(pin))
+}
+
+// reofferRenewal replaces the offer of an unclaimed PIN-protected renewal
+// with one under a new PIN and returns the PIN
+func (h *Handler) reofferRenewal(ctx context.Context, id string) (string, error) {
+	iss, err := h.Store.Issuance(id)
+	if err != nil {
+		return "", err
+	}
+	if iss.CredentialType != store.CredentialFarmer || iss.Status != store.StatusOffered ||
+		iss.PIN == nil || iss.Review != nil || iss.ExpiresAt == nil {
+		return "", errNoRenewalPIN
+	}
+	var farmer models.SimpleFarmerCredential
+	if err := json.Unmarshal(iss.Subject, &farmer); err != nil {
+		return "", fmt.Errorf("reading credential subject: %w", err)
+	}
+
+	// The new offer keeps the renewal's status list bit and validity
+	period := validity.Period{Until: *iss.ExpiresAt}
+	if iss.ValidFrom != nil {
+		period.From = *iss.ValidFrom
+	}
+	offerURL, pin, pinState, err := h.requestFarmerOffer(ctx, &farmer, true, iss.StatusListIndex, period)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = h.Store.UpdateIssuance(id, func(iss *store.Issuance) error {
+		if iss.Status != store.StatusOffered {
+			return store.ErrConflict
+		}
+		iss.OfferURL = offerURL
+		iss.PIN = pinState
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Renewal offered again with a new PIN", "issuance_id", id)
+	return pin, nil
+}
+
+// ShowRenewals handles GET /renewals
+// The dashboard of credentials expiring within the renewal window, and of
+// those that expired within the same period before now
+func (h *Handler) ShowRenewals(w http.ResponseWriter, r *http.Request) {
+	h.renderRenewals(w, r, nil)
+}
+
+// RunRenewals handles POST /renewals/run
+// Runs the renewal scheduler now and shows the dashboard with its summary
+func (h *Handler) RunRenewals(w http.ResponseWriter, r *http.Request) {
+	summary := h.Renewals.Run(context.WithoutCancel(r.Context()))
+	slog.InfoContext(r.Context(), "Renewal run started from the dashboard", "by", currentUsername(r))
+	h.renderRenewals(w, r, &summary)
+}
+
+// renderRenewals renders the expiry dashboard
+func (h *Handler) renderRenewals(w http.ResponseWriter, r *http.Request, summary *renewal.Summary) {
+	cfg := h.Renewals.Config()
+	now := time.Now()
+
+	var upcoming []renewalView
+	for _, iss := range h.Store.Expiring(now.Add(-cfg.Window), now.Add(cfg.Window)) {
+		left := iss.ExpiresAt.Sub(now)
+		view := renewalView{Issuance: iss, Expired: left < 0, Days: int(left.Abs().Hours() / 24)}
+		if iss.RenewedBy != "" {
+			if renewed, err := h.Store.Issuance(iss.RenewedBy); err == nil {
+				view.Renewal = renewed
+			}
+		}
+		upcoming = append(upcoming, view)
+	}
+
+	lastRun, last := h.Renewals.LastRun()
+	data := map[string]any{
+		"Upcoming":   upcoming,
+		"WindowDays": int(cfg.Window.Hours() / 24),
+		"Scheduled":  cfg.Interval > 0,
+		"Interval":   cfg.Interval.String(),
+		"Channels":   h.Renewals.Channels(),
+		"LastRun":    lastRun,
+		"Last":       last,
+		"Summary":    summary,
+		"CanRun":     false,
+	}
+	if u, ok := auth.UserFrom(r.Context()); ok {
+		data["CanRun"] = u.HasRole(auth.RoleSupervisor)
+	}
+	if err := h.Templates.ExecuteTemplate(w, "renewals.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering renewals", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// resolveContact checks the farmer's optional phone number and email
+// address and normalises Kenyan mobile numbers to international format for
+// SMS gateways, e.g. 0712 345678 becomes +254712345678
+func resolveContact(farmer *models.SimpleFarmerCredential) error {
+	if phone := strings.TrimSpace(farmer.Phone); phone != "" {
+		digits := strings.Map(func(r rune) rune {
+			if r == ' ' || r == '-' || r == '(' || r == ')' {
+				return -1
+			}
+			return r
+		}, phone)
+		international := strings.HasPrefix(digits, "+")
+		digits = strings.TrimPrefix(digits, "+")
+		if strings.Trim(digits, "0123456789") != "" || len(digits) < 9 || len(digits) > 15 {
+			return errors.New("phone is not a valid phone number")
+		}
+		switch {
+		case international:
+		case strings.HasPrefix(digits, "0") && len(digits) == 10:
+			digits = "254" + digits[1:]
+		case strings.HasPrefix(digits, "254"):
+		default:
+			return errors.New("phone needs a country code, e.g. +254")
+		}
+		farmer.Phone = "+" + digits
+	}
+
+	if email := strings.TrimSpace(farmer.Email); email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil || addr.Address != email {
+			return errors.New("email is not a valid email address")
+		}
+		farmer.Email = email
+	}
+	return nil
+}
+
+// resolveFarmer validates a bulk upload row's location and contact details
+func resolveFarmer(farmer *models.SimpleFarmerCredential) error {
+	if err := resolveLocation(farmer); err != nil {
+		return err
+	}
+	return resolveContact(farmer)
+}
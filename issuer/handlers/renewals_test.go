@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+func TestResolveContact(t *testing.T) {
+	tests := []struct {
+		name      string
+		phone     string
+		email     string
+		wantPhone string
+		wantEmail string
+		wantErr   bool
+	}{
+		{name: "no contact"},
+		{name: "local number", phone: "0712 345678", wantPhone: "+254712345678"},
+		{name: "country code without plus", phone: "254712345678", wantPhone: "+254712345678"},
+		{name: "international number", phone: "+255 (754) 123-456", wantPhone: "+255754123456"},
+		{name: "no country code", phone: "712345678", wantErr: true},
+		{name: "letters", phone: "0712 34567x", wantErr: true},
+		{name: "too short", phone: "+25471", wantErr: true},
+		{name: "too long", phone: "+2547123456789012", wantErr: true},
+		{name: "email", email: " amina@example.org ", wantEmail: "amina@example.org"},
+		{name: "named email", email: "Amina <amina@example.org>", wantErr: true},
+		{name: "not an email", email: "amina.example.org", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			farmer := &models.SimpleFarmerCredential{Phone: tt.phone, Email: tt.email}
+			err := resolveContact(farmer)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("resolveContact = %q, %q, want an error", farmer.Phone, farmer.Email)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveContact: %v", err)
+			}
+			if farmer.Phone != tt.wantPhone || farmer.Email != tt.wantEmail {
+				t.Errorf("resolveContact = %q, %q, want %q, %q", farmer.Phone, farmer.Email, tt.wantPhone, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestNewRenewalPIN(t *testing.T) {
+	var calls atomic.Int32
+	h := newTestHandler(t, offerServer(&calls))
+
+	expires := time.Now().Add(365 * 24 * time.Hour)
+	save := func(status string, pin *store.PINState, review *store.Review) string {
+		iss := &store.Issuance{
+			CredentialType: store.CredentialFarmer,
+			SubjectName:    "Amina Otieno",
+			Subject:        []byte(`{"given_name":"Amina","family_name":"Otieno","farm_type":"dairy"}`),
+			OfferURL:       "openid-credential-offer://first",
+			Status:         status,
+			PIN:            pin,
+			Review:         review,
+			ExpiresAt:      &expires,
+		}
+		if err := h.Store.SaveIssuance(iss); err != nil {
+			t.Fatal(err)
+		}
+		return iss.ID
+	}
+
+	tests := []struct {
+		name      string
+		id        string
+		wantPIN   bool
+		wantError string
+	}{
+		{name: "unclaimed PIN-protected renewal", id: save(store.StatusOffered, &store.PINState{Length: PINLength}, nil), wantPIN: true},
+		{name: "superseded renewal", id: save(store.StatusSuperseded, &store.PINState{Length: PINLength}, nil), wantError: "no longer offered"},
+		{name: "no PIN", id: save(store.StatusOffered, nil, nil), wantError: "no longer offered"},
+		{name: "approved application", id: save(store.StatusOffered, &store.PINState{Length: PINLength}, &store.Review{}), wantError: "no longer offered"},
+		{name: "unknown renewal", id: "missing", wantError: "Renewal not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls.Load()
+			r := httptest.NewRequest(http.MethodPost, "/renewals/"+tt.id+"/pin", nil)
+			r.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			h.NewRenewalPIN(w, r)
+
+			body := w.Body.String()
+			if tt.wantError != "" {
+				if !strings.Contains(body, tt.wantError) || calls.Load() != before {
+					t.Errorf("body = %q after %d walt.id calls, want %q and no call", body, calls.Load()-before, tt.wantError)
+				}
+				return
+			}
+			if !strings.Contains(body, "pin-value") || calls.Load() != before+1 {
+				t.Fatalf("body = %q after %d walt.id calls, want a PIN from one call", body, calls.Load()-before)
+			}
+			iss, err := h.Store.Issuance(tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iss.OfferURL == "openid-credential-offer://first" {
+				t.Error("claim page still shows the replaced offer")
+			}
+		})
+	}
+}
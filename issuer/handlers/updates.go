@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/geo"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/statuslist"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// FarmerStatusListPath is where the revocation status list of farmer
+// credentials is published
+const FarmerStatusListPath = "/status-lists/farmer"
+
+// errNotUpdatable is returned when an update targets a credential type that
+// cannot be updated
+var errNotUpdatable = errors.New("only farmer credentials can be updated")
+
+// farmTypes are the farm type options of the farmer form
+var farmTypes = []struct{ Value, Label string }{
+	{"Dairy", "Dairy"},
+	{"Poultry", "Poultry"},
+	{"Crop", "Crop/Arable"},
+	{"Mixed", "Mixed Farming"},
+	{"Horticulture", "Horticulture"},
+	{"Livestock", "Livestock"},
+	{"Aquaculture", "Aquaculture"},
+	{"Other", "Other"},
+}
+
+// issuanceView is an issuance as shown in the ledger, with the credentials
+// linked to it through updates
+type issuanceView struct {
+	*store.Issuance
+	History   []*store.Issuance
+	Updatable bool
+}
+
+// publicURL returns the externally reachable base URL of the issuer
+// (ISSUER_PUBLIC_URL). Status list links embedded in credentials use it, so
+// it must stay stable for as long as those credentials are in use.
+func publicURL() string {
+	if u := os.Getenv("ISSUER_PUBLIC_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8082"
+}
+
+// newStatusSigner returns the signer of the farmer status list, which uses
+// the farmer issuer key so verifiers can resolve it from the same DID
+func newStatusSigner() (*statuslist.Signer, error) {
+	seed, err := decodeJWKField(farmerIssuerJWK.D)
+	if err != nil {
+		return nil, fmt.Errorf("farmer issuer key: %w", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("farmer issuer key: d is %d bytes, want %d", len(seed), ed25519.SeedSize)
+	}
+	return &statuslist.Signer{
+		Issuer: farmerIssuerDID,
+		KeyID:  farmerIssuerDID + "#0",
+		Key:    ed25519.NewKeyFromSeed(seed),
+	}, nil
+}
+
+// farmerStatusListURL is the status list credential URL written into farmer
+// credentials
+func (h *Handler) farmerStatusListURL() string {
+	return h.PublicURL + FarmerStatusListPath
+}
+
+// FarmerStatusList handles GET /status-lists/farmer
+// Serves the signed revocation status list of farmer credentials, with the
+// bits of superseded credentials set
+func (h *Handler) FarmerStatusList(w http.ResponseWriter, r *http.Request) {
+	revoked := h.Store.RevokedStatusIndexes(store.CredentialFarmer)
+	jwt, err := h.statusSigner.Credential(h.farmerStatusListURL(), statuslist.PurposeRevocation, revoked, time.Now())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error signing status list", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vc+jwt")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Write([]byte(jwt))
+}
+
+// allocateStatusIndex reserves a status list bit for a new farmer credential
+func (h *Handler) allocateStatusIndex(ctx context.Context) (*int, error) {
+	index, err := h.Store.AllocateStatusIndex(statuslist.Size)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error allocating status list index", "error", err)
+		return nil, &IssuanceError{Message: "No status list entries are left for new credentials", Err: err}
+	}
+	return &index, nil
+}
+
+// updatableIssuance returns the issuance with the given ID if it can be
+// updated: an offered farmer credential that has not been superseded
+func (h *Handler) updatableIssuance(id string) (*store.Issuance, error) {
+	iss, err := h.Store.Issuance(id)
+	if err != nil {
+		return nil, err
+	}
+	if iss.CredentialType != store.CredentialFarmer {
+		return nil, errNotUpdatable
+	}
+	if iss.Status != store.StatusOffered || iss.SupersededBy != "" {
+		return nil, store.ErrConflict
+	}
+	return iss, nil
+}
+
+// supersede marks the old credential as replaced by the new one, setting its
+// status list bit. The new credential already exists at walt.id, so a
+// failure is logged rather than returned.
+func (h *Handler) supersede(ctx context.Context, oldID, newID string) {
+	if _, err := h.Store.Supersede(oldID, newID); err != nil {
+		slog.ErrorContext(ctx, "Error superseding issuance", "issuance_id", oldID, "superseded_by", newID, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Issuance superseded", "issuance_id", oldID, "superseded_by", newID)
+}
+
+// updateErrorStatus maps an updatableIssuance error to an HTTP status
+func updateErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotUpdatable):
+		return http.StatusBadRequest
+	case errors.Is(err, store.ErrConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// updateErrorMessage describes an updatableIssuance error to the user
+func updateErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return "The credential to update was not found"
+	case errors.Is(err, errNotUpdatable):
+		return "Only farmer credentials can be updated"
+	case errors.Is(err, store.ErrConflict):
+		return "Only an offered credential that has not been updated already can be updated"
+	default:
+		slog.Error("Error loading issuance for update", "error", err)
+		return "Failed to load the credential to update"
+	}
+}
+
+// ShowIssuances handles GET /issuances
+// Lists the issuance ledger, newest first, filtered by ?q= on the holder's
+// name, the issuance ID or the status
+func (h *Handler) ShowIssuances(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	needle := strings.ToLower(q)
+
+	var list []*store.Issuance
+	for _, iss := range h.Store.Issuances() {
+		if needle != "" &&
+			!strings.Contains(strings.ToLower(iss.SubjectName), needle) &&
+			!strings.HasPrefix(iss.ID, needle) &&
+			iss.Status != needle {
+			continue
+		}
+		list = append(list, iss)
+	}
+
+	err := h.Templates.ExecuteTemplate(w, "issuances.html", map[string]any{"Issuances": list, "Query": q})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering issuances", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ShowIssuance handles GET /issuances/{id}
+// Shows one issuance with the history of credentials linked by updates
+func (h *Handler) ShowIssuance(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	iss, err := h.Store.Issuance(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	history, err := h.Store.History(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	_, err = h.updatableIssuance(id)
+
+	view := issuanceView{Issuance: iss, History: history, Updatable: err == nil}
+	if err := h.Templates.ExecuteTemplate(w, "issuance.html", view); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering issuance", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// ShowUpdateForm handles GET /issuances/{id}/update
+// Renders the farmer form pre-filled from the credential being updated
+func (h *Handler) ShowUpdateForm(w http.ResponseWriter, r *http.Request) {
+	iss, err := h.updatableIssuance(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, updateErrorMessage(err), updateErrorStatus(err))
+		return
+	}
+
+	var farmer models.SimpleFarmerCredential
+	if err := json.Unmarshal(iss.Subject, &farmer); err != nil {
+		slog.ErrorContext(r.Context(), "Error reading issuance subject", "issuance_id", iss.ID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := h.formData()
+	data["Farmer"] = &farmer
+	if county, ok := geo.Default.County(farmer.County); ok {
+		data["CountyCode"] = county.Code
+	}
+	data["Update"] = iss
+	data["RequirePIN"] = iss.PIN != nil || iss.RequirePIN
+	if err := h.Templates.ExecuteTemplate(w, "farmer-form.html", data); err != nil {
+		slog.ErrorContext(r.Context(), "Error rendering update form", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
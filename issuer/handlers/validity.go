@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/adammwaniki/testa-walt/validity"
+)
+
+// validityDates are the validity fields of a walt.id credential request.
+// Dates fixed at issuance go in the credential data; the mapped
+// placeholders are filled in by walt.id when the offer is claimed.
+type validityDates struct {
+	ValidFrom            string
+	ExpirationDate       string
+	MappedValidFrom      string
+	MappedExpirationDate string
+}
+
+// validityPoliciesFromEnv reads the validity policy of each credential type.
+// PDA1 credentials cover the period of Section 2 of the form; farmer
+// credentials are valid for a year.
+func validityPoliciesFromEnv() (map[string]validity.Policy, error) {
+	pda1, err := validity.FromEnv("PDA1_VALIDITY", "form")
+	if err != nil {
+		return nil, err
+	}
+	farmer, err := validity.FromEnv("FARMER_VALIDITY", "365d")
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Credential validity", "pda1", pda1.String(), "farmer", farmer.String())
+	return map[string]validity.Policy{
+		store.CredentialPDA1:   pda1,
+		store.CredentialFarmer: farmer,
+	}, nil
+}
+
+// newValidityDates splits a period into credential data dates and mapping
+// placeholders of the given family, "timestamp" or "timestamp-ebsi"
+func newValidityDates(period validity.Period, placeholder string) validityDates {
+	var dates validityDates
+	if period.From.IsZero() {
+		dates.MappedValidFrom = "<" + placeholder + ">"
+	} else {
+		dates.ValidFrom = period.From.Format(time.RFC3339)
+	}
+	if period.Relative {
+		dates.MappedExpirationDate = fmt.Sprintf("<%s-in:%dd>", placeholder, period.Days())
+	} else {
+		dates.ExpirationDate = period.Until.Format(time.RFC3339)
+	}
+	return dates
+}
+
+// describeValidity explains a validity policy on the issuance forms
+func describeValidity(p validity.Policy) string {
+	days := int(p.Duration.Hours() / 24)
+	switch p.Kind {
+	case validity.KindForm:
+		return fmt.Sprintf("The credential is valid from the starting date to the end of the ending date. "+
+			"Without an ending date it is valid for %d days from issuance.", days)
+	case validity.KindSeason:
+		end := time.Date(2001, p.SeasonMonth, p.SeasonDay, 0, 0, 0, 0, time.UTC)
+		return fmt.Sprintf("The credential is valid until the end of the season on %s.", end.Format("2 January"))
+	}
+	return fmt.Sprintf("The credential is valid for %d days from issuance.", days)
+}
+
+// validityFor returns the validity of a credential about subject issued now,
+// under its type's policy. A later start delays it, as for a renewal. PDA1
+// credentials take their dates from Section 2 of the form.
+func (h *Handler) validityFor(subject any, start time.Time) (validity.Period, error) {
+	var credentialType, from, until string
+	switch s := subject.(type) {
+	case *models.FarmerCredential:
+		credentialType, from, until = store.CredentialPDA1, s.StartingDate, s.EndingDate
+	case *models.SimpleFarmerCredential:
+		credentialType = store.CredentialFarmer
+	default:
+		return validity.Period{}, fmt.Errorf("no validity policy for %T", subject)
+	}
+	return h.Validity[credentialType].Period(time.Now(), start, from, until)
+}
+
+// issuanceValidity is validityFor as an issuance error, for subjects already
+// validated with validityFor
+func (h *Handler) issuanceValidity(subject any, start time.Time) (validity.Period, error) {
+	period, err := h.validityFor(subject, start)
+	if err != nil {
+		return validity.Period{}, &IssuanceError{Message: fmt.Sprintf("Invalid validity period: %v", err), Err: err}
+	}
+	return period, nil
+}
+
+// setValidity records the validity period of an offered credential
+func setValidity(iss *store.Issuance, period validity.Period) {
+	iss.ValidFrom = nil
+	if !period.From.IsZero() {
+		from := period.From
+		iss.ValidFrom = &from
+	}
+	until := period.Until
+	iss.ExpiresAt = &until
+}
+
+// validityStart returns when the credential renewing the issuance renews
+// becomes valid: when that credential expires, so the two do not overlap.
+// Other credentials are valid from issuance and get a zero time.
+func (h *Handler) validityStart(renews string) time.Time {
+	if renews == "" {
+		return time.Time{}
+	}
+	old, err := h.Store.Issuance(renews)
+	if err != nil || old.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return *old.ExpiresAt
+}
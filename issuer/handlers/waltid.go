@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/common/waltid"
+	"github.com/adammwaniki/testa-walt/models"
+	"github.com/adammwaniki/testa-walt/statuslist"
+	"github.com/adammwaniki/testa-walt/store"
+	"github.com/adammwaniki/testa-walt/validity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// FarmerWaltIDURL is the walt.id endpoint for JWT farmer credentials
+const FarmerWaltIDURL = "http://139.59.15.151:7002/openid4vc/jwt/issue"
+
+// pda1IssuerJWK is the P-256 key walt.id signs PDA1 credentials with
+var pda1IssuerJWK = models.JWK{
+	Kty: "EC",
+	X:   "SgfOvOk1TL5yiXhK5Nq7OwKfn_RUkDizlIhAf8qd2wE",
+	Y:   "u_y5JZOsw3SrnNPydzJkoaiqb8raSdCNE_nPovt1fNI",
+	Crv: "P-256",
+	D:   "UqSi2MbJmPczfRmwRDeOJrdivoEy-qk4OEDjFwJYlUI",
+}
+
+// farmerIssuerDID is the did:jwk of farmerIssuerJWK, the issuer of farmer
+// credentials and of their status list
+const farmerIssuerDID = "did:jwk:eyJrdHkiOiJPS1AiLCJjcnYiOiJFZDI1NTE5Iiwia2lkIjoieW56SzZ1NTVTak82aEZFc1cwa0JLb25fYnB2cGY1enJyLVEzRk5IZUFWRSIsIngiOiJlM0NFMUVPcFl0RV82VXlJTjU4VUp3V21HR2VzVjNrWkhNVlpBQklRSTNNIn0"
+
+// farmerIssuerJWK is the Ed25519 key walt.id signs farmer credentials with
+var farmerIssuerJWK = models.FarmerJWK{
+	Kty: "OKP",
+	D:   "uX8gZ8UPrWQhzOFaA5gmmkfOiIGCE7w1zbRUh9v6xb8",
+	Crv: "Ed25519",
+	Kid: "ynzK6u55SjO6hFEsW0kBKon_bpvpf5zrr-Q3FNHeAVE",
+	X:   "e3CE1EOpYtE_6UyIN58UJwWmGGesV3kZHMVZABIQI3M",
+}
+
+// IssuanceError is an issuance failure with a message safe to show the user
+type IssuanceError struct {
+	Message string
+	Err     error
+}
+
+func (e *IssuanceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *IssuanceError) Unwrap() error {
+	return e.Err
+}
+
+// PINLength is the number of digits in a transaction code
+const PINLength = 6
+
+// issuePDA1Credential requests a PDA1 offer, records the issuance and
+// returns the walt.id offer link and the issuance ID
+func (h *Handler) issuePDA1Credential(ctx context.Context, farmer *models.FarmerCredential) (string, string, error) {
+	period, err := h.issuanceValidity(farmer, time.Time{})
+	if err != nil {
+		return "", "", err
+	}
+	offerURL, err := h.requestPDA1Offer(ctx, farmer, period)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := h.recordIssuance(ctx, store.CredentialPDA1, farmer.Forenames+" "+farmer.Surname, farmer, offerURL, nil, nil, "", period)
+	return offerURL, id, nil
+}
+
+// requestPDA1Offer builds the PDA1 request valid for period and returns the
+// walt.id offer link
+func (h *Handler) requestPDA1Offer(ctx context.Context, farmer *models.FarmerCredential, period validity.Period) (string, error) {
+	_, span := tracing.Start(ctx, "build credential request", attribute.String("credential.type", store.CredentialPDA1))
+	credRequest := h.buildCredentialRequest(farmer, period)
+	span.End()
+
+	return h.requestOffer(ctx, h.WaltIDURL, store.CredentialPDA1, credRequest)
+}
+
+// issueFarmerCredential issues a farmer credential as a plain bearer offer
+func (h *Handler) issueFarmerCredential(ctx context.Context, farmer *models.SimpleFarmerCredential) (string, error) {
+	offerURL, _, _, err := h.issueFarmerOffer(ctx, farmer, false, "", time.Time{})
+	return offerURL, err
+}
+
+// issueFarmerOffer requests a farmer offer, records the issuance and returns
+// the offer link, the PIN when requirePIN is set, and the issuance ID. When
+// supersedes names an earlier issuance, the new credential replaces it. A
+// non-zero start delays the credential's validity, as for a renewal.
+func (h *Handler) issueFarmerOffer(ctx context.Context, farmer *models.SimpleFarmerCredential, requirePIN bool, supersedes string, start time.Time) (string, string, string, error) {
+	period, err := h.issuanceValidity(farmer, start)
+	if err != nil {
+		return "", "", "", err
+	}
+	statusIndex, err := h.allocateStatusIndex(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+	offerURL, pin, pinState, err := h.requestFarmerOffer(ctx, farmer, requirePIN, statusIndex, period)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	id := h.recordIssuance(ctx, store.CredentialFarmer, farmer.GivenName+" "+farmer.FamilyName, farmer, offerURL, pinState, statusIndex, supersedes, period)
+	if id != "" && supersedes != "" {
+		h.supersede(ctx, supersedes, id)
+	}
+	return offerURL, pin, id, nil
+}
+
+// requestFarmerOffer builds the farmer request and returns the walt.id offer
+// link. With requirePIN the offer uses the pre-authorized code flow bound to
+// a generated transaction code, which is returned for out-of-band delivery
+// together with the state to store. A credential with a statusIndex points
+// verifiers at that bit of the farmer status list. The credential is valid
+// for period.
+func (h *Handler) requestFarmerOffer(ctx context.Context, farmer *models.SimpleFarmerCredential, requirePIN bool, statusIndex *int, period validity.Period) (string, string, *store.PINState, error) {
+	_, span := tracing.Start(ctx, "build credential request",
+		attribute.String("credential.type", store.CredentialFarmer), attribute.Bool("offer.pin", requirePIN))
+	credRequest := h.buildFarmerCredentialRequest(farmer, period)
+	if statusIndex != nil {
+		credRequest.CredentialData.CredentialStatus = statuslist.NewEntry(h.farmerStatusListURL(), statuslist.PurposeRevocation, *statusIndex)
+	}
+
+	var pin string
+	var pinState *store.PINState
+	if requirePIN {
+		var err error
+		pin, pinState, err = store.NewPIN(PINLength)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error generating PIN", "error", err)
+			tracing.Fail(span, err)
+			span.End()
+			return "", "", nil, &IssuanceError{Message: "Failed to generate a PIN for the offer", Err: err}
+		}
+
+		credRequest.AuthenticationMethod = "PRE_AUTHORIZED"
+		credRequest.TxCode = &models.TxCode{
+			InputMode:   "numeric",
+			Length:      PINLength,
+			Description: "Enter the PIN given to you by the issuing officer",
+		}
+		credRequest.TxCodeValue = pin
+	}
+	span.End()
+
+	offerURL, err := h.requestOffer(ctx, FarmerWaltIDURL, store.CredentialFarmer, credRequest)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return offerURL, pin, pinState, nil
+}
+
+// recordIssuance stores an offer in the issuance ledger and returns its ID.
+// The offer already exists at walt.id, so a storage failure is logged rather
+// than returned, and the ID is then empty.
+func (h *Handler) recordIssuance(ctx context.Context, credentialType, name string, subject any, offerURL string, pin *store.PINState, statusIndex *int, supersedes string, period validity.Period) string {
+	raw, err := json.Marshal(subject)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding issuance subject", "error", err)
+		return ""
+	}
+
+	iss := &store.Issuance{
+		CredentialType: credentialType,
+		SubjectName:    name,
+		SubjectKey:     subjectKey(subject),
+		Subject:        raw,
+		OfferURL:       offerURL,
+		Status:         store.StatusOffered,
+		PIN:            pin,
+
+		StatusListIndex: statusIndex,
+		Supersedes:      supersedes,
+	}
+	setValidity(iss, period)
+	if err := h.Store.SaveIssuance(iss); err != nil {
+		slog.ErrorContext(ctx, "Error recording issuance", "credential_type", credentialType, "error", err)
+		return ""
+	}
+	slog.InfoContext(ctx, "Recorded issuance", "credential_type", credentialType, "issuance_id", iss.ID)
+	return iss.ID
+}
+
+// requestOffer posts a credential request to walt.id and returns the
+// credential offer link from the response body. credentialType selects the
+// personal data masked when the exchange is logged.
+func (h *Handler) requestOffer(ctx context.Context, url, credentialType string, credRequest any) (string, error) {
+	ctx, span := tracing.Start(ctx, "request offer", attribute.String("credential.type", credentialType))
+	defer span.End()
+
+	outcome := metrics.OutcomeError
+	defer func() {
+		issuances.Count(credentialType, outcome)
+		span.SetAttributes(attribute.String("issuance.outcome", outcome))
+		if outcome != metrics.OutcomeSuccess {
+			span.SetStatus(codes.Error, outcome)
+		}
+	}()
+
+	// Marshal request to JSON
+	requestBody, err := json.Marshal(credRequest)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error marshaling request", "error", err)
+		return "", &IssuanceError{Message: "Failed to create credential request", Err: err}
+	}
+	slog.DebugContext(ctx, "Sending credential request to walt.id", "url", url, "body", logging.Body(credentialType, requestBody))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+
+	// Send request; the client queues for a walt.id slot and fails fast
+	// while walt.id is down. It retries only when walt.id was not reached,
+	// as a repeated request would create a second offer.
+	resp, err := h.waltID.Issue(ctx, url, header, requestBody)
+	switch {
+	case errors.Is(err, ratelimit.ErrBusy):
+		slog.WarnContext(ctx, "Error waiting for walt.id", "error", err)
+		outcome = metrics.OutcomeBusy
+		return "", &IssuanceError{Message: "The credential service is busy. Please try again in a moment.", Err: err}
+	case errors.Is(err, waltid.ErrUnavailable):
+		slog.WarnContext(ctx, "walt.id circuit breaker is open", "url", url)
+		outcome = metrics.OutcomeUnavailable
+		return "", &IssuanceError{Message: "The credential service is temporarily unavailable. Please try again in a few minutes.", Err: err}
+	case err != nil && ctx.Err() != nil:
+		slog.WarnContext(ctx, "Request ended before walt.id answered", "url", url, "error", err)
+		return "", &IssuanceError{Message: "The request was cancelled before the credential service answered.", Err: err}
+	case err != nil:
+		slog.ErrorContext(ctx, "Error sending request to walt.id", "url", url, "error", err)
+		return "", &IssuanceError{Message: "Failed to connect to credential service. Please try again.", Err: err}
+	}
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(ctx, "walt.id returned an error", "url", url, "status", resp.StatusCode, "body", logging.Body(credentialType, resp.Body))
+		outcome = metrics.OutcomeRejected
+		return "", &IssuanceError{
+			Message: fmt.Sprintf("Credential service error: %s", string(resp.Body)),
+			Err:     fmt.Errorf("walt.id returned status %d", resp.StatusCode),
+		}
+	}
+
+	// Response is the credential link
+	outcome = metrics.OutcomeSuccess
+	return string(resp.Body), nil
+}
+
+// subjectKey identifies the holder of a credential across issuances: the
+// personal identification number for PDA1, the farm type and licence number
+// for farmer credentials
+func subjectKey(subject any) string {
+	switch s := subject.(type) {
+	case *models.FarmerCredential:
+		return strings.ToUpper(strings.TrimSpace(s.PersonalIdentificationNumber))
+	case *models.SimpleFarmerCredential:
+		return s.FarmType + ":" + strings.ToUpper(strings.TrimSpace(s.LicenseNo))
+	}
+	return ""
+}
+
+// issuanceErrorMessage returns the user-facing message for an issuance error
+func issuanceErrorMessage(err error) string {
+	var issuanceErr *IssuanceError
+	if errors.As(err, &issuanceErr) {
+		return issuanceErr.Message
+	}
+	return "Failed to issue credential"
+}
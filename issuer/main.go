@@ -1,32 +1,167 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
 
+	"github.com/adammwaniki/testa-walt/common/apiauth"
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/openapi"
+	"github.com/adammwaniki/testa-walt/common/server"
+	"github.com/adammwaniki/testa-walt/common/tracing"
 	"github.com/adammwaniki/testa-walt/handlers"
 )
 
 func main() {
+	// Structured logs with request IDs; LOG_LEVEL and LOG_FORMAT tune them
+	logging.Setup("issuer")
+
+	// JSON API client administration: testa-gava clients add|list|revoke|usage
+	if len(os.Args) > 1 && os.Args[1] == "clients" {
+		if err := apiauth.Command(handlers.DataDir(), handlers.APIScopes, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Traces exported over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(context.Background(), "issuer")
+	if err != nil {
+		log.Fatal("Error setting up tracing:", err)
+	}
+
 	// Walt.id URL for PDA1 credentials
 	waltIDURL := "http://139.59.15.151:7002/openid4vc/sdjwt/issue"
 	
 	// Create handler
 	h := handlers.NewHandler(waltIDURL)
 
+	// Staff accounts and sessions
+	a, err := auth.New(filepath.Join(handlers.DataDir(), "users.json"), os.Getenv("AUTH_SECURE_COOKIES") == "true")
+	if err != nil {
+		log.Fatal("Error opening user store:", err)
+	}
+
+	// Optional single sign-on for staff with identity provider accounts
+	oidcConfig, err := auth.OIDCConfigFromEnv()
+	if err != nil {
+		log.Fatal("Error reading single sign-on settings:", err)
+	}
+	if oidcConfig != nil {
+		a.EnableOIDC(oidcConfig)
+	}
+
+	routes, spec := registerRoutes(http.DefaultServeMux, h, a)
+	if err := spec.Check(routes.Patterns(), handlers.IsAPIPath); err != nil {
+		slog.Warn("OpenAPI document and routes differ", "error", err)
+	}
+
+	// Start server
+	port := ":8082"
+	slog.Info("Server starting", "url", "http://localhost"+port)
+	handler := tracing.Middleware(http.DefaultServeMux, logging.Middleware(a.Middleware(http.DefaultServeMux)))
+	srv := server.New(server.ConfigFromEnv(port), metrics.Middleware(http.DefaultServeMux, handler), server.NewHealth(h.HealthChecks()...))
+
+	// On SIGTERM bulk jobs stop sending rows, and rows already with walt.id
+	// finish along with in-flight requests
+	srv.OnShutdown(h.Bulk.Shutdown)
+
+	// Renewals run in the background until shutdown
+	h.Renewals.Start()
+	srv.OnShutdown(h.Renewals.Shutdown)
+
+	// Save the API usage counted since the last periodic flush
+	srv.OnShutdown(h.FlushAPIUsage)
+
+	err = srv.Run()
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// registerRoutes registers every route on mux. It returns the recorded
+// routes and the API description, which openapi_test.go checks against
+// each other.
+func registerRoutes(mux *http.ServeMux, h *handlers.Handler, a *auth.Service) (*openapi.Routes, *openapi.Document) {
+	// Routes are recorded so they can be checked against the API description
+	routes := openapi.NewRoutes(mux)
+
 	// Serve static files
 	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+	routes.Handle("/static/", http.StripPrefix("/static/", fs))
+
+	// Sign-in and user administration
+	routes.HandleFunc("GET /login", a.ShowLogin)
+	routes.HandleFunc("POST /login", a.Login)
+	routes.HandleFunc("POST /logout", a.Logout)
+	routes.HandleFunc("GET "+auth.OIDCLoginPath, a.StartOIDC)
+	routes.HandleFunc("GET "+auth.OIDCCallbackPath, a.OIDCCallback)
+	routes.HandleFunc("GET /admin/users", a.Require(a.ShowUsers, auth.RoleAdmin))
+	routes.HandleFunc("POST /admin/users", a.Require(a.CreateUser, auth.RoleAdmin))
+	routes.HandleFunc("POST /admin/users/{username}", a.Require(a.UpdateUser, auth.RoleAdmin))
 
 	// Routes
-	http.HandleFunc("/", h.Home)
-	http.HandleFunc("/form/pda1", h.ShowPDA1Form)
-	http.HandleFunc("/form/farmer", h.ShowFarmerForm)
-	http.HandleFunc("/issue-credential", h.IssueCredential)
-	http.HandleFunc("/issue-farmer-credential", h.IssueFarmerCredential)
+	routes.HandleFunc("/", a.Require(h.Home))
+	routes.HandleFunc("/form/pda1", a.Require(h.ShowPDA1Form, auth.RoleClerk))
+	routes.HandleFunc("/form/farmer", a.Require(h.ShowFarmerForm, auth.RoleClerk))
+	routes.HandleFunc("/issue-credential", a.Require(h.RateLimited(h.IssueCredential), auth.RoleClerk))
+	routes.HandleFunc("/issue-farmer-credential", a.Require(h.RateLimited(h.IssueFarmerCredential), auth.RoleClerk))
 
-	// Start server
-	port := ":8082"
-	log.Printf("Server starting on http://localhost%s", port)
-	log.Fatal(http.ListenAndServe(port, nil))
-}
\ No newline at end of file
+	// JSON API for the mobile field app, sharing the form builders. Its
+	// clients authenticate with an API key or a client credentials token.
+	routes.HandleFunc("POST "+handlers.APITokenPath, h.RateLimited(h.APIAuth.TokenHandler))
+	routes.HandleFunc("POST /api/v1/credentials/pda1", h.RequireAPI(handlers.ScopeIssue, h.RateLimited(h.APIIssuePDA1)))
+	routes.HandleFunc("POST /api/v1/credentials/farmer", h.RequireAPI(handlers.ScopeIssue, h.RateLimited(h.APIIssueFarmer)))
+
+	// Bulk enrolment (CSV/XLSX upload)
+	routes.HandleFunc("/bulk", a.Require(h.ShowBulkForm, auth.RoleClerk))
+	routes.HandleFunc("/bulk/upload", a.Require(h.RateLimited(h.UploadBulk), auth.RoleClerk))
+	routes.HandleFunc("/bulk/jobs/{id}/events", a.Require(h.BulkEvents, auth.RoleClerk))
+	routes.HandleFunc("/bulk/jobs/{id}/results.csv", a.Require(h.BulkResults, auth.RoleClerk))
+	routes.HandleFunc("/bulk/jobs/{id}/rows/{row}/qr.png", a.Require(h.BulkQRCode, auth.RoleClerk))
+
+	// Deferred issuance and maker-checker: claim pages are public, the
+	// approval queue is for supervisors
+	routes.HandleFunc("GET /offers/{id}", h.ShowOffer)
+	routes.HandleFunc("GET /approvals", a.Require(h.ShowApprovals, auth.RoleSupervisor))
+	routes.HandleFunc("POST /approvals/{id}/approve", a.Require(h.ApproveIssuance, auth.RoleSupervisor))
+	routes.HandleFunc("POST /approvals/{id}/reject", a.Require(h.RejectIssuance, auth.RoleSupervisor))
+	routes.HandleFunc("POST /approvals/{id}/comments", a.Require(h.CommentOnApplication, auth.RoleSupervisor))
+
+	// Issuance ledger and credential updates. The status list revoking
+	// superseded credentials is public so wallets and verifiers can fetch it.
+	routes.HandleFunc("GET /issuances", a.Require(h.ShowIssuances, auth.RoleClerk, auth.RoleSupervisor))
+	routes.HandleFunc("GET /issuances/{id}", a.Require(h.ShowIssuance, auth.RoleClerk, auth.RoleSupervisor))
+	routes.HandleFunc("GET /issuances/{id}/update", a.Require(h.ShowUpdateForm, auth.RoleClerk))
+	routes.HandleFunc("GET "+handlers.FarmerStatusListPath, h.FarmerStatusList)
+
+	// Upcoming expiries and renewal reminders
+	routes.HandleFunc("GET /renewals", a.Require(h.ShowRenewals, auth.RoleClerk, auth.RoleSupervisor))
+	routes.HandleFunc("POST /renewals/run", a.Require(h.RunRenewals, auth.RoleSupervisor))
+	routes.HandleFunc("POST /renewals/{id}/pin", a.Require(h.NewRenewalPIN, auth.RoleClerk))
+
+	// Administrative geography for the farmer form dropdowns
+	routes.HandleFunc("/geo/counties", h.ListCounties)
+	routes.HandleFunc("/geo/counties/{id}/subcounties", h.ListSubCounties)
+
+	// Prometheus metrics, behind a bearer token when METRICS_TOKEN is set
+	routes.Handle("GET "+metrics.Path, metrics.Handler(os.Getenv("METRICS_TOKEN")))
+
+	// API description and docs
+	spec := handlers.OpenAPI()
+	routes.HandleFunc("GET "+openapi.SpecPath, spec.Handler())
+	routes.HandleFunc("GET "+openapi.DocsPath, openapi.DocsHandler)
+
+	return routes, spec
+}
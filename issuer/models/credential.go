@@ -1,5 +1,7 @@
 package models
 
+import "github.com/adammwaniki/testa-walt/statuslist"
+
 // FarmerCredential represents a farmer's information for PDA1 credential issuance
 type FarmerCredential struct {
 	// Section 1: Personal Information
@@ -79,6 +81,12 @@ type SimpleFarmerCredential struct {
 	FarmType   string `json:"farm_type"`
 	LicenseNo  string `json:"license_no"`
 	Region     string `json:"region"`
+	County     string `json:"county"`
+	SubCounty  string `json:"sub_county"`
+
+	// Contact details for expiry reminders; not part of the credential
+	Phone string `json:"phone,omitempty"`
+	Email string `json:"email,omitempty"`
 }
 
 // SimpleFarmerCredentialRequest represents the complete request for farmer credential
@@ -88,6 +96,19 @@ type SimpleFarmerCredentialRequest struct {
 	CredentialConfigurationID   string                    `json:"credentialConfigurationId"`
 	CredentialData              SimpleFarmerCredentialData `json:"credentialData"`
 	Mapping                     SimpleFarmerMapping        `json:"mapping"`
+
+	// Pre-authorized code flow with a transaction code (PIN)
+	AuthenticationMethod string  `json:"authenticationMethod,omitempty"`
+	TxCode               *TxCode `json:"txCode,omitempty"`
+	TxCodeValue          string  `json:"txCodeValue,omitempty"`
+}
+
+// TxCode describes the transaction code a wallet must prompt for before
+// redeeming a pre-authorized code (OpenID4VCI tx_code)
+type TxCode struct {
+	InputMode   string `json:"input_mode"`
+	Length      int    `json:"length"`
+	Description string `json:"description"`
 }
 
 // FarmerIssuerKey represents the issuer's cryptographic key for farmer credential
@@ -112,6 +133,15 @@ type SimpleFarmerCredentialData struct {
 	Type              []string                      `json:"type"`
 	Issuer            FarmerIssuer                  `json:"issuer"`
 	CredentialSubject SimpleFarmerCredentialSubject `json:"credentialSubject"`
+
+	// ValidFrom and ExpirationDate are set when the validity period is fixed
+	// at issuance; otherwise the mapping sets them when the offer is claimed
+	ValidFrom      string `json:"validFrom,omitempty"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+
+	// CredentialStatus points verifiers at the credential's bit in the
+	// issuer's status list
+	CredentialStatus *statuslist.Entry `json:"credentialStatus,omitempty"`
 }
 
 // FarmerIssuer represents the credential issuer
@@ -128,13 +158,15 @@ type SimpleFarmerCredentialSubject struct {
 	FarmType   string `json:"farm_type"`
 	LicenseNo  string `json:"license_no"`
 	Region     string `json:"region"`
+	County     string `json:"county"`
+	SubCounty  string `json:"sub_county"`
 }
 
 // SimpleFarmerMapping for dynamic field mapping
 type SimpleFarmerMapping struct {
 	ID             string `json:"id"`
 	IssuanceDate   string `json:"issuanceDate"`
-	ExpirationDate string `json:"expirationDate"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
 }
 
 // CredentialRequest represents the request to Walt.id for PDA1
@@ -169,6 +201,8 @@ type CredentialData struct {
 	Type            []string          `json:"type"`
 	Issuer          string            `json:"issuer"`
 	IssuanceDate    string            `json:"issuanceDate"`
+	ValidFrom       string            `json:"validFrom,omitempty"`
+	ExpirationDate  string            `json:"expirationDate,omitempty"`
 	CredentialSubject CredentialSubject `json:"credentialSubject"`
 }
 
@@ -262,8 +296,8 @@ type Mapping struct {
 	CredentialSubject map[string]interface{} `json:"credentialSubject"`
 	IssuanceDate      string                 `json:"issuanceDate"`
 	Issued            string                 `json:"issued"`
-	ValidFrom         string                 `json:"validFrom"`
-	ExpirationDate    string                 `json:"expirationDate"`
+	ValidFrom         string                 `json:"validFrom,omitempty"`
+	ExpirationDate    string                 `json:"expirationDate,omitempty"`
 	CredentialSchema  CredentialSchema       `json:"credentialSchema"`
 }
 
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email sends messages over SMTP, authenticating with PLAIN auth when a
+// username is set. net/smtp upgrades to TLS when the server offers
+// STARTTLS.
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Channel implements Notifier
+func (e *Email) Channel() string { return "email" }
+
+// Notify implements Notifier. net/smtp takes no context, so cancellation
+// only applies before the message is sent.
+func (e *Email) Notify(ctx context.Context, m Message) error {
+	if m.Email == "" {
+		return ErrNoAddress
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(m.Email, "\r\n") || strings.ContainsAny(e.From, "\r\n") {
+		return fmt.Errorf("invalid email address")
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	to := m.Email
+	if m.Name != "" {
+		to = mime.QEncoding.Encode("utf-8", m.Name) + " <" + m.Email + ">"
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	return smtp.SendMail(addr, auth, e.From, []string{m.Email}, []byte(msg.String()))
+}
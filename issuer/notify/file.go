@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSink appends every message to a file as one JSON object per line,
+// whatever addresses the recipient has. It stands in for real channels in
+// development and tests.
+type FileSink struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// fileRecord is one line of the file sink
+type fileRecord struct {
+	Time time.Time `json:"time"`
+	Message
+}
+
+// Channel implements Notifier
+func (f *FileSink) Channel() string { return "file" }
+
+// Notify implements Notifier
+func (f *FileSink) Notify(ctx context.Context, m Message) error {
+	line, err := json.Marshal(fileRecord{Time: time.Now().UTC(), Message: m})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
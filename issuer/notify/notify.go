@@ -0,0 +1,94 @@
+// Package notify delivers messages to credential holders over pluggable
+// channels: SMS through an HTTP gateway, email over SMTP, and a local file
+// sink for development and tests.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// ErrNoAddress is returned by a notifier that has no address for the
+// recipient on its channel, such as an SMS notifier for a holder without a
+// phone number
+var ErrNoAddress = errors.New("no address for this channel")
+
+// Message is a notification to one person
+type Message struct {
+	Name    string
+	Phone   string
+	Email   string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages over one channel
+type Notifier interface {
+	// Channel names the channel, such as "sms"
+	Channel() string
+	// Notify delivers m, or returns ErrNoAddress when the recipient cannot
+	// be reached on this channel
+	Notify(ctx context.Context, m Message) error
+}
+
+// Send delivers m over every notifier that can reach the recipient and
+// returns the channels it was delivered on. It fails when no channel
+// delivered it: with ErrNoAddress when none could reach the recipient,
+// otherwise with the delivery errors.
+func Send(ctx context.Context, notifiers []Notifier, m Message) ([]string, error) {
+	if len(notifiers) == 0 {
+		return nil, errors.New("no notification channels are configured")
+	}
+
+	var sent []string
+	var errs []error
+	for _, n := range notifiers {
+		err := n.Notify(ctx, m)
+		switch {
+		case err == nil:
+			sent = append(sent, n.Channel())
+		case errors.Is(err, ErrNoAddress):
+		default:
+			slog.WarnContext(ctx, "Notification failed", "channel", n.Channel(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", n.Channel(), err))
+		}
+	}
+	if len(sent) > 0 {
+		return sent, nil
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoAddress
+	}
+	return nil, errors.Join(errs...)
+}
+
+// FromEnv returns the notifiers configured in the environment:
+// NOTIFY_FILE for the file sink, SMS_GATEWAY_URL for SMS and SMTP_HOST for
+// email
+func FromEnv() []Notifier {
+	var notifiers []Notifier
+	if path := os.Getenv("NOTIFY_FILE"); path != "" {
+		notifiers = append(notifiers, &FileSink{Path: path})
+	}
+	if url := os.Getenv("SMS_GATEWAY_URL"); url != "" {
+		notifiers = append(notifiers, NewSMSGateway(url, os.Getenv("SMS_GATEWAY_TOKEN"), os.Getenv("SMS_SENDER_ID")))
+	}
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := 587
+		if v, err := strconv.Atoi(os.Getenv("SMTP_PORT")); err == nil && v > 0 {
+			port = v
+		}
+		notifiers = append(notifiers, &Email{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("NOTIFY_EMAIL_FROM"),
+		})
+	}
+	return notifiers
+}
@@ -0,0 +1,61 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SMSGateway sends text messages through an HTTP SMS gateway. Each message
+// is POSTed as JSON {"to", "message", "from"} with the token as a bearer
+// credential; any 2xx answer counts as accepted.
+type SMSGateway struct {
+	URL      string
+	Token    string
+	SenderID string
+
+	client *http.Client
+}
+
+// NewSMSGateway returns an SMS notifier for the gateway at url
+func NewSMSGateway(url, token, senderID string) *SMSGateway {
+	return &SMSGateway{URL: url, Token: token, SenderID: senderID, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Channel implements Notifier
+func (g *SMSGateway) Channel() string { return "sms" }
+
+// Notify implements Notifier
+func (g *SMSGateway) Notify(ctx context.Context, m Message) error {
+	if m.Phone == "" {
+		return ErrNoAddress
+	}
+
+	body, err := json.Marshal(map[string]string{"to": m.Phone, "message": m.Body, "from": g.SenderID})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.Token)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("SMS gateway answered %d: %s", resp.StatusCode, bytes.TrimSpace(detail))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/handlers"
+)
+
+// TestOpenAPIMatchesRoutes fails when a JSON API route is missing from the
+// OpenAPI document or the document describes a route that is not served
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	t.Setenv("ISSUER_DATA_DIR", t.TempDir())
+	t.Setenv("AUTH_ADMIN_PASSWORD", "openapi-test-only")
+
+	h := handlers.NewHandler("http://waltid.invalid/openid4vc/sdjwt/issue")
+	a, err := auth.New(filepath.Join(handlers.DataDir(), "users.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, spec := registerRoutes(http.NewServeMux(), h, a)
+	if err := spec.Check(routes.Patterns(), handlers.IsAPIPath); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,287 @@
+// Package renewal tracks credentials nearing expiry. On a schedule it
+// creates a renewal for each credential expiring within the renewal window
+// and reminds the holder, with a link to collect the renewed credential.
+package renewal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/notify"
+	"github.com/adammwaniki/testa-walt/store"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrNotRenewable is returned by a RenewFunc for credentials it cannot
+// renew. Their holders are still reminded, and asked to contact the issuing
+// office.
+var ErrNotRenewable = errors.New("credential cannot be renewed automatically")
+
+// Renewal is the replacement created for an expiring credential
+type Renewal struct {
+	ID string // issuance ID of the renewal
+}
+
+// RenewFunc creates the renewal of an expiring issuance
+type RenewFunc func(context.Context, *store.Issuance) (Renewal, error)
+
+// Config controls the scheduler
+type Config struct {
+	// Window is how long before expiry a credential is renewed
+	Window time.Duration
+	// Interval is the time between scheduled runs; zero disables them
+	Interval time.Duration
+	// MaxAttempts bounds the delivery attempts of one reminder
+	MaxAttempts int
+	// ClaimURL is the base URL of claim pages, to which the renewal ID is
+	// appended
+	ClaimURL string
+}
+
+// ConfigFromEnv reads the RENEWAL_* environment variables.
+// RENEWAL_SCHEDULER=false stops scheduled runs; runs can still be started
+// from the dashboard.
+func ConfigFromEnv(claimURL string) Config {
+	cfg := Config{
+		Window:      time.Duration(ratelimit.EnvInt("RENEWAL_WINDOW_DAYS", 30)) * 24 * time.Hour,
+		Interval:    time.Duration(ratelimit.EnvInt("RENEWAL_INTERVAL_MINUTES", 60)) * time.Minute,
+		MaxAttempts: ratelimit.EnvInt("RENEWAL_REMINDER_ATTEMPTS", 3),
+		ClaimURL:    claimURL,
+	}
+	if os.Getenv("RENEWAL_SCHEDULER") == "false" {
+		cfg.Interval = 0
+	}
+	return cfg
+}
+
+// Summary counts the outcomes of one run
+type Summary struct {
+	Expiring int // credentials in the renewal window
+	Renewed  int // renewals created
+	Reminded int // reminders delivered
+	Failed   int // renewals or reminders that failed
+}
+
+// Scheduler renews expiring credentials and reminds their holders
+type Scheduler struct {
+	cfg       Config
+	store     *store.Store
+	renew     RenewFunc
+	notifiers []notify.Notifier
+
+	running  sync.Mutex // held for the duration of a run
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu      sync.Mutex
+	lastRun time.Time
+	last    Summary
+}
+
+// New creates a scheduler; Start begins the scheduled runs
+func New(cfg Config, st *store.Store, renew RenewFunc, notifiers []notify.Notifier) *Scheduler {
+	return &Scheduler{
+		cfg:       cfg,
+		store:     st,
+		renew:     renew,
+		notifiers: notifiers,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Config returns the scheduler's configuration
+func (s *Scheduler) Config() Config {
+	return s.cfg
+}
+
+// Channels names the configured notification channels
+func (s *Scheduler) Channels() []string {
+	channels := make([]string, 0, len(s.notifiers))
+	for _, n := range s.notifiers {
+		channels = append(channels, n.Channel())
+	}
+	return channels
+}
+
+// LastRun returns when the last run finished and its summary
+func (s *Scheduler) LastRun() (time.Time, Summary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRun, s.last
+}
+
+// Start runs the scheduler every Interval in the background, beginning
+// now. It does nothing when scheduled runs are disabled.
+func (s *Scheduler) Start() {
+	if s.cfg.Interval <= 0 {
+		close(s.done)
+		slog.Info("Renewal scheduler disabled")
+		return
+	}
+	slog.Info("Renewal scheduler started", "interval", s.cfg.Interval.String(), "window_days", int(s.cfg.Window.Hours()/24),
+		"channels", s.Channels())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			s.Run(ctx)
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Shutdown stops scheduled runs, interrupting one in progress, and waits
+// for it to finish or for ctx to expire
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("renewal run still in progress: %w", ctx.Err())
+	}
+}
+
+// Run renews the credentials expiring within the window and sends the
+// reminders due. Runs do not overlap: a run started during another waits
+// for it.
+func (s *Scheduler) Run(ctx context.Context) Summary {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	ctx, span := tracing.Start(ctx, "renewal run")
+	defer span.End()
+
+	now := time.Now()
+	expiring := s.store.Expiring(time.Time{}, now.Add(s.cfg.Window))
+	summary := Summary{Expiring: len(expiring)}
+	for _, iss := range expiring {
+		if ctx.Err() != nil {
+			break
+		}
+		s.process(ctx, iss, &summary)
+	}
+	span.SetAttributes(attribute.Int("renewal.expiring", summary.Expiring),
+		attribute.Int("renewal.renewed", summary.Renewed), attribute.Int("renewal.reminded", summary.Reminded))
+
+	s.mu.Lock()
+	s.lastRun, s.last = time.Now(), summary
+	s.mu.Unlock()
+
+	if summary.Renewed > 0 || summary.Reminded > 0 || summary.Failed > 0 {
+		slog.InfoContext(ctx, "Renewal run finished", "expiring", summary.Expiring, "renewed", summary.Renewed,
+			"reminded", summary.Reminded, "failed", summary.Failed)
+	}
+	return summary
+}
+
+// process renews one expiring credential if needed and sends its reminder
+func (s *Scheduler) process(ctx context.Context, iss *store.Issuance, summary *Summary) {
+	// A renewal only takes effect when the credential it renews expires.
+	// Until then it is not due, even when a window longer than its validity
+	// brings its expiry into view.
+	if iss.ValidFrom != nil && iss.ValidFrom.After(time.Now()) {
+		return
+	}
+
+	renewalID := iss.RenewedBy
+	renewable := true
+	if renewalID == "" {
+		r, err := s.renew(ctx, iss)
+		switch {
+		case errors.Is(err, ErrNotRenewable):
+			renewable = false
+		case err != nil:
+			// Try again next run, so the reminder can carry the link
+			slog.ErrorContext(ctx, "Error renewing credential", "issuance_id", iss.ID, "error", err)
+			summary.Failed++
+			return
+		default:
+			if err := s.store.MarkRenewed(iss.ID, r.ID); err != nil {
+				slog.ErrorContext(ctx, "Error linking renewal", "issuance_id", iss.ID, "renewal_id", r.ID, "error", err)
+			}
+			slog.InfoContext(ctx, "Credential renewed", "issuance_id", iss.ID, "renewal_id", r.ID)
+			summary.Renewed++
+			renewalID = r.ID
+		}
+	}
+
+	if r := iss.Reminder; r != nil && (r.SentAt != nil || r.Attempts >= s.cfg.MaxAttempts) {
+		return
+	}
+
+	msg := s.reminder(iss, renewable, renewalID)
+	channels, err := notify.Send(ctx, s.notifiers, msg)
+	if _, recErr := s.store.RecordReminder(iss.ID, channels, err); recErr != nil {
+		slog.ErrorContext(ctx, "Error recording reminder", "issuance_id", iss.ID, "error", recErr)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Reminder not delivered", "issuance_id", iss.ID, "error", err)
+		summary.Failed++
+		return
+	}
+	summary.Reminded++
+}
+
+// contact is the holder's reachable addresses, from the credential subject
+type contact struct {
+	Phone string `json:"phone"`
+	Email string `json:"email"`
+}
+
+// reminder composes the expiry reminder of iss. It never carries the PIN of
+// a PIN-protected renewal: anyone who sees the message could claim the
+// credential with both, so the issuing office gives the PIN out instead.
+func (s *Scheduler) reminder(iss *store.Issuance, renewable bool, renewalID string) notify.Message {
+	var c contact
+	if err := json.Unmarshal(iss.Subject, &c); err != nil {
+		slog.Warn("Error reading holder contact details", "issuance_id", iss.ID, "error", err)
+	}
+
+	verb := "expires"
+	if iss.ExpiresAt.Before(time.Now()) {
+		verb = "expired"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Dear %s, your %s credential %s on %s.", iss.SubjectName, iss.CredentialType, verb, iss.ExpiresAt.Format("2 Jan 2006"))
+	switch {
+	case !renewable || renewalID == "":
+		b.WriteString(" Please contact your issuing office to renew it.")
+	default:
+		fmt.Fprintf(&b, " Collect your renewed credential with your wallet at %s%s .", s.cfg.ClaimURL, renewalID)
+		if iss.PIN != nil || iss.RequirePIN {
+			b.WriteString(" Your issuing office will give you the PIN.")
+		}
+	}
+
+	return notify.Message{
+		Name:    iss.SubjectName,
+		Phone:   c.Phone,
+		Email:   c.Email,
+		Subject: fmt.Sprintf("Your %s credential %s on %s", iss.CredentialType, verb, iss.ExpiresAt.Format("2 Jan 2006")),
+		Body:    b.String(),
+	}
+}
@@ -0,0 +1,154 @@
+package renewal
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammwaniki/testa-walt/notify"
+	"github.com/adammwaniki/testa-walt/store"
+)
+
+// recorder is a notifier that records messages, failing while fail is set
+type recorder struct {
+	fail bool
+	sent []notify.Message
+}
+
+func (r *recorder) Channel() string { return "test" }
+
+func (r *recorder) Notify(ctx context.Context, m notify.Message) error {
+	if r.fail {
+		return errors.New("gateway down")
+	}
+	r.sent = append(r.sent, m)
+	return nil
+}
+
+// fixture is a store holding one PIN-protected farmer credential expiring
+// within the window, and the renew function of a handler
+type fixture struct {
+	store    *store.Store
+	renewals int
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	st, err := store.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(10 * 24 * time.Hour)
+	iss := &store.Issuance{
+		CredentialType: store.CredentialFarmer,
+		SubjectName:    "Amina Otieno",
+		Subject:        []byte(`{"phone":"+254712345678"}`),
+		Status:         store.StatusOffered,
+		PIN:            &store.PINState{Length: 4},
+		ExpiresAt:      &expires,
+	}
+	if err := st.SaveIssuance(iss); err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{store: st}
+}
+
+func (f *fixture) renew(ctx context.Context, iss *store.Issuance) (Renewal, error) {
+	f.renewals++
+	validFrom, expires := *iss.ExpiresAt, iss.ExpiresAt.Add(365*24*time.Hour)
+	r := &store.Issuance{
+		CredentialType: iss.CredentialType,
+		SubjectName:    iss.SubjectName,
+		Status:         store.StatusOffered,
+		PIN:            &store.PINState{Length: 4},
+		ValidFrom:      &validFrom,
+		ExpiresAt:      &expires,
+	}
+	if err := f.store.SaveIssuance(r); err != nil {
+		return Renewal{}, err
+	}
+	return Renewal{ID: r.ID}, nil
+}
+
+func TestRunRemindsWithoutPIN(t *testing.T) {
+	f := newFixture(t)
+	n := &recorder{}
+	s := New(Config{Window: 30 * 24 * time.Hour, MaxAttempts: 3, ClaimURL: "https://issuer.example/offers/"},
+		f.store, f.renew, []notify.Notifier{n})
+
+	summary := s.Run(context.Background())
+	if summary.Renewed != 1 || summary.Reminded != 1 {
+		t.Fatalf("summary = %+v, want one renewal and one reminder", summary)
+	}
+	if len(n.sent) != 1 {
+		t.Fatalf("%d reminders, want one", len(n.sent))
+	}
+	body := n.sent[0].Body
+	if !strings.Contains(body, "https://issuer.example/offers/") || !strings.Contains(body, "Your issuing office will give you the PIN.") {
+		t.Errorf("reminder = %q, want the claim link and a pointer to the issuing office", body)
+	}
+	if strings.Contains(body, "Your PIN is") {
+		t.Errorf("reminder = %q, want no PIN next to the claim link", body)
+	}
+
+	// The reminder was sent, so later runs do nothing
+	summary = s.Run(context.Background())
+	if summary.Renewed != 0 || summary.Reminded != 0 || f.renewals != 1 || len(n.sent) != 1 {
+		t.Errorf("second run: summary = %+v, %d renewals, %d reminders", summary, f.renewals, len(n.sent))
+	}
+}
+
+func TestRunRetriedReminderKeepsOffer(t *testing.T) {
+	f := newFixture(t)
+	n := &recorder{fail: true}
+	s := New(Config{Window: 30 * 24 * time.Hour, MaxAttempts: 3, ClaimURL: "https://issuer.example/offers/"},
+		f.store, f.renew, []notify.Notifier{n})
+
+	summary := s.Run(context.Background())
+	if summary.Renewed != 1 || summary.Failed != 1 {
+		t.Fatalf("summary = %+v, want a renewal and a failed reminder", summary)
+	}
+	offers := len(f.store.Issuances())
+
+	n.fail = false
+	summary = s.Run(context.Background())
+	if summary.Renewed != 0 || summary.Reminded != 1 {
+		t.Fatalf("retry: summary = %+v, want the reminder only", summary)
+	}
+	if f.renewals != 1 || len(f.store.Issuances()) != offers {
+		t.Errorf("%d renewals and %d issuances after the retry, want no new offer", f.renewals, len(f.store.Issuances()))
+	}
+	if len(n.sent) != 1 || !strings.Contains(n.sent[0].Body, "Your issuing office will give you the PIN.") {
+		t.Errorf("reminders = %+v, want one pointing at the issuing office", n.sent)
+	}
+}
+
+func TestRunNotRenewable(t *testing.T) {
+	f := newFixture(t)
+	n := &recorder{}
+	renew := func(ctx context.Context, iss *store.Issuance) (Renewal, error) { return Renewal{}, ErrNotRenewable }
+	s := New(Config{Window: 30 * 24 * time.Hour, MaxAttempts: 3}, f.store, renew, []notify.Notifier{n})
+
+	summary := s.Run(context.Background())
+	if summary.Renewed != 0 || summary.Reminded != 1 {
+		t.Fatalf("summary = %+v, want a reminder only", summary)
+	}
+	if !strings.Contains(n.sent[0].Body, "Please contact your issuing office to renew it.") {
+		t.Errorf("reminder = %q, want a request to contact the office", n.sent[0].Body)
+	}
+}
+
+func TestRunSkipsFutureRenewals(t *testing.T) {
+	f := newFixture(t)
+	n := &recorder{}
+	// A window longer than a year brings the renewal's own expiry into view
+	s := New(Config{Window: 400 * 24 * time.Hour, MaxAttempts: 3}, f.store, f.renew, []notify.Notifier{n})
+
+	s.Run(context.Background())
+	s.Run(context.Background())
+	if f.renewals != 1 || len(n.sent) != 1 {
+		t.Errorf("%d renewals and %d reminders, want one of each", f.renewals, len(n.sent))
+	}
+}
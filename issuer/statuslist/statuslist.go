@@ -0,0 +1,118 @@
+// Package statuslist publishes credential status as a W3C Bitstring Status
+// List: one bit per issued credential, set when the credential is revoked,
+// wrapped in a credential signed as a JWT so verifiers can trust it.
+package statuslist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Size is the number of entries in a list. The specification's minimum of
+// 16 KiB of bits keeps any one credential's index from standing out.
+const Size = 131072
+
+// Status purposes
+const (
+	PurposeRevocation = "revocation"
+)
+
+// Entry is the credentialStatus of a credential with a bit in a list
+type Entry struct {
+	ID                   string `json:"id"`
+	Type                 string `json:"type"`
+	StatusPurpose        string `json:"statusPurpose"`
+	StatusListIndex      string `json:"statusListIndex"`
+	StatusListCredential string `json:"statusListCredential"`
+}
+
+// NewEntry returns the status entry for index in the list at listURL
+func NewEntry(listURL, purpose string, index int) *Entry {
+	return &Entry{
+		ID:                   fmt.Sprintf("%s#%d", listURL, index),
+		Type:                 "BitstringStatusListEntry",
+		StatusPurpose:        purpose,
+		StatusListIndex:      fmt.Sprint(index),
+		StatusListCredential: listURL,
+	}
+}
+
+// Encode returns the encodedList of a list with the given indexes set: the
+// GZIP-compressed bitstring, base64url encoded with the multibase "u"
+// prefix. Index 0 is the most significant bit of the first byte.
+func Encode(set []int) (string, error) {
+	bits := make([]byte, Size/8)
+	for _, i := range set {
+		if i < 0 || i >= Size {
+			return "", fmt.Errorf("status list index %d is out of range", i)
+		}
+		bits[i/8] |= 0x80 >> (i % 8)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(bits); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return "u" + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Signer issues status list credentials as JWTs signed with an Ed25519 key
+type Signer struct {
+	Issuer string // DID of the issuer
+	KeyID  string // verification method of the key, e.g. did:jwk:...#0
+	Key    ed25519.PrivateKey
+}
+
+// Credential returns the signed status list credential for the list at
+// listURL with the given indexes set
+func (s *Signer) Credential(listURL, purpose string, set []int, now time.Time) (string, error) {
+	encoded, err := Encode(set)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": s.KeyID}
+	claims := map[string]any{
+		"iss": s.Issuer,
+		"sub": listURL,
+		"iat": now.Unix(),
+		"vc": map[string]any{
+			"@context":  []string{"https://www.w3.org/ns/credentials/v2"},
+			"id":        listURL,
+			"type":      []string{"VerifiableCredential", "BitstringStatusListCredential"},
+			"issuer":    s.Issuer,
+			"validFrom": now.UTC().Format(time.RFC3339),
+			"credentialSubject": map[string]any{
+				"id":            listURL + "#list",
+				"type":          "BitstringStatusList",
+				"statusPurpose": purpose,
+				"encodedList":   encoded,
+			},
+		},
+	}
+	return s.sign(header, claims)
+}
+
+// sign returns the compact JWS of claims
+func (s *Signer) sign(header, claims any) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	sig := ed25519.Sign(s.Key, []byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
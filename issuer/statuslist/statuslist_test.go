@@ -0,0 +1,122 @@
+package statuslist
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decode reverses Encode
+func decode(t *testing.T, encoded string) []byte {
+	t.Helper()
+	if !strings.HasPrefix(encoded, "u") {
+		t.Fatalf("encodedList %q lacks the multibase prefix u", encoded[:8])
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(encoded[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bits) != Size/8 {
+		t.Fatalf("list of %d bytes, want %d", len(bits), Size/8)
+	}
+	return bits
+}
+
+func TestEncodeBitOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		set  []int
+		want map[int]byte // bytes other than zero
+	}{
+		{name: "empty", want: map[int]byte{}},
+		{name: "first index", set: []int{0}, want: map[int]byte{0: 0x80}},
+		{name: "end of first byte", set: []int{7}, want: map[int]byte{0: 0x01}},
+		{name: "second byte", set: []int{8, 10}, want: map[int]byte{1: 0xa0}},
+		{name: "last index", set: []int{Size - 1}, want: map[int]byte{Size/8 - 1: 0x01}},
+		{name: "repeated index", set: []int{3, 3}, want: map[int]byte{0: 0x10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := Encode(tt.set)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			for i, b := range decode(t, encoded) {
+				if b != tt.want[i] {
+					t.Errorf("byte %d = %#02x, want %#02x", i, b, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeOutOfRange(t *testing.T) {
+	for _, i := range []int{-1, Size} {
+		if _, err := Encode([]int{i}); err == nil {
+			t.Errorf("Encode(%d) succeeded, want an error", i)
+		}
+	}
+}
+
+func TestCredential(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Signer{Issuer: "did:jwk:issuer", KeyID: "did:jwk:issuer#0", Key: key}
+	listURL := "https://issuer.example/status/1"
+
+	jwt, err := s.Credential(listURL, PurposeRevocation, []int{5}, time.Unix(1750000000, 0))
+	if err != nil {
+		t.Fatalf("Credential: %v", err)
+	}
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT has %d parts, want 3", len(parts))
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		t.Fatal("signature does not verify")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+		VC  struct {
+			CredentialSubject struct {
+				StatusPurpose string `json:"statusPurpose"`
+				EncodedList   string `json:"encodedList"`
+			} `json:"credentialSubject"`
+		} `json:"vc"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Sub != listURL || claims.VC.CredentialSubject.StatusPurpose != PurposeRevocation {
+		t.Errorf("claims = %+v, want the revocation list at %s", claims, listURL)
+	}
+	if bits := decode(t, claims.VC.CredentialSubject.EncodedList); bits[0] != 0x04 {
+		t.Errorf("first byte = %#02x, want index 5 set", bits[0])
+	}
+}
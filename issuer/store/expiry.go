@@ -0,0 +1,79 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Expiring returns the offered credentials that expire between after and
+// before, soonest first. A zero after includes every credential already
+// expired.
+func (s *Store) Expiring(after, before time.Time) []*Issuance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []*Issuance
+	for _, iss := range s.issuances {
+		if iss.Status != StatusOffered || iss.ExpiresAt == nil || !iss.ExpiresAt.Before(before) || iss.ExpiresAt.Before(after) {
+			continue
+		}
+		c := *iss
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpiresAt.Before(*list[j].ExpiresAt) })
+	return list
+}
+
+// MarkRenewed links the renewal newID to the expiring issuance oldID. It
+// fails with ErrConflict when oldID was already renewed.
+func (s *Store) MarkRenewed(oldID, newID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.issuances[oldID]
+	if !ok {
+		return ErrNotFound
+	}
+	renewal, ok := s.issuances[newID]
+	if !ok {
+		return ErrNotFound
+	}
+	if old.RenewedBy != "" {
+		return ErrConflict
+	}
+
+	now := time.Now().UTC()
+	o, r := *old, *renewal
+	o.RenewedBy, o.UpdatedAt = newID, now
+	r.Renews, r.UpdatedAt = oldID, now
+
+	s.issuances[oldID], s.issuances[newID] = &o, &r
+	if err := s.persist(); err != nil {
+		s.issuances[oldID], s.issuances[newID] = old, renewal
+		return err
+	}
+	return nil
+}
+
+// RecordReminder records an attempt to send the expiry reminder of id over
+// the given channels. A nil err marks the reminder as sent.
+func (s *Store) RecordReminder(id string, channels []string, err error) (*Issuance, error) {
+	now := time.Now().UTC()
+	return s.UpdateIssuance(id, func(iss *Issuance) error {
+		r := Reminder{}
+		if iss.Reminder != nil {
+			r = *iss.Reminder
+		}
+		r.Attempts++
+		r.LastAttempt = now
+		r.Channels = channels
+		r.Error = ""
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.SentAt = &now
+		}
+		iss.Reminder = &r
+		return nil
+	})
+}
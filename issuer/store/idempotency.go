@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// IdempotencyTTL is how long an idempotency key is remembered
+const IdempotencyTTL = 24 * time.Hour
+
+// ErrKeyReused is returned when an idempotency key comes back with a
+// different request than the one it was first used for
+var ErrKeyReused = errors.New("idempotency key was already used for a different request")
+
+// IdempotencyRecord ties an idempotency key to the issuance its first
+// request created
+type IdempotencyRecord struct {
+	Scope       string    `json:"scope"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	IssuanceID  string    `json:"issuanceId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type idempotencyKey struct {
+	scope, key string
+}
+
+// ClaimIdempotencyKey starts a request made with an idempotency key. scope
+// keeps keys apart, for example per user, and fingerprint identifies the
+// request body.
+//
+// If an earlier request with the key created an issuance, that issuance is
+// returned and the request should be answered from it. Otherwise the key is
+// held for this request until finish is called with the ID of the issuance
+// it created, or an empty string if it created none, which frees the key for
+// a retry. A request
+// arriving while the key is held waits for finish. finish only fails when
+// the record cannot be saved; the key is then remembered until a restart.
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, scope, key, fingerprint string) (iss *Issuance, finish func(issuanceID string) error, err error) {
+	k := idempotencyKey{scope, key}
+	for {
+		s.mu.Lock()
+		if rec, ok := s.idempotency[k]; ok && time.Since(rec.CreatedAt) < IdempotencyTTL {
+			defer s.mu.Unlock()
+			if rec.Fingerprint != fingerprint {
+				return nil, nil, ErrKeyReused
+			}
+			iss, ok := s.issuances[rec.IssuanceID]
+			if !ok {
+				return nil, nil, ErrNotFound
+			}
+			c := *iss
+			return &c, nil, nil
+		}
+
+		held, ok := s.claimed[k]
+		if !ok {
+			held = make(chan struct{})
+			s.claimed[k] = held
+			s.mu.Unlock()
+			return nil, func(issuanceID string) error {
+				return s.finishIdempotencyKey(k, fingerprint, issuanceID, held)
+			}, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// finishIdempotencyKey records the issuance a held key created, if any, and
+// releases the key to requests waiting on it
+func (s *Store) finishIdempotencyKey(k idempotencyKey, fingerprint, issuanceID string, held chan struct{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(held)
+	delete(s.claimed, k)
+
+	if issuanceID == "" {
+		return nil
+	}
+	now := time.Now().UTC()
+	for other, rec := range s.idempotency {
+		if now.Sub(rec.CreatedAt) >= IdempotencyTTL {
+			delete(s.idempotency, other)
+		}
+	}
+	s.idempotency[k] = &IdempotencyRecord{
+		Scope:       k.scope,
+		Key:         k.key,
+		Fingerprint: fingerprint,
+		IssuanceID:  issuanceID,
+		CreatedAt:   now,
+	}
+	return s.persist()
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// PIN delivery channels
+const (
+	PINDeliveredInPerson = "in-person"
+)
+
+// PINState records that a pre-authorized offer is bound to a transaction code
+// (tx_code). The PIN itself is shown once to the issuing officer for
+// out-of-band delivery to the farmer and is not kept anywhere: walt.id checks
+// it when the wallet redeems the offer, and that is the only check.
+type PINState struct {
+	Length       int       `json:"length"`
+	DeliveredVia string    `json:"deliveredVia"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// NewPIN generates a numeric PIN of the given length and its stored state
+func NewPIN(length int) (string, *PINState, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		d, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to generate PIN: %w", err)
+		}
+		digits[i] = byte('0' + d.Int64())
+	}
+
+	state := &PINState{
+		Length:       length,
+		DeliveredVia: PINDeliveredInPerson,
+		CreatedAt:    time.Now().UTC(),
+	}
+	return string(digits), state, nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// ErrStatusListFull is returned when every index of the status list is taken
+var ErrStatusListFull = errors.New("status list is full")
+
+// AllocateStatusIndex hands out an unused index in a status list of size
+// entries. Indexes are picked at random, so a credential's index says
+// nothing about when it was issued. An index handed out for an issuance
+// that is never recorded is free again after a restart.
+func (s *Store) AllocateStatusIndex(size int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.statusIndexes) >= size {
+		return 0, ErrStatusListFull
+	}
+	start, err := rand.Int(rand.Reader, big.NewInt(int64(size)))
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < size; i++ {
+		index := (int(start.Int64()) + i) % size
+		if !s.statusIndexes[index] {
+			s.statusIndexes[index] = true
+			return index, nil
+		}
+	}
+	return 0, ErrStatusListFull
+}
+
+// RevokedStatusIndexes returns the status list indexes of superseded
+// issuances of credentialType, in order
+func (s *Store) RevokedStatusIndexes(credentialType string) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var indexes []int
+	for _, iss := range s.issuances {
+		if iss.CredentialType == credentialType && iss.Status == StatusSuperseded && iss.StatusListIndex != nil {
+			indexes = append(indexes, *iss.StatusListIndex)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// Supersede marks the offered issuance oldID as replaced by newID. It fails
+// with ErrConflict when oldID is not offered or was already superseded.
+func (s *Store) Supersede(oldID, newID string) (*Issuance, error) {
+	now := time.Now().UTC()
+	return s.UpdateIssuance(oldID, func(iss *Issuance) error {
+		if iss.Status != StatusOffered || iss.SupersededBy != "" {
+			return ErrConflict
+		}
+		iss.Status = StatusSuperseded
+		iss.SupersededBy = newID
+		iss.SupersededAt = &now
+		return nil
+	})
+}
+
+// History returns every issuance linked to id through updates, oldest
+// first: the credentials it replaced, those that replaced it, and update
+// applications still waiting for approval or rejected.
+func (s *Store) History(id string) ([]*Issuance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.issuances[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	// Updates point at the issuance they replace, so collect the tree of
+	// Supersedes links from the first credential in the chain
+	root := id
+	for {
+		prev := s.issuances[root].Supersedes
+		if _, ok := s.issuances[prev]; !ok {
+			break
+		}
+		root = prev
+	}
+
+	linked := map[string]bool{root: true}
+	for grew := true; grew; {
+		grew = false
+		for _, iss := range s.issuances {
+			if !linked[iss.ID] && linked[iss.Supersedes] {
+				linked[iss.ID] = true
+				grew = true
+			}
+		}
+	}
+
+	history := make([]*Issuance, 0, len(linked))
+	for linkedID := range linked {
+		c := *s.issuances[linkedID]
+		history = append(history, &c)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].CreatedAt.Before(history[j].CreatedAt) })
+	return history, nil
+}
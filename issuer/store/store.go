@@ -0,0 +1,313 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Credential types recorded in the ledger
+const (
+	CredentialPDA1   = "PDA1"
+	CredentialFarmer = "Farmer"
+)
+
+// Issuance statuses
+const (
+	StatusPending   = "pending"
+	StatusApproving = "approving"
+	StatusOffered   = "offered"
+	StatusRejected  = "rejected"
+
+	// StatusSuperseded marks an offered credential replaced by an update;
+	// its bit is set in the status list
+	StatusSuperseded = "superseded"
+)
+
+// ErrConflict is returned when an update finds a record in an unexpected state
+var ErrConflict = errors.New("record was changed by another request")
+
+// Issuance is one credential offer created by the issuer
+type Issuance struct {
+	ID             string          `json:"id"`
+	CredentialType string          `json:"credentialType"`
+	SubjectName    string          `json:"subjectName"`
+	SubjectKey     string          `json:"subjectKey,omitempty"`
+	Subject        json.RawMessage `json:"subject"`
+	OfferURL       string          `json:"offerUrl"`
+	Status         string          `json:"status"`
+	PIN            *PINState       `json:"pin,omitempty"`
+	RequirePIN     bool            `json:"requirePin,omitempty"`
+	Approval       string          `json:"approval,omitempty"`
+	SubmittedBy    string          `json:"submittedBy,omitempty"`
+	Review         *Review         `json:"review,omitempty"`
+	Comments       []Comment       `json:"comments,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+
+	// StatusListIndex is the credential's bit in the status list, when it
+	// has one. Supersedes and SupersededBy link an update to the issuance
+	// it replaces.
+	StatusListIndex *int       `json:"statusListIndex,omitempty"`
+	Supersedes      string     `json:"supersedes,omitempty"`
+	SupersededBy    string     `json:"supersededBy,omitempty"`
+	SupersededAt    *time.Time `json:"supersededAt,omitempty"`
+
+	// ValidFrom and ExpiresAt bound an offered credential's validity; a nil
+	// ValidFrom means from issuance. Renews and RenewedBy link a renewal to
+	// the expiring issuance, and Reminder records the holder's expiry
+	// reminder.
+	ValidFrom *time.Time `json:"validFrom,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Renews    string     `json:"renews,omitempty"`
+	RenewedBy string     `json:"renewedBy,omitempty"`
+	Reminder  *Reminder  `json:"reminder,omitempty"`
+}
+
+// Reminder records the delivery of an expiry reminder to a credential holder
+type Reminder struct {
+	SentAt      *time.Time `json:"sentAt,omitempty"`
+	Channels    []string   `json:"channels,omitempty"`
+	Attempts    int        `json:"attempts"`
+	LastAttempt time.Time  `json:"lastAttempt"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Review records an approver's decision on a deferred issuance
+type Review struct {
+	Reviewer  string    `json:"reviewer"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	DecidedAt time.Time `json:"decidedAt"`
+}
+
+// Comment is a note left on an application by a clerk or reviewer
+type Comment struct {
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Store is a JSON-file backed record store. It keeps everything in memory
+// and rewrites the file on each change, which suits the issuer's volumes.
+type Store struct {
+	path string
+
+	mu          sync.RWMutex
+	issuances   map[string]*Issuance
+	idempotency map[idempotencyKey]*IdempotencyRecord
+	claimed     map[idempotencyKey]chan struct{} // keys held by requests in progress
+
+	// statusIndexes holds the status list indexes of recorded issuances and
+	// those handed out since the store was opened
+	statusIndexes map[int]bool
+}
+
+// data is the on-disk layout of the store file
+type data struct {
+	Issuances       []*Issuance          `json:"issuances"`
+	IdempotencyKeys []*IdempotencyRecord `json:"idempotencyKeys,omitempty"`
+}
+
+// Open loads the store from dir, creating it if needed
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &Store{
+		path:        filepath.Join(dir, "issuer-store.json"),
+		issuances:   make(map[string]*Issuance),
+		idempotency: make(map[idempotencyKey]*IdempotencyRecord),
+		claimed:     make(map[idempotencyKey]chan struct{}),
+
+		statusIndexes: make(map[int]bool),
+	}
+
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store: %w", err)
+	}
+
+	var d data
+	if err := json.Unmarshal(raw, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse store: %w", err)
+	}
+	released := 0
+	for _, iss := range d.Issuances {
+		// An approval still in progress when the issuer stopped never got
+		// its offer; back in the queue it can be approved again
+		if iss.Status == StatusApproving {
+			iss.Status = StatusPending
+			released++
+		}
+		s.issuances[iss.ID] = iss
+		if iss.StatusListIndex != nil {
+			s.statusIndexes[*iss.StatusListIndex] = true
+		}
+	}
+	for _, rec := range d.IdempotencyKeys {
+		s.idempotency[idempotencyKey{rec.Scope, rec.Key}] = rec
+	}
+
+	if released > 0 {
+		slog.Warn("Returned interrupted approvals to the queue", "count", released)
+		if err := s.persist(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// SaveIssuance inserts or replaces an issuance
+func (s *Store) SaveIssuance(iss *Issuance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	if iss.ID == "" {
+		iss.ID = NewID()
+	}
+	if iss.CreatedAt.IsZero() {
+		iss.CreatedAt = now
+	}
+	iss.UpdatedAt = now
+
+	c := *iss
+	s.issuances[iss.ID] = &c
+	return s.persist()
+}
+
+// UpdateIssuance applies fn to the stored issuance under the store lock and
+// persists the result. If fn returns an error nothing is written.
+func (s *Store) UpdateIssuance(id string, fn func(*Issuance) error) (*Issuance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	iss, ok := s.issuances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	c := *iss
+	if err := fn(&c); err != nil {
+		return nil, err
+	}
+	c.UpdatedAt = time.Now().UTC()
+
+	s.issuances[id] = &c
+	if err := s.persist(); err != nil {
+		s.issuances[id] = iss
+		return nil, err
+	}
+
+	out := c
+	return &out, nil
+}
+
+// Issuance returns a copy of the issuance with the given ID
+func (s *Store) Issuance(id string) (*Issuance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	iss, ok := s.issuances[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *iss
+	return &c, nil
+}
+
+// Issuances returns copies of all issuances, newest first
+func (s *Store) Issuances() []*Issuance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*Issuance, 0, len(s.issuances))
+	for _, iss := range s.issuances {
+		c := *iss
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list
+}
+
+// Previous returns the most recent issuance of the same credential type and
+// subject key created before iss, if any
+func (s *Store) Previous(iss *Issuance) (*Issuance, bool) {
+	if iss.SubjectKey == "" {
+		return nil, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var prev *Issuance
+	for _, other := range s.issuances {
+		if other.ID == iss.ID || other.CredentialType != iss.CredentialType || other.SubjectKey != iss.SubjectKey {
+			continue
+		}
+		if !other.CreatedAt.Before(iss.CreatedAt) {
+			continue
+		}
+		if prev == nil || other.CreatedAt.After(prev.CreatedAt) {
+			prev = other
+		}
+	}
+	if prev == nil {
+		return nil, false
+	}
+	c := *prev
+	return &c, true
+}
+
+// persist atomically rewrites the store file. Callers must hold s.mu.
+func (s *Store) persist() error {
+	d := data{Issuances: make([]*Issuance, 0, len(s.issuances))}
+	for _, iss := range s.issuances {
+		d.Issuances = append(d.Issuances, iss)
+	}
+	sort.Slice(d.Issuances, func(i, j int) bool { return d.Issuances[i].CreatedAt.Before(d.Issuances[j].CreatedAt) })
+	for _, rec := range s.idempotency {
+		d.IdempotencyKeys = append(d.IdempotencyKeys, rec)
+	}
+	sort.Slice(d.IdempotencyKeys, func(i, j int) bool { return d.IdempotencyKeys[i].CreatedAt.Before(d.IdempotencyKeys[j].CreatedAt) })
+
+	raw, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace store: %w", err)
+	}
+	return nil
+}
+
+// NewID returns a random 128-bit hex identifier
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,39 @@
+package store
+
+import "testing"
+
+func TestOpenReleasesInterruptedApprovals(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approving := &Issuance{CredentialType: CredentialFarmer, Status: StatusApproving}
+	offered := &Issuance{CredentialType: CredentialFarmer, Status: StatusOffered}
+	for _, iss := range []*Issuance{approving, offered} {
+		if err := s.SaveIssuance(iss); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The issuer stops mid-approval and starts again
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		id   string
+		want string
+	}{
+		{approving.ID, StatusPending},
+		{offered.ID, StatusOffered},
+	} {
+		got, err := s.Issuance(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != tt.want {
+			t.Errorf("status after restart = %q, want %q", got.Status, tt.want)
+		}
+	}
+}
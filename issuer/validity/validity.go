@@ -0,0 +1,220 @@
+// Package validity decides when issued credentials become valid and when
+// they expire. Each credential type has a Policy: a fixed duration, the
+// dates entered on the form, or the end of a season.
+package validity
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of a validity policy
+type Kind string
+
+const (
+	// KindDuration credentials are valid for a fixed duration after issuance
+	KindDuration Kind = "duration"
+	// KindForm credentials are valid between the dates entered on the form,
+	// or for the policy's duration when no ending date was entered
+	KindForm Kind = "form"
+	// KindSeason credentials are valid until the end of the season: the next
+	// occurrence of a day of the year
+	KindSeason Kind = "season"
+)
+
+// DateLayout is the layout of form dates
+const DateLayout = "2006-01-02"
+
+// DefaultDuration is the validity of a form policy without an explicit
+// fallback duration
+const DefaultDuration = 365 * 24 * time.Hour
+
+var (
+	// ErrEndBeforeStart is returned when the ending date precedes the starting date
+	ErrEndBeforeStart = errors.New("the ending date is before the starting date")
+	// ErrEnded is returned when the ending date has already passed
+	ErrEnded = errors.New("the ending date has already passed")
+)
+
+// Policy is the validity policy of a credential type
+type Policy struct {
+	Kind Kind
+	// Duration is the validity of KindDuration credentials, and of KindForm
+	// credentials without an ending date
+	Duration time.Duration
+	// SeasonMonth and SeasonDay are the last day of a KindSeason season
+	SeasonMonth time.Month
+	SeasonDay   int
+}
+
+// Period is the validity of one credential. A zero From means the credential
+// is valid from issuance. Relative is set when the credential expires
+// Duration after issuance, so walt.id should set the expiry when the holder
+// claims it rather than use Until, which assumes it is claimed now.
+type Period struct {
+	From     time.Time
+	Until    time.Time
+	Relative bool
+	Duration time.Duration
+}
+
+// Days returns the duration of a relative period in whole days
+func (p Period) Days() int {
+	return int(p.Duration / (24 * time.Hour))
+}
+
+// Parse reads a policy: a duration in days such as "365d", "form" or
+// "form:180d" for the form's dates with a fallback duration, or
+// "season:12-31" for a season ending on 31 December
+func Parse(s string) (Policy, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(s), ":")
+	switch Kind(kind) {
+	case KindForm:
+		p := Policy{Kind: KindForm, Duration: DefaultDuration}
+		if arg != "" {
+			d, err := parseDays(arg)
+			if err != nil {
+				return Policy{}, err
+			}
+			p.Duration = d
+		}
+		return p, nil
+
+	case KindSeason:
+		month, day, ok := strings.Cut(arg, "-")
+		m, errM := strconv.Atoi(month)
+		d, errD := strconv.Atoi(day)
+		if !ok || errM != nil || errD != nil || m < 1 || m > 12 || d < 1 {
+			return Policy{}, fmt.Errorf("season %q: want season:MM-DD", arg)
+		}
+		// 29 February has no end in most years
+		end := time.Date(2001, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+		if end.Month() != time.Month(m) {
+			return Policy{}, fmt.Errorf("season %q: no such day in every year", arg)
+		}
+		return Policy{Kind: KindSeason, SeasonMonth: time.Month(m), SeasonDay: d}, nil
+	}
+
+	if arg != "" {
+		return Policy{}, fmt.Errorf("unknown validity policy %q", s)
+	}
+	d, err := parseDays(kind)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Policy{Kind: KindDuration, Duration: d}, nil
+}
+
+// FromEnv reads the policy in the environment variable name, or def when it
+// is unset
+func FromEnv(name, def string) (Policy, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		s = def
+	}
+	p, err := Parse(s)
+	if err != nil {
+		return Policy{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return p, nil
+}
+
+// parseDays reads a whole number of days such as "365d"
+func parseDays(s string) (time.Duration, error) {
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || !strings.HasSuffix(s, "d") || n < 1 {
+		return 0, fmt.Errorf("duration %q: want a number of days such as 365d", s)
+	}
+	return time.Duration(n) * 24 * time.Hour, nil
+}
+
+// String describes the policy in the syntax Parse reads
+func (p Policy) String() string {
+	switch p.Kind {
+	case KindForm:
+		return fmt.Sprintf("form:%dd", int(p.Duration.Hours()/24))
+	case KindSeason:
+		return fmt.Sprintf("season:%02d-%02d", p.SeasonMonth, p.SeasonDay)
+	}
+	return fmt.Sprintf("%dd", int(p.Duration.Hours()/24))
+}
+
+// Period returns the validity of a credential issued at now. A start after
+// now, such as the expiry of the credential a renewal replaces, delays it.
+// from and until are the form's starting and ending dates, used by form
+// policies and ignored otherwise; either may be empty.
+func (p Policy) Period(now, start time.Time, from, until string) (Period, error) {
+	now = now.UTC()
+	var period Period
+	if start.After(now) {
+		period.From = start.UTC()
+	} else {
+		start = now
+	}
+
+	switch p.Kind {
+	case KindForm:
+		first, err := parseDate("starting date", from)
+		if err != nil {
+			return Period{}, err
+		}
+		last, err := parseDate("ending date", until)
+		if err != nil {
+			return Period{}, err
+		}
+		if !first.IsZero() {
+			period.From = first
+		}
+		if last.IsZero() {
+			period.Until = start.Add(p.Duration)
+			period.Relative = period.From.IsZero()
+			period.Duration = p.Duration
+			return period, nil
+		}
+		// The start may be the form's starting date or a renewal's
+		period.Until = endOfDay(last)
+		if !period.From.IsZero() && period.Until.Before(period.From) {
+			return Period{}, ErrEndBeforeStart
+		}
+		if !period.Until.After(now) {
+			return Period{}, ErrEnded
+		}
+		return period, nil
+
+	case KindSeason:
+		end := endOfDay(time.Date(start.Year(), p.SeasonMonth, p.SeasonDay, 0, 0, 0, 0, time.UTC))
+		if !end.After(start) {
+			end = endOfDay(time.Date(start.Year()+1, p.SeasonMonth, p.SeasonDay, 0, 0, 0, 0, time.UTC))
+		}
+		period.Until = end
+		return period, nil
+	}
+
+	period.Until = start.Add(p.Duration)
+	period.Relative = period.From.IsZero()
+	period.Duration = p.Duration
+	return period, nil
+}
+
+// parseDate reads an optional form date
+func parseDate(field, s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("the %s %q is not a date (YYYY-MM-DD)", field, s)
+	}
+	return t, nil
+}
+
+// endOfDay returns the last second of t's day in UTC
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, time.UTC)
+}
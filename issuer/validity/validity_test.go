@@ -0,0 +1,239 @@
+package validity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{in: "365d", want: Policy{Kind: KindDuration, Duration: 365 * day}},
+		{in: " 30d ", want: Policy{Kind: KindDuration, Duration: 30 * day}},
+		{in: "form", want: Policy{Kind: KindForm, Duration: DefaultDuration}},
+		{in: "form:180d", want: Policy{Kind: KindForm, Duration: 180 * day}},
+		{in: "season:12-31", want: Policy{Kind: KindSeason, SeasonMonth: time.December, SeasonDay: 31}},
+		{in: "season:02-28", want: Policy{Kind: KindSeason, SeasonMonth: time.February, SeasonDay: 28}},
+		{in: "season:02-29", wantErr: true},
+		{in: "season:13-01", wantErr: true},
+		{in: "season:12", wantErr: true},
+		{in: "form:180", wantErr: true},
+		{in: "0d", wantErr: true},
+		{in: "365", wantErr: true},
+		{in: "year", wantErr: true},
+		{in: "365d:form", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+			if back, err := Parse(got.String()); err != nil || back != got {
+				t.Errorf("Parse(%q) = %+v, %v, want %+v", got.String(), back, err, got)
+			}
+		})
+	}
+}
+
+func TestPeriod(t *testing.T) {
+	now := time.Date(2025, 6, 15, 10, 0, 0, 0, time.UTC)
+	renewal := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	duration := Policy{Kind: KindDuration, Duration: 30 * day}
+	form := Policy{Kind: KindForm, Duration: 90 * day}
+
+	tests := []struct {
+		name        string
+		policy      Policy
+		start       time.Time
+		from, until string
+		want        Period
+		wantErr     error
+	}{
+		{
+			name:   "duration from issuance",
+			policy: duration,
+			want:   Period{Until: now.Add(30 * day), Relative: true, Duration: 30 * day},
+		},
+		{
+			name:   "duration ignores a past start",
+			policy: duration,
+			start:  now.Add(-day),
+			want:   Period{Until: now.Add(30 * day), Relative: true, Duration: 30 * day},
+		},
+		{
+			name:   "duration of a renewal",
+			policy: duration,
+			start:  renewal,
+			want:   Period{From: renewal, Until: renewal.Add(30 * day), Duration: 30 * day},
+		},
+		{
+			name:   "duration ignores form dates",
+			policy: duration,
+			from:   "2025-01-01",
+			until:  "2025-01-02",
+			want:   Period{Until: now.Add(30 * day), Relative: true, Duration: 30 * day},
+		},
+		{
+			name:   "form dates",
+			policy: form,
+			from:   "2025-06-20",
+			until:  "2025-12-31",
+			want: Period{
+				From:  time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC),
+				Until: time.Date(2025, 12, 31, 23, 59, 59, 0, time.UTC),
+			},
+		},
+		{
+			name:   "form without dates",
+			policy: form,
+			want:   Period{Until: now.Add(90 * day), Relative: true, Duration: 90 * day},
+		},
+		{
+			name:   "form starting date without an ending date",
+			policy: form,
+			from:   "2025-06-20",
+			want: Period{
+				From:     time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC),
+				Until:    now.Add(90 * day),
+				Duration: 90 * day,
+			},
+		},
+		{
+			name:   "form ending on the starting date",
+			policy: form,
+			from:   "2025-06-20",
+			until:  "2025-06-20",
+			want: Period{
+				From:  time.Date(2025, 6, 20, 0, 0, 0, 0, time.UTC),
+				Until: time.Date(2025, 6, 20, 23, 59, 59, 0, time.UTC),
+			},
+		},
+		{
+			name:    "form ending before the starting date",
+			policy:  form,
+			from:    "2025-06-20",
+			until:   "2025-06-19",
+			wantErr: ErrEndBeforeStart,
+		},
+		{
+			name:    "form ending before a renewal starts",
+			policy:  form,
+			start:   renewal,
+			until:   "2025-06-30",
+			wantErr: ErrEndBeforeStart,
+		},
+		{
+			name:   "form ending after a renewal starts",
+			policy: form,
+			start:  renewal,
+			until:  "2025-07-31",
+			want: Period{
+				From:  renewal,
+				Until: time.Date(2025, 7, 31, 23, 59, 59, 0, time.UTC),
+			},
+		},
+		{
+			name:    "form ended",
+			policy:  form,
+			until:   "2025-06-14",
+			wantErr: ErrEnded,
+		},
+		{
+			name:   "form ending today",
+			policy: form,
+			until:  "2025-06-15",
+			want:   Period{Until: time.Date(2025, 6, 15, 23, 59, 59, 0, time.UTC)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.policy.Period(now, tt.start, tt.from, tt.until)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Period = %+v, %v, want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Period: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Period = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeriodInvalidDates(t *testing.T) {
+	form := Policy{Kind: KindForm, Duration: DefaultDuration}
+	for _, dates := range [][2]string{{"20/06/2025", ""}, {"", "2025-13-01"}} {
+		if _, err := form.Period(time.Now(), time.Time{}, dates[0], dates[1]); err == nil {
+			t.Errorf("Period(%q, %q) succeeded, want an error", dates[0], dates[1])
+		}
+	}
+}
+
+func TestSeasonRollover(t *testing.T) {
+	season := Policy{Kind: KindSeason, SeasonMonth: time.March, SeasonDay: 31}
+	tests := []struct {
+		name  string
+		now   time.Time
+		start time.Time
+		want  time.Time
+	}{
+		{
+			name: "before the end of the season",
+			now:  time.Date(2025, 1, 10, 8, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 3, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name: "on the last day",
+			now:  time.Date(2025, 3, 31, 8, 0, 0, 0, time.UTC),
+			want: time.Date(2025, 3, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name: "after the season ended",
+			now:  time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC),
+		},
+		{
+			name:  "renewal starting at the end of the season",
+			now:   time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC),
+			start: time.Date(2025, 3, 31, 23, 59, 59, 0, time.UTC),
+			want:  time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := season.Period(tt.now, tt.start, "", "")
+			if err != nil {
+				t.Fatalf("Period: %v", err)
+			}
+			if !got.Until.Equal(tt.want) || got.Relative {
+				t.Errorf("Period = %+v, want a fixed end at %v", got, tt.want)
+			}
+			if tt.start.After(tt.now) && !got.From.Equal(tt.start) {
+				t.Errorf("From = %v, want the renewal start %v", got.From, tt.start)
+			}
+		})
+	}
+}
@@ -1,15 +1,21 @@
 package handlers
 
 import (
-	"bytes"
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"io"
 	"log"
+	"log/slog"
 	"net/http"
-	"time"
 
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+	"github.com/adammwaniki/testa-walt/common/tracing"
+	"github.com/adammwaniki/testa-walt/common/waltid"
 	"github.com/adammwaniki/testa-walt/verifier/models"
 )
 
@@ -17,6 +23,12 @@ import (
 type Handler struct {
 	WaltIDURL string
 	Templates *template.Template
+
+	// Abuse protection: request limits per user and per client IP, and a
+	// cap on concurrent walt.id calls
+	userLimits *ratelimit.Limiter
+	ipLimits   *ratelimit.Limiter
+	waltID     *waltid.Client
 }
 
 // NewHandler creates a new handler with dependencies
@@ -27,9 +39,15 @@ func NewHandler(waltIDURL string) *Handler {
 		log.Fatal("Error parsing templates:", err)
 	}
 
+	waltIDConfig := waltid.ConfigFromEnv()
+	waltIDConfig.Observer = waltIDMetrics
+
 	return &Handler{
-		WaltIDURL: waltIDURL,
-		Templates: templates,
+		WaltIDURL:  waltIDURL,
+		Templates:  templates,
+		userLimits: ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_USER_PER_MINUTE", 30), ratelimit.EnvInt("RATE_LIMIT_BURST", 10)),
+		ipLimits:   ratelimit.New(ratelimit.EnvInt("RATE_LIMIT_IP_PER_MINUTE", 60), ratelimit.EnvInt("RATE_LIMIT_BURST", 10)),
+		waltID:     waltid.New(waltIDConfig, tracing.Transport(waltid.PooledTransport(waltIDConfig.MaxConcurrent))),
 	}
 }
 
@@ -40,13 +58,21 @@ func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.Templates.ExecuteTemplate(w, "index.html", nil)
+	data := map[string]any{}
+	if u, ok := auth.UserFrom(r.Context()); ok {
+		data["User"] = u
+	}
+
+	err := h.Templates.ExecuteTemplate(w, "index.html", data)
 	if err != nil {
-		log.Printf("Error rendering template: %v", err)
+		slog.ErrorContext(r.Context(), "Error rendering template", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 	}
 }
 
+// defaultCredentialType is requested when the form does not choose a type
+const defaultCredentialType = "VerifiablePortableDocumentA1"
+
 // VerifyCredential handles the credential verification request
 func (h *Handler) VerifyCredential(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -56,7 +82,7 @@ func (h *Handler) VerifyCredential(w http.ResponseWriter, r *http.Request) {
 
 	// Parse form data
 	if err := r.ParseForm(); err != nil {
-		log.Printf("Error parsing form: %v", err)
+		slog.WarnContext(r.Context(), "Error parsing form", "error", err)
 		h.renderError(w, "Failed to parse form data")
 		return
 	}
@@ -64,6 +90,9 @@ func (h *Handler) VerifyCredential(w http.ResponseWriter, r *http.Request) {
 	// Extract verification options from form
 	options := h.extractVerificationOptions(r)
 
+	outcome := metrics.OutcomeError
+	defer func() { verifications.Count(cmp.Or(options.CredentialType, defaultCredentialType), outcome) }()
+
 	// Build verification request
 	verifyRequest := h.buildVerificationRequest(options)
 
@@ -72,60 +101,56 @@ func (h *Handler) VerifyCredential(w http.ResponseWriter, r *http.Request) {
 	// Marshal request to JSON
 	requestBody, err := json.Marshal(verifyRequest)
 	if err != nil {
-		log.Printf("Error marshaling request: %v", err)
+		slog.ErrorContext(r.Context(), "Error marshaling request", "error", err)
 		h.renderError(w, "Failed to create verification request")
 		return
 	}
 
-	log.Printf("Sending verification request: %s", string(requestBody))
-
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Make request to Walt.id
-	req, err := http.NewRequest("POST", h.WaltIDURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Printf("Error creating request: %v", err)
-		h.renderError(w, "Failed to create request")
-		return
-	}
+	slog.DebugContext(r.Context(), "Sending verification request", "credential_type", options.CredentialType, "body", logging.Body(options.CredentialType, requestBody))
 
 	// Set headers as per curl example
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "text/plain")
-	req.Header.Set("authorizeBaseUrl", "openid4vp://authorize")
-	req.Header.Set("responseMode", "direct_post")
-	req.Header.Set("successRedirectUri", SuccessRedirectURI)
-
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error sending request to Walt.id: %v", err)
-		h.renderError(w, "Failed to connect to verification service. Please try again.")
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "text/plain")
+	header.Set("authorizeBaseUrl", "openid4vp://authorize")
+	header.Set("responseMode", "direct_post")
+	header.Set("successRedirectUri", SuccessRedirectURI)
+
+	// Send request; the client queues for a walt.id slot, retries transient
+	// failures and fails fast while walt.id is down
+	resp, err := h.waltID.Post(r.Context(), h.WaltIDURL, header, requestBody)
+	switch {
+	case errors.Is(err, ratelimit.ErrBusy):
+		slog.WarnContext(r.Context(), "Error waiting for walt.id", "error", err)
+		outcome = metrics.OutcomeBusy
+		h.renderUnavailable(w, err, "The verification service is busy. Please try again in a moment.")
 		return
-	}
-	defer resp.Body.Close()
-
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response: %v", err)
-		h.renderError(w, "Failed to read response")
+	case errors.Is(err, waltid.ErrUnavailable):
+		slog.WarnContext(r.Context(), "walt.id circuit breaker is open", "url", h.WaltIDURL)
+		outcome = metrics.OutcomeUnavailable
+		h.renderUnavailable(w, err, "The verification service is temporarily unavailable. Please try again in a few minutes.")
+		return
+	case err != nil && r.Context().Err() != nil:
+		slog.WarnContext(r.Context(), "Request ended before walt.id answered", "url", h.WaltIDURL, "error", err)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error sending request to walt.id", "url", h.WaltIDURL, "error", err)
+		h.renderError(w, "Failed to connect to verification service. Please try again.")
 		return
 	}
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("Walt.id returned status %d: %s", resp.StatusCode, string(body))
-		h.renderError(w, fmt.Sprintf("Verification service error (status %d): %s", resp.StatusCode, string(body)))
+		slog.ErrorContext(r.Context(), "walt.id returned an error", "status", resp.StatusCode, "body", logging.Body(options.CredentialType, resp.Body))
+		outcome = metrics.OutcomeRejected
+		h.renderError(w, fmt.Sprintf("Verification service error (status %d): %s", resp.StatusCode, string(resp.Body)))
 		return
 	}
 
 	// Response is the verification link
-	verificationLink := string(body)
-	log.Printf("Verification link received: %s", verificationLink)
+	verificationLink := string(resp.Body)
+	outcome = metrics.OutcomeSuccess
+	slog.InfoContext(r.Context(), "Verification request created", "credential_type", options.CredentialType)
 
 	// Render success response with HTMX
 	h.renderSuccess(w, verificationLink, options)
@@ -144,10 +169,7 @@ func (h *Handler) extractVerificationOptions(r *http.Request) *models.Verificati
 
 // buildVerificationRequest builds the complete Walt.id verification request
 func (h *Handler) buildVerificationRequest(options *models.VerificationOptions) *models.VerificationRequest {
-	credentialType := options.CredentialType
-	if credentialType == "" {
-		credentialType = "VerifiablePortableDocumentA1"
-	}
+	credentialType := cmp.Or(options.CredentialType, defaultCredentialType)
 
 	// FarmerCredential uses simpler structure
 	if credentialType == "FarmerCredential" {
@@ -304,11 +326,20 @@ func (h *Handler) renderSuccess(w http.ResponseWriter, verificationLink string,
 			}, 2000);
 		}
 		</script>
-	`, credType, policiesHTML, verificationLink)
+	`, template.HTMLEscapeString(credType), policiesHTML, template.HTMLEscapeString(verificationLink))
 
 	w.Write([]byte(html))
 }
 
+// renderUnavailable renders message with 503 Service Unavailable and a
+// Retry-After header, for when walt.id is busy or down
+func (h *Handler) renderUnavailable(w http.ResponseWriter, err error, message string) {
+	w.Header().Set("Retry-After", ratelimit.RetryAfter(h.waltID.RetryAfter(err)))
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	h.renderError(w, message)
+}
+
 // renderError renders an error message
 func (h *Handler) renderError(w http.ResponseWriter, message string) {
 	w.Header().Set("Content-Type", "text/html")
@@ -319,7 +350,7 @@ func (h *Handler) renderError(w http.ResponseWriter, message string) {
 			<p>%s</p>
 			<button onclick="location.reload()" class="btn-secondary">Try Again</button>
 		</div>
-	`, message)
+	`, template.HTMLEscapeString(message))
 
 	w.Write([]byte(html))
 }
\ No newline at end of file
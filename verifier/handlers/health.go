@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/url"
+	"os"
+
+	"github.com/adammwaniki/testa-walt/common/server"
+)
+
+// defaultWalletURL is the walt.id wallet API holders present credentials from
+const defaultWalletURL = "http://139.59.15.151:7001"
+
+// HealthChecks returns the dependencies reported by /healthz and /readyz:
+// the walt.id verifier and wallet APIs, the page templates and the data
+// directory
+func (h *Handler) HealthChecks(dataDir string) []server.Check {
+	walletURL := os.Getenv("WALTID_WALLET_URL")
+	if walletURL == "" {
+		walletURL = defaultWalletURL
+	}
+
+	return []server.Check{
+		{Name: "waltid_verifier_api", Run: server.HTTPCheck(baseURL(h.WaltIDURL))},
+		{Name: "waltid_wallet_api", Run: server.HTTPCheck(walletURL)},
+		{Name: "templates", Local: true, Run: server.TemplatesCheck("templates/*.html")},
+		{Name: "storage", Local: true, Run: server.DirCheck(dataDir)},
+	}
+}
+
+// baseURL returns the scheme and host of rawURL
+func baseURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The verifier's own metrics; HTTP traffic is recorded by metrics.Middleware
+var (
+	// verifications counts walt.id presentation requests, for the
+	// credential types offered by the verification form
+	verifications = metrics.NewOutcomes(prometheus.CounterOpts{
+		Namespace: "verifier",
+		Name:      "verification_requests_total",
+		Help:      "Presentation requests to walt.id, by credential type and outcome.",
+	}, "VerifiablePortableDocumentA1", "FarmerCredential")
+
+	// waltIDMetrics records the calls of the walt.id client
+	waltIDMetrics = metrics.NewWaltID("verifier", "verification")
+)
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/openapi"
+)
+
+// APIVersion is the version of the verifier's OpenAPI document
+const APIVersion = "1.0.0"
+
+// IsDocumentedPath reports whether path is described by OpenAPI: every
+// route except static assets, metrics and the description itself
+func IsDocumentedPath(path string) bool {
+	return !strings.HasPrefix(path, "/static/") && path != metrics.Path &&
+		path != openapi.SpecPath && path != openapi.DocsPath
+}
+
+// OpenAPI describes the verifier's routes. The app is server-rendered, so
+// most responses are HTML pages or HTMX fragments.
+func OpenAPI() *openapi.Document {
+	doc := openapi.New("Testa SACCO verifier", APIVersion,
+		"Creates walt.id presentation requests for tellers checking member credentials. "+
+			"State-changing requests from a signed-in session must carry the csrf_token cookie "+
+			"value in the X-CSRF-Token header or a csrf_token form field.")
+
+	doc.Components.SecuritySchemes["session"] = openapi.SecurityScheme{
+		Type: "apiKey", In: "cookie", Name: "session",
+		Description: "Session cookie set by POST /login or single sign-on",
+	}
+	doc.Components.SecuritySchemes["csrf"] = openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "X-CSRF-Token",
+		Description: "Value of the csrf_token cookie",
+	}
+	session := []openapi.SecurityRequirement{{"session": {}}}
+	sessionAndCSRF := []openapi.SecurityRequirement{{"session": {}, "csrf": {}}}
+	public := []openapi.SecurityRequirement{{}}
+	doc.Security = session
+	doc.Tags = []openapi.Tag{
+		{Name: "Verification", Description: "Presentation requests for credential checks"},
+		{Name: "Sign-in", Description: "Password and single sign-on sessions"},
+		{Name: "Users", Description: "Staff account administration"},
+	}
+
+	page := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.HTML()}
+	}
+	redirect := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Headers: map[string]openapi.Header{
+			"Location": {Schema: openapi.Schema{"type": "string"}},
+		}}
+	}
+	next := openapi.Parameter{Name: "next", In: "query", Description: "Local path to return to after signing in",
+		Schema: openapi.Schema{"type": "string"}}
+	roles := openapi.Schema{"type": "array", "items": openapi.Schema{"type": "string",
+		"enum": []string{"clerk", "supervisor", "teller", "admin"}}}
+
+	doc.Add(http.MethodGet, "/", &openapi.Operation{
+		OperationID: "home",
+		Summary:     "Verification page",
+		Tags:        []string{"Verification"},
+		Responses: map[string]openapi.Response{
+			"200": page("The credential verification form"),
+			"303": redirect("Not signed in; redirects to the sign-in page"),
+		},
+	})
+
+	checkbox := openapi.Schema{"type": "string", "enum": []string{"on"}, "description": "Present when ticked"}
+	doc.Add(http.MethodPost, "/verify-credential", &openapi.Operation{
+		OperationID: "verifyCredential",
+		Summary:     "Create a presentation request",
+		Description: "Asks walt.id for a presentation request for the selected credential type and " +
+			"returns an HTMX fragment with its QR code. Errors are rendered into the fragment.",
+		Tags:     []string{"Verification"},
+		Security: sessionAndCSRF,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.Form(openapi.Schema{
+			"type": "object",
+			"properties": openapi.Schema{
+				"credentialType": openapi.Schema{"type": "string",
+					"enum":        []string{"VerifiablePortableDocumentA1", "FarmerCredential"},
+					"description": "Defaults to VerifiablePortableDocumentA1"},
+				"checkSignature":     checkbox,
+				"checkExpiration":    checkbox,
+				"checkNotBefore":     checkbox,
+				"checkRevokedStatus": checkbox,
+			},
+		})},
+		Responses: map[string]openapi.Response{
+			"200": page("Verification QR code, or the error that prevented it"),
+			"401": {Description: "Not signed in"},
+			"403": {Description: "Missing CSRF token or the teller role"},
+			"429": {Description: "Rate limit exceeded", Headers: map[string]openapi.Header{
+				"Retry-After": {Description: "Seconds to wait before retrying", Schema: openapi.Schema{"type": "integer"}},
+			}, Content: openapi.HTML()},
+			"503": {Description: "walt.id is saturated", Content: openapi.HTML()},
+		},
+	})
+
+	doc.Add(http.MethodGet, "/login", &openapi.Operation{
+		OperationID: "showLogin",
+		Summary:     "Sign-in page",
+		Tags:        []string{"Sign-in"},
+		Security:    public,
+		Parameters:  []openapi.Parameter{next},
+		Responses:   map[string]openapi.Response{"200": page("The sign-in form")},
+	})
+	doc.Add(http.MethodPost, "/login", &openapi.Operation{
+		OperationID: "login",
+		Summary:     "Sign in with a password",
+		Tags:        []string{"Sign-in"},
+		Security:    public,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.Form(openapi.Schema{
+			"type": "object",
+			"properties": openapi.Schema{
+				"username": openapi.Schema{"type": "string"},
+				"password": openapi.Schema{"type": "string", "format": "password"},
+				"next":     openapi.Schema{"type": "string"},
+			},
+			"required": []string{"username", "password"},
+		})},
+		Responses: map[string]openapi.Response{
+			"303": redirect("Signed in; sets the session and csrf_token cookies"),
+			"401": page("Wrong username or password"),
+		},
+	})
+	doc.Add(http.MethodPost, "/logout", &openapi.Operation{
+		OperationID: "logout",
+		Summary:     "Sign out",
+		Description: "Single sign-on sessions are also ended at the identity provider when it supports RP-initiated logout.",
+		Tags:        []string{"Sign-in"},
+		Security:    sessionAndCSRF,
+		Responses:   map[string]openapi.Response{"303": redirect("Signed out")},
+	})
+	doc.Add(http.MethodGet, "/login/oidc", &openapi.Operation{
+		OperationID: "startOIDC",
+		Summary:     "Start single sign-on",
+		Tags:        []string{"Sign-in"},
+		Security:    public,
+		Parameters:  []openapi.Parameter{next},
+		Responses: map[string]openapi.Response{
+			"302": redirect("Redirects to the identity provider"),
+			"404": {Description: "Single sign-on is not configured"},
+		},
+	})
+	doc.Add(http.MethodGet, "/login/oidc/callback", &openapi.Operation{
+		OperationID: "oidcCallback",
+		Summary:     "Single sign-on callback",
+		Tags:        []string{"Sign-in"},
+		Security:    public,
+		Parameters: []openapi.Parameter{
+			{Name: "code", In: "query", Schema: openapi.Schema{"type": "string"}},
+			{Name: "state", In: "query", Required: true, Schema: openapi.Schema{"type": "string"}},
+			{Name: "error", In: "query", Schema: openapi.Schema{"type": "string"}},
+		},
+		Responses: map[string]openapi.Response{
+			"303": redirect("Signed in"),
+			"400": page("Invalid or expired sign-in attempt"),
+			"403": page("No role is mapped from the user's groups"),
+			"404": {Description: "Single sign-on is not configured"},
+		},
+	})
+
+	doc.Add(http.MethodGet, "/admin/users", &openapi.Operation{
+		OperationID: "listUsers",
+		Summary:     "User administration page",
+		Tags:        []string{"Users"},
+		Responses: map[string]openapi.Response{
+			"200": page("Staff accounts"),
+			"403": {Description: "Requires the admin role"},
+		},
+	})
+	doc.Add(http.MethodPost, "/admin/users", &openapi.Operation{
+		OperationID: "createUser",
+		Summary:     "Create a staff account",
+		Tags:        []string{"Users"},
+		Security:    sessionAndCSRF,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.Form(openapi.Schema{
+			"type": "object",
+			"properties": openapi.Schema{
+				"username": openapi.Schema{"type": "string"},
+				"name":     openapi.Schema{"type": "string"},
+				"password": openapi.Schema{"type": "string", "format": "password", "minLength": 10},
+				"roles":    roles,
+			},
+			"required": []string{"username", "password", "roles"},
+		})},
+		Responses: map[string]openapi.Response{
+			"200": page("Staff accounts with the outcome"),
+			"403": {Description: "Missing CSRF token or the admin role"},
+		},
+	})
+	doc.Add(http.MethodPost, "/admin/users/{username}", &openapi.Operation{
+		OperationID: "updateUser",
+		Summary:     "Update a staff account",
+		Description: "Changes end the user's sessions.",
+		Tags:        []string{"Users"},
+		Security:    sessionAndCSRF,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.Form(openapi.Schema{
+			"type": "object",
+			"properties": openapi.Schema{
+				"action":   openapi.Schema{"type": "string", "enum": []string{"password", "roles", "disable", "enable"}},
+				"password": openapi.Schema{"type": "string", "format": "password", "description": "For action=password"},
+				"roles":    roles,
+			},
+			"required": []string{"action"},
+		})},
+		Responses: map[string]openapi.Response{
+			"200": page("Staff accounts with the outcome"),
+			"403": {Description: "Missing CSRF token or the admin role"},
+		},
+	})
+
+	return doc
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import "github.com/adammwaniki/testa-walt/common/logging"
+
+// The claims of each verified credential type that identify a person
+func init() {
+	logging.RegisterPII("VerifiablePortableDocumentA1",
+		"personalIdentificationNumber", "sex", "surname", "forenames", "dateBirth", "nationalities",
+		"stateOfResidenceAddress", "stateOfStayAddress",
+		"email", "officePhoneNo", "officeFaxNo", "signature",
+	)
+	logging.RegisterPII("FarmerCredential",
+		"given_name", "family_name", "license_no", "phone", "email",
+	)
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/ratelimit"
+)
+
+// RateLimited applies the per-user and per-IP request limits. Rejected
+// requests get 429 Too Many Requests with a Retry-After header.
+func (h *Handler) RateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ratelimit.ClientIP(r)
+		ok, retryAfter := h.ipLimits.Allow(ip)
+		who := ip
+		if u, signedIn := auth.UserFrom(r.Context()); ok && signedIn {
+			ok, retryAfter = h.userLimits.Allow(u.Username)
+			who = u.Username
+		}
+
+		if !ok {
+			slog.WarnContext(r.Context(), "Rate limited", "method", r.Method, "path", r.URL.Path, "client", who)
+			w.Header().Set("Retry-After", ratelimit.RetryAfter(retryAfter))
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusTooManyRequests)
+			h.renderError(w, "Too many requests. Please wait a moment and try again.")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -1,14 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/common/logging"
+	"github.com/adammwaniki/testa-walt/common/metrics"
+	"github.com/adammwaniki/testa-walt/common/openapi"
+	"github.com/adammwaniki/testa-walt/common/server"
+	"github.com/adammwaniki/testa-walt/common/tracing"
 	"github.com/adammwaniki/testa-walt/verifier/handlers"
 )
 
 func main() {
+	// Structured logs with request IDs; LOG_LEVEL and LOG_FORMAT tune them
+	logging.Setup("verifier")
+
+	// Traces exported over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT is set
+	shutdownTracing, err := tracing.Setup(context.Background(), "verifier")
+	if err != nil {
+		log.Fatal("Error setting up tracing:", err)
+	}
+
 	// Get configuration from environment
 	port := getEnv("PORT", "8081")
 	waltIDURL := getEnv("WALTID_VERIFIER_URL", "http://139.59.15.151:7003/openid4vc/verify")
@@ -16,16 +34,72 @@ func main() {
 	// Initialize handlers with configuration
 	h := handlers.NewHandler(waltIDURL)
 
-	// Routes
-	http.HandleFunc("/", h.Home)
-	http.HandleFunc("/verify-credential", h.VerifyCredential)
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	// Staff accounts and sessions
+	dataDir := getEnv("VERIFIER_DATA_DIR", "data")
+	a, err := auth.New(filepath.Join(dataDir, "users.json"), os.Getenv("AUTH_SECURE_COOKIES") == "true")
+	if err != nil {
+		log.Fatal("Error opening user store:", err)
+	}
+
+	// Optional single sign-on for staff with identity provider accounts
+	oidcConfig, err := auth.OIDCConfigFromEnv()
+	if err != nil {
+		log.Fatal("Error reading single sign-on settings:", err)
+	}
+	if oidcConfig != nil {
+		a.EnableOIDC(oidcConfig)
+	}
+
+	routes, spec := registerRoutes(http.DefaultServeMux, h, a)
+	if err := spec.Check(routes.Patterns(), handlers.IsDocumentedPath); err != nil {
+		slog.Warn("OpenAPI document and routes differ", "error", err)
+	}
 
 	// Start server
 	addr := ":" + port
-	log.Printf("Testa SACCO verifier starting on %s", addr)
-	log.Printf("Walt.id Verifier URL: %s", waltIDURL)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	slog.Info("Testa SACCO verifier starting", "addr", addr, "waltid_url", waltIDURL)
+	handler := tracing.Middleware(http.DefaultServeMux, logging.Middleware(a.Middleware(http.DefaultServeMux)))
+	srv := server.New(server.ConfigFromEnv(addr), metrics.Middleware(http.DefaultServeMux, handler), server.NewHealth(h.HealthChecks(dataDir)...))
+	err = srv.Run()
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// registerRoutes registers every route on mux. It returns the recorded
+// routes and the API description, which openapi_test.go checks against
+// each other.
+func registerRoutes(mux *http.ServeMux, h *handlers.Handler, a *auth.Service) (*openapi.Routes, *openapi.Document) {
+	// Routes are recorded so they can be checked against the API description
+	routes := openapi.NewRoutes(mux)
+
+	// Sign-in and user administration
+	routes.HandleFunc("GET /login", a.ShowLogin)
+	routes.HandleFunc("POST /login", a.Login)
+	routes.HandleFunc("POST /logout", a.Logout)
+	routes.HandleFunc("GET "+auth.OIDCLoginPath, a.StartOIDC)
+	routes.HandleFunc("GET "+auth.OIDCCallbackPath, a.OIDCCallback)
+	routes.HandleFunc("GET /admin/users", a.Require(a.ShowUsers, auth.RoleAdmin))
+	routes.HandleFunc("POST /admin/users", a.Require(a.CreateUser, auth.RoleAdmin))
+	routes.HandleFunc("POST /admin/users/{username}", a.Require(a.UpdateUser, auth.RoleAdmin))
+
+	// Routes
+	routes.HandleFunc("/", a.Require(h.Home))
+	routes.HandleFunc("/verify-credential", a.Require(h.RateLimited(h.VerifyCredential), auth.RoleTeller))
+	routes.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	// Prometheus metrics, behind a bearer token when METRICS_TOKEN is set
+	routes.Handle("GET "+metrics.Path, metrics.Handler(os.Getenv("METRICS_TOKEN")))
+
+	// API description and docs
+	spec := handlers.OpenAPI()
+	routes.HandleFunc("GET "+openapi.SpecPath, spec.Handler())
+	routes.HandleFunc("GET "+openapi.DocsPath, openapi.DocsHandler)
+
+	return routes, spec
 }
 
 func getEnv(key, defaultValue string) string {
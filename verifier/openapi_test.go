@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/adammwaniki/testa-walt/common/auth"
+	"github.com/adammwaniki/testa-walt/verifier/handlers"
+)
+
+// TestOpenAPIMatchesRoutes fails when a route is missing from the OpenAPI
+// document or the document describes a route that is not served
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	t.Setenv("AUTH_ADMIN_PASSWORD", "openapi-test-only")
+
+	h := handlers.NewHandler("http://waltid.invalid/openid4vc/verify")
+	a, err := auth.New(filepath.Join(t.TempDir(), "users.json"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, spec := registerRoutes(http.NewServeMux(), h, a)
+	if err := spec.Check(routes.Patterns(), handlers.IsDocumentedPath); err != nil {
+		t.Error(err)
+	}
+}
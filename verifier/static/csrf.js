@@ -0,0 +1,24 @@
+// Sends the session's CSRF token, mirrored in the csrf_token cookie, with
+// every HTMX request and plain form post
+(function () {
+    function token() {
+        const match = document.cookie.match(/(?:^|; )csrf_token=([^;]*)/);
+        return match ? decodeURIComponent(match[1]) : '';
+    }
+
+    document.addEventListener('htmx:configRequest', function (event) {
+        event.detail.headers['X-CSRF-Token'] = token();
+    });
+
+    document.addEventListener('submit', function (event) {
+        const form = event.target;
+        if (form.method.toLowerCase() !== 'post' || form.querySelector('input[name="csrf_token"]')) {
+            return;
+        }
+        const input = document.createElement('input');
+        input.type = 'hidden';
+        input.name = 'csrf_token';
+        input.value = token();
+        form.appendChild(input);
+    });
+})();
@@ -0,0 +1,9 @@
+// Lets HTMX swap in the error fragments sent with 429 Too Many Requests and
+// 503 Service Unavailable, which it would otherwise discard
+document.addEventListener('htmx:beforeSwap', function (event) {
+    const status = event.detail.xhr.status;
+    if (status === 429 || status === 503) {
+        event.detail.shouldSwap = true;
+        event.detail.isError = false;
+    }
+});